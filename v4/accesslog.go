@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const accessEntryContextKey contextKey = "access_entry"
+
+// accessLogger writes one JSON line per request to stdout, apart from the
+// rest of the log so it can be shipped and queried on its own
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// accessEntry is the access log line of a request, errors are the ones the
+// handler hit while serving it
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Bytes      int       `json:"bytes"`
+	RemoteIP   string    `json:"remote_ip"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+// accessLog logs every request once it has been served, it needs to run
+// inside requestIDs to pick up the request ID
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &accessEntry{
+			Time:      time.Now(),
+			RequestID: requestIDFrom(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			RemoteIP:  clientIP(r),
+		}
+		cw := &countingWriter{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), accessEntryContextKey, entry)
+
+		next.ServeHTTP(cw, r.WithContext(ctx))
+
+		entry.Status = cw.status
+		entry.Bytes = cw.bytes
+		entry.DurationMS = milliseconds(time.Since(entry.Time))
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		accessLogger.Println(string(line))
+	})
+}
+
+// logError records an error the handler hit on the request's access log
+// line, requests served without the access log log it on its own
+func logError(r *http.Request, err error) {
+	logContextError(r.Context(), err)
+}
+
+// logDBError is logError for code that is only handed the database, handles
+// from dbFor carry their request so the error lands on its access log line.
+// Background jobs have no request and log it on its own.
+func logDBError(db *gorm.DB, err error) {
+	ctx := context.Background()
+	if v, ok := db.Get("context"); ok {
+		ctx = v.(context.Context)
+	}
+	logContextError(ctx, err)
+}
+
+func logContextError(ctx context.Context, err error) {
+	entry, ok := ctx.Value(accessEntryContextKey).(*accessEntry)
+	if !ok {
+		log.Println(err)
+		return
+	}
+	entry.Errors = append(entry.Errors, err.Error())
+}
+
+// countingWriter remembers the status code and counts the bytes of the body
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (cw *countingWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.bytes += n
+	return n, err
+}
+
+// Flush passes flushes through so streamed responses aren't held back
+func (cw *countingWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
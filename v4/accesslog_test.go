@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRequestsAreLoggedWithTheirErrors(t *testing.T) {
+	// Arrange
+	logs := bytes.Buffer{}
+	accessLogger.SetOutput(&logs)
+	defer accessLogger.SetOutput(os.Stdout)
+	h := chain(requestIDs, accessLog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logError(r, errors.New("database is locked"))
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}))
+	req := httptest.NewRequest("POST", "/meetups", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	// Act
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert
+	entry := accessEntry{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON log line, got %v instead", logs.String())
+	}
+	if entry.Method != "POST" || entry.Path != "/meetups" || entry.Status != http.StatusInternalServerError || entry.RequestID != "abc-123" || entry.RemoteIP != "192.0.2.1" || entry.Bytes == 0 {
+		t.Errorf("expected the request to be described, got %v instead", logs.String())
+	}
+	if len(entry.Errors) != 1 || entry.Errors[0] != "database is locked" {
+		t.Errorf("expected the handler's error to be logged, got %v instead", entry.Errors)
+	}
+}
+
+func TestErrorsFromRequestDatabaseHandlesAreLoggedWithTheRequest(t *testing.T) {
+	// Arrange
+	logs := bytes.Buffer{}
+	accessLogger.SetOutput(&logs)
+	defer accessLogger.SetOutput(os.Stdout)
+	db := getDB()
+	h := chain(requestIDs, accessLog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logDBError(dbFor(r, db), errors.New("mail server unavailable"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// Act
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/meetups/1", nil))
+
+	// Assert
+	entry := accessEntry{}
+	json.Unmarshal(logs.Bytes(), &entry)
+	if len(entry.Errors) != 1 || entry.Errors[0] != "mail server unavailable" {
+		t.Errorf("expected the error to be on the request's log line, got %v instead", logs.String())
+	}
+}
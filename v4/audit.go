@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// the kinds of authentication events written to the audit log
+const (
+	authEventLogin           = "login"
+	authEventLoginFailed     = "login_failed"
+	authEventLogout          = "logout"
+	authEventPasswordChanged = "password_changed"
+	authEventEmailChanged    = "email_changed"
+	authEventTokenCreated    = "token_created"
+)
+
+// authEvent is an entry in the authentication audit log, the user ID is zero
+// when a login was attempted for an unknown email
+type authEvent struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	Kind      string    `gorm:"type:varchar(30);index" json:"kind"`
+	Email     string    `gorm:"type:varchar(100)" json:"email"`
+	IP        string    `gorm:"type:varchar(45)" json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// recordAuthEvent writes an audit entry for the request, failures are logged
+// rather than returned so auditing never blocks authentication
+func recordAuthEvent(db *gorm.DB, r *http.Request, kind string, userID uint, email string) {
+	e := authEvent{
+		UserID:    userID,
+		Kind:      kind,
+		Email:     email,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if err := db.Create(&e).Error; err != nil {
+		log.Println(err)
+	}
+}
+
+// clientIP returns the address of the client that sent the request, which
+// is the peer unless trustedProxies found it behind a proxy
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+func authEventsIndex(db *gorm.DB) http.HandlerFunc {
+	type authEventsIndexResponse struct {
+		Events     []authEvent `json:"events"`
+		Pagination pagination  `json:"pagination"`
+		Links      pageLinks   `json:"links"`
+	}
+
+	policy := listPolicyFor("/admin/audit/auth")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, errs := parseListOptions(r, policy)
+		q := dbFor(r, db)
+		params := r.URL.Query()
+
+		if s := params.Get("user_id"); s != "" {
+			id, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				errs["user_id"] = append(errs["user_id"], "The user_id field must be a number")
+			}
+			q = q.Where("user_id = ?", id)
+		}
+		if s := params.Get("from"); s != "" {
+			from, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				errs["from"] = append(errs["from"], "The from field must be an RFC 3339 timestamp")
+			}
+			q = q.Where("created_at >= ?", from)
+		}
+		if s := params.Get("to"); s != "" {
+			to, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				errs["to"] = append(errs["to"], "The to field must be an RFC 3339 timestamp")
+			}
+			q = q.Where("created_at <= ?", to)
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		page := opts.paginate(q, &authEvent{})
+		resp := authEventsIndexResponse{
+			Events:     []authEvent{},
+			Pagination: page,
+			Links:      page.links(r),
+		}
+		setLinkHeader(w, resp.Links)
+		opts.apply(q).Order("id desc").Find(&resp.Events)
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// registerAuditCallbacks stamps records that have CreatedByID and UpdatedByID
+// fields with the signed in user passed along by dbFor, changes made outside
+// a request or by guests leave them alone
+func registerAuditCallbacks(db *gorm.DB) {
+	stamp := func(fields ...string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			actor, ok := scope.Get("actor_id")
+			if !ok {
+				return
+			}
+			// UpdateColumn and UpdateColumns are for bookkeeping such as
+			// recording logins, not edits
+			if _, ok := scope.Get("gorm:update_column"); ok {
+				return
+			}
+			for _, name := range fields {
+				if _, ok := scope.FieldByName(name); ok {
+					scope.SetColumn(name, actor)
+				}
+			}
+		}
+	}
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("audit:created_by", stamp("CreatedByID", "UpdatedByID"))
+	cb.Update().Before("gorm:update").Register("audit:updated_by", stamp("UpdatedByID"))
+}
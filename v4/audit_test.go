@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthEventsRequireAnAdmin(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("GET", "/admin/audit/auth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAdmin(db, authEventsIndex(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, status)
+	}
+}
+
+func TestAuthEventsCanBeFilteredByUser(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@mccallister.io", "somePassword1!")
+	db.Model(&admin).Update("admin", true)
+	other := createUser(db, "jason@mccallister.io", "somePassword1!")
+	db.Create(&authEvent{UserID: admin.ID, Kind: authEventLogin})
+	db.Create(&authEvent{UserID: other.ID, Kind: authEventLogin})
+	db.Create(&authEvent{UserID: other.ID, Kind: authEventLogout})
+	req, err := http.NewRequest("GET", fmt.Sprintf("/admin/audit/auth?user_id=%v", other.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, admin))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAdmin(db, authEventsIndex(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	resp := struct {
+		Events []authEvent `json:"events"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Events) != 2 {
+		t.Fatalf("expected 2 events to be returned, got %v instead", len(resp.Events))
+	}
+	for _, e := range resp.Events {
+		if e.UserID != other.ID {
+			t.Errorf("expected only events for user %v, got one for %v", other.ID, e.UserID)
+		}
+	}
+}
+
+func TestAuthEventsRejectInvalidTimeRanges(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	req, err := http.NewRequest("GET", "/admin/audit/auth?from=yesterday", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(authEventsIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestChangesAreStampedWithTheActingUser(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	registerAuditCallbacks(db)
+	admin := createUser(db, "admin@example.com", "somePassword1!")
+	db.Model(&admin).Update("admin", true)
+	u := createUser(db, "stamped@example.com", "somePassword1!")
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"name":"Stamped"}`))
+	req.Header.Set("Authorization", login(db, admin))
+	imported := httptest.NewRequest("POST", "/admin/users/import", strings.NewReader(`{"email":"imported@example.com","password":"somePassword1!"}`))
+	imported.Header.Set("Content-Type", "application/x-ndjson")
+	imported.Header.Set("Authorization", login(db, admin))
+
+	// Act
+	updateRouter(db).ServeHTTP(httptest.NewRecorder(), req)
+	requireAdmin(db, usersImport(db)).ServeHTTP(httptest.NewRecorder(), imported)
+
+	// Assert
+	db.First(&u, u.ID)
+	if u.CreatedByID != nil || u.UpdatedByID == nil || *u.UpdatedByID != admin.ID {
+		t.Errorf("expected only updated_by to be stamped with the administrator, got %v and %v instead", u.CreatedByID, u.UpdatedByID)
+	}
+	created := user{}
+	db.Where("email = ?", "imported@example.com").First(&created)
+	if created.CreatedByID == nil || *created.CreatedByID != admin.ID {
+		t.Errorf("expected created_by to be stamped with the administrator, got %v instead", created.CreatedByID)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// token is an opaque bearer credential issued when a user logs in, only the
+// hash of the value handed to the client is stored
+type token struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"index"`
+	Hash      string `gorm:"type:varchar(64);unique_index"`
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+type contextKey string
+
+const (
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "token"
+)
+
+// randomToken returns a random hex encoded secret suitable for handing to clients
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// issueToken creates a new token for the user and returns the plaintext value
+func issueToken(db *gorm.DB, u user) (string, error) {
+	plain, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	t := token{UserID: u.ID, Hash: hashToken(plain)}
+	if err := db.Create(&t).Error; err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate resolves the bearer token on the request to an active token and its user
+func authenticate(db *gorm.DB, r *http.Request) (user, token, bool) {
+	u := user{}
+	t := token{}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return u, t, false
+	}
+	plain := strings.TrimPrefix(header, "Bearer ")
+	db = dbFor(r, db)
+
+	if db.Where("hash = ? AND revoked_at IS NULL", hashToken(plain)).First(&t).RecordNotFound() {
+		return u, t, false
+	}
+	if db.First(&u, t.UserID).RecordNotFound() {
+		return u, t, false
+	}
+
+	return u, t, true
+}
+
+// currentUser returns the user authenticated by requireAuth, if any
+func currentUser(r *http.Request) *user {
+	u, ok := r.Context().Value(userContextKey).(*user)
+	if !ok {
+		return nil
+	}
+	return u
+}
+
+func currentToken(r *http.Request) *token {
+	t, ok := r.Context().Value(tokenContextKey).(*token)
+	if !ok {
+		return nil
+	}
+	return t
+}
+
+// withUser attaches the authenticated user and token to the request context
+func withUser(r *http.Request, u user, t token) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, &u)
+	ctx = context.WithValue(ctx, tokenContextKey, &t)
+	return r.WithContext(ctx)
+}
+
+// requireAuth rejects requests without a valid bearer token and makes the
+// user available to the next handler through currentUser
+func requireAuth(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, t, ok := authenticate(db, r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthenticated")
+			return
+		}
+
+		next(w, withUser(r, u, t))
+	}
+}
+
+// optionalAuth makes the user available through currentUser when the request
+// carries a valid bearer token, guests are passed through untouched
+func optionalAuth(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, t, ok := authenticate(db, r)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		next(w, withUser(r, u, t))
+	}
+}
+
+// canManage reports whether the viewer may change the user's account, which
+// is limited to the user themselves and administrators
+func canManage(viewer *user, u user) bool {
+	return viewer != nil && (viewer.Admin || viewer.ID == u.ID)
+}
+
+// requireAdmin is requireAuth for endpoints only administrators may use
+func requireAdmin(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return requireAuth(db, func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).Admin {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// sessionStoreRules are the rules for signing in
+var sessionStoreRules = govalidator.MapData{
+	"email":    []string{"required", "email"},
+	"password": []string{"required"},
+}
+
+func sessionsStore(db *gorm.DB) http.HandlerFunc {
+	type sessionStoreRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	type sessionStoreResponse struct {
+		Token string `json:"token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := sessionStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: sessionStoreRules,
+		})
+		stop := startPhase(r, "validation")
+		e := v.ValidateStruct()
+		stop()
+		if len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		tx := dbFor(r, db)
+		u := user{}
+		email := normalizeEmail(req.Email)
+		found := !tx.Where("lower(email) = ?", strings.ToLower(email)).First(&u).RecordNotFound()
+		stop = startPhase(r, "hashing")
+		matches := found && bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.Password)) == nil
+		stop()
+		if !matches {
+			recordAuthEvent(tx, r, authEventLoginFailed, u.ID, email)
+			writeError(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+
+		if u.EraseAfter != nil {
+			writeError(w, http.StatusForbidden, "account is scheduled for deletion")
+			return
+		}
+
+		plain, err := issueToken(tx, u)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		recordLogin(tx, r, u)
+		recordAuthEvent(tx, r, authEventLogin, u.ID, u.Email)
+		recordAuthEvent(tx, r, authEventTokenCreated, u.ID, u.Email)
+
+		writeJSON(w, http.StatusCreated, sessionStoreResponse{Token: plain})
+	}
+}
+
+// recordLogin stamps the user with when, where, and from what device they
+// last logged in, without touching updated_at
+func recordLogin(db *gorm.DB, r *http.Request, u user) {
+	db.Model(&u).UpdateColumns(map[string]interface{}{
+		"last_login_at":     time.Now(),
+		"last_login_ip":     clientIP(r),
+		"last_login_device": describeUserAgent(r.UserAgent()),
+	})
+}
+
+func sessionsDestroy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := currentUser(r)
+		tx := dbFor(r, db)
+		tx.Model(currentToken(r)).Update("revoked_at", time.Now())
+		recordAuthEvent(tx, r, authEventLogout, u.ID, u.Email)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// bcryptCost is the work factor passwords are hashed with, api doctor
+// recommends one for the machine
+var bcryptCost = bcrypt.MinCost
+
+// passwordUpdateRules are the rules for changing a password
+var passwordUpdateRules = govalidator.MapData{
+	"current_password": []string{"required"},
+	"password":         []string{"required", "min:8", "max:255"},
+}
+
+func passwordUpdate(db *gorm.DB) http.HandlerFunc {
+	type passwordUpdateRequest struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := passwordUpdateRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: passwordUpdateRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		u := currentUser(r)
+		if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.CurrentPassword)) != nil {
+			writeValidationErrors(w, map[string][]string{
+				"current_password": {"The current password is incorrect"},
+			})
+			return
+		}
+
+		stop := startPhase(r, "hashing")
+		hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
+		stop()
+		tx := dbFor(r, db)
+		tx.Model(u).Update("password", string(hash))
+		recordAuthEvent(tx, r, authEventPasswordChanged, u.ID, u.Email)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUsersCanLoginWithValidCredentials(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("POST", "/login", bytes.NewBuffer([]byte(`{"email":"jason@mccallister.io","password":"somePassword1!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sessionsStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusCreated, status)
+	}
+	resp := struct {
+		Token string `json:"token"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Token == "" {
+		t.Errorf("expected a token to be returned, got %v instead", rr.Body.String())
+	}
+	count := 0
+	db.Model(&token{}).Where("hash = ?", hashToken(resp.Token)).Count(&count)
+	if count != 1 {
+		t.Errorf("expected the hashed token to be stored, found %v tokens", count)
+	}
+}
+
+func TestLoginWithWrongPasswordIsRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("POST", "/login", bytes.NewBuffer([]byte(`{"email":"jason@mccallister.io","password":"wrongPassword"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sessionsStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnauthorized, status)
+	}
+	e := authEvent{}
+	db.Where("kind = ?", authEventLoginFailed).First(&e)
+	if e.UserID != u.ID {
+		t.Errorf("expected a failed login event for user %v, got %v instead", u.ID, e.UserID)
+	}
+}
+
+func TestLogoutRevokesTheToken(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	auth := login(db, u)
+	req, err := http.NewRequest("POST", "/logout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", auth)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAuth(db, sessionsDestroy(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, status)
+	}
+	if _, _, ok := authenticate(db, req); ok {
+		t.Errorf("expected the token to be revoked after logout")
+	}
+}
+
+func TestPasswordUpdateRequiresTheCurrentPassword(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("PUT", "/me/password", bytes.NewBuffer([]byte(`{"current_password":"notMyPassword","password":"newPassword1!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAuth(db, passwordUpdate(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+	count := 0
+	db.Model(&authEvent{}).Where("kind = ?", authEventPasswordChanged).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no password change to be recorded, found %v", count)
+	}
+}
+
+func TestRequestsWithoutATokenAreUnauthorized(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	req, err := http.NewRequest("PUT", "/me/password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAuth(db, passwordUpdate(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnauthorized, status)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// avatarTypes are the image types accepted as avatars, by sniffed content
+// type rather than the one the client claims
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// avatarSizes are the square variants generated for every avatar that can be
+// decoded, WebP uploads are kept as they are since only the standard library
+// decoders are available
+var avatarSizes = []int{64, 128, 512}
+
+// maxAvatarPixels guards against images that are small on disk but huge
+// once decoded
+const maxAvatarPixels = 4096 * 4096
+
+// avatarUpdate stores the image in the avatar field of a multipart upload and
+// points the user's avatar_url at it
+func avatarUpdate(db *gorm.DB, store storage, maxBytes int64) http.HandlerFunc {
+	type avatarUpdateResponse struct {
+		User interface{} `json:"user"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// leave room for the multipart boundaries and headers
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+		if err := r.ParseMultipartForm(maxBytes); err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the avatar must be a multipart upload of at most %v bytes", maxBytes))
+			return
+		}
+
+		file, header, err := r.FormFile("avatar")
+		if err != nil {
+			writeValidationErrors(w, map[string][]string{"avatar": {"The avatar field is required"}})
+			return
+		}
+		defer file.Close()
+		if header.Size > maxBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the avatar must be at most %v bytes", maxBytes))
+			return
+		}
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			writeValidationErrors(w, map[string][]string{"avatar": {"The avatar could not be read"}})
+			return
+		}
+		head = head[:n]
+		ext, ok := avatarTypes[http.DetectContentType(head)]
+		if !ok {
+			writeError(w, http.StatusUnsupportedMediaType, "the avatar must be a PNG, JPEG, GIF, or WebP image")
+			return
+		}
+
+		rest, err := ioutil.ReadAll(file)
+		if err != nil {
+			writeValidationErrors(w, map[string][]string{"avatar": {"The avatar could not be read"}})
+			return
+		}
+		data := append(head, rest...)
+
+		var img image.Image
+		if ext != ".webp" {
+			if img, err = decodeAvatar(data); err != nil {
+				writeValidationErrors(w, map[string][]string{"avatar": {err.Error()}})
+				return
+			}
+		}
+
+		// the name is random so the public URL says nothing about who
+		// uploaded it and can't be guessed from another user's
+		base, err := randomToken()
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		u := currentUser(r)
+		url, err := store.put(base+ext, bytes.NewReader(data))
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		variants := map[string]string{}
+		if img != nil {
+			if variants, err = storeAvatarVariants(store, base, img); err != nil {
+				logError(r, err)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
+
+		stored := append(avatarURLs(variants), url)
+		replaced := append(avatarURLs(avatarVariantURLs(*u)), u.AvatarURL)
+		encoded, _ := json.Marshal(variants)
+		if err := dbFor(r, db).Model(u).Updates(map[string]interface{}{"avatar_url": url, "avatar_variants": string(encoded)}).Error; err != nil {
+			logError(r, err)
+			removeAvatars(r, store, stored)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		// the old files go only once nothing points at them any more
+		removeAvatars(r, store, replaced)
+
+		writeJSON(w, http.StatusOK, avatarUpdateResponse{User: presentUser(dbFor(r, db), *u, u)})
+	}
+}
+
+// avatarURLs lists the URLs of the variants
+func avatarURLs(variants map[string]string) []string {
+	urls := make([]string, 0, len(variants))
+	for _, url := range variants {
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// removeAvatars deletes the stored avatar files, a file left behind only
+// costs space so failures are logged rather than failing the request
+func removeAvatars(r *http.Request, store storage, urls []string) {
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		if err := store.remove(url); err != nil {
+			logError(r, err)
+		}
+	}
+}
+
+// decodeAvatar decodes the image after checking its dimensions
+func decodeAvatar(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("The avatar could not be decoded")
+	}
+	if cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, fmt.Errorf("The avatar must be at most %v pixels", maxAvatarPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("The avatar could not be decoded")
+	}
+	return img, nil
+}
+
+// storeAvatarVariants stores a JPEG of every avatar size and returns their
+// URLs keyed by size
+func storeAvatarVariants(store storage, base string, img image.Image) (map[string]string, error) {
+	variants := map[string]string{}
+	for _, size := range avatarSizes {
+		buf := &bytes.Buffer{}
+		if err := jpeg.Encode(buf, resizeSquare(img, size), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		url, err := store.put(fmt.Sprintf("%v-%v.jpg", base, size), buf)
+		if err != nil {
+			return nil, err
+		}
+		variants[strconv.Itoa(size)] = url
+	}
+	return variants, nil
+}
+
+// resizeSquare crops the middle square out of the image and scales it to
+// size by averaging the source pixels each destination pixel covers
+func resizeSquare(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0, sy1 := y0+y*side/size, y0+(y+1)*side/size
+		if sy1 == sy0 {
+			sy1++
+		}
+		for x := 0; x < size; x++ {
+			sx0, sx1 := x0+x*side/size, x0+(x+1)*side/size
+			if sx1 == sx0 {
+				sx1++
+			}
+
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// gravatarFallback offers the Gravatar of users without an avatar, it is off
+// by default since the hash identifies the email to anyone who has it
+var gravatarFallback = false
+
+// gravatarURL returns the Gravatar for the email, which Gravatar expects
+// hashed in lower case regardless of how the email is stored
+func gravatarURL(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?d=identicon"
+}
+
+// avatarVariantURLs returns the stored avatar variants keyed by size
+func avatarVariantURLs(u user) map[string]string {
+	variants := map[string]string{}
+	if strings.TrimSpace(u.AvatarVariants) != "" {
+		json.Unmarshal([]byte(u.AvatarVariants), &variants)
+	}
+	return variants
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pngHeader is enough of a PNG for content sniffing to recognise it, but not
+// for decoding it
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// encodePNG returns a real width by height PNG
+func encodePNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func avatarRequest(t *testing.T, bearer string, contents []byte) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(contents)
+	mw.Close()
+
+	req := httptest.NewRequest("PUT", "/me/avatar", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", bearer)
+	return req
+}
+
+func TestAvatarsCanBeUploaded(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "me@example.com", "somePassword1!")
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rr := httptest.NewRecorder()
+	handler := requireAuth(db, avatarUpdate(db, newLocalStorage(dir, "/avatars"), 4096))
+	upload := encodePNG(t, 30, 20)
+
+	// Act
+	handler.ServeHTTP(rr, avatarRequest(t, login(db, u), upload))
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("expected the status code to be %v, got %v instead: %v", http.StatusOK, status, rr.Body.String())
+	}
+	resp := struct {
+		User publicUser `json:"user"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if !strings.HasPrefix(resp.User.AvatarURL, "/avatars/") || !strings.HasSuffix(resp.User.AvatarURL, ".png") {
+		t.Errorf("expected the avatar url to point at the upload, got %q instead", resp.User.AvatarURL)
+	}
+	if strings.HasPrefix(resp.User.AvatarURL, "/avatars/1-") {
+		t.Errorf("expected the avatar url not to give away the user's key, got %q instead", resp.User.AvatarURL)
+	}
+	stored, _ := ioutil.ReadFile(filepath.Join(dir, filepath.Base(resp.User.AvatarURL)))
+	if !bytes.Equal(stored, upload) {
+		t.Errorf("expected the upload to be stored as is, got %q instead", stored)
+	}
+	for _, size := range []int{64, 128, 512} {
+		variant, err := os.Open(filepath.Join(dir, filepath.Base(resp.User.Avatars[strconv.Itoa(size)])))
+		if err != nil {
+			t.Errorf("expected a %v pixel variant to be stored, got %v instead", size, err)
+			continue
+		}
+		cfg, format, _ := image.DecodeConfig(variant)
+		variant.Close()
+		if format != "jpeg" || cfg.Width != size || cfg.Height != size {
+			t.Errorf("expected a %v pixel square JPEG, got a %vx%v %v instead", size, cfg.Width, cfg.Height, format)
+		}
+	}
+	db.First(&u, u.ID)
+	if u.AvatarURL != resp.User.AvatarURL || len(avatarVariantURLs(u)) != 3 {
+		t.Errorf("expected the avatar to be saved on the user, got %q and %q instead", u.AvatarURL, u.AvatarVariants)
+	}
+}
+
+func TestReplacedAvatarsAreRemoved(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "me@example.com", "somePassword1!")
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	handler := requireAuth(db, avatarUpdate(db, newLocalStorage(dir, "/avatars"), 4096))
+	bearer := login(db, u)
+	handler.ServeHTTP(httptest.NewRecorder(), avatarRequest(t, bearer, encodePNG(t, 30, 20)))
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), avatarRequest(t, bearer, encodePNG(t, 20, 30)))
+
+	// Assert
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1+len(avatarSizes) {
+		t.Errorf("expected only the latest avatar and its variants to be kept, got %v files instead", len(files))
+	}
+	db.First(&u, u.ID)
+	if _, err := os.Stat(filepath.Join(dir, filepath.Base(u.AvatarURL))); err != nil {
+		t.Errorf("expected the latest avatar to be kept, got %v instead", err)
+	}
+}
+
+func TestAvatarUploadsAreValidated(t *testing.T) {
+	tests := map[string]struct {
+		contents []byte
+		expected int
+	}{
+		"text":      {[]byte("definitely not an image"), http.StatusUnsupportedMediaType},
+		"corrupt":   {pngHeader, http.StatusUnprocessableEntity},
+		"too large": {append(append([]byte{}, pngHeader...), make([]byte, 2048)...), http.StatusRequestEntityTooLarge},
+	}
+
+	for name, tt := range tests {
+		// Arrange
+		dir, err := ioutil.TempDir("", "avatars")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, rollback := testTx(t)
+		u := createUser(db, "me@example.com", "somePassword1!")
+		rr := httptest.NewRecorder()
+		handler := requireAuth(db, avatarUpdate(db, newLocalStorage(dir, "/avatars"), 1024))
+
+		// Act
+		handler.ServeHTTP(rr, avatarRequest(t, login(db, u), tt.contents))
+		rollback()
+
+		// Assert
+		if status := rr.Code; status != tt.expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", name, tt.expected, status)
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+			t.Errorf("expected nothing to be stored for %v, got %v files instead", name, len(files))
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestResizeSquareCropsTheMiddleAndAverages(t *testing.T) {
+	// Arrange
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 80), A: 0xff})
+		}
+	}
+
+	// Act
+	resized := resizeSquare(img, 1)
+
+	// Assert
+	if c := resized.RGBAAt(0, 0); c.R != 120 || c.A != 0xff {
+		t.Errorf("expected the middle two columns to be averaged, got %+v instead", c)
+	}
+}
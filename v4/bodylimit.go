@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// defaultMaxBodyBytes is the most a request body can hold unless the route
+// or MAX_BODY_BYTES says otherwise
+const defaultMaxBodyBytes = 1 << 20
+
+// limitBody rejects request bodies over max bytes with a 413. Bodies that
+// announce their length are turned away before the handler runs, for the
+// rest the handler's error about the cut off body is swapped for the 413.
+func limitBody(max int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body must be at most %v bytes", max))
+			return
+		}
+
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, max)}
+		r.Body = body
+		next(&limitedBodyWriter{ResponseWriter: w, body: body, max: max}, r)
+	}
+}
+
+// limitedBody notes when the handler read past the limit
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	// MaxBytesReader has no error type of its own to check for
+	if err != nil && err.Error() == "http: request body too large" {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limitedBodyWriter answers with a 413 instead of the client error the
+// handler sends after reading past the limit
+type limitedBodyWriter struct {
+	http.ResponseWriter
+	body     *limitedBody
+	max      int64
+	replaced bool
+}
+
+func (lw *limitedBodyWriter) WriteHeader(status int) {
+	if lw.body.exceeded && status >= 400 && status < 500 {
+		lw.replaced = true
+		writeError(lw.ResponseWriter, http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body must be at most %v bytes", lw.max))
+		return
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *limitedBodyWriter) Write(b []byte) (int, error) {
+	if lw.replaced {
+		return len(b), nil
+	}
+	return lw.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through so streamed responses aren't held back
+func (lw *limitedBodyWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLargeBodiesAreRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodPost, path: "/users", maxBody: 64, handler: usersStore(db)},
+	})
+	big := `{"email":"big@example.com","password":"somePassword1!","name":"` + strings.Repeat("a", 100) + `"}`
+	announced := httptest.NewRequest("POST", "/users", strings.NewReader(big))
+	chunked := httptest.NewRequest("POST", "/users", strings.NewReader(big))
+	chunked.ContentLength = -1
+	tests := map[string]*http.Request{"announced": announced, "chunked": chunked}
+
+	for name, req := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		rt.ServeHTTP(rr, req)
+
+		// Assert
+		p := problem{}
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if rr.Code != http.StatusRequestEntityTooLarge || p.Code != "request_entity_too_large" {
+			t.Errorf("expected the %v body to be rejected as too large, got %v %v instead", name, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestBodiesWithinTheLimitAreRead(t *testing.T) {
+	// Arrange
+	h := limitBody(64, func(w http.ResponseWriter, r *http.Request) {
+		v := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	})
+	rr := httptest.NewRecorder()
+
+	// Act
+	h(rr, httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"Norfolk Gophers"}`)))
+
+	// Assert
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"name":"Norfolk Gophers"}` {
+		t.Errorf("expected the body to be read, got %v %v instead", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLimitedBodiesStillFlush(t *testing.T) {
+	// Arrange
+	h := limitBody(64, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("expected the response writer to flush")
+		}
+	})
+
+	// Act
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/users/export", nil))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxBulkDelete caps how many users one bulk delete may touch
+const maxBulkDelete = 100
+
+// usersBulkDestroy soft deletes the users whose IDs are in the JSON array
+// body in one transaction, applying the same cascade as deleting one
+func usersBulkDestroy(db *gorm.DB, cascade cascadePolicy) http.HandlerFunc {
+	type usersBulkDestroyResponse struct {
+		Deleted  []apiID `json:"deleted"`
+		NotFound []apiID `json:"not_found"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids := []apiID{}
+		if err := decodeJSON(r, &ids); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		if len(ids) == 0 || len(ids) > maxBulkDelete {
+			writeValidationErrors(w, map[string][]string{"ids": {fmt.Sprintf("Between 1 and %v IDs can be deleted at once", maxBulkDelete)}})
+			return
+		}
+
+		resp := usersBulkDestroyResponse{Deleted: []apiID{}, NotFound: []apiID{}}
+		seen := map[apiID]bool{}
+		now := time.Now()
+		err := transaction(dbFor(r, db), func(tx *gorm.DB) error {
+			for _, id := range ids {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				u := user{}
+				if id.where(tx).First(&u).RecordNotFound() {
+					resp.NotFound = append(resp.NotFound, id)
+					continue
+				}
+				if err := tx.Delete(&u).Error; err != nil {
+					return err
+				}
+				if err := cascade.apply(tx, []uint{u.ID}, now); err != nil {
+					return err
+				}
+				resp.Deleted = append(resp.Deleted, idOf(u))
+			}
+			return nil
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUsersCanBeDeletedInBulk(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	one := createUser(db, "one@example.com", "somePassword1!")
+	two := createUser(db, "two@example.com", "somePassword1!")
+	keep := createUser(db, "keep@example.com", "somePassword1!")
+	login(db, one)
+	body := fmt.Sprintf("[%v, %v, 99, %v]", one.ID, two.ID, one.ID)
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersBulkDestroy(db, cascadePolicy{"sessions"}).ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/users", strings.NewReader(body)))
+
+	// Assert
+	resp := struct {
+		Deleted  []apiID `json:"deleted"`
+		NotFound []apiID `json:"not_found"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if !reflect.DeepEqual(resp.Deleted, []apiID{{Key: one.ID}, {Key: two.ID}}) || !reflect.DeepEqual(resp.NotFound, []apiID{{Key: 99}}) {
+		t.Errorf("expected the deleted and missing IDs to be reported, got %v instead", rr.Body.String())
+	}
+	remaining := []uint{}
+	db.Model(&user{}).Pluck("id", &remaining)
+	if !reflect.DeepEqual(remaining, []uint{keep.ID}) {
+		t.Errorf("expected only the kept user to remain, got %v instead", remaining)
+	}
+	active := 0
+	db.Model(&token{}).Where("revoked_at IS NULL").Count(&active)
+	if active != 0 {
+		t.Errorf("expected the cascade to revoke the sessions, got %v active instead", active)
+	}
+}
+
+func TestBulkDeletesAreCapped(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	ids := make([]string, maxBulkDelete+1)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i + 1)
+	}
+	tests := map[string]int{
+		"[]":                               http.StatusUnprocessableEntity,
+		"[" + strings.Join(ids, ",") + "]": http.StatusUnprocessableEntity,
+		`{"ids":[1]}`:                      http.StatusBadRequest,
+	}
+
+	for body, expected := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		usersBulkDestroy(db, cascadePolicy{}).ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/users", strings.NewReader(body)))
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code to be %v, got %v instead", expected, status)
+		}
+	}
+}
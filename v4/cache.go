@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheVary are the request headers the cached responses differ by, on top
+// of the path and query string
+var cacheVary = []string{"Accept", "Accept-Language"}
+
+// responseCache keeps guest responses of a list endpoint for ttl, once they
+// expire they are still served for up to stale while a background request
+// refreshes them, so only the first request ever waits on the database.
+// At most maxEntries responses are kept, the oldest makes room for a new one.
+type responseCache struct {
+	ttl        time.Duration
+	stale      time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu         sync.Mutex
+	entries    map[string]cachedResponse
+	refreshing map[string]bool
+}
+
+type cachedResponse struct {
+	status   int
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+}
+
+func newResponseCache(ttl, stale time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		ttl:        ttl,
+		stale:      stale,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]cachedResponse{},
+		refreshing: map[string]bool{},
+	}
+}
+
+// wrap serves the handler through the cache, requests from signed in users
+// see fields guests don't so they always go straight to the handler, as do
+// requests for a tenant since the cache is shared by every tenant. Responses
+// vary by Authorization so shared caches never hand a signed in user the
+// public guest response, or a guest a signed in user's.
+func (c *responseCache) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+		if c.ttl == 0 || r.Method != http.MethodGet || currentUser(r) != nil || tenantDBFrom(r) != nil {
+			next(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		c.mu.Unlock()
+		age := c.now().Sub(entry.storedAt)
+
+		switch {
+		case ok && age < c.ttl:
+			c.serve(w, entry, age, "HIT")
+		case ok && age < c.ttl+c.stale:
+			c.serve(w, entry, age, "STALE")
+			if c.startRefresh(key) {
+				go c.refresh(key, r, next)
+			}
+		default:
+			entry := c.fill(key, r, next)
+			if entry.status != http.StatusOK {
+				c.write(w, entry)
+				return
+			}
+			c.serve(w, entry, 0, "MISS")
+		}
+	}
+}
+
+// fill runs the handler and stores the response when it succeeded, the
+// response is returned either way
+func (c *responseCache) fill(key string, r *http.Request, next http.HandlerFunc) cachedResponse {
+	rec := newResponseRecorder()
+	next(rec, r)
+	entry := cachedResponse{status: rec.status, header: rec.header, body: rec.body.Bytes(), storedAt: c.now()}
+	if entry.status != http.StatusOK {
+		return entry
+	}
+
+	c.mu.Lock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	return entry
+}
+
+// evictOldest drops the entry stored longest ago, the lock must be held
+func (c *responseCache) evictOldest() {
+	oldest, oldestAt := "", time.Time{}
+	for key, entry := range c.entries {
+		if oldest == "" || entry.storedAt.Before(oldestAt) {
+			oldest, oldestAt = key, entry.storedAt
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// cacheKey identifies the response to a request, the path is taken from
+// the request line so /v1/users and /users keep their own Link headers
+func cacheKey(r *http.Request) string {
+	parts := []string{pageURL(r, nil)}
+	for _, name := range cacheVary {
+		parts = append(parts, r.Header.Get(name))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// startRefresh claims the refresh of the key so a burst of stale hits only
+// triggers one
+func (c *responseCache) startRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+// refresh runs the handler again with a copy of the request that served the
+// stale response, outliving it
+func (c *responseCache) refresh(key string, r *http.Request, next http.HandlerFunc) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	}()
+
+	c.fill(key, r.Clone(detachedContext{r.Context()}), next)
+}
+
+// detachedContext keeps the values of a request's context without being
+// cancelled when the request is done. The request's access log line and
+// timings are written by then, so they are left out.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	if key == accessEntryContextKey || key == timingsContextKey {
+		return nil
+	}
+	return c.Context.Value(key)
+}
+
+// serve writes a cached response along with how old it is
+func (c *responseCache) serve(w http.ResponseWriter, entry cachedResponse, age time.Duration, state string) {
+	w.Header().Set("Age", strconv.Itoa(int(age/time.Second)))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v, stale-while-revalidate=%v", int(c.ttl/time.Second), int(c.stale/time.Second)))
+	w.Header().Set("X-Cache", state)
+	c.write(w, entry)
+}
+
+func (c *responseCache) write(w http.ResponseWriter, entry cachedResponse) {
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// responseRecorder captures a response so it can be cached
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	return rr.body.Write(b)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingHandler responds with how many times it has been called
+func countingHandler(calls *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Write([]byte{byte('0' + *calls)})
+	}
+}
+
+// waitForRefreshes blocks until the background refreshes have finished
+func waitForRefreshes(c *responseCache) {
+	for i := 0; i < 1000; i++ {
+		c.mu.Lock()
+		busy := len(c.refreshing) > 0
+		c.mu.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFreshResponsesAreServedFromTheCache(t *testing.T) {
+	// Arrange
+	calls := 0
+	c := newResponseCache(time.Minute, time.Minute, 100)
+	handler := c.wrap(countingHandler(&calls))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users?page=1", nil))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?page=1", nil))
+
+	// Assert
+	if calls != 1 {
+		t.Errorf("expected the handler to be called once, got %v instead", calls)
+	}
+	if state := rr.Header().Get("X-Cache"); state != "HIT" {
+		t.Errorf("expected a cache hit, got %v instead", state)
+	}
+	if body := rr.Body.String(); body != "1" {
+		t.Errorf("expected the cached body, got %v instead", body)
+	}
+}
+
+func TestStaleResponsesAreServedWhileTheyRefresh(t *testing.T) {
+	// Arrange
+	calls := 0
+	now := time.Now()
+	c := newResponseCache(time.Minute, time.Minute, 100)
+	c.now = func() time.Time { return now }
+	handler := c.wrap(countingHandler(&calls))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	now = now.Add(90 * time.Second)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+
+	// Assert
+	if state := rr.Header().Get("X-Cache"); state != "STALE" {
+		t.Errorf("expected a stale response, got %v instead", state)
+	}
+	if age := rr.Header().Get("Age"); age != "90" {
+		t.Errorf("expected the age to be 90, got %v instead", age)
+	}
+	if body := rr.Body.String(); body != "1" {
+		t.Errorf("expected the stale body, got %v instead", body)
+	}
+	waitForRefreshes(c)
+	fresh := httptest.NewRecorder()
+	handler.ServeHTTP(fresh, httptest.NewRequest("GET", "/users", nil))
+	if body := fresh.Body.String(); body != "2" {
+		t.Errorf("expected the refreshed body, got %v instead", body)
+	}
+}
+
+func TestSignedInUsersSkipTheCache(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "cached@example.com", "somePassword1!")
+	calls := 0
+	handler := optionalAuth(db, newResponseCache(time.Minute, time.Minute, 100).wrap(countingHandler(&calls)))
+
+	// Act
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("Authorization", login(db, u))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	if calls != 2 {
+		t.Errorf("expected the handler to be called for every request, got %v calls instead", calls)
+	}
+}
+
+func TestPublicResponsesVaryByAuthorization(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "cached@example.com", "somePassword1!")
+	calls := 0
+	handler := optionalAuth(db, newResponseCache(time.Minute, time.Minute, 100).wrap(countingHandler(&calls)))
+	signedIn := httptest.NewRequest("GET", "/users", nil)
+	signedIn.Header.Set("Authorization", login(db, u))
+
+	// Act
+	guest := httptest.NewRecorder()
+	handler.ServeHTTP(guest, httptest.NewRequest("GET", "/users", nil))
+	member := httptest.NewRecorder()
+	handler.ServeHTTP(member, signedIn)
+
+	// Assert
+	for _, rr := range []*httptest.ResponseRecorder{guest, member} {
+		if vary := rr.Header().Get("Vary"); vary != "Authorization" {
+			t.Errorf("expected the response to vary by Authorization, got %q instead", vary)
+		}
+	}
+	if cc := member.Header().Get("Cache-Control"); strings.Contains(cc, "public") {
+		t.Errorf("expected the signed in response not to be public, got %q instead", cc)
+	}
+}
+
+func TestResponsesAreCachedByPathAndNegotiatedHeaders(t *testing.T) {
+	// Arrange
+	calls := 0
+	handler := newResponseCache(time.Minute, time.Minute, 100).wrap(countingHandler(&calls))
+	spanish := httptest.NewRequest("GET", "/users", nil)
+	spanish.Header.Set("Accept-Language", "es")
+	xml := httptest.NewRequest("GET", "/users", nil)
+	xml.Header.Set("Accept", "application/xml")
+	requests := []*http.Request{httptest.NewRequest("GET", "/users", nil), httptest.NewRequest("GET", "/v1/users", nil), spanish, xml}
+
+	// Act
+	for _, req := range requests {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	if calls != len(requests) {
+		t.Errorf("expected every request to get its own entry, got %v calls instead", calls)
+	}
+}
+
+func TestTheOldestResponseMakesRoom(t *testing.T) {
+	// Arrange
+	calls := 0
+	now := time.Now()
+	c := newResponseCache(time.Minute, time.Minute, 2)
+	c.now = func() time.Time { return now }
+	handler := c.wrap(countingHandler(&calls))
+
+	// Act
+	for _, target := range []string{"/users?page=1", "/users?page=2", "/users?page=3"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+		now = now.Add(time.Second)
+	}
+
+	// Assert
+	if len(c.entries) != 2 {
+		t.Errorf("expected 2 entries to be kept, got %v instead", len(c.entries))
+	}
+	if _, ok := c.entries[cacheKey(httptest.NewRequest("GET", "/users?page=1", nil))]; ok {
+		t.Errorf("expected the oldest entry to be evicted")
+	}
+}
+
+func TestRefreshesKeepTheRequestHeaders(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	c := newResponseCache(time.Minute, time.Minute, 100)
+	c.now = func() time.Time { return now }
+	languages := make(chan string, 2)
+	handler := c.wrap(func(w http.ResponseWriter, r *http.Request) {
+		languages <- r.Header.Get("Accept-Language")
+	})
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept-Language", "es")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	now = now.Add(90 * time.Second)
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	waitForRefreshes(c)
+
+	// Assert
+	<-languages
+	if got := <-languages; got != "es" {
+		t.Errorf("expected the refresh to ask for es, got %q instead", got)
+	}
+}
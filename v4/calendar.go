@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// meetupLength is how long a meetup is shown as lasting in calendars, since
+// meetups only have a start
+const meetupLength = 2 * time.Hour
+
+// icsEscaper escapes the characters RFC 5545 reserves in text values
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// calendarEvent is a meetup as it appears in a calendar, tentative when the
+// viewer is only on the waitlist. A recurring meetup carries the changes made
+// to single occurrences.
+type calendarEvent struct {
+	meetup    meetup
+	tentative bool
+	changes   []meetupOccurrence
+}
+
+// loadChanges attaches the changed and cancelled occurrences of the
+// recurring meetups to their events
+func loadChanges(db *gorm.DB, events []calendarEvent) {
+	ids := []uint{}
+	for _, e := range events {
+		if e.meetup.Recurrence != "" {
+			ids = append(ids, e.meetup.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	changes := []meetupOccurrence{}
+	db.Where("meetup_id IN (?)", ids).Order("occurs_at asc").Find(&changes)
+	for i := range events {
+		for _, o := range changes {
+			if o.MeetupID == events[i].meetup.ID {
+				events[i].changes = append(events[i].changes, o)
+			}
+		}
+	}
+}
+
+// writeCalendar writes the events as an iCalendar document, lines are folded
+// at 75 octets and end with CRLF as RFC 5545 requires
+func writeCalendar(w io.Writer, host, name string, events []calendarEvent) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Norfolk Gophers//Meetups//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icsEscaper.Replace(name),
+	}
+	for _, e := range events {
+		status := "CONFIRMED"
+		if e.tentative {
+			status = "TENTATIVE"
+		}
+		if e.meetup.CancelledAt != nil {
+			status = "CANCELLED"
+		}
+		uid := fmt.Sprintf("UID:meetup-%v@%v", e.meetup.ID, host)
+		lines = append(lines, "BEGIN:VEVENT", uid)
+		lines = append(lines, eventLines(e.meetup, e.meetup.UpdatedAt, e.meetup.StartsAt)...)
+		if e.meetup.Recurrence != "" {
+			lines = append(lines, "RRULE:"+e.meetup.Recurrence)
+		}
+		for _, o := range e.changes {
+			if o.Cancelled {
+				lines = append(lines, "EXDATE:"+icsTime(o.OccursAt))
+			}
+		}
+		lines = append(lines, "STATUS:"+status, "END:VEVENT")
+
+		// a changed occurrence overrides the one the rule generates
+		for _, o := range e.changes {
+			if o.Cancelled {
+				continue
+			}
+			occurrence := presentOccurrence(e.meetup, o.OccursAt, o)
+			m := e.meetup
+			m.Title, m.Description, m.Location = occurrence.Title, occurrence.Description, occurrence.Location
+			lines = append(lines, "BEGIN:VEVENT", uid, "RECURRENCE-ID:"+icsTime(o.OccursAt))
+			lines = append(lines, eventLines(m, o.UpdatedAt, occurrence.StartsAt)...)
+			lines = append(lines, "STATUS:"+status, "END:VEVENT")
+		}
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, foldLine(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventLines are the properties describing when and what a meetup is
+func eventLines(m meetup, stamp, start time.Time) []string {
+	lines := []string{
+		"DTSTAMP:" + icsTime(stamp),
+		"DTSTART:" + icsTime(start),
+		"DTEND:" + icsTime(start.Add(meetupLength)),
+		"SUMMARY:" + icsEscaper.Replace(m.Title),
+	}
+	if m.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+icsEscaper.Replace(m.Description))
+	}
+	if m.Location != "" {
+		lines = append(lines, "LOCATION:"+icsEscaper.Replace(m.Location))
+	}
+	return lines
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// foldLine splits a content line longer than 75 octets into continuation
+// lines starting with a space, without splitting a UTF-8 character
+func foldLine(line string) string {
+	if len(line) <= 75 {
+		return line
+	}
+
+	b := strings.Builder{}
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space counts towards the next line
+		limit = 74
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+func writeICS(w http.ResponseWriter, r *http.Request, name string, events []calendarEvent) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := writeCalendar(w, r.Host, name, events); err != nil {
+		logError(r, err)
+	}
+}
+
+// meetupsCalendar serves a single meetup as an iCalendar file
+func meetupsCalendar(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		events := []calendarEvent{{meetup: m}}
+		loadChanges(tx, events)
+		writeICS(w, r, m.Title, events)
+	}
+}
+
+// calendarFeed serves the meetups the user (co-)organizes or RSVPed to as a
+// calendar. Calendar apps can't send a bearer token, so a subscription
+// authenticates with the feed token from POST /me/calendar/token in the
+// query string instead.
+func calendarFeed(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		u := user{}
+		if plain := r.URL.Query().Get("token"); plain != "" {
+			if tx.Where("calendar_token_hash = ?", hashToken(plain)).First(&u).RecordNotFound() {
+				writeError(w, http.StatusUnauthorized, "unauthenticated")
+				return
+			}
+		} else {
+			var ok bool
+			if u, _, ok = authenticate(db, r); !ok {
+				writeError(w, http.StatusUnauthorized, "unauthenticated")
+				return
+			}
+		}
+
+		rsvps := []rsvp{}
+		tx.Where("user_id = ?", u.ID).Find(&rsvps)
+		statuses := make(map[uint]string, len(rsvps))
+		ids := make([]uint, len(rsvps))
+		for i, rv := range rsvps {
+			statuses[rv.MeetupID] = rv.Status
+			ids[i] = rv.MeetupID
+		}
+		coOrganized := []uint{}
+		tx.Model(&meetupOrganizer{}).Where("user_id = ?", u.ID).Pluck("meetup_id", &coOrganized)
+		ids = append(ids, coOrganized...)
+
+		meetups := []meetup{}
+		tx.Where("organizer_id = ? OR id IN (?)", u.ID, ids).Order("starts_at asc").Find(&meetups)
+		events := make([]calendarEvent, len(meetups))
+		for i, m := range meetups {
+			events[i] = calendarEvent{meetup: m, tentative: m.OrganizerID != u.ID && statuses[m.ID] == rsvpWaitlisted}
+		}
+		loadChanges(tx, events)
+
+		writeICS(w, r, "Meetups", events)
+	}
+}
+
+// calendarTokenStore issues a new feed token for the signed in user, the
+// previous one stops working so a leaked feed URL can be revoked
+func calendarTokenStore(db *gorm.DB) http.HandlerFunc {
+	type calendarTokenResponse struct {
+		URL string `json:"url"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		plain, err := randomToken()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		if err := dbFor(r, db).Model(currentUser(r)).UpdateColumn("calendar_token_hash", hashToken(plain)).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, calendarTokenResponse{URL: "/me/calendar.ics?token=" + plain})
+	}
+}
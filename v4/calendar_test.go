@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMeetupsCanBeDownloadedAsICalendar(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Go, TDD; and REST", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Model(&m).Update("description", strings.Repeat("Testing all the things. ", 5))
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/meetups/{id}.ics", meetupsCalendar(db))
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v.ics", m.ID), nil))
+
+	// Assert
+	if rr.Header().Get("Content-Type") != "text/calendar; charset=utf-8" {
+		t.Errorf("expected an iCalendar content type, got %v instead", rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		fmt.Sprintf("UID:meetup-%v@example.com\r\n", m.ID),
+		"DTSTART:20191015T183000Z\r\n",
+		"DTEND:20191015T203000Z\r\n",
+		`SUMMARY:Go\, TDD\; and REST` + "\r\n",
+		"\r\n ",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the calendar to contain %q, got %q instead", expected, body)
+		}
+	}
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("expected lines to be folded at 75 octets, got %q instead", line)
+		}
+	}
+}
+
+func TestFoldingKeepsCharactersWhole(t *testing.T) {
+	// Arrange
+	line := "SUMMARY:" + strings.Repeat("é", 60)
+
+	// Act
+	folded := foldLine(line)
+
+	// Assert
+	if strings.Replace(folded, "\r\n ", "", -1) != line {
+		t.Errorf("expected unfolding to give back the line, got %q instead", folded)
+	}
+	for _, part := range strings.Split(folded, "\r\n") {
+		if len(part) > 75 || !utf8.ValidString(part) {
+			t.Errorf("expected every part to be whole characters within 75 octets, got %q instead", part)
+		}
+	}
+}
+
+func TestCalendarFeedsAuthenticateWithTheirToken(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	attendee := createUser(db, "attendee@example.com", "somePassword1!")
+	going := createMeetup(db, organizer, "Going", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	waitlisted := createMeetup(db, organizer, "Waitlisted", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	createMeetup(db, organizer, "Not going", time.Date(2019, 12, 17, 18, 30, 0, 0, time.UTC))
+	db.Create(&rsvp{MeetupID: going.ID, UserID: attendee.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: waitlisted.ID, UserID: attendee.ID, Status: rsvpWaitlisted})
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/me/calendar.ics", handler: calendarFeed(db)},
+		{method: http.MethodPost, path: "/me/calendar/token", access: accessUser, handler: calendarTokenStore(db)},
+	})
+	issue := httptest.NewRequest("POST", "/me/calendar/token", nil)
+	issue.Header.Set("Authorization", login(db, attendee))
+	issued := httptest.NewRecorder()
+	rt.ServeHTTP(issued, issue)
+	resp := struct {
+		URL string `json:"url"`
+	}{}
+	json.Unmarshal(issued.Body.Bytes(), &resp)
+	feed := httptest.NewRecorder()
+	guest := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(feed, httptest.NewRequest("GET", resp.URL, nil))
+	rt.ServeHTTP(guest, httptest.NewRequest("GET", "/me/calendar.ics?token=forged", nil))
+
+	// Assert
+	body := feed.Body.String()
+	if feed.Code != http.StatusOK || strings.Count(body, "BEGIN:VEVENT") != 2 || strings.Contains(body, "Not going") {
+		t.Errorf("expected only the meetups the attendee RSVPed to, got %v %q instead", feed.Code, body)
+	}
+	if !strings.Contains(body, "SUMMARY:Waitlisted\r\nSTATUS:TENTATIVE") {
+		t.Errorf("expected the waitlisted meetup to be tentative, got %q instead", body)
+	}
+	if guest.Code != http.StatusUnauthorized {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnauthorized, guest.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// meetupCancelRules are the rules for cancelling a meetup, the reason is
+// passed on to the people who RSVPed
+var meetupCancelRules = govalidator.MapData{
+	"reason": []string{"max:500"},
+}
+
+// meetupsCancel cancels a meetup and emails everyone who RSVPed, going or
+// waitlisted. The meetup and its RSVPs are kept so people can see what
+// happened, nobody can RSVP to it but places freed up still go to the
+// waitlist.
+// Cancelling it again changes nothing, and nobody is emailed twice.
+func meetupsCancel(db *gorm.DB, mail mailer) http.HandlerFunc {
+	type meetupCancelRequest struct {
+		Reason string `json:"reason"`
+	}
+
+	type meetupCancelResponse struct {
+		Meetup meetupResponse `json:"meetup"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		// the body is optional, organizers don't have to give a reason
+		req := meetupCancelRequest{}
+		if r.ContentLength != 0 {
+			if err := decodeJSON(r, &req); err != nil {
+				writeAppError(w, err)
+				return
+			}
+			v := govalidator.New(govalidator.Options{
+				Data:  &req,
+				Rules: meetupCancelRules,
+			})
+			if e := v.ValidateStruct(); len(e) >= 1 {
+				writeValidationErrors(w, e)
+				return
+			}
+		}
+
+		if m.CancelledAt == nil {
+			if err := tx.Model(&m).Where("cancelled_at IS NULL").Update("cancelled_at", time.Now()).Error; err != nil {
+				logError(r, err)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			tx.First(&m, m.ID)
+		}
+		notifyCancelled(tx, mail, m, req.Reason)
+
+		writeJSON(w, http.StatusOK, meetupCancelResponse{Meetup: presentMeetups(tx, []meetup{m})[0]})
+	}
+}
+
+// notifyCancelled emails everyone who RSVPed to the cancelled meetup through
+// the outbox, so retrying a cancellation only reaches the people a failed one
+// missed. Unlike reminders it ignores email_notifications, people who turned
+// them off would otherwise show up to nothing.
+func notifyCancelled(db *gorm.DB, mail mailer, m meetup, reason string) int {
+	ids := []uint{}
+	db.Model(&rsvp{}).Where("meetup_id = ?", m.ID).Pluck("user_id", &ids)
+	if len(ids) == 0 {
+		return 0
+	}
+	users := []user{}
+	db.Where("id IN (?)", ids).Find(&users)
+
+	body := fmt.Sprintf("%v on %v has been cancelled.", m.Title, m.StartsAt.Format(time.RFC1123))
+	if reason != "" {
+		body += "\n\n" + reason
+	}
+
+	sent := 0
+	for _, u := range users {
+		msg := outboxMessage{
+			Key:     fmt.Sprintf("cancelled:%v:%v", m.ID, u.ID),
+			UserID:  u.ID,
+			To:      u.Email,
+			Subject: "Cancelled: " + m.Title,
+			Body:    body,
+		}
+		if deliver(db, mail, msg) {
+			sent++
+		}
+	}
+	return sent
+}
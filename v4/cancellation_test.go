@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrganizersCanCancelMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	going := createUser(db, "going@example.com", "somePassword1!")
+	waiting := createUser(db, "waiting@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Now().Add(48*time.Hour))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: m.ID, UserID: waiting.ID, Status: rsvpWaitlisted})
+	mail := &recordingMailer{}
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/cancel", m.ID), strings.NewReader(`{"reason":"The venue flooded"}`))
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouterWithMailer(db, mail).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v %v instead", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	resp := struct {
+		Meetup meetupResponse `json:"meetup"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Meetup.CancelledAt == nil {
+		t.Errorf("expected the meetup to be cancelled, got %v instead", rr.Body.String())
+	}
+	if mail.sent != 2 || !strings.Contains(mail.body, "The venue flooded") {
+		t.Errorf("expected both people who RSVPed to be told why, got %v emails %q instead", mail.sent, mail.body)
+	}
+}
+
+func TestCancellingAgainEmailsNobodyTwice(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	going := createUser(db, "going@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Now().Add(48*time.Hour))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	mail := &recordingMailer{}
+	auth := login(db, organizer)
+
+	// Act
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/cancel", m.ID), nil)
+		req.Header.Set("Authorization", auth)
+		meetupsRouterWithMailer(db, mail).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	if mail.sent != 1 {
+		t.Errorf("expected one email, got %v instead", mail.sent)
+	}
+}
+
+func TestOnlyOrganizersCanCancelMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	other := createUser(db, "other@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Now().Add(48*time.Hour))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/cancel", m.ID), nil)
+	req.Header.Set("Authorization", login(db, other))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestCancelledMeetupsCannotBeRSVPedTo(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	u := createUser(db, "going@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Now().Add(48*time.Hour))
+	db.Model(&m).Update("cancelled_at", time.Now())
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/rsvp", m.ID), nil)
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusConflict, rr.Code)
+	}
+}
+
+func TestCancelledMeetupsAreNotReminded(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	now := time.Date(2019, 10, 15, 12, 0, 0, 0, time.UTC)
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	u := createUser(db, "going@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", now.Add(30*time.Minute))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: u.ID, Status: rsvpGoing})
+	db.Model(&m).Update("cancelled_at", now)
+	mail := &recordingMailer{}
+
+	// Act
+	sent := sendReminders(db, mail, now)
+
+	// Assert
+	if sent != 0 {
+		t.Errorf("expected no reminders, got %v instead", sent)
+	}
+}
+
+func TestPlacesFreedOnCancelledMeetupsGoToTheWaitlist(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	going := createUser(db, "going@example.com", "somePassword1!")
+	waiting := createUser(db, "waiting@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Now().Add(48*time.Hour))
+	db.Model(&m).UpdateColumns(map[string]interface{}{"capacity": 1, "cancelled_at": time.Now()})
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: m.ID, UserID: waiting.ID, Status: rsvpWaitlisted})
+	mail := &recordingMailer{}
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v/rsvp", m.ID), nil)
+	req.Header.Set("Authorization", login(db, going))
+
+	// Act
+	meetupsRouterWithMailer(db, mail).ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert
+	rv := rsvp{}
+	db.Where("meetup_id = ? AND user_id = ?", m.ID, waiting.ID).First(&rv)
+	if rv.Status != rsvpGoing {
+		t.Errorf("expected the waitlisted user to be promoted, got %v instead", rv.Status)
+	}
+	if mail.sent != 0 {
+		t.Errorf("expected nobody to be told they're going to a cancelled meetup, got %v emails instead", mail.sent)
+	}
+}
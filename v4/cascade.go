@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// cascadeReaction updates one kind of record related to the records being
+// removed, named by their IDs. It runs in the same transaction as the
+// removal.
+type cascadeReaction func(tx *gorm.DB, ids []uint, now time.Time) error
+
+// cascadeReactions are the relations a user soft delete can cascade to, keyed
+// by the name used in USER_DELETE_CASCADE. Only reactions a restore can live
+// with belong here. API keys and webhooks are not modelled yet, so their
+// reactions have to be registered here once they are.
+var cascadeReactions = map[string]cascadeReaction{
+	"sessions": revokeSessions,
+}
+
+// erasureReactions are the relations erasing users cascades to, they all run
+// in the order of erasurePolicy
+var erasureReactions = map[string]cascadeReaction{
+	"meetups":     destroyOrganizedMeetups,
+	"venues":      forgetVenueActors,
+	"sessions":    deleteBy(&token{}, "user_id"),
+	"preferences": deleteBy(&preference{}, "user_id"),
+	"rsvps":       deleteBy(&rsvp{}, "user_id"),
+	"organizers":  deleteBy(&meetupOrganizer{}, "user_id"),
+	"talks":       deleteBy(&talk{}, "speaker_id"),
+	"comments":    blankComments,
+	"outbox":      deleteBy(&outboxMessage{}, "user_id"),
+}
+
+var erasurePolicy = cascadePolicy{"meetups", "venues", "sessions", "preferences", "rsvps", "organizers", "talks", "comments", "outbox"}
+
+// meetupReactions are the relations destroying meetups cascades to, they all
+// run in the order of meetupPolicy
+var meetupReactions = map[string]cascadeReaction{
+	"rsvps":       deleteBy(&rsvp{}, "meetup_id"),
+	"occurrences": deleteBy(&meetupOccurrence{}, "meetup_id"),
+	"talks":       deleteBy(&talk{}, "meetup_id"),
+	"tags":        deleteBy(&meetupTag{}, "meetup_id"),
+	"organizers":  deleteBy(&meetupOrganizer{}, "meetup_id"),
+	"comments":    deleteCommentsOnMeetups,
+}
+
+var meetupPolicy = cascadePolicy{"rsvps", "occurrences", "talks", "tags", "organizers", "comments"}
+
+// cascadePolicy lists the relations that react when records are removed
+type cascadePolicy []string
+
+// parseCascadePolicy reads a comma separated list of relations, "none"
+// turns cascading off
+func parseCascadePolicy(s string) (cascadePolicy, error) {
+	p := cascadePolicy{}
+	if s == "none" {
+		return p, nil
+	}
+	for _, relation := range strings.Split(s, ",") {
+		relation = strings.TrimSpace(relation)
+		if relation == "" {
+			continue
+		}
+		if _, ok := cascadeReactions[relation]; !ok {
+			return nil, fmt.Errorf("cannot cascade deletes to %q", relation)
+		}
+		p = append(p, relation)
+	}
+	return p, nil
+}
+
+// apply runs the soft delete reaction of every relation in the policy
+func (p cascadePolicy) apply(tx *gorm.DB, ids []uint, now time.Time) error {
+	return p.run(tx, cascadeReactions, ids, now)
+}
+
+// run runs the reaction of every relation in the policy in order
+func (p cascadePolicy) run(tx *gorm.DB, reactions map[string]cascadeReaction, ids []uint, now time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, relation := range p {
+		if err := reactions[relation](tx, ids, now); err != nil {
+			return fmt.Errorf("%v: %v", relation, err)
+		}
+	}
+	return nil
+}
+
+// deleteBy deletes the records of the model whose column holds one of the IDs
+func deleteBy(model interface{}, column string) cascadeReaction {
+	return func(tx *gorm.DB, ids []uint, now time.Time) error {
+		return tx.Where(column+" IN (?)", ids).Delete(model).Error
+	}
+}
+
+// revokeSessions signs the users out everywhere
+func revokeSessions(tx *gorm.DB, ids []uint, now time.Time) error {
+	return tx.Model(&token{}).Where("user_id IN (?) AND revoked_at IS NULL", ids).Update("revoked_at", now).Error
+}
+
+// destroyOrganizedMeetups takes the meetups the users organize the way
+// meetupsDestroy does
+func destroyOrganizedMeetups(tx *gorm.DB, ids []uint, now time.Time) error {
+	organized := []uint{}
+	if err := tx.Model(&meetup{}).Where("organizer_id IN (?)", ids).Pluck("id", &organized).Error; err != nil {
+		return err
+	}
+	return destroyMeetups(tx, organized)
+}
+
+// forgetVenueActors keeps the venues, they are shared, and only forgets that
+// the users created or last changed them
+func forgetVenueActors(tx *gorm.DB, ids []uint, now time.Time) error {
+	if err := tx.Model(&venue{}).Where("created_by_id IN (?)", ids).UpdateColumn("created_by_id", nil).Error; err != nil {
+		return err
+	}
+	return tx.Model(&venue{}).Where("updated_by_id IN (?)", ids).UpdateColumn("updated_by_id", nil).Error
+}
+
+// blankComments blanks the users' comments rather than removing them so the
+// replies to them still have a parent
+func blankComments(tx *gorm.DB, ids []uint, now time.Time) error {
+	blanked := map[string]interface{}{"author_id": 0, "body": "", "deleted_at": gorm.Expr("COALESCE(deleted_at, ?)", now)}
+	return tx.Unscoped().Model(&comment{}).Where("author_id IN (?)", ids).UpdateColumns(blanked).Error
+}
+
+// deleteCommentsOnMeetups removes the comments for good, replies included
+func deleteCommentsOnMeetups(tx *gorm.DB, ids []uint, now time.Time) error {
+	return tx.Unscoped().Where("meetup_id IN (?)", ids).Delete(&comment{}).Error
+}
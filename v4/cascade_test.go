@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func deleteUser(db *gorm.DB, cascade cascadePolicy, u user) *httptest.ResponseRecorder {
+	rt := newRouter()
+	rt.handle(http.MethodDelete, "/users/{id}", requireAuth(db, usersDestroy(db, cascade)))
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/users/%v", u.ID), nil)
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	rt.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestDeletingAUserCanRevokeTheirSessions(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "cascade@example.com", "somePassword1!")
+	login(db, u)
+
+	// Act
+	rr := deleteUser(db, cascadePolicy{"sessions"}, u)
+
+	// Assert
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, status)
+	}
+	active := 0
+	db.Model(&token{}).Where("user_id = ? AND revoked_at IS NULL", u.ID).Count(&active)
+	if active != 0 {
+		t.Errorf("expected every session to be revoked, got %v active instead", active)
+	}
+}
+
+func TestSessionsAreKeptWithoutTheSessionsCascade(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "cascade@example.com", "somePassword1!")
+
+	// Act
+	deleteUser(db, cascadePolicy{}, u)
+
+	// Assert
+	active := 0
+	db.Model(&token{}).Where("user_id = ? AND revoked_at IS NULL", u.ID).Count(&active)
+	if active != 1 {
+		t.Errorf("expected the session to be left alone, got %v active instead", active)
+	}
+}
+
+func TestUnknownCascadeRelationsAreRejected(t *testing.T) {
+	// Act
+	_, err := parseCascadePolicy("sessions, webhooks")
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected an error for a relation that isn't modelled")
+	}
+}
+
+func TestEveryCascadeReactionIsInItsPolicy(t *testing.T) {
+	for name, c := range map[string]struct {
+		policy    cascadePolicy
+		reactions map[string]cascadeReaction
+	}{
+		"erasure": {erasurePolicy, erasureReactions},
+		"meetup":  {meetupPolicy, meetupReactions},
+	} {
+		listed := map[string]bool{}
+		for _, relation := range c.policy {
+			if _, ok := c.reactions[relation]; !ok {
+				t.Errorf("expected the %v policy to only list relations with a reaction, got %v instead", name, relation)
+			}
+			listed[relation] = true
+		}
+		for relation := range c.reactions {
+			if !listed[relation] {
+				t.Errorf("expected the %v policy to run every reaction, %v is missing", name, relation)
+			}
+		}
+	}
+}
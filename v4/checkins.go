@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// checkinsStore checks an attendee in at the door, organizers name them by
+// their user ID or scan their ticket. Checking someone in again keeps the
+// time they arrived.
+func checkinsStore(db *gorm.DB) http.HandlerFunc {
+	type checkinRequest struct {
+		UserID apiID  `json:"user_id"`
+		Ticket string `json:"ticket"`
+	}
+
+	type checkinResponse struct {
+		User        publicUser `json:"user"`
+		CheckedInAt time.Time  `json:"checked_in_at"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		req := checkinRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		if req.UserID == (apiID{}) && req.Ticket == "" {
+			writeValidationErrors(w, map[string][]string{"user_id": {"The user_id or ticket field is required"}})
+			return
+		}
+
+		u := user{}
+		q := req.UserID.where(tx)
+		if req.Ticket != "" {
+			meetupID, userID, ok := parseTicketCode(req.Ticket)
+			if !ok || meetupID != m.ID {
+				writeValidationErrors(w, map[string][]string{"ticket": {"The ticket is not valid for the meetup"}})
+				return
+			}
+			q = tx.Where("id = ?", userID)
+		}
+		rv := rsvp{}
+		if q.First(&u).RecordNotFound() || tx.Where("meetup_id = ? AND user_id = ?", m.ID, u.ID).First(&rv).RecordNotFound() {
+			writeValidationErrors(w, map[string][]string{"user_id": {"The user has not RSVPed to the meetup"}})
+			return
+		}
+		if rv.Status != rsvpGoing {
+			writeValidationErrors(w, map[string][]string{"user_id": {"The user is on the waitlist"}})
+			return
+		}
+
+		if rv.CheckedInAt == nil {
+			now := time.Now()
+			// only the first check in counts when two doors scan the same
+			// person at once
+			if err := tx.Model(&rv).Where("checked_in_at IS NULL").UpdateColumn("checked_in_at", now).Error; err != nil {
+				logError(r, err)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			tx.First(&rv, rv.ID)
+		}
+
+		writeJSON(w, http.StatusOK, checkinResponse{User: newPublicUser(u), CheckedInAt: *rv.CheckedInAt})
+	}
+}
+
+// attendanceShow summarizes who turned up to a meetup for its organizers,
+// people who were going but didn't check in are no shows once it started
+func attendanceShow(db *gorm.DB) http.HandlerFunc {
+	type attendanceResponse struct {
+		Going          int     `json:"going"`
+		Waitlisted     int     `json:"waitlisted"`
+		CheckedIn      int     `json:"checked_in"`
+		NoShows        int     `json:"no_shows"`
+		AttendanceRate float64 `json:"attendance_rate"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		counts := rsvpCounts(tx, []uint{m.ID})[m.ID]
+		resp := attendanceResponse{Going: counts[rsvpGoing], Waitlisted: counts[rsvpWaitlisted]}
+		tx.Model(&rsvp{}).Where("meetup_id = ? AND checked_in_at IS NOT NULL", m.ID).Count(&resp.CheckedIn)
+		if time.Now().After(m.StartsAt) {
+			resp.NoShows = resp.Going - resp.CheckedIn
+		}
+		if resp.Going > 0 {
+			resp.AttendanceRate = float64(resp.CheckedIn) / float64(resp.Going)
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrganizersCanCheckAttendeesIn(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	attendee := createUser(db, "attendee@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: attendee.ID, Status: rsvpGoing})
+	auth := login(db, organizer)
+	path := fmt.Sprintf("/meetups/%v/checkin", m.ID)
+	times := []time.Time{}
+
+	// Act
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", path, strings.NewReader(fmt.Sprintf(`{"user_id":%v}`, attendee.ID)))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		meetupsRouter(db).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected the status code to be %v, got %v %v instead", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		resp := struct {
+			CheckedInAt time.Time `json:"checked_in_at"`
+		}{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		times = append(times, resp.CheckedInAt)
+	}
+
+	// Assert
+	if times[0].IsZero() || !times[0].Equal(times[1]) {
+		t.Errorf("expected checking in twice to keep the first time, got %v instead", times)
+	}
+}
+
+func TestOnlyPeopleGoingCanCheckIn(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	waitlisted := createUser(db, "waitlisted@example.com", "somePassword1!")
+	stranger := createUser(db, "stranger@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: waitlisted.ID, Status: rsvpWaitlisted})
+	auth := login(db, organizer)
+
+	for _, u := range []user{waitlisted, stranger} {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/checkin", m.ID), strings.NewReader(fmt.Sprintf(`{"user_id":%v}`, u.ID)))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+
+		// Act
+		meetupsRouter(db).ServeHTTP(rr, req)
+
+		// Assert
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected %v not to be checked in, got %v instead", u.Email, rr.Code)
+		}
+	}
+}
+
+func TestAttendanceIsSummarizedForOrganizers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	arrived := time.Date(2019, 10, 15, 18, 25, 0, 0, time.UTC)
+	for i, checkedIn := range []*time.Time{&arrived, &arrived, &arrived, nil} {
+		u := createUser(db, fmt.Sprintf("attendee%v@example.com", i), "somePassword1!")
+		db.Create(&rsvp{MeetupID: m.ID, UserID: u.ID, Status: rsvpGoing, CheckedInAt: checkedIn})
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/attendance", m.ID), nil)
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	expected := `{"going":4,"waitlisted":0,"checked_in":3,"no_shows":1,"attendance_rate":0.75}`
+	if body := strings.TrimSpace(rr.Body.String()); body != expected {
+		t.Errorf("expected the body to be %v, got %v instead", expected, body)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const clientContextKey contextKey = "client"
+
+// apiClientHeader identifies the registered client making the request
+const apiClientHeader = "X-API-Key"
+
+// apiClient is a registered consumer of the API, clients that pin a version
+// are served it whenever they don't ask for one explicitly
+type apiClient struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// apiClients are the registered clients keyed by their API key
+var apiClients = map[string]apiClient{}
+
+// loadAPIClients replaces the registered clients with the ones in the file,
+// nothing changes when any of them is invalid
+func loadAPIClients(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	clients := map[string]apiClient{}
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return err
+	}
+	for key, c := range clients {
+		if c.Name == "" {
+			return fmt.Errorf("%v: a name is required", key)
+		}
+		if c.Version != 0 && (c.Version < oldestAPIVersion || c.Version > currentAPIVersion) {
+			return fmt.Errorf("%v: version %v is not supported", c.Name, c.Version)
+		}
+	}
+
+	apiClients = clients
+	return nil
+}
+
+// clientFor returns the registered client the request identifies itself as
+func clientFor(r *http.Request) (apiClient, bool) {
+	c, ok := apiClients[r.Header.Get(apiClientHeader)]
+	return c, ok && r.Header.Get(apiClientHeader) != ""
+}
+
+func withClient(r *http.Request, c apiClient) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientContextKey, c))
+}
+
+// clientName names the client for the deprecation report, requests without
+// a registered key are grouped together
+func clientName(r *http.Request) string {
+	if c, ok := r.Context().Value(clientContextKey).(apiClient); ok {
+		return c.Name
+	}
+	return "unregistered"
+}
+
+// deprecatedCallsMetric counts calls relying on deprecated behavior by feature
+var deprecatedCallsMetric = expvar.NewMap("deprecated_calls")
+
+// deprecatedUsage is how often a client relied on a deprecated feature
+type deprecatedUsage struct {
+	Feature  string    `json:"feature"`
+	Calls    int       `json:"calls"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// deprecationTracker remembers which clients still rely on deprecated
+// behavior since the process started
+type deprecationTracker struct {
+	mu      sync.Mutex
+	clients map[string]map[string]*deprecatedUsage
+}
+
+func newDeprecationTracker() *deprecationTracker {
+	return &deprecationTracker{clients: map[string]map[string]*deprecatedUsage{}}
+}
+
+var deprecations = newDeprecationTracker()
+
+// record counts a call by the request's client that relies on the feature
+func (dt *deprecationTracker) record(r *http.Request, feature string, now time.Time) {
+	deprecatedCallsMetric.Add(feature, 1)
+
+	name := clientName(r)
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	if dt.clients[name] == nil {
+		dt.clients[name] = map[string]*deprecatedUsage{}
+	}
+	u := dt.clients[name][feature]
+	if u == nil {
+		u = &deprecatedUsage{Feature: feature}
+		dt.clients[name][feature] = u
+	}
+	u.Calls++
+	u.LastSeen = now
+}
+
+// clientDeprecations is the report entry for one client
+type clientDeprecations struct {
+	Client string            `json:"client"`
+	Usages []deprecatedUsage `json:"usages"`
+}
+
+// report lists the clients by name with their usages, most used first
+func (dt *deprecationTracker) report() []clientDeprecations {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	out := []clientDeprecations{}
+	for name, usages := range dt.clients {
+		c := clientDeprecations{Client: name}
+		for _, u := range usages {
+			c.Usages = append(c.Usages, *u)
+		}
+		sort.Slice(c.Usages, func(i, j int) bool {
+			if c.Usages[i].Calls != c.Usages[j].Calls {
+				return c.Usages[i].Calls > c.Usages[j].Calls
+			}
+			return c.Usages[i].Feature < c.Usages[j].Feature
+		})
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Client < out[j].Client })
+	return out
+}
+
+// warnDeprecated tells the client the request relies on deprecated behavior
+// and records it for the report
+func warnDeprecated(w http.ResponseWriter, r *http.Request, feature, message string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Add("Warning", fmt.Sprintf("299 - %q", message))
+	deprecations.record(r, feature, time.Now())
+}
+
+// deprecatedRoute warns on every call to a route slated for removal
+func deprecatedRoute(d routeDef, next http.HandlerFunc) http.HandlerFunc {
+	feature := d.method + " " + d.path
+	message := feature + " is deprecated"
+	if d.sunset != "" {
+		message += " and will be removed on " + d.sunset
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.sunset != "" {
+			w.Header().Set("Sunset", d.sunset)
+		}
+		warnDeprecated(w, r, feature, message)
+		next(w, r)
+	}
+}
+
+func clientDeprecationsIndex(dt *deprecationTracker) http.HandlerFunc {
+	type clientDeprecationsIndexResponse struct {
+		Clients []clientDeprecations `json:"clients"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, clientDeprecationsIndexResponse{Clients: dt.report()})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinnedClientsAreServedTheirVersion(t *testing.T) {
+	// Arrange
+	original := apiClients
+	defer func() { apiClients = original }()
+	apiClients = map[string]apiClient{"mobile-key": {Name: "mobile", Version: 2}}
+	deprecations = newDeprecationTracker()
+	handler := apiVersions(okHandler())
+	tests := map[string]string{
+		"/healthz":    "2",
+		"/v4/healthz": "4",
+	}
+
+	for target, expected := range tests {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set(apiClientHeader, "mobile-key")
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if version := rr.Header().Get("API-Version"); version != expected {
+			t.Errorf("expected %v to be served version %v, got %v instead", target, expected, version)
+		}
+	}
+	report := deprecations.report()
+	if len(report) != 1 || report[0].Client != "mobile" || report[0].Usages[0].Feature != "API version 2" || report[0].Usages[0].Calls != 1 {
+		t.Errorf("expected the pinned call to be reported against the client, got %+v instead", report)
+	}
+}
+
+func TestDeprecatedRoutesWarnTheirCallers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	deprecations = newDeprecationTracker()
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/old", deprecated: true, sunset: "Wed, 30 Jun 2027 00:00:00 GMT", handler: okHandler().ServeHTTP},
+	})
+	rr := httptest.NewRecorder()
+
+	// Act
+	apiVersions(rt).ServeHTTP(rr, httptest.NewRequest("GET", "/v3/old", nil))
+
+	// Assert
+	if rr.Header().Get("Deprecation") != "true" || rr.Header().Get("Sunset") == "" {
+		t.Errorf("expected deprecation and sunset headers, got %v instead", rr.Header())
+	}
+	if warnings := strings.Join(rr.Header()["Warning"], "\n"); len(rr.Header()["Warning"]) != 2 || !strings.Contains(warnings, "GET /old") || !strings.Contains(warnings, "API version 3") {
+		t.Errorf("expected a warning for the route and the version, got %v instead", warnings)
+	}
+	report := deprecations.report()
+	if len(report) != 1 || report[0].Client != "unregistered" || len(report[0].Usages) != 2 {
+		t.Errorf("expected both usages to be reported for unregistered clients, got %+v instead", report)
+	}
+}
+
+func TestDeprecationsAreCountedPerClientAndFeature(t *testing.T) {
+	// Arrange
+	dt := newDeprecationTracker()
+	mobile := withClient(httptest.NewRequest("GET", "/", nil), apiClient{Name: "mobile"})
+	now := time.Now()
+
+	// Act
+	dt.record(mobile, "API version 1", now)
+	dt.record(mobile, "GET /old", now)
+	dt.record(mobile, "GET /old", now)
+
+	// Assert
+	usages := dt.report()[0].Usages
+	if usages[0].Feature != "GET /old" || usages[0].Calls != 2 || usages[1].Calls != 1 {
+		t.Errorf("expected the most used feature first, got %+v instead", usages)
+	}
+}
+
+func TestInvalidClientFilesChangeNothing(t *testing.T) {
+	// Arrange
+	path := writePolicyFile(t, `{"good": {"name": "web"}, "bad": {"name": "cli", "version": 9}}`)
+	defer os.Remove(path)
+	original := apiClients
+
+	// Act
+	err := loadAPIClients(path)
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected an error for an unsupported version")
+	}
+	if len(apiClients) != len(original) {
+		t.Errorf("expected the clients to be left alone, got %v instead", apiClients)
+	}
+}
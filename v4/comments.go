@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// commentRateLimit is how many comments a user can post in commentRateWindow
+// before being asked to slow down
+const (
+	commentRateLimit  = 5
+	commentRateWindow = time.Minute
+)
+
+// comment is a message left on a meetup, replies name the comment they answer.
+// Deleted comments are kept so the replies to them still have a parent.
+type comment struct {
+	ID        uint   `gorm:"primary_key"`
+	MeetupID  uint   `gorm:"index"`
+	AuthorID  uint   `gorm:"index"`
+	ParentID  *uint  `gorm:"index"`
+	Body      string `gorm:"type:varchar(2000)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+}
+
+// commentResponse is a comment as the API shows it, deleted comments lose
+// their body and author
+type commentResponse struct {
+	ID        uint        `json:"id"`
+	ParentID  *uint       `json:"parent_id"`
+	Author    *publicUser `json:"author"`
+	Body      string      `json:"body"`
+	Deleted   bool        `json:"deleted"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// presentComments loads the authors of the comments in one query
+func presentComments(db *gorm.DB, comments []comment) []commentResponse {
+	ids := make([]uint, len(comments))
+	for i, c := range comments {
+		ids[i] = c.AuthorID
+	}
+	authors := []user{}
+	db.Where("id IN (?)", ids).Find(&authors)
+	byID := make(map[uint]user, len(authors))
+	for _, u := range authors {
+		byID[u.ID] = u
+	}
+
+	resp := make([]commentResponse, len(comments))
+	for i, c := range comments {
+		resp[i] = commentResponse{ID: c.ID, ParentID: c.ParentID, Deleted: c.DeletedAt != nil, CreatedAt: c.CreatedAt}
+		if c.DeletedAt != nil {
+			continue
+		}
+		resp[i].Body = c.Body
+		if author, ok := byID[c.AuthorID]; ok {
+			p := newPublicUser(author)
+			resp[i].Author = &p
+		}
+	}
+	return resp
+}
+
+// commentRules are the rules for posting a comment, a parent_id makes it a
+// reply
+var commentRules = govalidator.MapData{
+	"body":      []string{"required", "max:2000"},
+	"parent_id": []string{"numeric"},
+}
+
+// commentsIndexParams are the query parameters commentsIndex accepts
+var commentsIndexParams = []string{"page", "per_page"}
+
+// commentsIndex lists the comments on a meetup oldest first, clients thread
+// them by their parent_id
+func commentsIndex(db *gorm.DB) http.HandlerFunc {
+	type commentsIndexResponse struct {
+		Comments   []commentResponse `json:"comments"`
+		Pagination pagination        `json:"pagination"`
+		Links      pageLinks         `json:"links"`
+	}
+
+	policy := listPolicyFor("/meetups/{id}/comments")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, commentsIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		opts, errs := parseListOptions(r, policy)
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		q := tx.Unscoped().Where("meetup_id = ?", m.ID)
+		page := opts.paginate(q, &comment{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		comments := []comment{}
+		opts.apply(q).Find(&comments)
+
+		writeJSON(w, http.StatusOK, commentsIndexResponse{Comments: presentComments(tx, comments), Pagination: page, Links: links})
+	}
+}
+
+// commentsStore posts a comment on a meetup as the signed in user, who can
+// post commentRateLimit comments a commentRateWindow across every meetup
+func commentsStore(db *gorm.DB) http.HandlerFunc {
+	type commentStoreRequest struct {
+		Body     string `json:"body"`
+		ParentID *uint  `json:"parent_id"`
+	}
+
+	type commentStoreResponse struct {
+		Comment commentResponse `json:"comment"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		u := currentUser(r)
+		// deleted comments still count so deleting them doesn't lift the limit
+		recent := []comment{}
+		tx.Unscoped().Where("author_id = ? AND created_at > ?", u.ID, time.Now().Add(-commentRateWindow)).Order("created_at asc").Find(&recent)
+		setCommentRateLimit(w, recent)
+		if len(recent) >= commentRateLimit {
+			writeError(w, http.StatusTooManyRequests, "too many comments, try again later")
+			return
+		}
+
+		req := commentStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: commentRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+		if req.ParentID != nil && tx.Where("meetup_id = ?", m.ID).First(&comment{}, *req.ParentID).RecordNotFound() {
+			writeValidationErrors(w, map[string][]string{"parent_id": {"The parent comment was not found on the meetup"}})
+			return
+		}
+
+		c := comment{MeetupID: m.ID, AuthorID: u.ID, ParentID: req.ParentID, Body: req.Body}
+		if err := tx.Create(&c).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		setCommentRateLimit(w, append(recent, c))
+		writeJSON(w, http.StatusCreated, commentStoreResponse{Comment: presentComments(tx, []comment{c})[0]})
+	}
+}
+
+// setCommentRateLimit sets the rate limit headers from the comments the user
+// posted in the last commentRateWindow, oldest first. The limit resets when
+// the comment holding up the next one falls out of the window.
+func setCommentRateLimit(w http.ResponseWriter, recent []comment) {
+	reset := time.Now().Add(commentRateWindow)
+	if n := len(recent); n >= commentRateLimit {
+		reset = recent[n-commentRateLimit].CreatedAt.Add(commentRateWindow)
+	} else if n > 0 {
+		reset = recent[0].CreatedAt.Add(commentRateWindow)
+	}
+	setRateLimit(w, commentRateLimit, commentRateLimit-len(recent), reset)
+}
+
+// commentsDestroy deletes a comment, authors can delete their own and
+// organizers any on their meetup
+func commentsDestroy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		c := comment{}
+		id, err := strconv.ParseUint(param(r, "comment"), 10, 64)
+		if err != nil || tx.Where("meetup_id = ?", m.ID).First(&c, id).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "comment not found")
+			return
+		}
+		viewer := currentUser(r)
+		if viewer.ID != c.AuthorID && !canOrganize(tx, viewer, m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		if err := tx.Delete(&c).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func commentsRouter(db *gorm.DB) *router {
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/meetups/{id}/comments", handler: commentsIndex(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/comments", access: accessUser, handler: commentsStore(db)},
+		{method: http.MethodDelete, path: "/meetups/{id}/comments/{comment}", access: accessUser, handler: commentsDestroy(db)},
+	})
+	return rt
+}
+
+func TestUsersCanReplyToComments(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	parent := comment{MeetupID: m.ID, AuthorID: u.ID, Body: "Who is bringing pizza?"}
+	db.Create(&parent)
+	body := fmt.Sprintf(`{"body":"I am!","parent_id":%v}`, parent.ID)
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/comments", m.ID), strings.NewReader(body))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected the status code to be %v, got %v %v instead", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	resp := struct {
+		Comment commentResponse `json:"comment"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Comment.ParentID == nil || *resp.Comment.ParentID != parent.ID || resp.Comment.Author == nil || resp.Comment.Author.ID.Key != idOf(u).Key {
+		t.Errorf("expected a reply by the user, got %v instead", rr.Body.String())
+	}
+}
+
+func TestCommentsHaveAMaximumLength(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	body := fmt.Sprintf(`{"body":%q}`, strings.Repeat("a", 2001))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/comments", m.ID), strings.NewReader(body))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestCommentsAreRateLimited(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	for i := 0; i < commentRateLimit; i++ {
+		db.Create(&comment{MeetupID: m.ID, AuthorID: u.ID, Body: "spam"})
+	}
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/comments", m.ID), strings.NewReader(`{"body":"more spam"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header, got none instead")
+	}
+}
+
+func TestCommentsReportTheRateLimit(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/comments", m.ID), strings.NewReader(`{"body":"first"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if got := rr.Header().Get("X-RateLimit-Limit"); got != fmt.Sprint(commentRateLimit) {
+		t.Errorf("expected the limit to be %v, got %v instead", commentRateLimit, got)
+	}
+	if got := rr.Header().Get("X-RateLimit-Remaining"); got != fmt.Sprint(commentRateLimit-1) {
+		t.Errorf("expected %v comments to remain, got %v instead", commentRateLimit-1, got)
+	}
+	if got := rr.Header().Get("X-RateLimit-Reset"); got == "" {
+		t.Errorf("expected the limit to say when it resets, got none instead")
+	}
+	if got := rr.Header().Get("Retry-After"); got != "" {
+		t.Errorf("expected no Retry-After while comments remain, got %v instead", got)
+	}
+}
+
+func TestDeletedCommentsKeepTheirPlaceInTheThread(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	parent := comment{MeetupID: m.ID, AuthorID: u.ID, Body: "Who is bringing pizza?"}
+	db.Create(&parent)
+	db.Create(&comment{MeetupID: m.ID, AuthorID: u.ID, ParentID: &parent.ID, Body: "I am!"})
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v/comments/%v", m.ID, parent.ID), nil)
+	req.Header.Set("Authorization", login(db, u))
+	deleted := httptest.NewRecorder()
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(deleted, req)
+	commentsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/comments", m.ID), nil))
+
+	// Assert
+	if deleted.Code != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, deleted.Code)
+	}
+	resp := struct {
+		Comments []commentResponse `json:"comments"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Comments) != 2 || !resp.Comments[0].Deleted || resp.Comments[0].Body != "" || resp.Comments[0].Author != nil || resp.Comments[1].Body != "I am!" {
+		t.Errorf("expected the deleted comment to be blanked, got %v instead", rr.Body.String())
+	}
+}
+
+func TestOnlyAuthorsAndOrganizersCanDeleteComments(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	author := createUser(db, "author@example.com", "somePassword1!")
+	other := createUser(db, "other@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	c := comment{MeetupID: m.ID, AuthorID: author.ID, Body: "Who is bringing pizza?"}
+	db.Create(&c)
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v/comments/%v", m.ID, c.ID), nil)
+	req.Header.Set("Authorization", login(db, other))
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestCommentsArePaginated(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	for _, body := range []string{"first", "second", "third"} {
+		db.Create(&comment{MeetupID: m.ID, AuthorID: u.ID, Body: body})
+	}
+	rr := httptest.NewRecorder()
+
+	// Act
+	commentsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/comments?page=2&per_page=2", m.ID), nil))
+
+	// Assert
+	resp := struct {
+		Comments   []commentResponse `json:"comments"`
+		Pagination pagination        `json:"pagination"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Comments) != 1 || resp.Comments[0].Body != "third" {
+		t.Errorf("expected the second page to hold the third comment, got %v instead", rr.Body.String())
+	}
+	if resp.Pagination.Total != 3 || resp.Pagination.TotalPages != 2 {
+		t.Errorf("expected 3 comments over 2 pages, got %+v instead", resp.Pagination)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressibleTypes are the content types worth compressing, images and
+// other binary formats are compressed already
+var compressibleTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"application/problem+xml",
+	"application/x-ndjson",
+	"application/xml",
+	"text/",
+}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// compress gzips responses for clients that accept it, once the body reaches
+// minSize bytes. Smaller responses aren't worth the CPU and are sent as is.
+func compress(minSize int) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// close isn't deferred, a panicking handler has to leave what it
+			// held back unsent so recoverPanics can still answer with a 500
+			gw := &gzipWriter{ResponseWriter: w, minSize: minSize, status: http.StatusOK}
+			next.ServeHTTP(gw, r)
+			gw.close()
+		})
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip, a
+// quality of zero rules it out
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.TrimSpace(fields[0])
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter holds the start of the body back until it knows whether the
+// response is big enough to compress
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if !gw.decided {
+		gw.status = status
+	}
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(b)
+		}
+		return gw.ResponseWriter.Write(b)
+	}
+
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) >= gw.minSize {
+		if err := gw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush starts compressing a streamed response straight away, since a
+// stream is likely to grow past minSize
+func (gw *gzipWriter) Flush() {
+	if !gw.decided {
+		gw.decide(true)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide sends the headers, compressing the body when it is big enough, of a
+// compressible type, and not already encoded
+func (gw *gzipWriter) decide(big bool) error {
+	gw.decided = true
+	h := gw.Header()
+	if h.Get("Content-Type") == "" && len(gw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+	if big && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) && bodyAllowed(gw.status) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		gw.gz = gzipWriters.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+	buf := gw.buf
+	gw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := gw.Write(buf)
+	return err
+}
+
+// close sends what is still held back and finishes the gzip stream
+func (gw *gzipWriter) close() {
+	if !gw.decided {
+		gw.decide(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+		gzipWriters.Put(gw.gz)
+		gw.gz = nil
+	}
+}
+
+func compressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyAllowed reports whether a response with the status can have a body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressGzipsLargeResponses(t *testing.T) {
+	// Arrange
+	body := strings.Repeat(`{"name":"meetup"}`, 100)
+	h := compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "1700")
+		w.Write([]byte(body))
+	}))
+	req := httptest.NewRequest("GET", "/meetups", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	res := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(res, req)
+
+	// Assert
+	if got := res.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected the response to be gzipped, got Content-Encoding %q instead", got)
+	}
+	if got := res.Header().Get("Content-Length"); got != "" {
+		t.Errorf("expected no Content-Length, got %v instead", got)
+	}
+	if got := res.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("expected Vary: Accept-Encoding, got %v instead", got)
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip body, got %v instead", err)
+	}
+	got, _ := ioutil.ReadAll(zr)
+	if string(got) != body {
+		t.Errorf("expected the body to decompress to what was written, got %v bytes instead", len(got))
+	}
+}
+
+func TestCompressSkipsResponses(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+	}{
+		{"no accept encoding", "", "application/json", strings.Repeat("a", 2048)},
+		{"gzip refused", "gzip;q=0", "application/json", strings.Repeat("a", 2048)},
+		{"small body", "gzip", "application/json", `{"ok":true}`},
+		{"binary type", "gzip", "image/png", strings.Repeat("a", 2048)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			h := compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(tt.body))
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			res := httptest.NewRecorder()
+
+			// Act
+			h.ServeHTTP(res, req)
+
+			// Assert
+			if got := res.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("expected no Content-Encoding, got %v instead", got)
+			}
+			if res.Code != http.StatusCreated {
+				t.Errorf("expected status %v, got %v instead", http.StatusCreated, res.Code)
+			}
+			if got := res.Body.String(); got != tt.body {
+				t.Errorf("expected the body as written, got %v instead", got)
+			}
+		})
+	}
+}
+
+func TestCompressFlushStartsGzipping(t *testing.T) {
+	// Arrange
+	h := compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{}\n"))
+		w.(http.Flusher).Flush()
+	}))
+	req := httptest.NewRequest("GET", "/me/export", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(res, req)
+
+	// Assert
+	if !res.Flushed {
+		t.Errorf("expected the response to be flushed")
+	}
+	if got := res.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected a flushed stream to be gzipped, got Content-Encoding %q instead", got)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(res.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("expected a gzip body, got %v instead", err)
+	}
+	if got, _ := ioutil.ReadAll(zr); string(got) != "{}\n" {
+		t.Errorf("expected the streamed body, got %q instead", got)
+	}
+}
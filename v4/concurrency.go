@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// userETag identifies the version of the user, it changes whenever the user
+// is updated
+func userETag(u user) string {
+	return fmt.Sprintf(`"%x"`, u.UpdatedAt.UnixNano())
+}
+
+// representationETag tags the version of the user as the viewer gets it. The
+// same version reads differently to its owner, administrators and everyone
+// else, and in another API version, format or with includes, so a 304 must
+// never confirm one of those for another. If-Match only looks at the version.
+func representationETag(w http.ResponseWriter, r *http.Request, u user, viewer *user) string {
+	role := "public"
+	switch {
+	case viewer != nil && viewer.Admin:
+		role = "admin"
+	case viewer != nil && viewer.ID == u.ID:
+		role = "owner"
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{role, w.Header().Get("API-Version"), r.Header.Get("Accept"), r.URL.Query().Get("include")}, "\n")))
+	return fmt.Sprintf(`"%x-%x"`, u.UpdatedAt.UnixNano(), sum[:6])
+}
+
+// versionOfETag drops the representation from a tag made by
+// representationETag, leaving the userETag of the version
+func versionOfETag(tag string) string {
+	if i := strings.LastIndex(tag, "-"); i > 0 && strings.HasPrefix(tag, `"`) {
+		return tag[:i] + `"`
+	}
+	return tag
+}
+
+// setVersionHeaders tells the client which version of the user it got so it
+// can make its next update conditional on it
+func setVersionHeaders(w http.ResponseWriter, r *http.Request, u user, viewer *user) {
+	w.Header().Set("ETag", representationETag(w, r, u, viewer))
+	w.Header().Set("Last-Modified", u.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// conditional reports whether the request only applies to a given version
+func conditional(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// preconditionFailed reports whether the user changed since the version the
+// request's If-Match or If-Unmodified-Since names, If-Match wins when both
+// are sent
+func preconditionFailed(r *http.Request, u user) bool {
+	if match := r.Header.Get("If-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			// weak tags never match, If-Match needs a strong comparison
+			if tag = strings.TrimSpace(tag); tag == "*" || tag == userETag(u) || versionOfETag(tag) == userETag(u) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if since := r.Header.Get("If-Unmodified-Since"); since != "" {
+		t, err := http.ParseTime(since)
+		if err != nil {
+			return false
+		}
+		return u.UpdatedAt.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
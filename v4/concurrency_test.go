@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdatesHonorIfMatch(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "etag@example.com", "somePassword1!")
+	auth := login(db, u)
+	handler := updateRouter(db)
+	update := func(name, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"name":"`+name+`"}`))
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("If-Match", etag)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Act
+	first := update("First", userETag(u))
+	stale := update("Stale", userETag(u))
+	second := update("Second", first.Header().Get("ETag"))
+
+	// Assert
+	if status := first.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead: %v", http.StatusOK, status, first.Body.String())
+	}
+	if status := stale.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusPreconditionFailed, status)
+	}
+	if status := second.Code; status != http.StatusOK {
+		t.Errorf("expected the new ETag to be accepted, got %v instead", status)
+	}
+	db.First(&u, u.ID)
+	if u.Name != "Second" {
+		t.Errorf("expected the stale update to be lost, got %v instead", u.Name)
+	}
+}
+
+func TestUpdatesHonorIfUnmodifiedSince(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "since@example.com", "somePassword1!")
+	auth := login(db, u)
+	tests := map[string]int{
+		u.UpdatedAt.Add(-time.Hour).UTC().Format(http.TimeFormat): http.StatusPreconditionFailed,
+		u.UpdatedAt.Add(time.Hour).UTC().Format(http.TimeFormat):  http.StatusOK,
+	}
+
+	for since, expected := range tests {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"bio":"Gopher"}`))
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("If-Unmodified-Since", since)
+		rr := httptest.NewRecorder()
+
+		// Act
+		updateRouter(db).ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", since, expected, status)
+		}
+	}
+}
+
+func TestWeakETagsNeverMatch(t *testing.T) {
+	// Arrange
+	u := user{UpdatedAt: time.Now()}
+	req := httptest.NewRequest("PATCH", "/users/1", nil)
+	req.Header.Set("If-Match", "W/"+userETag(u))
+
+	// Act
+	failed := preconditionFailed(req, u)
+
+	// Assert
+	if !failed {
+		t.Errorf("expected a weak ETag not to satisfy If-Match")
+	}
+}
+
+func TestUserETagsDifferByRepresentation(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "owner@example.com", "somePassword1!")
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", optionalAuth(db, usersShow(db)))
+	handler := etags(rt)
+	owner := httptest.NewRequest("GET", fmt.Sprintf("/users/%v", u.ID), nil)
+	owner.Header.Set("Authorization", login(db, u))
+	fetched := httptest.NewRecorder()
+	handler.ServeHTTP(fetched, owner)
+	public := httptest.NewRequest("GET", fmt.Sprintf("/users/%v", u.ID), nil)
+	public.Header.Set("If-None-Match", fetched.Header().Get("ETag"))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, public)
+
+	// Assert
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == fetched.Header().Get("ETag") {
+		t.Errorf("expected the owner's tag not to validate the public body, got %v with %v instead", rr.Code, rr.Header().Get("ETag"))
+	}
+	if strings.Contains(rr.Body.String(), "owner@example.com") {
+		t.Errorf("expected the public body, got %v instead", rr.Body.String())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationFromEnv parses the environment variable as a duration such as
+// "72h", falling back to def when it is not set
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %v", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%v: must not be negative", name)
+	}
+
+	return d, nil
+}
+
+// intFromEnv parses the environment variable as a positive integer, falling
+// back to def when it is not set
+func intFromEnv(name string, def int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%v: must be a positive number", name)
+	}
+
+	return n, nil
+}
+
+// listenAddr picks the address to listen on, the -addr flag wins over ADDR,
+// which wins over PORT on its own. The port has to be given and valid, an
+// empty host listens on every interface. An address such as
+// unix:/run/api.sock is a Unix socket path instead.
+func listenAddr(flagAddr string) (string, error) {
+	name, addr := "-addr", flagAddr
+	if addr == "" {
+		name, addr = "ADDR", os.Getenv("ADDR")
+	}
+	if addr == "" {
+		name, addr = "PORT", os.Getenv("PORT")
+		if addr == "" {
+			return ":8080", nil
+		}
+		addr = ":" + addr
+	}
+	if strings.HasPrefix(addr, "unix:") {
+		if addr == "unix:" {
+			return "", fmt.Errorf("%v: the socket path is missing", name)
+		}
+		return addr, nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", name, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("%v: the port must be between 1 and 65535", name)
+	}
+
+	return addr, nil
+}
+
+// fileModeFromEnv parses the environment variable as octal permissions such
+// as "660", falling back to def when it is not set
+func fileModeFromEnv(name string, def os.FileMode) (os.FileMode, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%v: must be octal permissions such as 660", name)
+	}
+
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		flag     string
+		addr     string
+		port     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "defaults to port 8080", expected: ":8080"},
+		{name: "takes the port", port: "9000", expected: ":9000"},
+		{name: "takes the address over the port", addr: "127.0.0.1:9001", port: "9000", expected: "127.0.0.1:9001"},
+		{name: "takes the flag over both", flag: "[::1]:9002", addr: "127.0.0.1:9001", port: "9000", expected: "[::1]:9002"},
+		{name: "rejects an address without a port", addr: "127.0.0.1", wantErr: true},
+		{name: "rejects a port out of range", port: "70000", wantErr: true},
+		{name: "rejects a named port", flag: ":http", wantErr: true},
+		{name: "takes a Unix socket", addr: "unix:/run/api.sock", expected: "unix:/run/api.sock"},
+		{name: "rejects a Unix socket without a path", addr: "unix:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			os.Setenv("ADDR", tt.addr)
+			os.Setenv("PORT", tt.port)
+			defer os.Unsetenv("ADDR")
+			defer os.Unsetenv("PORT")
+
+			// Act
+			addr, err := listenAddr(tt.flag)
+
+			// Assert
+			if tt.wantErr != (err != nil) {
+				t.Errorf("expected an error to be %v, got %v instead", tt.wantErr, err)
+			}
+			if addr != tt.expected {
+				t.Errorf("expected the address to be %q, got %q instead", tt.expected, addr)
+			}
+		})
+	}
+}
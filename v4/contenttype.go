@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// jsonOnly is what the write routes consume unless they say otherwise
+var jsonOnly = []string{"application/json"}
+
+// requireContentType turns away request bodies of a media type the route
+// doesn't consume with a 415. Requests without a body pass, so write routes
+// that need none, like DELETE, don't need a Content-Type either.
+func requireContentType(types []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength == 0 {
+			next(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err == nil {
+			for _, t := range types {
+				if strings.EqualFold(mediaType, t) {
+					next(w, r)
+					return
+				}
+			}
+		}
+
+		accept := "Accept-Post"
+		if r.Method == http.MethodPatch {
+			accept = "Accept-Patch"
+		}
+		w.Header().Set(accept, strings.Join(types, ", "))
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("the request body must be %v", strings.Join(types, " or ")))
+	}
+}
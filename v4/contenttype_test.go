@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteRequestsMustSendJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"json", "application/json", `{}`, http.StatusOK},
+		{"json with charset", "application/json; charset=utf-8", `{}`, http.StatusOK},
+		{"form", "application/x-www-form-urlencoded", "email=a@example.com", http.StatusUnsupportedMediaType},
+		{"missing", "", `{}`, http.StatusUnsupportedMediaType},
+		{"no body", "", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			h := requireContentType(jsonOnly, func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+
+			// Act
+			h(rr, req)
+
+			// Assert
+			if rr.Code != tt.expected {
+				t.Errorf("expected the status code to be %v, got %v instead", tt.expected, rr.Code)
+			}
+			if tt.expected == http.StatusUnsupportedMediaType && rr.Header().Get("Accept-Post") != "application/json" {
+				t.Errorf("expected the Accept-Post header to name application/json, got %v instead", rr.Header().Get("Accept-Post"))
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsMaxAge is how many seconds browsers may cache a preflight response
+const corsMaxAge = "600"
+
+// corsExposedHeaders are the response headers scripts on other origins may
+// read
+var corsExposedHeaders = []string{"ETag", "Link", "Retry-After", "X-Request-ID", "Deprecation", "Sunset", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"}
+
+// cors lets scripts on the origins call the API from a browser, "*" allows
+// any origin. The router answers preflight requests, this adds the CORS
+// headers to them and every other response. Without origins it does nothing.
+func cors(origins []string) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" || !allowedOrigin(origins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			h.Set("Access-Control-Max-Age", corsMaxAge)
+			next.ServeHTTP(&preflightWriter{ResponseWriter: w}, r)
+		})
+	}
+}
+
+func allowedOrigin(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// preflightWriter allows the methods the router put in the Allow header
+type preflightWriter struct {
+	http.ResponseWriter
+}
+
+func (pw *preflightWriter) WriteHeader(status int) {
+	if allow := pw.Header().Get("Allow"); allow != "" {
+		pw.Header().Set("Access-Control-Allow-Methods", allow)
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
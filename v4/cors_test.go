@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func corsRouter(origins []string) http.Handler {
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/meetups", func(w http.ResponseWriter, r *http.Request) {})
+	rt.handle(http.MethodPost, "/meetups", func(w http.ResponseWriter, r *http.Request) {})
+	return cors(origins)(rt)
+}
+
+func TestPreflightRequestsFromAllowedOriginsAreAnswered(t *testing.T) {
+	// Arrange
+	req := httptest.NewRequest("OPTIONS", "/meetups", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+	rr := httptest.NewRecorder()
+
+	// Act
+	corsRouter([]string{"https://app.example.com"}).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, rr.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST",
+		"Access-Control-Allow-Headers": "Authorization, Content-Type",
+		"Access-Control-Max-Age":       corsMaxAge,
+	}
+	for name, value := range expected {
+		if got := rr.Header().Get(name); got != value {
+			t.Errorf("expected %v to be %v, got %v instead", name, value, got)
+		}
+	}
+}
+
+func TestCORSHeadersAreOnlySentToAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		name     string
+		origins  []string
+		origin   string
+		expected string
+	}{
+		{"allowed", []string{"https://app.example.com"}, "https://app.example.com", "https://app.example.com"},
+		{"any origin", []string{"*"}, "https://other.example.com", "https://other.example.com"},
+		{"other origin", []string{"https://app.example.com"}, "https://evil.example.com", ""},
+		{"not configured", nil, "https://app.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			req := httptest.NewRequest("GET", "/meetups", nil)
+			req.Header.Set("Origin", tt.origin)
+			rr := httptest.NewRecorder()
+
+			// Act
+			corsRouter(tt.origins).ServeHTTP(rr, req)
+
+			// Assert
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expected {
+				t.Errorf("expected Access-Control-Allow-Origin to be %q, got %q instead", tt.expected, got)
+			}
+		})
+	}
+}
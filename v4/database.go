@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// defaultDSNs are the DSNs used when DB_DSN is not set, one for each
+// supported driver. SQLite keeps everything in memory, the others expect a
+// meetups database on the local default port.
+var defaultDSNs = map[string]string{
+	"sqlite3":  ":memory:",
+	"postgres": "host=localhost port=5432 user=postgres dbname=meetups sslmode=disable",
+	"mysql":    "root@tcp(localhost:3306)/meetups?charset=utf8mb4&parseTime=True&loc=UTC",
+}
+
+// maxConnectWait caps the wait between connection attempts as it doubles
+const maxConnectWait = 30 * time.Second
+
+// dbConfigFromEnv reads the driver from DB_DRIVER and the DSN from DB_DSN,
+// an in-memory SQLite database when neither is set. Only sqlite3 is
+// compiled in by default, postgres and mysql need the build tag of the
+// same name.
+func dbConfigFromEnv() (string, string, error) {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite3"
+	}
+	def, ok := defaultDSNs[driver]
+	if !ok {
+		supported := make([]string, 0, len(defaultDSNs))
+		for name := range defaultDSNs {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		return "", "", fmt.Errorf("DB_DRIVER: %q is not supported, use one of %v", driver, strings.Join(supported, ", "))
+	}
+	if !driverCompiledIn(driver) {
+		return "", "", fmt.Errorf("DB_DRIVER: the %v driver is not compiled in, build with -tags %v", driver, driver)
+	}
+
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		dsn = def
+	}
+	return driver, dsn, nil
+}
+
+func driverCompiledIn(driver string) bool {
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			return true
+		}
+	}
+	return false
+}
+
+// connectDB opens the database, trying up to attempts times and doubling
+// the wait in between so the API can start alongside a database that is
+// still coming up
+func connectDB(open func(driver, dsn string) (*gorm.DB, error), driver, dsn string, attempts int, wait time.Duration) (*gorm.DB, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var db *gorm.DB
+		if db, err = open(driver, dsn); err == nil {
+			return db, nil
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("connecting to the %v database failed after %v attempts: %v", driver, attempts, err)
+		}
+
+		log.Printf("connecting to the %v database failed, retrying in %v: %v", driver, wait, err)
+		time.Sleep(wait)
+		if wait *= 2; wait > maxConnectWait {
+			wait = maxConnectWait
+		}
+	}
+}
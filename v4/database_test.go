@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestTheDatabaseDefaultsToInMemorySQLite(t *testing.T) {
+	// Arrange
+	os.Unsetenv("DB_DRIVER")
+	os.Unsetenv("DB_DSN")
+
+	// Act
+	driver, dsn, err := dbConfigFromEnv()
+
+	// Assert
+	if err != nil || driver != "sqlite3" || dsn != ":memory:" {
+		t.Errorf("expected an in-memory sqlite3 database, got %v %v %v instead", driver, dsn, err)
+	}
+}
+
+func TestUnsupportedDatabaseDriversAreRejected(t *testing.T) {
+	// Arrange
+	os.Setenv("DB_DRIVER", "oracle")
+	defer os.Unsetenv("DB_DRIVER")
+
+	// Act
+	_, _, err := dbConfigFromEnv()
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected the oracle driver to be rejected")
+	}
+}
+
+func TestConnectingRetriesUntilTheDatabaseIsUp(t *testing.T) {
+	// Arrange
+	calls := 0
+	open := func(driver, dsn string) (*gorm.DB, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return gorm.Open(driver, dsn)
+	}
+
+	// Act
+	db, err := connectDB(open, "sqlite3", ":memory:", 5, time.Millisecond)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("expected to connect on the third attempt, got %v instead", err)
+	}
+	db.Close()
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %v instead", calls)
+	}
+}
+
+func TestConnectingGivesUpAfterTheLastAttempt(t *testing.T) {
+	// Arrange
+	calls := 0
+	open := func(driver, dsn string) (*gorm.DB, error) {
+		calls++
+		return nil, errors.New("connection refused")
+	}
+
+	// Act
+	_, err := connectDB(open, "postgres", "", 2, time.Millisecond)
+
+	// Assert
+	if err == nil || calls != 2 {
+		t.Errorf("expected to give up after 2 attempts, got %v attempts and %v instead", calls, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// decodeJSON decodes the request body into dst strictly, fields dst doesn't
+// have and anything after the JSON value are rejected. The error is an
+// appError saying what is wrong with the body.
+func decodeJSON(r *http.Request, dst interface{}) error {
+	if r.Body == nil {
+		return invalidJSON(io.EOF)
+	}
+	defer r.Body.Close()
+	return decodeJSONFrom(r.Body, dst)
+}
+
+// decodeJSONFrom is decodeJSON for a body that was read already
+func decodeJSONFrom(src io.Reader, dst interface{}) error {
+	dec := json.NewDecoder(src)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return invalidJSON(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &appError{Status: http.StatusBadRequest, Code: "invalid_json", Message: "the request body must hold a single JSON value"}
+	}
+	return nil
+}
+
+// invalidJSON describes why the body couldn't be decoded, naming the field
+// at fault when there is one
+func invalidJSON(err error) *appError {
+	e := &appError{Status: http.StatusBadRequest, Code: "invalid_json"}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		e.Message = "the request body is empty"
+	case err == io.ErrUnexpectedEOF:
+		e.Message = "the request body ends in the middle of the JSON"
+	case errors.As(err, &syntaxErr):
+		e.Message = fmt.Sprintf("the request body is not valid JSON at byte %v", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		e.Message = "the request body has fields of the wrong type"
+		e.Fields = map[string][]string{typeErr.Field: {fmt.Sprintf("The %v field must be a %v", typeErr.Field, jsonTypeName(typeErr.Type))}}
+	case errors.As(err, &typeErr):
+		e.Message = fmt.Sprintf("the request body must be a JSON %v", jsonTypeName(typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder has no error type for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		e.Message = "the request body has fields that aren't supported"
+		e.Fields = map[string][]string{field: {fmt.Sprintf("The %v field is not supported", field)}}
+	default:
+		e.Message = err.Error()
+	}
+	return e
+}
+
+// jsonTypeName names the Go type the way a client sending JSON thinks of it
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestBodiesAreDecodedStrictly(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+		field   string
+	}{
+		{"empty", "", "the request body is empty", ""},
+		{"malformed", `{"title":`, "the request body ends in the middle of the JSON", ""},
+		{"syntax", `{"title" "Gophers"}`, "the request body is not valid JSON at byte 10", ""},
+		{"trailing garbage", `{"title":"Gophers"} {}`, "the request body must hold a single JSON value", ""},
+		{"unknown field", `{"title":"Gophers","titel":"Gophers"}`, "the request body has fields that aren't supported", "titel"},
+		{"wrong type", `{"title":"Gophers","capacity":"lots"}`, "the request body has fields of the wrong type", "capacity"},
+		{"not an object", `["Gophers"]`, "the request body must be a JSON object", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			dst := struct {
+				Title    string `json:"title"`
+				Capacity int    `json:"capacity"`
+			}{}
+			req := httptest.NewRequest("POST", "/meetups", strings.NewReader(tt.body))
+
+			// Act
+			err := decodeJSON(req, &dst)
+
+			// Assert
+			e, ok := err.(*appError)
+			if !ok {
+				t.Fatalf("expected an appError, got %v instead", err)
+			}
+			if e.Status != 400 || e.Code != "invalid_json" || e.Message != tt.message {
+				t.Errorf("expected a 400 invalid_json saying %q, got %v %v %q instead", tt.message, e.Status, e.Code, e.Message)
+			}
+			if _, ok := e.Fields[tt.field]; tt.field != "" && !ok {
+				t.Errorf("expected the %v field to be named, got %v instead", tt.field, e.Fields)
+			}
+		})
+	}
+}
+
+func TestValidBodiesAreDecoded(t *testing.T) {
+	// Arrange
+	dst := struct {
+		Title string `json:"title"`
+	}{}
+	req := httptest.NewRequest("POST", "/meetups", strings.NewReader(`{"title":"Gophers"}`+"\n"))
+
+	// Act
+	err := decodeJSON(req, &dst)
+
+	// Assert
+	if err != nil || dst.Title != "Gophers" {
+		t.Errorf("expected the body to be decoded, got %v %+v instead", err, dst)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// meDestroy schedules the account for erasure once the grace period is over,
+// revoking every token and handing back a token that cancels the deletion
+func meDestroy(db *gorm.DB, grace time.Duration) http.HandlerFunc {
+	type meDestroyResponse struct {
+		EraseAfter   time.Time `json:"erase_after"`
+		RestoreToken string    `json:"restore_token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		plain, err := randomToken()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		eraseAfter := time.Now().Add(grace)
+
+		u := currentUser(r)
+		err = transaction(dbFor(r, db), func(tx *gorm.DB) error {
+			err := tx.Model(u).UpdateColumns(map[string]interface{}{
+				"erase_after":        eraseAfter,
+				"restore_token_hash": hashToken(plain),
+			}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Model(&token{}).Where("user_id = ? AND revoked_at IS NULL", u.ID).Update("revoked_at", time.Now()).Error
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, meDestroyResponse{
+			EraseAfter:   eraseAfter,
+			RestoreToken: plain,
+		})
+	}
+}
+
+// accountRestore cancels a pending deletion using the restore token
+// accountRestoreRules are the rules for restoring a deleted account
+var accountRestoreRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+func accountRestore(db *gorm.DB) http.HandlerFunc {
+	type accountRestoreRequest struct {
+		Token string `json:"token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := accountRestoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: accountRestoreRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		tx := dbFor(r, db)
+		u := user{}
+		if tx.Where("restore_token_hash = ? AND erase_after > ?", hashToken(req.Token), time.Now()).First(&u).RecordNotFound() {
+			writeValidationErrors(w, map[string][]string{
+				"token": {"The token is invalid or has expired"},
+			})
+			return
+		}
+
+		tx.Model(&u).UpdateColumns(map[string]interface{}{
+			"erase_after":        nil,
+			"restore_token_hash": "",
+		})
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// eraseDueAccounts permanently removes accounts whose grace period ended
+// before now, along with their tokens
+func eraseDueAccounts(db *gorm.DB, now time.Time) {
+	ids := []uint{}
+	db.Unscoped().Model(&user{}).Where("erase_after <= ?", now).Pluck("id", &ids)
+	if len(ids) == 0 {
+		return
+	}
+
+	if err := eraseUsers(db, ids); err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Printf("erased %v accounts after their deletion grace period", len(ids))
+}
+
+// eraseUsers permanently removes the users and everything that belongs to
+// them through erasurePolicy, the audit log is kept
+func eraseUsers(db *gorm.DB, ids []uint) error {
+	return transaction(db, func(tx *gorm.DB) error {
+		if err := erasurePolicy.run(tx, erasureReactions, ids, time.Now()); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN (?)", ids).Delete(&user{}).Error
+	})
+}
+
+// purgedUsersMetric counts the soft deleted users purged since the process
+// started, purgeCandidatesMetric the ones a dry run would have purged
+var (
+	purgedUsersMetric     = expvar.NewInt("purged_users")
+	purgeCandidatesMetric = expvar.NewInt("purge_candidates")
+)
+
+// purgeDeletedUsers permanently removes users soft deleted longer than the
+// retention ago, a dry run only counts and logs them
+func purgeDeletedUsers(db *gorm.DB, retention time.Duration, dryRun bool, now time.Time) (int, error) {
+	ids := []uint{}
+	err := db.Unscoped().Model(&user{}).Where("deleted_at IS NOT NULL AND deleted_at <= ?", now.Add(-retention)).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	if dryRun {
+		purgeCandidatesMetric.Add(int64(len(ids)))
+		log.Printf("dry run: would purge %v users deleted more than %v ago", len(ids), retention)
+		return len(ids), nil
+	}
+
+	if err := eraseUsers(db, ids); err != nil {
+		return 0, err
+	}
+	purgedUsersMetric.Add(int64(len(ids)))
+	log.Printf("purged %v users deleted more than %v ago", len(ids), retention)
+	return len(ids), nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeletingAnAccountRevokesItsTokens(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	other := login(db, u)
+	req, err := http.NewRequest("DELETE", "/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAuth(db, meDestroy(db, time.Hour)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusAccepted, status)
+	}
+	check, _ := http.NewRequest("GET", "/me", nil)
+	check.Header.Set("Authorization", other)
+	if _, _, ok := authenticate(db, check); ok {
+		t.Errorf("expected every token to be revoked")
+	}
+	db.First(&u, u.ID)
+	if u.EraseAfter == nil || u.EraseAfter.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("expected the account to be erased after the grace period, got %v instead", u.EraseAfter)
+	}
+}
+
+func TestAccountsCanBeRestoredDuringTheGracePeriod(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	del, _ := http.NewRequest("DELETE", "/me", nil)
+	del.Header.Set("Authorization", login(db, u))
+	deleted := httptest.NewRecorder()
+	requireAuth(db, meDestroy(db, time.Hour)).ServeHTTP(deleted, del)
+	resp := struct {
+		RestoreToken string `json:"restore_token"`
+	}{}
+	json.Unmarshal(deleted.Body.Bytes(), &resp)
+	req, err := http.NewRequest("POST", "/account/restore", bytes.NewBuffer([]byte(`{"token":"`+resp.RestoreToken+`"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(accountRestore(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+	eraseDueAccounts(db, time.Now().Add(2*time.Hour))
+
+	// Assert
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, status)
+	}
+	if db.First(&u, u.ID).RecordNotFound() {
+		t.Errorf("expected the restored account to survive erasure")
+	}
+	if u.EraseAfter != nil {
+		t.Errorf("expected the pending deletion to be cleared, got %v instead", u.EraseAfter)
+	}
+}
+
+func TestAccountsAreErasedAfterTheGracePeriod(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	due := createUser(db, "due@mccallister.io", "somePassword1!")
+	pending := createUser(db, "pending@mccallister.io", "somePassword1!")
+	kept := createUser(db, "kept@mccallister.io", "somePassword1!")
+	db.Model(&due).UpdateColumn("erase_after", time.Now().Add(-time.Minute))
+	db.Model(&pending).UpdateColumn("erase_after", time.Now().Add(time.Hour))
+	login(db, due)
+
+	// Act
+	eraseDueAccounts(db, time.Now())
+
+	// Assert
+	if !db.Unscoped().First(&user{}, due.ID).RecordNotFound() {
+		t.Errorf("expected the due account to be erased")
+	}
+	count := 0
+	db.Model(&token{}).Where("user_id = ?", due.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("expected the tokens of the erased account to be removed, found %v", count)
+	}
+	for _, u := range []user{pending, kept} {
+		if db.First(&user{}, u.ID).RecordNotFound() {
+			t.Errorf("expected %v to be kept", u.Email)
+		}
+	}
+}
+
+func TestErasedUsersCommentsKeepTheirReplies(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	erased := createUser(db, "erased@example.com", "somePassword1!")
+	other := createUser(db, "other@example.com", "somePassword1!")
+	m := createMeetup(db, other, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	parent := comment{MeetupID: m.ID, AuthorID: erased.ID, Body: "Who is bringing pizza?"}
+	db.Create(&parent)
+	reply := comment{MeetupID: m.ID, AuthorID: other.ID, ParentID: &parent.ID, Body: "I am!"}
+	db.Create(&reply)
+
+	// Act
+	err := eraseUsers(db, []uint{erased.ID})
+
+	// Assert
+	if err != nil {
+		t.Errorf("expected no error, got %v instead", err)
+	}
+	kept := comment{}
+	if db.Unscoped().First(&kept, parent.ID).RecordNotFound() {
+		t.Fatalf("expected the erased user's comment to be kept for its replies")
+	}
+	if kept.DeletedAt == nil || kept.AuthorID != 0 || kept.Body != "" {
+		t.Errorf("expected the erased user's comment to be deleted and blanked, got %+v instead", kept)
+	}
+	if db.First(&comment{}, reply.ID).RecordNotFound() {
+		t.Errorf("expected the reply to be kept")
+	}
+}
+
+func TestErasedOrganizersTakeTheirMeetupsWithThem(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "organizer@example.com", "somePassword1!")
+	attendee := createUser(db, "attendee@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	kept := createMeetup(db, attendee, "Kept", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: attendee.ID, Status: rsvpGoing})
+	db.Create(&comment{MeetupID: m.ID, AuthorID: attendee.ID, Body: "See you there"})
+	v := venue{Name: "Hatch", CreatedByID: &organizer.ID, UpdatedByID: &organizer.ID}
+	db.Create(&v)
+
+	// Act
+	err := eraseUsers(db, []uint{organizer.ID})
+
+	// Assert
+	if err != nil {
+		t.Errorf("expected no error, got %v instead", err)
+	}
+	if !db.First(&meetup{}, m.ID).RecordNotFound() {
+		t.Errorf("expected the erased organizer's meetup to be deleted")
+	}
+	if db.First(&meetup{}, kept.ID).RecordNotFound() {
+		t.Errorf("expected other organizers' meetups to be kept")
+	}
+	left := 0
+	db.Model(&rsvp{}).Where("meetup_id = ?", m.ID).Count(&left)
+	comments := 0
+	db.Unscoped().Model(&comment{}).Where("meetup_id = ?", m.ID).Count(&comments)
+	if left != 0 || comments != 0 {
+		t.Errorf("expected the meetup's rsvps and comments to go with it, got %v and %v instead", left, comments)
+	}
+	db.First(&v, v.ID)
+	if v.CreatedByID != nil || v.UpdatedByID != nil {
+		t.Errorf("expected the venue to forget the erased user, got %v and %v instead", v.CreatedByID, v.UpdatedByID)
+	}
+}
+
+func TestSoftDeletedUsersArePurgedAfterTheRetention(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	now := time.Now()
+	old := createUser(db, "old@example.com", "somePassword1!")
+	recent := createUser(db, "recent@example.com", "somePassword1!")
+	active := createUser(db, "active@example.com", "somePassword1!")
+	login(db, old)
+	db.Model(&old).UpdateColumn("deleted_at", now.Add(-48*time.Hour))
+	db.Model(&recent).UpdateColumn("deleted_at", now.Add(-time.Hour))
+
+	// Act
+	purged, err := purgeDeletedUsers(db, 24*time.Hour, false, now)
+
+	// Assert
+	if err != nil || purged != 1 {
+		t.Errorf("expected one user to be purged, got %v and %v instead", purged, err)
+	}
+	ids := []uint{}
+	db.Unscoped().Model(&user{}).Order("id").Pluck("id", &ids)
+	if len(ids) != 2 || ids[0] != recent.ID || ids[1] != active.ID {
+		t.Errorf("expected only the recently deleted and active users to remain, got %v instead", ids)
+	}
+	tokens := 0
+	db.Model(&token{}).Where("user_id = ?", old.ID).Count(&tokens)
+	if tokens != 0 {
+		t.Errorf("expected the purged user's tokens to be removed, got %v instead", tokens)
+	}
+}
+
+func TestPurgeDryRunsChangeNothing(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	now := time.Now()
+	u := createUser(db, "old@example.com", "somePassword1!")
+	db.Model(&u).UpdateColumn("deleted_at", now.Add(-48*time.Hour))
+
+	// Act
+	purged, _ := purgeDeletedUsers(db, 24*time.Hour, true, now)
+
+	// Assert
+	remaining := 0
+	db.Unscoped().Model(&user{}).Count(&remaining)
+	if purged != 1 || remaining != 1 {
+		t.Errorf("expected one candidate and nothing removed, got %v candidates and %v users instead", purged, remaining)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// doctorEnv is what the doctor knows about the machine and configuration,
+// gathered by inspectEnvironment
+type doctorEnv struct {
+	cpus        int
+	memoryBytes uint64
+	driver      string
+	getenv      func(string) string
+	hashTime    func(cost int) time.Duration
+}
+
+// recommendation is a setting the doctor suggests and why
+type recommendation struct {
+	setting     string
+	current     string
+	recommended string
+	why         string
+}
+
+// maxHashTime is the longest a login should spend hashing the password
+const maxHashTime = 250 * time.Millisecond
+
+// inspectEnvironment looks at the machine the API runs on, preferring the
+// container limits over what the host has
+func inspectEnvironment() doctorEnv {
+	env := doctorEnv{
+		cpus:   runtime.NumCPU(),
+		driver: "sqlite3",
+		getenv: os.Getenv,
+		hashTime: func(cost int) time.Duration {
+			start := time.Now()
+			bcrypt.GenerateFromPassword([]byte("doctor-sample-password"), cost)
+			return time.Since(start)
+		},
+	}
+	if quota := cgroupCPUs(); quota > 0 && quota < env.cpus {
+		env.cpus = quota
+	}
+	env.memoryBytes = cgroupMemory()
+	if env.memoryBytes == 0 {
+		env.memoryBytes = meminfoTotal()
+	}
+	if os.Getenv("DB_DRIVER") != "" {
+		env.driver = os.Getenv("DB_DRIVER")
+	}
+	if os.Getenv("TENANT_DSN") != "" && os.Getenv("TENANT_DB_DRIVER") != "" {
+		env.driver = os.Getenv("TENANT_DB_DRIVER")
+	}
+	return env
+}
+
+// cgroupCPUs reads the CPU quota of a cgroup v2 container, rounded up
+func cgroupCPUs() int {
+	data, err := ioutil.ReadFile("/sys/fs/cgroup/cpu.max")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0
+	}
+	quota, err1 := strconv.Atoi(fields[0])
+	period, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || period == 0 {
+		return 0
+	}
+	return (quota + period - 1) / period
+}
+
+// cgroupMemory reads the memory limit of a cgroup v2 container
+func cgroupMemory() uint64 {
+	data, err := ioutil.ReadFile("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+func meminfoTotal() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// recommend works out the settings that suit the environment, each
+// explained the way the meetup talks did
+func recommend(env doctorEnv) []recommendation {
+	current := func(name, def string) string {
+		if v := env.getenv(name); v != "" {
+			return v
+		}
+		return def + " (default)"
+	}
+	recs := []recommendation{}
+
+	// the highest cost that still hashes within maxHashTime, the cost
+	// doubles the work with every step so stop at the first that is too slow
+	cost, took := bcrypt.DefaultCost, env.hashTime(bcrypt.DefaultCost)
+	for next := cost + 1; next <= 14; next++ {
+		d := env.hashTime(next)
+		if d > maxHashTime {
+			break
+		}
+		cost, took = next, d
+	}
+	// a coarse clock can time a hash at zero, which says nothing about the rate
+	rate := "an unmeasurable number of"
+	if took > 0 {
+		rate = "about " + strconv.Itoa(int(float64(env.cpus)/took.Seconds()))
+	}
+	recs = append(recs, recommendation{
+		setting:     "BCRYPT_COST",
+		current:     current("BCRYPT_COST", strconv.Itoa(bcrypt.MinCost)),
+		recommended: strconv.Itoa(cost),
+		why: fmt.Sprintf("Hashing takes %v at cost %v here, the slowest that stays under %v. With %v CPUs that is %v sign ups or logins a second.",
+			took.Round(time.Millisecond), cost, maxHashTime, env.cpus, rate),
+	})
+
+	recs = append(recs, recommendation{
+		setting:     "GOMAXPROCS",
+		current:     current("GOMAXPROCS", strconv.Itoa(runtime.NumCPU())),
+		recommended: strconv.Itoa(env.cpus),
+		why:         "Running more threads than the CPUs the container may use only adds scheduling and throttling.",
+	})
+
+	recs = append(recs, recommendation{
+		setting:     "SHED_MAX_IN_FLIGHT",
+		current:     current("SHED_MAX_IN_FLIGHT", "512"),
+		recommended: strconv.Itoa(env.cpus * 64),
+		why:         fmt.Sprintf("Requests beyond what %v CPUs get through only queue up and add latency, shedding them early keeps the rest fast.", env.cpus),
+	})
+
+	latency := 2 * time.Second
+	if min := 8 * took; min > latency {
+		latency = min
+	}
+	recs = append(recs, recommendation{
+		setting:     "SHED_MAX_LATENCY",
+		current:     current("SHED_MAX_LATENCY", "2s"),
+		recommended: latency.Round(time.Millisecond).String(),
+		why:         "The latency budget has to leave room for several password hashes, otherwise logins alone trigger shedding.",
+	})
+
+	if env.memoryBytes > 0 {
+		mb := env.memoryBytes / (1 << 20)
+		recs = append(recs, recommendation{
+			setting:     "WATCHDOG_HEAP_LIMIT_MB",
+			current:     current("WATCHDOG_HEAP_LIMIT_MB", "unset"),
+			recommended: strconv.FormatUint(mb*3/4, 10),
+			why:         fmt.Sprintf("There are %v MiB available, shedding reads at three quarters of it leaves room for the garbage collector before the process is killed.", mb),
+		})
+
+		// every open sqlite database keeps its page cache, a couple of MiB each
+		pools := mb / 4 / 2
+		if pools > 1000 {
+			pools = 1000
+		}
+		recs = append(recs, recommendation{
+			setting:     "TENANT_POOLS_OPEN",
+			current:     current("TENANT_POOLS_OPEN", "100"),
+			recommended: strconv.FormatUint(pools, 10),
+			why:         fmt.Sprintf("Each open %v tenant database holds on to its cache, this keeps them to a quarter of the memory.", env.driver),
+		})
+	}
+
+	return recs
+}
+
+// runDoctor prints the recommendations for the environment
+func runDoctor(w io.Writer, env doctorEnv) {
+	fmt.Fprintf(w, "%v CPUs, %v MiB of memory, %v database\n", env.cpus, env.memoryBytes/(1<<20), env.driver)
+	for _, rec := range recommend(env) {
+		fmt.Fprintf(w, "\n%v=%v\n  current: %v\n  %v\n", rec.setting, rec.recommended, rec.current, rec.why)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeDoctorEnv(vars map[string]string) doctorEnv {
+	return doctorEnv{
+		cpus:        4,
+		memoryBytes: 2 << 30,
+		driver:      "sqlite3",
+		getenv:      func(name string) string { return vars[name] },
+		// every step of the cost doubles the work, 50ms at the default of 10
+		hashTime: func(cost int) time.Duration { return 50 * time.Millisecond << uint(cost-10) },
+	}
+}
+
+func TestTheDoctorRecommendsSettingsForTheEnvironment(t *testing.T) {
+	// Arrange
+	env := fakeDoctorEnv(map[string]string{"SHED_MAX_IN_FLIGHT": "2048"})
+
+	// Act
+	recs := map[string]recommendation{}
+	for _, rec := range recommend(env) {
+		recs[rec.setting] = rec
+	}
+
+	// Assert
+	expected := map[string]string{
+		"BCRYPT_COST":            "12",
+		"GOMAXPROCS":             "4",
+		"SHED_MAX_IN_FLIGHT":     "256",
+		"SHED_MAX_LATENCY":       "2s",
+		"WATCHDOG_HEAP_LIMIT_MB": "1536",
+		"TENANT_POOLS_OPEN":      "256",
+	}
+	for setting, value := range expected {
+		if recs[setting].recommended != value {
+			t.Errorf("expected %v to be %v, got %v instead", setting, value, recs[setting].recommended)
+		}
+	}
+	if current := recs["SHED_MAX_IN_FLIGHT"].current; current != "2048" {
+		t.Errorf("expected the configured value to be reported, got %v instead", current)
+	}
+	if current := recs["BCRYPT_COST"].current; current != "4 (default)" {
+		t.Errorf("expected the default to be reported, got %v instead", current)
+	}
+}
+
+func TestTheDoctorExplainsEachRecommendation(t *testing.T) {
+	// Arrange
+	out := &bytes.Buffer{}
+
+	// Act
+	runDoctor(out, fakeDoctorEnv(nil))
+
+	// Assert
+	if !strings.Contains(out.String(), "BCRYPT_COST=12\n  current: 4 (default)\n  Hashing takes 200ms at cost 12") {
+		t.Errorf("expected the bcrypt cost to be explained, got %v instead", out.String())
+	}
+}
+
+func TestTheDoctorCopesWithHashesTooFastToTime(t *testing.T) {
+	// Arrange
+	env := fakeDoctorEnv(nil)
+	env.hashTime = func(cost int) time.Duration { return 0 }
+
+	// Act
+	recs := recommend(env)
+
+	// Assert
+	if !strings.Contains(recs[0].why, "unmeasurable") {
+		t.Errorf("expected the rate to be reported as unmeasurable, got %q instead", recs[0].why)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "strings"
+
+// preserveLocalCase keeps the case of the part of an email before the @,
+// the domain is always lowercased. Uniqueness ignores case either way.
+var preserveLocalCase = false
+
+// normalizeEmail trims and lowercases an email so the same address is always
+// stored and looked up the same way
+func normalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return strings.ToLower(email)
+	}
+
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if !preserveLocalCase {
+		local = strings.ToLower(local)
+	}
+	return local + "@" + domain
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmailsAreNormalized(t *testing.T) {
+	tests := map[string]string{
+		"  User@Example.COM ": "user@example.com",
+		"user@example.com":    "user@example.com",
+		"not-an-email":        "not-an-email",
+	}
+
+	for email, expected := range tests {
+		// Act
+		normalized := normalizeEmail(email)
+
+		// Assert
+		if normalized != expected {
+			t.Errorf("expected %q to be normalized to %q, got %q instead", email, expected, normalized)
+		}
+	}
+}
+
+func TestTheLocalPartCaseCanBePreserved(t *testing.T) {
+	// Arrange
+	preserveLocalCase = true
+	defer func() { preserveLocalCase = false }()
+
+	// Act
+	normalized := normalizeEmail("User@Example.COM")
+
+	// Assert
+	if normalized != "User@example.com" {
+		t.Errorf("expected the local part to keep its case, got %v instead", normalized)
+	}
+}
+
+func TestEmailsAreUniqueRegardlessOfCase(t *testing.T) {
+	// Arrange
+	preserveLocalCase = true
+	defer func() { preserveLocalCase = false }()
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "user@example.com", "somePassword1!")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"User@Example.com","password":"somePassword1!"}`)))
+
+	// Assert
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusConflict, status)
+	}
+}
+
+func TestUsersCanSignInWithAnyCase(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "user@example.com", "somePassword1!")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sessionsStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"USER@example.com","password":"somePassword1!"}`)))
+
+	// Assert
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusCreated, status)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// emailChangeRules are the rules for asking to change the email, the
+// password is checked again since the new address takes over the account
+var emailChangeRules = govalidator.MapData{
+	"email":    []string{"required", "min:4", "max:30", "email"},
+	"password": []string{"required"},
+}
+
+// emailConfirmRules are the rules for confirming a new email
+var emailConfirmRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+// errEmailTaken is the error for moving to an email another account uses
+func errEmailTaken() *appError {
+	return &appError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    "email_taken",
+		Message: "The email has already been taken",
+		Fields:  map[string][]string{"email": {"The email has already been taken"}},
+	}
+}
+
+// emailTaken reports whether another account already uses the email
+func emailTaken(db *gorm.DB, email string, except uint) bool {
+	taken := 0
+	db.Unscoped().Model(&user{}).Where("lower(email) = ? AND id <> ?", strings.ToLower(email), except).Count(&taken)
+	return taken >= 1
+}
+
+// emailChange stores the new email as pending and mails a confirmation token
+// to it, the email only changes once the token is confirmed
+func emailChange(db *gorm.DB, mail mailer, ttl time.Duration) http.HandlerFunc {
+	type emailChangeRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	type emailChangeResponse struct {
+		PendingEmail string    `json:"pending_email"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := emailChangeRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: emailChangeRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		u := currentUser(r)
+		if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.Password)) != nil {
+			writeValidationErrors(w, map[string][]string{
+				"password": {"The password is incorrect"},
+			})
+			return
+		}
+
+		tx := dbFor(r, db)
+		email := normalizeEmail(req.Email)
+		if strings.EqualFold(email, u.Email) {
+			writeValidationErrors(w, map[string][]string{"email": {"The email is already yours"}})
+			return
+		}
+		if emailTaken(tx, email, u.ID) {
+			writeAppError(w, errEmailTaken())
+			return
+		}
+
+		plain, err := randomToken()
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		expiresAt := time.Now().Add(ttl)
+		err = tx.Model(u).UpdateColumns(map[string]interface{}{
+			"pending_email":           email,
+			"email_change_token_hash": hashToken(plain),
+			"email_change_expires_at": expiresAt,
+		}).Error
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		body := fmt.Sprintf("Confirm your new email by sending this token to POST /me/email/confirm:\n\n%v\n\nIt expires at %v.", plain, expiresAt.UTC().Format(time.RFC1123))
+		if err := mail.send(email, "Confirm your new email", body); err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "the confirmation email could not be sent")
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, emailChangeResponse{PendingEmail: email, ExpiresAt: expiresAt})
+	}
+}
+
+// emailConfirm swaps in the pending email the token was sent to, whoever
+// holds the token has the mailbox so it doesn't need a bearer token
+func emailConfirm(db *gorm.DB) http.HandlerFunc {
+	type emailConfirmRequest struct {
+		Token string `json:"token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := emailConfirmRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: emailConfirmRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		tx := dbFor(r, db)
+		u := user{}
+		if tx.Where("email_change_token_hash = ? AND email_change_expires_at > ?", hashToken(req.Token), time.Now()).First(&u).RecordNotFound() {
+			writeValidationErrors(w, map[string][]string{
+				"token": {"The token is invalid or has expired"},
+			})
+			return
+		}
+
+		// the address may have been taken since the change was asked for
+		err := tx.Model(&u).UpdateColumns(map[string]interface{}{
+			"email":                   u.PendingEmail,
+			"pending_email":           "",
+			"email_change_token_hash": "",
+			"email_change_expires_at": nil,
+		}).Error
+		if err != nil {
+			if isUniqueViolation(err) {
+				writeAppError(w, errEmailTaken())
+				return
+			}
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		recordAuthEvent(tx, r, authEventEmailChanged, u.ID, u.Email)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingMailer keeps the emails it is asked to send
+type recordingMailer struct {
+	to, subject, body string
+	sent              int
+}
+
+func (m *recordingMailer) send(to, subject, body string) error {
+	m.to, m.subject, m.body = to, subject, body
+	m.sent++
+	return nil
+}
+
+// mailedToken picks the confirmation token out of the email
+func mailedToken(m *recordingMailer) string {
+	for _, line := range strings.Split(m.body, "\n") {
+		if len(line) == 64 {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestEmailsOnlyChangeOnceConfirmed(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "old@example.com", "somePassword1!")
+	mail := &recordingMailer{}
+	req := httptest.NewRequest("POST", "/me/email", strings.NewReader(`{"email":"New@Example.com","password":"somePassword1!"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	requireAuth(db, emailChange(db, mail, time.Hour)).ServeHTTP(rr, req)
+	db.First(&u, u.ID)
+	before := u.Email
+	confirmed := httptest.NewRecorder()
+	emailConfirm(db).ServeHTTP(confirmed, httptest.NewRequest("POST", "/me/email/confirm", strings.NewReader(fmt.Sprintf(`{"token":%q}`, mailedToken(mail)))))
+	db.First(&u, u.ID)
+
+	// Assert
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Fatalf("expected the status code to be %v, got %v instead: %v", http.StatusAccepted, status, rr.Body.String())
+	}
+	if mail.to != "new@example.com" {
+		t.Errorf("expected the confirmation to be sent to the new email, got %v instead", mail.to)
+	}
+	if before != "old@example.com" {
+		t.Errorf("expected the email to stay the same until confirmed, got %v instead", before)
+	}
+	if status := confirmed.Code; status != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead: %v", http.StatusNoContent, status, confirmed.Body.String())
+	}
+	if u.Email != "new@example.com" || u.PendingEmail != "" || u.EmailChangeTokenHash != "" {
+		t.Errorf("expected the pending email to be swapped in, got %+v instead", u)
+	}
+}
+
+func TestEmailChangesAreValidated(t *testing.T) {
+	tests := map[string]string{
+		`{"email":"taken@example.com","password":"somePassword1!"}`: "The email has already been taken",
+		`{"email":"me@example.com","password":"somePassword1!"}`:    "The email is already yours",
+		`{"email":"new@example.com","password":"wrongPassword1!"}`:  "The password is incorrect",
+		`{"email":"not an email","password":"somePassword1!"}`:      "The email field must be a valid email address",
+	}
+
+	for body, message := range tests {
+		// Arrange
+		db, rollback := testTx(t)
+		u := createUser(db, "me@example.com", "somePassword1!")
+		createUser(db, "taken@example.com", "somePassword1!")
+		req := httptest.NewRequest("POST", "/me/email", strings.NewReader(body))
+		req.Header.Set("Authorization", login(db, u))
+		rr := httptest.NewRecorder()
+
+		// Act
+		requireAuth(db, emailChange(db, &recordingMailer{}, time.Hour)).ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), message) {
+			t.Errorf("expected %v for %v, got %v %v instead", message, body, status, rr.Body.String())
+		}
+		rollback()
+	}
+}
+
+func TestExpiredEmailConfirmationsAreRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "old@example.com", "somePassword1!")
+	db.Model(&u).UpdateColumns(map[string]interface{}{
+		"pending_email":           "new@example.com",
+		"email_change_token_hash": hashToken("expired"),
+		"email_change_expires_at": time.Now().Add(-time.Minute),
+	})
+	rr := httptest.NewRecorder()
+
+	// Act
+	emailConfirm(db).ServeHTTP(rr, httptest.NewRequest("POST", "/me/email/confirm", strings.NewReader(`{"token":"expired"}`)))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestUsersCannotPatchTheirEmailDirectly(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "old@example.com", "somePassword1!")
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"email":"new@example.com"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	updateRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "POST /me/email") {
+		t.Errorf("expected to be pointed at POST /me/email, got %v %v instead", status, rr.Body.String())
+	}
+}
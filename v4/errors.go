@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// appError is an error a client can act on, its code says what went wrong
+// in a way clients can branch on without parsing the message
+type appError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  map[string][]string
+}
+
+func (e *appError) Error() string {
+	return e.Message
+}
+
+// statusCodes are the codes of errors that name nothing more specific than
+// their status, statuses missing here use their status text
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_parameters",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal_error",
+}
+
+// codeFor is the code of a generic error with the status, such as not_found
+// for a 404
+func codeFor(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// newAppError makes an error with the status' generic code
+func newAppError(status int, message string) *appError {
+	return &appError{Status: status, Code: codeFor(status), Message: message}
+}
+
+// writeAppError sends the error as a problem with its code. Errors that
+// aren't appErrors are unexpected, the client only learns something went
+// wrong.
+func writeAppError(w http.ResponseWriter, err error) {
+	e, ok := err.(*appError)
+	if !ok {
+		e = newAppError(http.StatusInternalServerError, "internal server error")
+	}
+
+	p := problem{Status: e.Status, Code: e.Code, Detail: e.Message, Errors: e.Fields}
+	if p.Code == "" {
+		p.Code = codeFor(e.Status)
+	}
+	writeProblem(w, p)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// etags gives successful GET responses an ETag and answers requests whose
+// If-None-Match names it with a 304, so polling clients only download what
+// changed. Handlers that know their version, like the user endpoints, set
+// their own ETag, everything else is tagged with a hash of its body.
+func etags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &etagWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
+
+// bodyETag is a weak ETag for the body, weak because compressing the
+// response changes its bytes but not what it means
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`W/"%x"`, sum[:16])
+}
+
+// noneMatch reports whether the If-None-Match header names the ETag, using
+// the weak comparison the header calls for
+func noneMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter holds the response back until the handler finishes so its body
+// can be hashed. Streamed responses stop being held back when they flush.
+type etagWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (ew *etagWriter) WriteHeader(status int) {
+	if ew.streaming {
+		return
+	}
+	ew.status = status
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if ew.streaming {
+		return ew.ResponseWriter.Write(b)
+	}
+	return ew.buf.Write(b)
+}
+
+func (ew *etagWriter) Flush() {
+	if !ew.streaming {
+		ew.streaming = true
+		ew.ResponseWriter.WriteHeader(ew.status)
+		ew.ResponseWriter.Write(ew.buf.Bytes())
+		ew.buf.Reset()
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish tags the response and sends it, or a 304 if the client has it
+func (ew *etagWriter) finish(r *http.Request) {
+	if ew.streaming {
+		return
+	}
+
+	h := ew.Header()
+	if ew.status == http.StatusOK {
+		if h.Get("ETag") == "" {
+			h.Set("ETag", bodyETag(ew.buf.Bytes()))
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" && noneMatch(match, h.Get("ETag")) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			ew.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeetupsCanBeFetchedConditionally(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "etags@example.com", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Now().Add(24*time.Hour))
+	h := etags(meetupsRouter(db))
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v", m.ID), nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Act
+	first := get("")
+	etag := first.Header().Get("ETag")
+	unchanged := get(etag)
+	db.Model(&m).Update("title", "Norfolk Gophers Halloween")
+	changed := get(etag)
+
+	// Assert
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected a 200 with an ETag, got %v and %q instead", first.Code, etag)
+	}
+	if unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+		t.Errorf("expected an empty 304 for an unchanged meetup, got %v with %v bytes instead", unchanged.Code, unchanged.Body.Len())
+	}
+	if got := unchanged.Header().Get("ETag"); got != etag {
+		t.Errorf("expected the 304 to carry the ETag %v, got %v instead", etag, got)
+	}
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Errorf("expected a 200 with a new ETag once the meetup changed, got %v and %v instead", changed.Code, changed.Header().Get("ETag"))
+	}
+}
+
+func TestETagsKeepTheHandlersOwn(t *testing.T) {
+	// Arrange
+	h := etags(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("{}"))
+	}))
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("If-None-Match", `W/"v0", W/"v1"`)
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected a weak match on the handler's ETag to give a 304, got %v instead", rr.Code)
+	}
+}
+
+func TestETagsSkipUnsuccessfulAndUnsafeRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+	}{
+		{"not found", "GET", http.StatusNotFound},
+		{"post", "POST", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			h := etags(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("{}"))
+			}))
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(""))
+			req.Header.Set("If-None-Match", "*")
+			rr := httptest.NewRecorder()
+
+			// Act
+			h.ServeHTTP(rr, req)
+
+			// Assert
+			if rr.Code != tt.status || rr.Header().Get("ETag") != "" {
+				t.Errorf("expected an untagged %v, got %v with ETag %q instead", tt.status, rr.Code, rr.Header().Get("ETag"))
+			}
+		})
+	}
+}
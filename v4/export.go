@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+// exportFlushEvery is how many rows are written between flushes
+const exportFlushEvery = 500
+
+// usersExport streams every user as one JSON object per line, reading the
+// rows one at a time so the export never holds the table in memory
+func usersExport(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, []string{"format"}); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		if format := r.URL.Query().Get("format"); format != "" && format != "ndjson" {
+			writeValidationErrors(w, map[string][]string{"format": {"The format must be ndjson"}})
+			return
+		}
+
+		tx := dbFor(r, db)
+		// loaded up front, the rows hold the connection while they stream
+		actors := loadActorIDs(tx.Where("id IN (SELECT created_by_id FROM users) OR id IN (SELECT updated_by_id FROM users)"))
+		rows, err := tx.Model(&user{}).Order("id asc").Rows()
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		defer rows.Close()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		n := 0
+		for rows.Next() {
+			u := user{}
+			if err := tx.ScanRows(rows, &u); err != nil {
+				// the status is already sent, a truncated export is all
+				// that can be signalled
+				logError(r, err)
+				return
+			}
+			if err := enc.Encode(newAdminUser(u, actors)); err != nil {
+				return
+			}
+			if n++; n%exportFlushEvery == 0 && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err := rows.Err(); err != nil {
+			logError(r, err)
+		}
+	}
+}
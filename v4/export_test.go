@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUsersAreExportedAsNDJSON(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com", "three@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersExport(db).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/users/export?format=ndjson", nil))
+
+	// Assert
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("expected an NDJSON content type, got %v instead", contentType)
+	}
+	emails := []string{}
+	s := bufio.NewScanner(rr.Body)
+	for s.Scan() {
+		u := adminUser{}
+		if err := json.Unmarshal(s.Bytes(), &u); err != nil {
+			t.Fatalf("expected every line to be a user, got %q instead", s.Text())
+		}
+		emails = append(emails, u.Email)
+	}
+	if len(emails) != 3 || emails[0] != "one@example.com" || emails[2] != "three@example.com" {
+		t.Errorf("expected every user in id order, got %v instead", emails)
+	}
+}
+
+func TestExportsOnlySupportNDJSON(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersExport(db).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/users/export?format=csv", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestWrappedWritersCanStillFlush(t *testing.T) {
+	// Arrange
+	rr := httptest.NewRecorder()
+	var w http.ResponseWriter = &statusWriter{ResponseWriter: &timingWriter{ResponseWriter: rr, timings: &timings{}}}
+
+	// Act
+	w.(http.Flusher).Flush()
+
+	// Assert
+	if !rr.Flushed {
+		t.Errorf("expected the flush to reach the underlying writer")
+	}
+}
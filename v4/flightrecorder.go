@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecordedBody is how much of a request body the flight recorder keeps
+const maxRecordedBody = 4096
+
+// slowRequest is what the flight recorder keeps about a request
+type slowRequest struct {
+	Method     string             `json:"method"`
+	Path       string             `json:"path"`
+	Status     int                `json:"status"`
+	DurationMS float64            `json:"duration_ms"`
+	PhasesMS   map[string]float64 `json:"phases_ms"`
+	Queries    []string           `json:"queries"`
+	Body       interface{}        `json:"body"`
+	At         time.Time          `json:"at"`
+}
+
+// flightRecorder keeps the slowest requests seen since the process started so
+// production slowness can be looked into after the fact
+type flightRecorder struct {
+	mu       sync.Mutex
+	size     int
+	requests []slowRequest
+}
+
+func newFlightRecorder(size int) *flightRecorder {
+	return &flightRecorder{size: size}
+}
+
+// add keeps the request when it is slower than the fastest one kept so far
+func (fr *flightRecorder) add(req slowRequest) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if len(fr.requests) == fr.size && req.DurationMS <= fr.requests[fr.size-1].DurationMS {
+		return
+	}
+
+	fr.requests = append(fr.requests, req)
+	sort.SliceStable(fr.requests, func(i, j int) bool {
+		return fr.requests[i].DurationMS > fr.requests[j].DurationMS
+	})
+	if len(fr.requests) > fr.size {
+		fr.requests = fr.requests[:fr.size]
+	}
+}
+
+// slowest returns the kept requests, slowest first
+func (fr *flightRecorder) slowest() []slowRequest {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	out := make([]slowRequest, len(fr.requests))
+	copy(out, fr.requests)
+	return out
+}
+
+// middleware times every request and offers it to the recorder, it needs to
+// run inside serverTiming to pick up the phases and queries
+func (fr *flightRecorder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &bodyRecorder{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		req := slowRequest{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+			DurationMS: milliseconds(time.Since(start)),
+			Body:       sanitizeBody(body.buf.Bytes(), body.truncated),
+			At:         start,
+		}
+		if t := timingsFrom(r); t != nil {
+			req.PhasesMS, req.Queries = t.snapshot()
+		}
+		fr.add(req)
+	})
+}
+
+// statusWriter remembers the status code written by the handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through so streamed responses aren't held back
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// bodyRecorder keeps a copy of the start of the body as the handler reads it
+type bodyRecorder struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (br *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := br.ReadCloser.Read(p)
+	if room := maxRecordedBody - br.buf.Len(); room > 0 {
+		if n > room {
+			br.buf.Write(p[:room])
+			br.truncated = true
+		} else {
+			br.buf.Write(p[:n])
+		}
+	} else if n > 0 {
+		br.truncated = true
+	}
+	return n, err
+}
+
+// sensitiveFields are masked anywhere they appear in a recorded JSON body
+var sensitiveFields = []string{"password", "token", "secret"}
+
+// sanitizeBody masks sensitive fields in a JSON body, bodies that are not
+// JSON or were truncated are only described by their size
+func sanitizeBody(body []byte, truncated bool) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var v interface{}
+	if truncated || json.Unmarshal(body, &v) != nil {
+		return fmt.Sprintf("[%v bytes omitted]", len(body))
+	}
+
+	return maskSensitive(v)
+}
+
+func maskSensitive(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSensitive(key) {
+				v[key] = "[FILTERED]"
+				continue
+			}
+			v[key] = maskSensitive(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = maskSensitive(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range sensitiveFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func slowRequestsIndex(fr *flightRecorder) http.HandlerFunc {
+	type slowRequestsIndexResponse struct {
+		Requests []slowRequest `json:"requests"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, slowRequestsIndexResponse{Requests: fr.slowest()})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTheFlightRecorderKeepsTheSlowestRequests(t *testing.T) {
+	// Arrange
+	fr := newFlightRecorder(2)
+
+	// Act
+	for i, duration := range []float64{5, 50, 1, 20, 10} {
+		fr.add(slowRequest{Path: string(rune('a' + i)), DurationMS: duration})
+	}
+
+	// Assert
+	slowest := fr.slowest()
+	if len(slowest) != 2 {
+		t.Fatalf("expected 2 requests to be kept, got %v instead", len(slowest))
+	}
+	if slowest[0].DurationMS != 50 || slowest[1].DurationMS != 20 {
+		t.Errorf("expected the 50ms and 20ms requests to be kept, got %+v instead", slowest)
+	}
+}
+
+func TestTheFlightRecorderCapturesQueriesAndMasksSecrets(t *testing.T) {
+	// Arrange
+	db := getDB()
+	registerTimingCallbacks(db)
+	migrate(db)
+	fr := newFlightRecorder(5)
+	handler := serverTiming(fr.middleware(usersStore(db)))
+	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer([]byte(`{"email":"jason@mccallister.io","password":"somePassword1!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	rr := httptest.NewRecorder()
+	slowRequestsIndex(fr).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/debug/slow", nil))
+
+	// Assert
+	body := rr.Body.String()
+	if strings.Contains(body, "somePassword1!") {
+		t.Errorf("expected the password to be masked, got %v instead", body)
+	}
+	for _, expected := range []string{`"password":"[FILTERED]"`, `"status":201`, `INTO \"users\"`, `"hashing":`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the recorded request to contain %v, got %v instead", expected, body)
+		}
+	}
+}
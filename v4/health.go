@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+func healthShow() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// readiness flips once the server has warmed up, until then load balancers
+// polling /readyz are told to keep traffic away
+type readiness struct {
+	ready int32
+}
+
+func (rd *readiness) isReady() bool {
+	return atomic.LoadInt32(&rd.ready) == 1
+}
+
+func (rd *readiness) markReady() {
+	atomic.StoreInt32(&rd.ready, 1)
+}
+
+func readyShow(rd *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rd.isReady() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "warming up"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	}
+}
+
+// warmUpStep is a piece of work done before the first request is served so
+// it doesn't show up as latency on that request
+type warmUpStep struct {
+	name string
+	run  func() error
+}
+
+// warmUp runs the steps in order and marks the server ready once they all
+// succeeded, the first failure is returned and readiness is left alone
+func warmUp(rd *readiness, steps []warmUpStep) error {
+	for _, step := range steps {
+		start := time.Now()
+		if err := step.run(); err != nil {
+			return fmt.Errorf("warm up %v: %v", step.name, err)
+		}
+		log.Printf("warm up %v took %v", step.name, time.Since(start))
+	}
+
+	rd.markReady()
+	return nil
+}
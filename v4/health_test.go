@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessFlipsAfterWarmingUp(t *testing.T) {
+	// Arrange
+	db := getDB()
+	migrate(db)
+	rd := &readiness{}
+	handler := readyShow(rd)
+	before := httptest.NewRecorder()
+	handler.ServeHTTP(before, httptest.NewRequest("GET", "/readyz", nil))
+
+	// Act
+	err := warmUp(rd, warmUpSteps(db))
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := before.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusServiceUnavailable, status)
+	}
+	after := httptest.NewRecorder()
+	handler.ServeHTTP(after, httptest.NewRequest("GET", "/readyz", nil))
+	if status := after.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+}
+
+func TestAFailedWarmUpLeavesTheServerUnready(t *testing.T) {
+	// Arrange
+	rd := &readiness{}
+	ran := false
+	steps := []warmUpStep{
+		{name: "broken", run: func() error { return errors.New("no database") }},
+		{name: "after", run: func() error { ran = true; return nil }},
+	}
+
+	// Act
+	err := warmUp(rd, steps)
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected the warm up error to be returned")
+	}
+	if ran {
+		t.Errorf("expected the remaining steps to be skipped")
+	}
+	if rd.isReady() {
+		t.Errorf("expected the server to stay unready")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the language the messages are written in, requests
+// that don't ask for a supported language get it
+const defaultLanguage = "en"
+
+// messageStore translates the English messages of problems, the details,
+// titles and field errors the handlers and govalidator write
+type messageStore interface {
+	// languages are the languages the store translates to
+	languages() []string
+	// translate returns the message in the language, ok is false when the
+	// store has no translation for it
+	translate(lang, message string) (translated string, ok bool)
+}
+
+// messages is the store problems are translated with, set by main
+var messages messageStore = newCatalog(spanishMessages)
+
+// catalog is a messageStore of message templates by language, a {name} in
+// a template stands for whatever the English message has in its place so
+// "The {field} field is required" covers every field
+type catalog struct {
+	langs     []string
+	templates map[string][]messageTemplate
+}
+
+type messageTemplate struct {
+	pattern     *regexp.Regexp
+	names       []string
+	translation string
+}
+
+var placeholderPattern = regexp.MustCompile(`\\\{([a-z_]+)\\\}`)
+
+// newCatalog compiles the translations, given as English template to
+// translated template by language
+func newCatalog(translations map[string]map[string]string) *catalog {
+	c := &catalog{langs: []string{defaultLanguage}, templates: map[string][]messageTemplate{}}
+	for lang, byMessage := range translations {
+		c.langs = append(c.langs, lang)
+		for message, translation := range byMessage {
+			t := messageTemplate{translation: translation}
+			pattern := placeholderPattern.ReplaceAllStringFunc(regexp.QuoteMeta(message), func(s string) string {
+				t.names = append(t.names, placeholderPattern.FindStringSubmatch(s)[1])
+				return "(.+?)"
+			})
+			t.pattern = regexp.MustCompile("^" + pattern + "$")
+			c.templates[lang] = append(c.templates[lang], t)
+		}
+		// literal templates go first so a placeholder can't claim a message
+		// that has a translation of its own
+		sort.SliceStable(c.templates[lang], func(i, j int) bool {
+			return len(c.templates[lang][i].names) < len(c.templates[lang][j].names)
+		})
+	}
+	sort.Strings(c.langs[1:])
+	return c
+}
+
+func (c *catalog) languages() []string {
+	return c.langs
+}
+
+func (c *catalog) translate(lang, message string) (string, bool) {
+	for _, t := range c.templates[lang] {
+		match := t.pattern.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		translated := t.translation
+		for i, name := range t.names {
+			translated = strings.Replace(translated, "{"+name+"}", match[i+1], 1)
+		}
+		return translated, true
+	}
+	return "", false
+}
+
+// preferredLanguage picks the language with the highest quality in the
+// Accept-Language header, a range like es-MX matches es. Ties go to the
+// language listed first.
+func preferredLanguage(header string, supported []string) string {
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(tag, "-"); i > 0 {
+			tag = tag[:i]
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		for _, lang := range supported {
+			if lang == tag && q > bestQ {
+				best, bestQ = lang, q
+			}
+		}
+	}
+	return best
+}
+
+// localize translates the problems the handlers send into the language the
+// client prefers, messages the store can't translate are left in English.
+// It needs to run inside apiVersions so older versions get translated
+// errors too.
+func localize(store messageStore) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Language")
+			lang := preferredLanguage(r.Header.Get("Accept-Language"), store.languages())
+			if lang == defaultLanguage {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			pw := &problemWriter{ResponseWriter: w}
+			next.ServeHTTP(pw, r)
+			pw.finish(func(p *problem) {
+				tr := func(message string) string {
+					if translated, ok := store.translate(lang, message); ok {
+						return translated
+					}
+					return message
+				}
+				w.Header().Set("Content-Language", lang)
+				p.Title = tr(p.Title)
+				p.Detail = tr(p.Detail)
+				for field, errs := range p.Errors {
+					for i, message := range errs {
+						errs[i] = tr(message)
+					}
+					p.Errors[field] = errs
+				}
+			})
+		})
+	}
+}
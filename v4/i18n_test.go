@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemsAreTranslatedToThePreferredLanguage(t *testing.T) {
+	// Arrange
+	handler := localize(newCatalog(spanishMessages))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeValidationErrors(w, map[string][]string{"email": {"The email field is required", "Something nobody translated"}})
+	}))
+	req := httptest.NewRequest("POST", "/users", nil)
+	req.Header.Set("Accept-Language", "fr;q=0.9, es-MX, en;q=0.5")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	expected := `{"type":"about:blank","title":"Entidad no procesable","status":422,"code":"validation_failed","detail":"La solicitud tiene campos no válidos","errors":{"email":["El campo email es obligatorio","Something nobody translated"]}}`
+	if body := rr.Body.String(); body != expected {
+		t.Errorf("expected %v, got %v instead", expected, body)
+	}
+	if got := rr.Header().Get("Content-Language"); got != "es" {
+		t.Errorf("expected the Content-Language to be es, got %v instead", got)
+	}
+}
+
+func TestProblemsStayInEnglishByDefault(t *testing.T) {
+	for _, header := range []string{"", "fr", "en, es;q=0.5"} {
+		// Arrange
+		handler := localize(newCatalog(spanishMessages))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusNotFound, "not found")
+		}))
+		req := httptest.NewRequest("GET", "/users/99", nil)
+		req.Header.Set("Accept-Language", header)
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if body := rr.Body.String(); !strings.Contains(body, `"detail":"not found"`) {
+			t.Errorf("expected %q to get the English message, got %v instead", header, body)
+		}
+	}
+}
+
+func TestTemplatesFillInWhatTheMessageSays(t *testing.T) {
+	// Arrange
+	c := newCatalog(spanishMessages)
+
+	// Act
+	translated, ok := c.translate("es", "The title field must be maximum 255 char")
+
+	// Assert
+	if !ok || translated != "El campo title debe tener como máximo 255 caracteres" {
+		t.Errorf("expected the template to be filled in, got %v instead", translated)
+	}
+}
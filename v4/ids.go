@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// uuidIDs hands out UUIDs instead of the auto-increment keys so user IDs
+// can't be enumerated. The integer key stays the primary key that tokens
+// and other records refer to, it just never leaves the server.
+var uuidIDs = false
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// BeforeCreate gives every user a UUID, whether or not it is handed out, so
+// the option can be turned on later without renumbering anyone
+func (u *user) BeforeCreate(scope *gorm.Scope) error {
+	if u.UUID == nil {
+		id := newUUID()
+		return scope.SetColumn("UUID", &id)
+	}
+	return nil
+}
+
+// backfillUUIDs gives users created before UUIDs existed one
+func backfillUUIDs(db *gorm.DB) error {
+	ids := []uint{}
+	if err := db.Unscoped().Model(&user{}).Where("uuid IS NULL").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.Unscoped().Model(&user{}).Where("id = ?", id).UpdateColumn("uuid", newUUID()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiID is a user ID as the API shows it, the UUID when UUIDs are handed out
+// and the integer key otherwise
+type apiID struct {
+	Key  uint
+	UUID string
+}
+
+func idOf(u user) apiID {
+	id := apiID{Key: u.ID}
+	if u.UUID != nil {
+		id.UUID = *u.UUID
+	}
+	return id
+}
+
+// parseAPIID reads an ID from a path, numbers are keys and anything else a UUID
+func parseAPIID(s string) apiID {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return apiID{Key: uint(n)}
+	}
+	return apiID{UUID: s}
+}
+
+func (id apiID) MarshalJSON() ([]byte, error) {
+	if id.UUID != "" && (uuidIDs || id.Key == 0) {
+		return json.Marshal(id.UUID)
+	}
+	return json.Marshal(id.Key)
+}
+
+func (id *apiID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &id.UUID)
+	}
+	return json.Unmarshal(data, &id.Key)
+}
+
+// where scopes the query to the user with the ID, only the kind of ID the
+// API hands out is accepted so keys can't be guessed when UUIDs are on
+func (id apiID) where(q *gorm.DB) *gorm.DB {
+	if uuidIDs {
+		if !uuidPattern.MatchString(id.UUID) {
+			return q.Where("1 = 0")
+		}
+		return q.Where("uuid = ?", id.UUID)
+	}
+	if id.Key == 0 {
+		return q.Where("1 = 0")
+	}
+	return q.Where("id = ?", id.Key)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsersCanBeIdentifiedByUUID(t *testing.T) {
+	// Arrange
+	defer func() { uuidIDs = false }()
+	uuidIDs = true
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "uuid@example.com", "somePassword1!")
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", usersShow(db))
+	tests := map[string]int{
+		"/users/" + *u.UUID:            http.StatusOK,
+		fmt.Sprintf("/users/%v", u.ID): http.StatusNotFound,
+	}
+
+	for target, expected := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", target, expected, status)
+		}
+		if expected == http.StatusOK && !strings.Contains(rr.Body.String(), `"id":"`+*u.UUID+`"`) {
+			t.Errorf("expected the UUID to be the id, got %v instead", rr.Body.String())
+		}
+	}
+}
+
+func TestNewUsersAreGivenUUIDs(t *testing.T) {
+	// Arrange
+	defer func() { uuidIDs = false }()
+	uuidIDs = true
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersStore(db).ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"new@example.com","password":"somePassword1!"}`)))
+
+	// Assert
+	resp := struct {
+		ID string `json:"id"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if !uuidPattern.MatchString(resp.ID) {
+		t.Errorf("expected a UUID to be returned, got %v instead", rr.Body.String())
+	}
+}
+
+func TestExistingUsersAreBackfilledWithUUIDs(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "old@example.com", "somePassword1!")
+	db.Model(&u).UpdateColumn("uuid", nil)
+
+	// Act
+	err := backfillUUIDs(db)
+
+	// Assert
+	db.First(&u, u.ID)
+	if err != nil || u.UUID == nil || !uuidPattern.MatchString(*u.UUID) {
+		t.Errorf("expected a UUID to be assigned, got %v and %v instead", u.UUID, err)
+	}
+}
+
+func TestAdministratorsSeeActorsByUUID(t *testing.T) {
+	// Arrange
+	defer func() { uuidIDs = false }()
+	uuidIDs = true
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@example.com", "somePassword1!")
+	admin.Admin = true
+	u := createUser(db, "uuid@example.com", "somePassword1!")
+	db.Model(&u).UpdateColumn("created_by_id", admin.ID)
+	db.First(&u, u.ID)
+
+	// Act
+	data, err := json.Marshal(presentUser(db, u, &admin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if !strings.Contains(string(data), `"created_by":"`+*admin.UUID+`"`) {
+		t.Errorf("expected created_by to be the administrator's UUID, got %v instead", string(data))
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// importBatchSize is how many users are created per transaction
+const importBatchSize = 100
+
+// importRow is a user read from an import along with the line it was on
+type importRow struct {
+	line int
+	req  userStoreRequest
+	errs map[string][]string
+}
+
+// importResult reports what happened to one row of an import
+type importResult struct {
+	Line   int                 `json:"line"`
+	Status string              `json:"status"`
+	ID     *apiID              `json:"id,omitempty"`
+	Errors map[string][]string `json:"errors,omitempty"`
+}
+
+// importColumns are the CSV headers an import understands, the same fields
+// POST /users accepts
+var importColumns = func() map[string]int {
+	columns := map[string]int{}
+	t := reflect.TypeOf(userStoreRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		columns[t.Field(i).Tag.Get("json")] = i
+	}
+	return columns
+}()
+
+// readCSVImport reads the rows of a CSV import, the first line names the
+// columns. Quoted fields spanning lines make the reported lines approximate.
+func readCSVImport(body io.Reader, each func(importRow)) error {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("the first line must name the columns")
+	}
+	for _, name := range header {
+		if _, ok := importColumns[strings.TrimSpace(name)]; !ok {
+			return fmt.Errorf("the %v column is not supported", name)
+		}
+	}
+
+	line := 1
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line++
+		row := importRow{line: line}
+		if err != nil {
+			row.errs = map[string][]string{"_error": {err.Error()}}
+			each(row)
+			continue
+		}
+		v := reflect.ValueOf(&row.req).Elem()
+		for i, value := range record {
+			if i < len(header) {
+				v.Field(importColumns[strings.TrimSpace(header[i])]).SetString(value)
+			}
+		}
+		each(row)
+	}
+}
+
+// readNDJSONImport reads one JSON user per line, blank lines are skipped
+func readNDJSONImport(body io.Reader, each func(importRow)) error {
+	s := bufio.NewScanner(body)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		row := importRow{line: line}
+		if err := json.Unmarshal(s.Bytes(), &row.req); err != nil {
+			row.errs = map[string][]string{"_error": {"the line is not a JSON object of user fields"}}
+		}
+		each(row)
+	}
+	return s.Err()
+}
+
+// usersImport creates the users in a CSV or NDJSON body, each row is
+// validated like POST /users and the response reports the outcome per line
+func usersImport(db *gorm.DB) http.HandlerFunc {
+	type usersImportResponse struct {
+		Created int            `json:"created"`
+		Failed  int            `json:"failed"`
+		Results []importResult `json:"results"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var read func(io.Reader, func(importRow)) error
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			read = readCSVImport
+		case "application/x-ndjson", "application/ndjson":
+			read = readNDJSONImport
+		default:
+			writeError(w, http.StatusUnsupportedMediaType, "imports must be text/csv or application/x-ndjson")
+			return
+		}
+
+		tx := dbFor(r, db)
+		resp := usersImportResponse{Results: []importResult{}}
+		batch := []importRow{}
+		var failure error
+		flush := func() {
+			if len(batch) == 0 || failure != nil {
+				return
+			}
+			results, err := importBatch(tx, batch)
+			if err != nil {
+				failure = err
+				return
+			}
+			for _, result := range results {
+				if result.Status == "created" {
+					resp.Created++
+				} else {
+					resp.Failed++
+				}
+			}
+			resp.Results = append(resp.Results, results...)
+			batch = batch[:0]
+		}
+
+		err := read(r.Body, func(row importRow) {
+			if row.errs == nil {
+				v := govalidator.New(govalidator.Options{Data: &row.req, Rules: userStoreRules})
+				if e := v.ValidateStruct(); len(e) >= 1 {
+					row.errs = e
+				}
+			}
+			if row.errs != nil {
+				flush()
+				resp.Failed++
+				resp.Results = append(resp.Results, importResult{Line: row.line, Status: "invalid", Errors: row.errs})
+				return
+			}
+			batch = append(batch, row)
+			if len(batch) == importBatchSize {
+				flush()
+			}
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		flush()
+		if failure != nil {
+			logError(r, failure)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// importBatch creates the users of a batch in one transaction, a row that
+// is already taken only rolls back its own savepoint
+func importBatch(db *gorm.DB, rows []importRow) ([]importResult, error) {
+	results := make([]importResult, 0, len(rows))
+	err := transaction(db, func(tx *gorm.DB) error {
+		for _, row := range rows {
+			u := row.req.newUser()
+			err := transaction(tx, func(tx *gorm.DB) error {
+				return tx.Create(&u).Error
+			})
+			switch {
+			case err == nil:
+				id := idOf(u)
+				results = append(results, importResult{Line: row.line, Status: "created", ID: &id})
+			case isUniqueViolation(err):
+				results = append(results, importResult{Line: row.line, Status: "failed", Errors: map[string][]string{takenField(err): {"already taken"}}})
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
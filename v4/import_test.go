@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type importResponse struct {
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Results []importResult `json:"results"`
+}
+
+func TestUsersCanBeImportedFromCSV(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "taken@example.com", "somePassword1!")
+	body := "email,password,name\n" +
+		"one@example.com,somePassword1!,One\n" +
+		"not-an-email,somePassword1!,Two\n" +
+		"taken@example.com,somePassword1!,Three\n" +
+		"four@example.com,somePassword1!,Four\n"
+	req := httptest.NewRequest("POST", "/admin/users/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersImport(db).ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("expected the status code to be %v, got %v instead: %v", http.StatusOK, status, rr.Body.String())
+	}
+	resp := importResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Created != 2 || resp.Failed != 2 {
+		t.Errorf("expected 2 users to be created and 2 to fail, got %+v instead", resp)
+	}
+	statuses := []string{}
+	lines := []int{}
+	for _, result := range resp.Results {
+		statuses = append(statuses, result.Status)
+		lines = append(lines, result.Line)
+	}
+	if expected := []string{"created", "invalid", "failed", "created"}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected the statuses to be %v, got %v instead", expected, statuses)
+	}
+	if expected := []int{2, 3, 4, 5}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected the lines to be %v, got %v instead", expected, lines)
+	}
+	u := user{}
+	db.Where("email = ?", "four@example.com").First(&u)
+	if u.Name != "Four" {
+		t.Errorf("expected the imported user to be stored, got %+v instead", u)
+	}
+}
+
+func TestUsersCanBeImportedFromNDJSON(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	body := `{"email":"one@example.com","password":"somePassword1!"}` + "\n\n" +
+		`{"email":"two@example.com"}` + "\n" +
+		`not json` + "\n"
+	req := httptest.NewRequest("POST", "/admin/users/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersImport(db).ServeHTTP(rr, req)
+
+	// Assert
+	resp := importResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Created != 1 || resp.Failed != 2 {
+		t.Fatalf("expected 1 user to be created and 2 to fail, got %v instead", rr.Body.String())
+	}
+	if missing := resp.Results[1]; missing.Line != 3 || len(missing.Errors["password"]) == 0 {
+		t.Errorf("expected a missing password on line 3, got %+v instead", missing)
+	}
+	if invalid := resp.Results[2]; invalid.Line != 4 || invalid.Status != "invalid" {
+		t.Errorf("expected line 4 to be invalid, got %+v instead", invalid)
+	}
+}
+
+func TestImportsRejectUnknownFormatsAndColumns(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		body        string
+		expected    int
+	}{
+		"json":           {"application/json", `[]`, http.StatusUnsupportedMediaType},
+		"unknown column": {"text/csv", "email,password,admin\n", http.StatusBadRequest},
+	}
+
+	for name, tt := range tests {
+		// Arrange
+		db, rollback := testTx(t)
+		req := httptest.NewRequest("POST", "/admin/users/import", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		rr := httptest.NewRecorder()
+
+		// Act
+		usersImport(db).ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != tt.expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", name, tt.expected, status)
+		}
+		rollback()
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxIncludeDepth bounds how deeply a ?include= path may nest, so
+// "posts.comments" is allowed and "posts.comments.author" is not
+const maxIncludeDepth = 2
+
+// userIncludes maps each relation clients may request on user responses with
+// ?include= to the gorm association it preloads. Posts are the comments the
+// user wrote, the only thing users post. There is no organization to include,
+// every tenant is its own organization with its own database rather than a
+// row users belong to.
+var userIncludes = map[string]string{
+	"meetups": "Meetups",
+	"posts":   "Posts",
+}
+
+// parseIncludes reads the comma separated ?include= list and checks every
+// path against the allowed relations and the depth limit
+func parseIncludes(r *http.Request, allowed map[string]string) ([]string, map[string][]string) {
+	includes := []string{}
+	errs := map[string][]string{}
+
+	s := r.URL.Query().Get("include")
+	if s == "" {
+		return includes, errs
+	}
+
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		if strings.Count(path, ".")+1 > maxIncludeDepth {
+			errs["include"] = append(errs["include"], fmt.Sprintf("The %v include is nested deeper than %v levels", path, maxIncludeDepth))
+			continue
+		}
+		if _, ok := allowed[path]; !ok {
+			errs["include"] = append(errs["include"], fmt.Sprintf("The %v include is not supported", path))
+			continue
+		}
+
+		includes = append(includes, path)
+	}
+
+	return includes, errs
+}
+
+// applyIncludes preloads the associations for the requested relations
+func applyIncludes(q *gorm.DB, includes []string, allowed map[string]string) *gorm.DB {
+	for _, path := range includes {
+		q = q.Preload(allowed[path])
+	}
+	return q
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnsupportedIncludesAreRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?include=passwords", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusBadRequest, status)
+	}
+	if !strings.Contains(rr.Body.String(), "The passwords include is not supported") {
+		t.Errorf("expected the unsupported include to be reported, got %v instead", rr.Body.String())
+	}
+}
+
+func TestIncludesAreLimitedInDepth(t *testing.T) {
+	// Arrange
+	allowed := map[string]string{"posts": "Posts", "posts.comments": "Posts.Comments", "posts.comments.author": "Posts.Comments.Author"}
+	req := httptest.NewRequest("GET", "/users?include=posts,posts.comments,posts.comments.author", nil)
+
+	// Act
+	includes, errs := parseIncludes(req, allowed)
+
+	// Assert
+	if len(includes) != 2 {
+		t.Errorf("expected 2 includes to be accepted, got %v instead", includes)
+	}
+	if len(errs["include"]) != 1 {
+		t.Errorf("expected the deeply nested include to be rejected, got %v instead", errs)
+	}
+}
+
+func TestOrganizedMeetupsCanBeIncluded(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	createUser(db, "guest@mccallister.io", "somePassword1!")
+	createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?include=meetups", nil))
+
+	// Assert
+	resp := struct {
+		Users []struct {
+			ID      uint              `json:"id"`
+			Meetups *[]meetupResponse `json:"meetups"`
+		} `json:"users"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Users) != 2 {
+		t.Fatalf("expected 2 users, got %v instead", rr.Body.String())
+	}
+	for _, got := range resp.Users {
+		expected := 0
+		if got.ID == u.ID {
+			expected = 1
+		}
+		if got.Meetups == nil || len(*got.Meetups) != expected {
+			t.Errorf("expected user %v to include %v meetups, got %v instead", got.ID, expected, rr.Body.String())
+		}
+	}
+	plain := httptest.NewRecorder()
+	handler.ServeHTTP(plain, httptest.NewRequest("GET", "/users", nil))
+	if strings.Contains(plain.Body.String(), `"meetups"`) {
+		t.Errorf("expected meetups only when included, got %v instead", plain.Body.String())
+	}
+}
+
+func TestPostsCanBeIncludedWithAUser(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&comment{MeetupID: m.ID, AuthorID: u.ID, Body: "Who is bringing pizza?"})
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", usersShow(db))
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/users/%v?include=posts,meetups", u.ID), nil))
+
+	// Assert
+	resp := struct {
+		User struct {
+			Meetups []meetupResponse  `json:"meetups"`
+			Posts   []commentResponse `json:"posts"`
+		} `json:"user"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.User.Posts) != 1 || resp.User.Posts[0].Body != "Who is bringing pizza?" || len(resp.User.Meetups) != 1 {
+		t.Errorf("expected the user's posts and meetups, got %v instead", rr.Body.String())
+	}
+}
+
+func TestNestedIncludesOnAUserAreLimitedInDepth(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", usersShow(db))
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/users/%v?include=posts.meetup.organizer", u.ID), nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusBadRequest, status)
+	}
+	if !strings.Contains(rr.Body.String(), "nested deeper than 2 levels") {
+		t.Errorf("expected the depth limit to be reported, got %v instead", rr.Body.String())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ipRule restricts which clients may reach the paths under Prefix, a client
+// in Deny is always rejected and, when Allow is not empty, a client outside
+// of it is rejected as well
+type ipRule struct {
+	Prefix string
+	Allow  []*net.IPNet
+	Deny   []*net.IPNet
+}
+
+func (rule ipRule) permits(ip net.IP) bool {
+	if containsIP(rule.Deny, ip) {
+		return false
+	}
+	return len(rule.Allow) == 0 || containsIP(rule.Allow, ip)
+}
+
+// versionSegment is the version prefix of a path such as /v1/admin
+var versionSegment = regexp.MustCompile(`^v[0-9]+$`)
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter enforces the rules before the request reaches the router. Paths
+// are compared by segment the way the router matches them, so doubled
+// slashes or a version prefix don't slip past a rule.
+func ipFilter(rules []ipRule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		segments := []string{}
+		for _, segment := range strings.Split(r.URL.Path, "/") {
+			if segment != "" {
+				segments = append(segments, segment)
+			}
+		}
+		if len(segments) >= 1 && versionSegment.MatchString(segments[0]) {
+			segments = segments[1:]
+		}
+
+		for _, rule := range rules {
+			if !hasSegments(segments, splitPath(rule.Prefix)) {
+				continue
+			}
+			if ip == nil || !rule.permits(ip) {
+				writeError(w, http.StatusForbidden, "forbidden")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseCIDRs parses a comma separated list of CIDR ranges, single addresses
+// are treated as a range containing only that address
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", part)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// ipRulesFromEnv builds the rules from IP_ALLOW and IP_DENY, which apply to
+// every path, and ADMIN_IP_ALLOW, which only applies to /admin/
+func ipRulesFromEnv() ([]ipRule, error) {
+	allow, err := parseCIDRs(os.Getenv("IP_ALLOW"))
+	if err != nil {
+		return nil, fmt.Errorf("IP_ALLOW: %v", err)
+	}
+	deny, err := parseCIDRs(os.Getenv("IP_DENY"))
+	if err != nil {
+		return nil, fmt.Errorf("IP_DENY: %v", err)
+	}
+	admin, err := parseCIDRs(os.Getenv("ADMIN_IP_ALLOW"))
+	if err != nil {
+		return nil, fmt.Errorf("ADMIN_IP_ALLOW: %v", err)
+	}
+
+	rules := []ipRule{}
+	if len(allow) >= 1 || len(deny) >= 1 {
+		rules = append(rules, ipRule{Prefix: "/", Allow: allow, Deny: deny})
+	}
+	if len(admin) >= 1 {
+		rules = append(rules, ipRule{Prefix: "/admin/", Allow: admin})
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestAdminRoutesAreLockedToTheAllowlist(t *testing.T) {
+	// Arrange
+	office, err := parseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []ipRule{{Prefix: "/admin/", Allow: office}}
+	handler := ipFilter(rules, okHandler())
+	outside := httptest.NewRequest("GET", "/admin/audit/auth", nil)
+	outside.RemoteAddr = "203.0.113.7:4321"
+	inside := httptest.NewRequest("GET", "/admin/audit/auth", nil)
+	inside.RemoteAddr = "10.1.2.3:4321"
+	public := httptest.NewRequest("GET", "/users", nil)
+	public.RemoteAddr = "203.0.113.7:4321"
+
+	// Act
+	blocked := httptest.NewRecorder()
+	handler.ServeHTTP(blocked, outside)
+	allowed := httptest.NewRecorder()
+	handler.ServeHTTP(allowed, inside)
+	untouched := httptest.NewRecorder()
+	handler.ServeHTTP(untouched, public)
+
+	// Assert
+	if status := blocked.Code; status != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, status)
+	}
+	if blocked.Header().Get("content-type") != problemContentType || !strings.Contains(blocked.Body.String(), "forbidden") {
+		t.Errorf("expected a problem body, got %v instead", blocked.Body.String())
+	}
+	if status := allowed.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if status := untouched.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+}
+
+func TestAdminRulesCantBeBypassedThroughThePath(t *testing.T) {
+	// Arrange
+	office, err := parseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := ipFilter([]ipRule{{Prefix: "/admin/", Allow: office}}, okHandler())
+
+	for _, path := range []string{"/admin", "//admin/users", "/admin//users", "/v5/admin/users", "/v5//admin/users"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("expected %v to be forbidden, got %v instead", path, status)
+		}
+	}
+}
+
+func TestDeniedAddressesAreRejectedEvenWhenAllowed(t *testing.T) {
+	// Arrange
+	allow, _ := parseCIDRs("10.0.0.0/8")
+	deny, err := parseCIDRs("10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := ipFilter([]ipRule{{Prefix: "/", Allow: allow, Deny: deny}}, okHandler())
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.RemoteAddr = "10.0.0.5:4321"
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, status)
+	}
+}
+
+func TestInvalidCIDRsAreReported(t *testing.T) {
+	if _, err := parseCIDRs("10.0.0.0/8, not-an-ip"); err == nil {
+		t.Errorf("expected an error for an invalid address")
+	}
+}
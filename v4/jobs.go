@@ -0,0 +1,13 @@
+package main
+
+import "time"
+
+// every runs fn once per interval for the life of the process
+func every(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fn()
+	}
+}
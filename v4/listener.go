@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// listen listens on the address from listenAddr. A Unix socket left behind by
+// a previous run is replaced and the new one gets the mode, so the proxy in
+// front can be let in through the socket's group.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, "unix:")
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListeningOnAUnixSocket(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	// Act
+	l, err := listen("unix:"+path, 0600)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v instead", err)
+	}
+	defer l.Close()
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the socket to have mode 0600, got %v instead", fi.Mode().Perm())
+	}
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	if c, err := net.Dial("unix", path); err != nil {
+		t.Errorf("expected to connect through the socket, got %v instead", err)
+	} else {
+		c.Close()
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// listPolicy controls how a list endpoint pages and orders its results, a
+// sort is a comma separated list of column names each optionally prefixed
+// with "-" for descending order
+type listPolicy struct {
+	DefaultPerPage int      `json:"default_per_page"`
+	MaxPerPage     int      `json:"max_per_page"`
+	Sorts          []string `json:"sorts"`
+	DefaultSort    string   `json:"default_sort"`
+}
+
+// defaultListPolicy applies to list endpoints without a policy of their own
+var defaultListPolicy = listPolicy{
+	DefaultPerPage: 25,
+	MaxPerPage:     100,
+	Sorts:          []string{"id"},
+	DefaultSort:    "id",
+}
+
+// listPolicies are keyed by route, operators can tune them without code
+// changes by pointing LIST_POLICIES_FILE at a JSON file of overrides
+var listPolicies = map[string]listPolicy{
+	"/users": {
+		DefaultPerPage: 25,
+		MaxPerPage:     100,
+		Sorts:          []string{"id", "email", "created_at", "updated_at"},
+		DefaultSort:    "id",
+	},
+	"/meetups": {
+		DefaultPerPage: 25,
+		MaxPerPage:     100,
+		Sorts:          []string{"id", "starts_at", "created_at"},
+		DefaultSort:    "starts_at",
+	},
+	"/users/{id}/meetups": {
+		DefaultPerPage: 25,
+		MaxPerPage:     100,
+		Sorts:          []string{"id", "starts_at", "created_at"},
+		DefaultSort:    "starts_at",
+	},
+	"/me/rsvps": {
+		DefaultPerPage: 25,
+		MaxPerPage:     100,
+		Sorts:          []string{"starts_at", "rsvps.id"},
+		DefaultSort:    "starts_at",
+	},
+	"/tags": {
+		DefaultPerPage: 50,
+		MaxPerPage:     200,
+		Sorts:          []string{"name", "meetup_count"},
+		DefaultSort:    "-meetup_count,name",
+	},
+	"/venues": {
+		DefaultPerPage: 25,
+		MaxPerPage:     100,
+		Sorts:          []string{"id", "name", "created_at"},
+		DefaultSort:    "name",
+	},
+	"/venues/{id}/meetups": {
+		DefaultPerPage: 25,
+		MaxPerPage:     100,
+		Sorts:          []string{"id", "starts_at", "created_at"},
+		DefaultSort:    "starts_at",
+	},
+	"/meetups/{id}/attendees": {
+		DefaultPerPage: 50,
+		MaxPerPage:     200,
+		Sorts:          []string{"rsvps.status", "rsvps.id"},
+		DefaultSort:    "rsvps.status,rsvps.id",
+	},
+	"/meetups/{id}/comments": {
+		DefaultPerPage: 50,
+		MaxPerPage:     200,
+		Sorts:          []string{"id"},
+		DefaultSort:    "id",
+	},
+	"/admin/audit/auth": {
+		DefaultPerPage: 50,
+		MaxPerPage:     500,
+		Sorts:          []string{"id", "created_at"},
+		DefaultSort:    "-created_at",
+	},
+}
+
+// listPolicyFor returns the policy for the route
+func listPolicyFor(route string) listPolicy {
+	if p, ok := listPolicies[route]; ok {
+		return p
+	}
+	return defaultListPolicy
+}
+
+// loadListPolicies merges the overrides in the JSON file into listPolicies,
+// only the fields present in the file are changed and nothing is changed
+// when any of the overrides is invalid
+func loadListPolicies(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	overrides := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return err
+	}
+
+	merged := map[string]listPolicy{}
+	for route, raw := range overrides {
+		p := listPolicyFor(route)
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return fmt.Errorf("%v: %v", route, err)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("%v: %v", route, err)
+		}
+		merged[route] = p
+	}
+
+	for route, p := range merged {
+		listPolicies[route] = p
+	}
+
+	return nil
+}
+
+func (p listPolicy) validate() error {
+	if p.DefaultPerPage < 1 || p.MaxPerPage < 1 {
+		return fmt.Errorf("page sizes must be positive")
+	}
+	if p.DefaultPerPage > p.MaxPerPage {
+		return fmt.Errorf("default_per_page %v exceeds max_per_page %v", p.DefaultPerPage, p.MaxPerPage)
+	}
+	if !p.allowsSort(p.DefaultSort) {
+		return fmt.Errorf("default_sort %q is not one of the allowed sorts", p.DefaultSort)
+	}
+	return nil
+}
+
+func (p listPolicy) allowsSort(sort string) bool {
+	for _, field := range strings.Split(sort, ",") {
+		if !p.allowsColumn(strings.TrimPrefix(field, "-")) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p listPolicy) allowsColumn(column string) bool {
+	for _, s := range p.Sorts {
+		if s == column {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSort reads sort from the query string, every column has to be one of
+// the policy sorts since they end up in the ORDER BY clause
+func parseSort(r *http.Request, p listPolicy) (string, map[string][]string) {
+	errs := map[string][]string{}
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		return p.DefaultSort, errs
+	}
+
+	for _, field := range strings.Split(sort, ",") {
+		column := strings.TrimPrefix(field, "-")
+		if !p.allowsColumn(column) {
+			errs["sort"] = append(errs["sort"], fmt.Sprintf("Sorting by %q is not supported, use one of %v", column, strings.Join(p.Sorts, ", ")))
+		}
+	}
+
+	return sort, errs
+}
+
+// globalParams are the query parameters every endpoint accepts, they are
+// handled by middleware rather than the endpoint
+var globalParams = []string{"pretty"}
+
+// checkParams rejects query parameters the endpoint doesn't know about, so a
+// misspelt filter fails loudly instead of silently returning everything
+func checkParams(r *http.Request, known []string) map[string][]string {
+	errs := map[string][]string{}
+	for name := range r.URL.Query() {
+		found := false
+		for _, k := range globalParams {
+			if name == k {
+				found = true
+				break
+			}
+		}
+		for _, k := range known {
+			if name == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs[name] = append(errs[name], fmt.Sprintf("The %v filter is not supported", name))
+		}
+	}
+	return errs
+}
+
+// parseDate accepts either a date such as "2024-01-01" or an RFC 3339 timestamp
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// listOptions are the paging and ordering settings for a single list request
+type listOptions struct {
+	Page    int
+	PerPage int
+	Sort    string
+}
+
+// parseListOptions reads page and per_page from the query string, falling
+// back to the policy default page size and capping it at the policy maximum
+func parseListOptions(r *http.Request, p listPolicy) (listOptions, map[string][]string) {
+	opts := listOptions{Page: 1, PerPage: p.DefaultPerPage, Sort: p.DefaultSort}
+	errs := map[string][]string{}
+
+	if s := r.URL.Query().Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			errs["page"] = append(errs["page"], "The page field must be a positive number")
+		}
+		opts.Page = n
+	}
+
+	if s := r.URL.Query().Get("per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			errs["per_page"] = append(errs["per_page"], "The per_page field must be a positive number")
+		}
+		opts.PerPage = n
+	}
+	if opts.PerPage > p.MaxPerPage {
+		opts.PerPage = p.MaxPerPage
+	}
+
+	return opts, errs
+}
+
+// apply orders the query and limits it to the requested page
+func (o listOptions) apply(q *gorm.DB) *gorm.DB {
+	return q.Order(orderClause(o.Sort)).Limit(o.PerPage).Offset((o.Page - 1) * o.PerPage)
+}
+
+// pagination describes where a page sits in the full list
+type pagination struct {
+	Total      int `json:"total"`
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+}
+
+// paginate counts the rows of model matching the query, it has to be called
+// before the query is ordered and limited by apply
+func (o listOptions) paginate(q *gorm.DB, model interface{}) pagination {
+	total := 0
+	q.Model(model).Count(&total)
+
+	return pagination{
+		Total:      total,
+		Page:       o.Page,
+		PerPage:    o.PerPage,
+		TotalPages: (total + o.PerPage - 1) / o.PerPage,
+	}
+}
+
+// pageLinks are the URLs of a page and its neighbours so clients can page
+// without building URLs themselves, next and prev are null at either end
+type pageLinks struct {
+	Self string  `json:"self"`
+	Next *string `json:"next"`
+	Prev *string `json:"prev"`
+}
+
+// links points at the pages either side of this one, keeping the rest of the
+// query string as it was
+func (p pagination) links(r *http.Request) pageLinks {
+	l := pageLinks{Self: pageURL(r, nil)}
+	if p.Page < p.TotalPages {
+		next := pageURL(r, map[string]string{"page": strconv.Itoa(p.Page + 1)})
+		l.Next = &next
+	}
+	if p.Page > 1 {
+		n := p.Page - 1
+		if n > p.TotalPages {
+			n = p.TotalPages
+		}
+		if n < 1 {
+			n = 1
+		}
+		prev := pageURL(r, map[string]string{"page": strconv.Itoa(n)})
+		l.Prev = &prev
+	}
+	return l
+}
+
+// cursorLinks is links for keyset pagination, where only the next page can be
+// reached from a cursor
+func cursorLinks(r *http.Request, cursor *string) pageLinks {
+	l := pageLinks{Self: pageURL(r, nil)}
+	if cursor != nil {
+		next := pageURL(r, map[string]string{"after": *cursor})
+		l.Next = &next
+	}
+	return l
+}
+
+// pageURL is the URL of the request with params replaced, it is relative and
+// taken from the request line so a /v1/ prefix is kept
+func pageURL(r *http.Request, params map[string]string) string {
+	path := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		path = u.Path
+	}
+
+	q := r.URL.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// setLinkHeader repeats the links in an RFC 8288 Link header
+func setLinkHeader(w http.ResponseWriter, l pageLinks) {
+	parts := []string{fmt.Sprintf(`<%v>; rel="self"`, l.Self)}
+	if l.Next != nil {
+		parts = append(parts, fmt.Sprintf(`<%v>; rel="next"`, *l.Next))
+	}
+	if l.Prev != nil {
+		parts = append(parts, fmt.Sprintf(`<%v>; rel="prev"`, *l.Prev))
+	}
+	w.Header().Set("Link", strings.Join(parts, ", "))
+}
+
+// orderClause converts a sort such as "-created_at,email" into
+// "created_at desc, email asc", the sort must already have been checked
+// against a policy
+func orderClause(sort string) string {
+	fields := strings.Split(sort, ",")
+	for i, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			fields[i] = strings.TrimPrefix(field, "-") + " desc"
+			continue
+		}
+		fields[i] = field + " asc"
+	}
+	return strings.Join(fields, ", ")
+}
+
+// cursorKey encrypts the cursors handed to clients, main replaces it with
+// CURSOR_SECRET so cursors survive restarts and work across instances
+var cursorKey = randomKey()
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// cursorOptions are the settings for a keyset paginated list request, the
+// rows after the cursor are read in id order so deep pages cost no more than
+// the first one
+type cursorOptions struct {
+	Kind        string
+	After       uint
+	Limit       int
+	Fingerprint string
+}
+
+// usesCursor reports whether the request asked for keyset pagination
+func usesCursor(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("after") != "" || q.Get("limit") != ""
+}
+
+// parseCursorOptions reads after and limit from the query string, limit falls
+// back to the policy default page size and is capped at the policy maximum.
+// A cursor is only accepted for the kind of list and the filters it was
+// issued for.
+func parseCursorOptions(r *http.Request, p listPolicy, kind string) (cursorOptions, map[string][]string) {
+	opts := cursorOptions{Kind: kind, Limit: p.DefaultPerPage, Fingerprint: fingerprint(r)}
+	errs := map[string][]string{}
+
+	if r.URL.Query().Get("page") != "" || r.URL.Query().Get("per_page") != "" {
+		errs["after"] = append(errs["after"], "The after and limit fields cannot be combined with page and per_page")
+	}
+	if r.URL.Query().Get("sort") != "" {
+		errs["sort"] = append(errs["sort"], "The sort field cannot be combined with after and limit")
+	}
+
+	if s := r.URL.Query().Get("after"); s != "" {
+		c, ok := decodeCursor(s)
+		switch {
+		case !ok || c.Kind != kind:
+			errs["after"] = append(errs["after"], "The after field must be a cursor returned by a previous page")
+		case c.Fingerprint != opts.Fingerprint:
+			errs["after"] = append(errs["after"], "The after cursor was issued for different filters, start again from the first page")
+		}
+		opts.After = c.After
+	}
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			errs["limit"] = append(errs["limit"], "The limit field must be a positive number")
+		}
+		opts.Limit = n
+	}
+	if opts.Limit > p.MaxPerPage {
+		opts.Limit = p.MaxPerPage
+	}
+
+	return opts, errs
+}
+
+// fingerprint identifies the filters of a list request, everything in the
+// query string except the paging itself
+func fingerprint(r *http.Request) string {
+	params := r.URL.Query()
+	params.Del("after")
+	params.Del("limit")
+
+	sum := sha256.Sum256([]byte(params.Encode()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// apply limits the query to the rows after the cursor, one extra row is read
+// so next can tell whether there is another page
+func (o cursorOptions) apply(q *gorm.DB, table string) *gorm.DB {
+	return q.Where(table+".id > ?", o.After).Order(table + ".id asc").Limit(o.Limit + 1)
+}
+
+// next trims the extra row read by apply and returns the cursor for the
+// following page, or nil on the last page
+func (o cursorOptions) next(ids []uint) (int, *string) {
+	if len(ids) <= o.Limit {
+		return len(ids), nil
+	}
+	c := o
+	c.After = ids[o.Limit-1]
+	cursor := encodeCursor(c)
+	return o.Limit, &cursor
+}
+
+// cursorPayload is what a cursor carries, encrypted so clients can neither
+// read the key in it nor forge one
+type cursorPayload struct {
+	Kind        string `json:"k"`
+	After       uint   `json:"a"`
+	Fingerprint string `json:"f"`
+}
+
+// encodeCursor encrypts the position and filters of the next page into an
+// opaque token
+func encodeCursor(o cursorOptions) string {
+	payload, _ := json.Marshal(cursorPayload{Kind: o.Kind, After: o.After, Fingerprint: o.Fingerprint})
+	aead := cursorCipher()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, payload, nil))
+}
+
+// decodeCursor decrypts the cursor, which fails for any that was tampered with
+func decodeCursor(cursor string) (cursorOptions, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	aead := cursorCipher()
+	if err != nil || len(data) < aead.NonceSize() {
+		return cursorOptions{}, false
+	}
+	payload, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return cursorOptions{}, false
+	}
+
+	c := cursorPayload{}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return cursorOptions{}, false
+	}
+	return cursorOptions{Kind: c.Kind, After: c.After, Fingerprint: c.Fingerprint}, true
+}
+
+// cursorCipher encrypts cursors with AES-GCM, the key is derived from
+// cursorKey since CURSOR_SECRET can be of any length
+func cursorCipher() cipher.AEAD {
+	key := sha256.Sum256(cursorKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
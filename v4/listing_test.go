@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUsersIndexCapsThePageSize(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com", "three@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	original := listPolicies["/users"]
+	defer func() { listPolicies["/users"] = original }()
+	listPolicies["/users"] = listPolicy{DefaultPerPage: 1, MaxPerPage: 2, Sorts: []string{"id"}, DefaultSort: "id"}
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	defaults := httptest.NewRecorder()
+	handler.ServeHTTP(defaults, httptest.NewRequest("GET", "/users", nil))
+	capped := httptest.NewRecorder()
+	handler.ServeHTTP(capped, httptest.NewRequest("GET", "/users?per_page=50", nil))
+
+	// Assert
+	for rr, expected := range map[*httptest.ResponseRecorder]int{defaults: 1, capped: 2} {
+		resp := struct {
+			Users []publicUser `json:"users"`
+		}{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if len(resp.Users) != expected {
+			t.Errorf("expected %v users to be returned, got %v instead", expected, len(resp.Users))
+		}
+	}
+}
+
+func TestInvalidPageSizesAreRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?per_page=-1", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func writePolicyFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(contents))
+	f.Close()
+	return f.Name()
+}
+
+func TestListPoliciesCanBeOverriddenFromAFile(t *testing.T) {
+	// Arrange
+	path := writePolicyFile(t, `{"/users": {"max_per_page": 10, "default_per_page": 5}}`)
+	defer os.Remove(path)
+	original := listPolicies["/users"]
+	defer func() { listPolicies["/users"] = original }()
+
+	// Act
+	err := loadListPolicies(path)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := listPolicyFor("/users"); p.MaxPerPage != 10 || p.DefaultPerPage != 5 || p.DefaultSort != original.DefaultSort {
+		t.Errorf("expected the override to be merged into the built in policy, got %+v instead", p)
+	}
+}
+
+func TestInvalidListPolicyFilesChangeNothing(t *testing.T) {
+	// Arrange
+	path := writePolicyFile(t, `{"/users": {"max_per_page": 10}, "/admin/audit/auth": {"default_sort": "email"}}`)
+	defer os.Remove(path)
+	original := listPolicies["/users"]
+
+	// Act
+	err := loadListPolicies(path)
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected an error for a sort that is not allowed")
+	}
+	if p := listPolicyFor("/users"); p.MaxPerPage != original.MaxPerPage {
+		t.Errorf("expected the policies to be left alone, got %+v instead", p)
+	}
+}
+
+func TestUsersIndexReturnsPaginationMetadata(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com", "three@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?page=2&per_page=2", nil))
+
+	// Assert
+	resp := struct {
+		Users      []publicUser `json:"users"`
+		Pagination pagination   `json:"pagination"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Users) != 1 || resp.Users[0].ID.Key != 3 {
+		t.Errorf("expected only the third user on the second page, got %+v instead", resp.Users)
+	}
+	expected := pagination{Total: 3, Page: 2, PerPage: 2, TotalPages: 2}
+	if resp.Pagination != expected {
+		t.Errorf("expected the pagination to be %+v, got %+v instead", expected, resp.Pagination)
+	}
+}
+
+func TestListResponsesLinkToTheirNeighbouringPages(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com", "three@example.com", "four@example.com", "five@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/users?per_page=2&page=2&sort=email", nil))
+
+	// Assert
+	resp := struct {
+		Links pageLinks `json:"links"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Links.Self != "/v1/users?page=2&per_page=2&sort=email" {
+		t.Errorf("expected the self link to keep the query and version, got %v instead", resp.Links.Self)
+	}
+	if resp.Links.Next == nil || *resp.Links.Next != "/v1/users?page=3&per_page=2&sort=email" {
+		t.Errorf("expected a link to the third page, got %v instead", resp.Links.Next)
+	}
+	if resp.Links.Prev == nil || *resp.Links.Prev != "/v1/users?page=1&per_page=2&sort=email" {
+		t.Errorf("expected a link to the first page, got %v instead", resp.Links.Prev)
+	}
+	expected := `</v1/users?page=2&per_page=2&sort=email>; rel="self", </v1/users?page=3&per_page=2&sort=email>; rel="next", </v1/users?page=1&per_page=2&sort=email>; rel="prev"`
+	if rr.Header().Get("Link") != expected {
+		t.Errorf("expected the links in the Link header, got %v instead", rr.Header().Get("Link"))
+	}
+}
+
+func TestLinksStopAtTheEndsOfTheList(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "one@example.com", "somePassword1!")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+
+	// Assert
+	resp := struct {
+		Links pageLinks `json:"links"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Links.Self != "/users" || resp.Links.Next != nil || resp.Links.Prev != nil {
+		t.Errorf("expected only a self link on the only page, got %+v instead", resp.Links)
+	}
+	if rr.Header().Get("Link") != `</users>; rel="self"` {
+		t.Errorf("expected only a self link in the Link header, got %v instead", rr.Header().Get("Link"))
+	}
+}
+
+func TestInvalidPagesAreRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?page=zero", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestUsersIndexPagesWithACursor(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com", "three@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	handler := http.HandlerFunc(usersIndex(db))
+	type cursorResponse struct {
+		Users      []publicUser `json:"users"`
+		NextCursor *string      `json:"next_cursor"`
+		Links      pageLinks    `json:"links"`
+	}
+
+	// Act
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest("GET", "/users?limit=2", nil))
+	firstResp := cursorResponse{}
+	json.Unmarshal(first.Body.Bytes(), &firstResp)
+	second := httptest.NewRecorder()
+	if firstResp.NextCursor != nil {
+		handler.ServeHTTP(second, httptest.NewRequest("GET", "/users?limit=2&after="+*firstResp.NextCursor, nil))
+	}
+	secondResp := cursorResponse{}
+	json.Unmarshal(second.Body.Bytes(), &secondResp)
+
+	// Assert
+	if len(firstResp.Users) != 2 || firstResp.NextCursor == nil {
+		t.Fatalf("expected two users and a next cursor on the first page, got %v instead", first.Body.String())
+	}
+	if len(secondResp.Users) != 1 || secondResp.Users[0].ID.Key != 3 {
+		t.Errorf("expected only the third user on the second page, got %+v instead", secondResp.Users)
+	}
+	if secondResp.NextCursor != nil {
+		t.Errorf("expected no next cursor on the last page, got %v instead", *secondResp.NextCursor)
+	}
+	if firstResp.Links.Next == nil || *firstResp.Links.Next != "/users?after="+url.QueryEscape(*firstResp.NextCursor)+"&limit=2" {
+		t.Errorf("expected the next link to carry the cursor, got %v instead", firstResp.Links.Next)
+	}
+}
+
+func TestInvalidCursorsAreRejected(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?after=not-a-cursor", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestUsersIndexCanBeSorted(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"b@example.com", "c@example.com", "a@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?sort=-created_at,-id", nil))
+
+	// Assert
+	resp := struct {
+		Users []publicUser `json:"users"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	ids := []uint{}
+	for _, u := range resp.Users {
+		ids = append(ids, u.ID.Key)
+	}
+	if fmt.Sprint(ids) != "[3 2 1]" {
+		t.Errorf("expected the users newest first, got %v instead", ids)
+	}
+}
+
+func TestSortingByUnknownColumnsIsRejected(t *testing.T) {
+	for _, sort := range []string{"password", "id,-password", "id;drop table users"} {
+		// Arrange
+		db, rollback := testTx(t)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(usersIndex(db))
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?sort="+url.QueryEscape(sort), nil))
+		rollback()
+
+		// Assert
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("expected the status code for %q to be %v, got %v instead", sort, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestCursorsAreTiedToTheirSignatureAndFilters(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	filters := fingerprint(httptest.NewRequest("GET", "/users", nil))
+	issued := encodeCursor(cursorOptions{Kind: "users", After: 1, Fingerprint: filters})
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"k":"users","a":0,"f":"` + filters + `"}`))
+	otherKind := encodeCursor(cursorOptions{Kind: "events", After: 1, Fingerprint: filters})
+	tests := map[string]int{
+		"/users?after=" + issued:                          http.StatusOK,
+		"/users?after=" + forged:                          http.StatusUnprocessableEntity,
+		"/users?after=" + otherKind:                       http.StatusUnprocessableEntity,
+		"/users?created_after=2020-01-01&after=" + issued: http.StatusUnprocessableEntity,
+	}
+
+	for target, expected := range tests {
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(usersIndex(db))
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", target, expected, status)
+		}
+	}
+}
+
+func TestCursorsDontRevealTheirContents(t *testing.T) {
+	// Arrange
+	o := cursorOptions{Kind: "users", After: 42, Fingerprint: "filters"}
+
+	// Act
+	cursor := encodeCursor(o)
+
+	// Assert
+	data, _ := base64.RawURLEncoding.DecodeString(cursor)
+	if strings.Contains(string(data), `"a":42`) || strings.Contains(string(data), `"users"`) {
+		t.Errorf("expected the cursor to be opaque, got %q instead", data)
+	}
+	if decoded, ok := decodeCursor(cursor); !ok || decoded != o {
+		t.Errorf("expected the cursor to decode to %v, got %v instead", o, decoded)
+	}
+}
+
+func TestUsersIndexSendsTheTotalCount(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com", "three@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersIndex(db).ServeHTTP(rr, httptest.NewRequest("GET", "/users?per_page=1", nil))
+
+	// Assert
+	if total := rr.Header().Get("X-Total-Count"); total != "3" {
+		t.Errorf("expected the total count to be 3, got %v instead", total)
+	}
+}
+
+func TestUsersCanBeCounted(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	for _, email := range []string{"one@example.com", "two@example.com"} {
+		createUser(db, email, "somePassword1!")
+	}
+	tests := map[string]string{
+		"/users/count":                          `{"count":2}`,
+		"/users/count?created_after=2999-01-01": `{"count":0}`,
+	}
+
+	for target, expected := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		usersCount(db).ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+
+		// Assert
+		if body := rr.Body.String(); body != expected {
+			t.Errorf("expected %v to respond with %v, got %v instead", target, expected, body)
+		}
+	}
+}
+
+func TestCountingRejectsUnknownFilters(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+
+	// Act
+	usersCount(db).ServeHTTP(rr, httptest.NewRequest("GET", "/users/count?page=2", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusBadRequest, status)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+// mailer sends emails to users
+type mailer interface {
+	send(to, subject, body string) error
+}
+
+// logMailer writes emails to the log instead of sending them, it is used
+// when no SMTP server is configured
+type logMailer struct{}
+
+func (logMailer) send(to, subject, body string) error {
+	log.Printf("mail to %v: %v\n%v", to, subject, body)
+	return nil
+}
+
+// smtpMailer relays emails through an SMTP server
+type smtpMailer struct {
+	addr string
+	from string
+}
+
+func (m smtpMailer) send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("mail headers must not contain line breaks")
+	}
+	msg := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\n\r\n%v\r\n", m.from, to, subject, body)
+	return smtp.SendMail(m.addr, nil, m.from, []string{to}, []byte(msg))
+}
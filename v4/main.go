@@ -1,10 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
-	"io/ioutil"
+	"expvar"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -14,131 +23,1113 @@ import (
 	"github.com/thedevsaddam/govalidator"
 )
 
-// user represents a customer of the application
+// user represents a customer of the application as it is stored, responses
+// use one of the representations in serializers.go instead
 type user struct {
-	ID        uint       `gorm:"primary_key" json:"id"`
-	Email     string     `gorm:"type:varchar(100);unique_index" json:"email"`
-	Password  string     `json:"-"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at"`
+	ID                   uint    `gorm:"primary_key"`
+	UUID                 *string `gorm:"type:varchar(36);unique_index"`
+	CreatedByID          *uint
+	UpdatedByID          *uint
+	Email                string  `gorm:"type:varchar(100);unique_index"`
+	Username             *string `gorm:"type:varchar(30);unique_index"`
+	UsernameChangedAt    *time.Time
+	Password             string `json:"-"`
+	Admin                bool   `gorm:"not null;default:false"`
+	Name                 string `gorm:"type:varchar(100)"`
+	Bio                  string `gorm:"type:varchar(500)"`
+	Location             string `gorm:"type:varchar(100)"`
+	Website              string `gorm:"type:varchar(255)"`
+	Twitter              string `gorm:"type:varchar(15)"`
+	GitHub               string `gorm:"type:varchar(39)"`
+	AvatarURL            string `gorm:"type:varchar(255)"`
+	AvatarVariants       string `gorm:"type:varchar(1024)"`
+	PendingEmail         string `gorm:"type:varchar(255)"`
+	EmailChangeTokenHash string `gorm:"type:varchar(64);index"`
+	EmailChangeExpiresAt *time.Time
+	LastLoginAt          *time.Time
+	LastLoginIP          string `gorm:"type:varchar(45)"`
+	LastLoginDevice      string
+	EraseAfter           *time.Time
+	RestoreTokenHash     string `gorm:"type:varchar(64);index"`
+	CalendarTokenHash    string `gorm:"type:varchar(64);index"`
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	DeletedAt            *time.Time
+	// Meetups are the meetups the user organizes, only loaded for
+	// ?include=meetups
+	Meetups []meetup `gorm:"foreignkey:OrganizerID"`
+	// Posts are the comments the user wrote, only loaded for ?include=posts
+	Posts []comment `gorm:"foreignkey:AuthorID"`
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		runDoctor(os.Stdout, inspectEnvironment())
+		return
+	}
+
+	addrFlag := flag.String("addr", "", "address to listen on such as :8080, 127.0.0.1:8080 or unix:/run/api.sock, overrides ADDR and PORT")
+	certFlag := flag.String("tls-cert", os.Getenv("TLS_CERT"), "certificate file to serve HTTPS with, along with -tls-key")
+	keyFlag := flag.String("tls-key", os.Getenv("TLS_KEY"), "key file of the -tls-cert certificate")
+	redirectFlag := flag.String("redirect-addr", os.Getenv("REDIRECT_ADDR"), "address to redirect plain HTTP to HTTPS from such as :80")
+	acmeHostsFlag := flag.String("acme-hosts", os.Getenv("ACME_HOSTS"), "comma separated hostnames to get Let's Encrypt certificates for")
+	flag.Parse()
+	addr, err := listenAddr(*addrFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig, err := loadTLSConfig(*certFlag, *keyFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// with ACME_HOSTS certificates come from Let's Encrypt, which checks the
+	// hosts are ours by calling port 80 unless told otherwise
+	var redirect http.Handler
+	if *acmeHostsFlag != "" {
+		if tlsConfig != nil {
+			log.Fatal("-acme-hosts: can't be combined with -tls-cert and -tls-key")
+		}
+		dir := os.Getenv("ACME_CACHE_DIR")
+		if dir == "" {
+			dir = "certs"
+		}
+		hosts := acmeHosts(*acmeHostsFlag)
+		if len(hosts) == 0 {
+			log.Fatal("-acme-hosts: no hostnames given")
+		}
+		certs := newCertManager(hosts, dir, os.Getenv("ACME_EMAIL"))
+		tlsConfig = certManagerTLSConfig(certs)
+		redirect = certs.HTTPHandler(redirectToHTTPS(addr))
+		if *redirectFlag == "" {
+			*redirectFlag = ":80"
+		}
+	} else if *redirectFlag != "" {
+		if tlsConfig == nil {
+			log.Fatal("-redirect-addr: needs -tls-cert and -tls-key")
+		}
+		redirect = redirectToHTTPS(addr)
+	}
+
 	// establish a database connection
-	db, err := gorm.Open("sqlite3", ":memory:")
+	driver, dsn, err := dbConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	connectAttempts, err := intFromEnv("DB_CONNECT_ATTEMPTS", 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+	connectWait, err := durationFromEnv("DB_CONNECT_WAIT", time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := connectDB(openGorm, driver, dsn, connectAttempts, connectWait)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	db.AutoMigrate(&user{})
+	registerTimingCallbacks(db)
+	registerContextCallbacks(db)
+	registerAuditCallbacks(db)
+
+	// with MIGRATION_GATE the server starts straight away and only answers
+	// /healthz until the migrations have run
+	gate := &migrationGate{}
+	holder := migrationHolder()
+	gated := os.Getenv("MIGRATION_GATE") == "true" && pendingMigrations(db)
+	if gated {
+		gate.start()
+	} else if err := runMigrations(db, holder); err != nil {
+		log.Fatal(err)
+	}
+
+	if path := os.Getenv("LIST_POLICIES_FILE"); path != "" {
+		if err := loadListPolicies(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if path := os.Getenv("API_CLIENTS_FILE"); path != "" {
+		if err := loadAPIClients(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if versionSunsets, err = parseVersionSunsets(os.Getenv("API_VERSION_SUNSETS")); err != nil {
+		log.Fatal(err)
+	}
+
+	grace, err := durationFromEnv("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cascadeSetting := os.Getenv("USER_DELETE_CASCADE")
+	if cascadeSetting == "" {
+		cascadeSetting = "sessions"
+	}
+	retention, err := durationFromEnv("SOFT_DELETE_RETENTION", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	purgeDryRun := os.Getenv("PURGE_DRY_RUN") == "true"
 
-	// this creates a duplicate route error
-	// http.HandleFunc("/users", usersIndex(db))
+	cascade, err := parseCascadePolicy(cascadeSetting)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	http.HandleFunc("/users", usersStore(db))
+	var mail mailer = logMailer{}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mail = smtpMailer{addr: addr, from: os.Getenv("SMTP_FROM")}
+	}
+	emailChangeTTL, err := durationFromEnv("EMAIL_CHANGE_TTL", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	http.ListenAndServe(":8080", nil)
+	avatarDir := os.Getenv("AVATAR_DIR")
+	if avatarDir == "" {
+		avatarDir = "avatars"
+	}
+	avatars := newLocalStorage(avatarDir, "/avatars")
+	requestTimeoutDuration, err := durationFromEnv("REQUEST_TIMEOUT", 30*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slowThreshold, err := durationFromEnv("SLOW_REQUEST_THRESHOLD", time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maintenanceRetry, err := durationFromEnv("MAINTENANCE_RETRY_AFTER", 5*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maint := newMaintenanceMode(os.Getenv("MAINTENANCE_FILE"), maintenanceRetry)
+	maxBody, err := intFromEnv("MAX_BODY_BYTES", defaultMaxBodyBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	avatarMaxBytes, err := intFromEnv("AVATAR_MAX_BYTES", 2<<20)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	kept, err := intFromEnv("SLOW_REQUESTS_KEPT", 20)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recorder := newFlightRecorder(kept)
+
+	heapLimit, err := intFromEnv("WATCHDOG_HEAP_LIMIT_MB", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	goroutineLimit, err := intFromEnv("WATCHDOG_GOROUTINE_LIMIT", 10000)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wd := newWatchdog(uint64(heapLimit)<<20, goroutineLimit)
+
+	maxInFlight, err := intFromEnv("SHED_MAX_IN_FLIGHT", 512)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxLatency, err := durationFromEnv("SHED_MAX_LATENCY", 2*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ls := newLoadShedder(maxInFlight, maxLatency)
+
+	preserveLocalCase = os.Getenv("EMAIL_PRESERVE_LOCAL_CASE") == "true"
+	if bcryptCost, err = intFromEnv("BCRYPT_COST", bcrypt.MinCost); err != nil || bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		log.Fatalf("BCRYPT_COST: must be between %v and %v", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	gravatarFallback = os.Getenv("GRAVATAR_FALLBACK") == "true"
+	uuidIDs = os.Getenv("USER_IDS") == "uuid"
+	cooldown, err := durationFromEnv("USERNAME_CHANGE_COOLDOWN", usernameCooldown)
+	if err != nil {
+		log.Fatal(err)
+	}
+	usernameCooldown = cooldown
+
+	if secret := os.Getenv("CURSOR_SECRET"); secret != "" {
+		cursorKey = []byte(secret)
+	}
+	if secret := os.Getenv("TICKET_SECRET"); secret != "" {
+		ticketKey = []byte(secret)
+	}
+
+	cacheTTL, err := durationFromEnv("USERS_CACHE_TTL", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacheStale, err := durationFromEnv("USERS_CACHE_STALE", time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacheEntries, err := intFromEnv("USERS_CACHE_ENTRIES", 1000)
+	if err != nil {
+		log.Fatal(err)
+	}
+	usersCache := newResponseCache(cacheTTL, cacheStale, cacheEntries)
+
+	rd := &readiness{}
+
+	routes := []routeDef{
+		{method: http.MethodGet, path: "/healthz", summary: "Check the server is up", handler: healthShow()},
+		{method: http.MethodGet, path: "/readyz", summary: "Check the server has warmed up", handler: readyShow(rd)},
+		{method: http.MethodGet, path: "/users", summary: "List users", access: accessOptional, handler: usersCache.wrap(usersIndex(db))},
+		{method: http.MethodPost, path: "/users", summary: "Sign up", rules: userStoreRules, status: http.StatusCreated, handler: usersStore(db)},
+		{method: http.MethodGet, path: "/users/count", summary: "Count users", access: accessOptional, handler: usersCount(db)},
+		{method: http.MethodGet, path: "/users/search", summary: "Search users by email or name", access: accessAdmin, handler: usersSearch(db)},
+		{method: http.MethodGet, path: "/users/{id}", summary: "Show a user", access: accessOptional, handler: usersShow(db)},
+		{method: http.MethodGet, path: "/users/{id}/meetups", summary: "List the meetups a user organizes", handler: userMeetupsIndex(db)},
+		{method: http.MethodPatch, path: "/users/{id}", summary: "Update a user", access: accessUser, rules: userUpdateRules, partial: true, handler: usersUpdate(db)},
+		{method: http.MethodDelete, path: "/users/{id}", summary: "Delete a user", access: accessUser, status: http.StatusNoContent, handler: usersDestroy(db, cascade)},
+		{method: http.MethodDelete, path: "/admin/users", summary: "Delete users in bulk", access: accessAdmin, handler: usersBulkDestroy(db, cascade)},
+		{method: http.MethodGet, path: "/admin/users/export", summary: "Stream every user as NDJSON", access: accessAdmin, handler: usersExport(db)},
+		{method: http.MethodPost, path: "/admin/users/import", summary: "Import users from CSV or NDJSON", access: accessAdmin, maxBody: unlimitedBody, consumes: []string{"text/csv", "application/x-ndjson", "application/ndjson"}, handler: usersImport(db)},
+		{method: http.MethodPost, path: "/users/{id}/restore", summary: "Restore a deleted user", access: accessAdmin, handler: usersRestore(db)},
+		{method: http.MethodGet, path: "/meetups", summary: "List meetups", cache: publicListingCache, handler: meetupsIndex(db)},
+		{method: http.MethodPost, path: "/meetups", summary: "Organize a meetup", access: accessUser, rules: meetupRules, status: http.StatusCreated, handler: meetupsStore(db)},
+		{method: http.MethodGet, path: "/meetups/{id}", summary: "Show a meetup", handler: meetupsShow(db)},
+		{method: http.MethodPatch, path: "/meetups/{id}", summary: "Update a meetup", access: accessUser, rules: meetupRules, partial: true, handler: meetupsUpdate(db, mail)},
+		{method: http.MethodDelete, path: "/meetups/{id}", summary: "Delete a meetup", access: accessUser, status: http.StatusNoContent, handler: meetupsDestroy(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/cancel", summary: "Cancel a meetup and tell everyone who RSVPed", access: accessUser, rules: meetupCancelRules, handler: meetupsCancel(db, mail)},
+		{method: http.MethodGet, path: "/meetups/{id}.ics", summary: "Download a meetup as an iCalendar file", handler: meetupsCalendar(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/attendees", summary: "List the people going to a meetup and its waitlist", handler: attendeesIndex(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/occurrences", summary: "List the upcoming occurrences of a meetup", handler: occurrencesIndex(db)},
+		{method: http.MethodPatch, path: "/meetups/{id}/occurrences/{date}", summary: "Change one occurrence of a recurring meetup", access: accessUser, rules: occurrenceUpdateRules, partial: true, handler: occurrencesUpdate(db)},
+		{method: http.MethodDelete, path: "/meetups/{id}/occurrences/{date}", summary: "Cancel one occurrence of a recurring meetup", access: accessUser, status: http.StatusNoContent, handler: occurrencesDestroy(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/talks", summary: "List the talks proposed for a meetup", access: accessOptional, handler: talksIndex(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/talks", summary: "Propose a talk for a meetup", access: accessUser, rules: talkStoreRules, status: http.StatusCreated, handler: talksStore(db)},
+		{method: http.MethodPatch, path: "/meetups/{id}/talks/{talk}", summary: "Accept or reject a talk", access: accessUser, rules: talkDecisionRules, handler: talksUpdate(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/organizers", summary: "List the organizers of a meetup", handler: organizersIndex(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/organizers", summary: "Add a co-organizer to a meetup", access: accessUser, rules: organizerStoreRules, status: http.StatusCreated, handler: organizersStore(db, mail)},
+		{method: http.MethodDelete, path: "/meetups/{id}/organizers/{user}", summary: "Remove a co-organizer from a meetup", access: accessUser, status: http.StatusNoContent, handler: organizersDestroy(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/comments", summary: "List the comments on a meetup", handler: commentsIndex(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/comments", summary: "Comment on a meetup", access: accessUser, rules: commentRules, status: http.StatusCreated, handler: commentsStore(db)},
+		{method: http.MethodDelete, path: "/meetups/{id}/comments/{comment}", summary: "Delete a comment", access: accessUser, status: http.StatusNoContent, handler: commentsDestroy(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/checkin", summary: "Check an attendee in at the door", access: accessUser, handler: checkinsStore(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/attendance", summary: "Show who turned up to a meetup", access: accessUser, handler: attendanceShow(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/rsvp", summary: "RSVP to a meetup", access: accessUser, handler: rsvpsStore(db)},
+		{method: http.MethodDelete, path: "/meetups/{id}/rsvp", summary: "Cancel an RSVP", access: accessUser, handler: rsvpsDestroy(db, mail)},
+		{method: http.MethodGet, path: "/tags", summary: "List the tags meetups use", cache: publicListingCache, handler: tagsIndex(db)},
+		{method: http.MethodGet, path: "/venues", summary: "List venues", cache: publicListingCache, handler: venuesIndex(db)},
+		{method: http.MethodPost, path: "/venues", summary: "Add a venue", access: accessUser, rules: venueRules, status: http.StatusCreated, handler: venuesStore(db)},
+		{method: http.MethodGet, path: "/venues/{id}", summary: "Show a venue", handler: venuesShow(db)},
+		{method: http.MethodPatch, path: "/venues/{id}", summary: "Update a venue", access: accessUser, rules: venueRules, partial: true, handler: venuesUpdate(db)},
+		{method: http.MethodDelete, path: "/venues/{id}", summary: "Delete a venue", access: accessUser, status: http.StatusNoContent, handler: venuesDestroy(db)},
+		{method: http.MethodGet, path: "/venues/{id}/meetups", summary: "List the meetups held at a venue", handler: venueMeetupsIndex(db)},
+		{method: http.MethodGet, path: "/speakers/{id}", summary: "Show a speaker and their talks", handler: speakersShow(db)},
+		{method: http.MethodPost, path: "/login", summary: "Sign in", rules: sessionStoreRules, status: http.StatusCreated, handler: sessionsStore(db)},
+		{method: http.MethodPost, path: "/logout", summary: "Sign out", access: accessUser, status: http.StatusNoContent, handler: sessionsDestroy(db)},
+		{method: http.MethodGet, path: "/me", summary: "Show the signed in user", access: accessUser, handler: meShow(db)},
+		{method: http.MethodDelete, path: "/me", summary: "Schedule the account for deletion", access: accessUser, status: http.StatusAccepted, handler: meDestroy(db, grace)},
+		{method: http.MethodPut, path: "/me/password", summary: "Change the password", access: accessUser, rules: passwordUpdateRules, status: http.StatusNoContent, handler: passwordUpdate(db)},
+		{method: http.MethodPost, path: "/me/email", summary: "Ask to change the email", access: accessUser, rules: emailChangeRules, status: http.StatusAccepted, handler: emailChange(db, mail, emailChangeTTL)},
+		{method: http.MethodPost, path: "/me/email/confirm", summary: "Confirm a new email", rules: emailConfirmRules, status: http.StatusNoContent, handler: emailConfirm(db)},
+		{method: http.MethodGet, path: "/me/rsvps", summary: "List the meetups you RSVPed to", access: accessUser, handler: rsvpsIndex(db)},
+		{method: http.MethodGet, path: "/me/rsvps/{id}/ticket.png", summary: "Download your ticket to a meetup as a QR code", access: accessUser, handler: ticketShow(db)},
+		{method: http.MethodGet, path: "/me/calendar.ics", summary: "Subscribe to the meetups you organize or RSVPed to", handler: calendarFeed(db)},
+		{method: http.MethodPost, path: "/me/calendar/token", summary: "Issue a new calendar subscription URL", access: accessUser, status: http.StatusCreated, handler: calendarTokenStore(db)},
+		{method: http.MethodGet, path: "/me/preferences", summary: "Show the signed in user's preferences", access: accessUser, handler: preferencesShow(db)},
+		{method: http.MethodPut, path: "/me/preferences", summary: "Save preferences", access: accessUser, handler: preferencesUpdate(db)},
+		{method: http.MethodPut, path: "/me/avatar", summary: "Upload an avatar", access: accessUser, maxBody: unlimitedBody, consumes: []string{"multipart/form-data"}, handler: avatarUpdate(db, avatars, int64(avatarMaxBytes))},
+		{method: http.MethodGet, path: "/avatars/{name}", summary: "Download an avatar", handler: avatars.serve()},
+		{method: http.MethodPost, path: "/account/restore", summary: "Restore an account scheduled for deletion", rules: accountRestoreRules, status: http.StatusNoContent, handler: accountRestore(db)},
+		{method: http.MethodGet, path: "/admin/audit/auth", summary: "List authentication events", access: accessAdmin, handler: authEventsIndex(db)},
+		{method: http.MethodGet, path: "/admin/clients/deprecations", summary: "List the clients relying on deprecated behavior", access: accessAdmin, handler: clientDeprecationsIndex(deprecations)},
+		{method: http.MethodGet, path: "/admin/debug/slow", summary: "List the slowest requests", access: accessAdmin, handler: slowRequestsIndex(recorder)},
+		{method: http.MethodGet, path: "/admin/maintenance", summary: "Show whether the server is in maintenance", access: accessAdmin, handler: maintenanceShow(maint)},
+		{method: http.MethodPut, path: "/admin/maintenance", summary: "Put the server in maintenance", access: accessAdmin, handler: maintenanceUpdate(maint)},
+		{method: http.MethodDelete, path: "/admin/maintenance", summary: "Take the server out of maintenance", access: accessAdmin, handler: maintenanceDestroy(maint)},
+		{method: http.MethodGet, path: "/admin/debug/vars", summary: "Show runtime metrics", access: accessAdmin, handler: expvar.Handler().ServeHTTP},
+	}
+	routes = append(routes,
+		routeDef{method: http.MethodGet, path: "/meta/validation", summary: "List the validation rules of each endpoint", handler: validationShow(routes)},
+		routeDef{method: http.MethodGet, path: "/openapi.json", summary: "Describe the API", handler: openAPIShow(routes)},
+	)
+
+	rt := newRouter()
+	rt.group("/admin", noStore)
+	rt.group("/me", noStore)
+	for i := range routes {
+		// reads take no body, wrapping them would only get in the way of
+		// streamed responses such as the export
+		if routes[i].method == http.MethodGet {
+			continue
+		}
+		if routes[i].maxBody == 0 {
+			routes[i].maxBody = int64(maxBody)
+		}
+		if routes[i].consumes == nil {
+			routes[i].consumes = jsonOnly
+		}
+	}
+	registerRoutes(rt, db, routes)
+
+	// with TENANT_DSN every tenant gets a database of its own, the default
+	// database is then only used by the health checks
+	var app http.Handler = rt
+	var tenants *tenantRegistry
+	if dsn := os.Getenv("TENANT_DSN"); dsn != "" {
+		driver := os.Getenv("TENANT_DB_DRIVER")
+		if driver == "" {
+			driver = "sqlite3"
+		}
+		capacity, err := intFromEnv("TENANT_POOLS_OPEN", 100)
+		if err != nil {
+			log.Fatal(err)
+		}
+		idle, err := durationFromEnv("TENANT_IDLE_TIMEOUT", 10*time.Minute)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		known, err := tenantsFromEnv()
+		if err != nil {
+			log.Fatalf("TENANTS: %v", err)
+		}
+
+		tenants = newTenantRegistry(driver, dsn, capacity, known)
+		app = tenants.middleware(rt)
+		go every(time.Minute, func() { tenants.evictIdle(idle, time.Now()) })
+	}
+
+	go func() {
+		if gated {
+			if err := runMigrations(db, holder); err != nil {
+				log.Fatal(err)
+			}
+			gate.finish()
+		}
+		if err := warmUp(rd, warmUpSteps(db)); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	go every(time.Hour, func() {
+		eraseDueAccounts(db, time.Now())
+		if tenants != nil {
+			tenants.each(func(tdb *gorm.DB) { eraseDueAccounts(tdb, time.Now()) })
+		}
+	})
+	go every(time.Hour, func() {
+		if retention == 0 {
+			return
+		}
+		purge := func(db *gorm.DB) {
+			if _, err := purgeDeletedUsers(db, retention, purgeDryRun, time.Now()); err != nil {
+				logDBError(db, err)
+			}
+		}
+		purge(db)
+		if tenants != nil {
+			tenants.each(purge)
+		}
+	})
+	go every(time.Minute, func() {
+		sendReminders(db, mail, time.Now())
+		if tenants != nil {
+			tenants.each(func(tdb *gorm.DB) { sendReminders(tdb, mail, time.Now()) })
+		}
+	})
+	go every(5*time.Second, wd.check)
+
+	rules, err := ipRulesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	compressMin, err := intFromEnv("COMPRESS_MIN_BYTES", 1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	corsOrigins := []string{}
+	if s := os.Getenv("CORS_ORIGINS"); s != "" {
+		for _, origin := range strings.Split(s, ",") {
+			corsOrigins = append(corsOrigins, strings.TrimSpace(origin))
+		}
+	}
+	proxies, err := parseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	// method overrides are opt in, they let a POST through a proxy that
+	// screens methods act as a DELETE
+	overrides := chain()
+	if os.Getenv("METHOD_OVERRIDE") == "true" {
+		overrides = methodOverride
+	}
+	server := chain(
+		trustedProxies(proxies),
+		requestIDs,
+		overrides,
+		accessLog,
+		recoverPanics,
+		cors(corsOrigins),
+		compress(compressMin),
+		etags,
+		negotiate,
+		problemInstances,
+		apiVersions,
+		localize(messages),
+		gate.middleware,
+		maint.middleware,
+		requestTimeout(requestTimeoutDuration),
+		serverTiming,
+		slowRequests(slowThreshold),
+		recorder.middleware,
+		ls.middleware,
+		func(next http.Handler) http.Handler { return ipFilter(rules, next) },
+		wd.shed,
+	)
+	shutdownTimeout, err := durationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	socketMode, err := fileModeFromEnv("SOCKET_MODE", 0660)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, err := listen(addr, socketMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %v", l.Addr())
+
+	// the redirect has nothing in flight worth draining, it stops with the
+	// process
+	if redirect != nil {
+		go func() {
+			log.Printf("redirecting %v to HTTPS", *redirectFlag)
+			log.Fatal(http.ListenAndServe(*redirectFlag, redirect))
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	if err := serveUntil(&http.Server{Handler: server(app), TLSConfig: tlsConfig}, l, stop, shutdownTimeout); err != nil {
+		log.Println(err)
+	}
+
+	// the requests are done with the databases, the default one is closed on
+	// the way out of main
+	if tenants != nil {
+		tenants.close()
+	}
+}
+
+// migrate brings the schema up to date for every model
+func migrate(db *gorm.DB) {
+	db.AutoMigrate(&user{}, &token{}, &authEvent{}, &preference{}, &meetup{}, &rsvp{}, &meetupOccurrence{}, &talk{}, &venue{}, &outboxMessage{}, &tag{}, &meetupTag{}, &comment{}, &meetupOrganizer{})
+
+	// emails are unique regardless of case, even when the case is preserved
+	db.Model(&user{}).AddUniqueIndex("idx_users_email_lower", "lower(email)")
+
+	if err := backfillUUIDs(db); err != nil {
+		log.Println(err)
+	}
 }
 
 func usersIndex(db *gorm.DB) http.HandlerFunc {
 	type userIndexResponse struct {
-		Users []user `json:"users"`
+		Users      []interface{} `json:"users"`
+		Pagination pagination    `json:"pagination"`
+		Links      pageLinks     `json:"links"`
+	}
+	type userCursorResponse struct {
+		Users      []interface{} `json:"users"`
+		NextCursor *string       `json:"next_cursor"`
+		Links      pageLinks     `json:"links"`
 	}
-	resp := userIndexResponse{}
-	users := []user{}
+
+	policy := listPolicyFor("/users")
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-type", "application/json")
-		db.Find(&users)
+		if errs := checkParams(r, usersIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
 
-		resp.Users = users
+		includes, errs := parseIncludes(r, userIncludes)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		q, errs := filterUsers(r, withDeleted(r, dbFor(r, db)))
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
 
+		var resp interface{}
+		if usesCursor(r) {
+			opts, errs := parseCursorOptions(r, policy, "users")
+			if len(errs) >= 1 {
+				writeValidationErrors(w, errs)
+				return
+			}
+
+			users := []user{}
+			applyIncludes(opts.apply(q, "users"), includes, userIncludes).Find(&users)
+			ids := make([]uint, len(users))
+			for i, u := range users {
+				ids[i] = u.ID
+			}
+			n, next := opts.next(ids)
+			links := cursorLinks(r, next)
+			setLinkHeader(w, links)
+
+			resp = userCursorResponse{
+				Users:      presentUsers(dbFor(r, db), users[:n], currentUser(r)),
+				NextCursor: next,
+				Links:      links,
+			}
+		} else {
+			opts, errs := parseListOptions(r, policy)
+			if len(errs) >= 1 {
+				writeValidationErrors(w, errs)
+				return
+			}
+			sort, errs := parseSort(r, policy)
+			if len(errs) >= 1 {
+				writeErrors(w, http.StatusBadRequest, errs)
+				return
+			}
+			opts.Sort = sort
+
+			page := opts.paginate(q, &user{})
+			w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+			links := page.links(r)
+			setLinkHeader(w, links)
+			users := []user{}
+			applyIncludes(opts.apply(q), includes, userIncludes).Find(&users)
+
+			resp = userIndexResponse{
+				Users:      presentUsers(dbFor(r, db), users, currentUser(r)),
+				Pagination: page,
+				Links:      links,
+			}
+		}
+
+		stop := startPhase(r, "serialization")
 		data, err := json.Marshal(resp)
 		if err != nil {
-			log.Println(err)
+			logError(r, err)
 		}
+		stop()
 		w.WriteHeader(http.StatusOK)
 		w.Write(data)
 	}
 }
 
-func usersStore(db *gorm.DB) http.HandlerFunc {
-	type userStoreRequest struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+// usersSearch finds users whose email or name contains q, ignoring case
+func usersSearch(db *gorm.DB) http.HandlerFunc {
+	type usersSearchResponse struct {
+		Users      []interface{} `json:"users"`
+		Pagination pagination    `json:"pagination"`
+		Links      pageLinks     `json:"links"`
 	}
 
-	type userStoreResponse struct {
-		ID uint `json:"id"`
+	policy := listPolicyFor("/users/search")
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, errs := parseListOptions(r, policy)
+		term := strings.TrimSpace(r.URL.Query().Get("q"))
+		if term == "" {
+			errs["q"] = append(errs["q"], "The q field is required")
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		pattern := "%" + escaper.Replace(strings.ToLower(term)) + "%"
+		q := dbFor(r, db).Where(`LOWER(email) LIKE ? ESCAPE '\' OR LOWER(name) LIKE ? ESCAPE '\'`, pattern, pattern)
+		page := opts.paginate(q, &user{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		users := []user{}
+		opts.apply(q).Find(&users)
+
+		writeJSON(w, http.StatusOK, usersSearchResponse{
+			Users:      presentUsers(dbFor(r, db), users, currentUser(r)),
+			Pagination: page,
+			Links:      links,
+		})
+	}
+}
+
+// usersIndexParams are the query parameters the users index understands
+var usersIndexParams = []string{
+	"page", "per_page", "sort", "after", "limit", "include", "with_deleted",
+	"email", "created_after", "created_before",
+}
+
+// usersCountParams are the filters GET /users/count shares with the index
+var usersCountParams = []string{"with_deleted", "email", "created_after", "created_before"}
+
+// withDeleted includes soft deleted users when an administrator asks for them
+func withDeleted(r *http.Request, q *gorm.DB) *gorm.DB {
+	if viewer := currentUser(r); viewer != nil && viewer.Admin && r.URL.Query().Get("with_deleted") == "true" {
+		return q.Unscoped()
 	}
+	return q
+}
+
+// usersCount counts the users matching the index filters with COUNT(*)
+// instead of loading them, for clients building their own pagers
+func usersCount(db *gorm.DB) http.HandlerFunc {
+	type usersCountResponse struct {
+		Count int `json:"count"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("content-type", "application/json")
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(`{"error": "method not allowed"}`))
+		if errs := checkParams(r, usersCountParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
 			return
 		}
 
-		req := userStoreRequest{}
-		err := r.ParseForm()
+		q, errs := filterUsers(r, withDeleted(r, dbFor(r, db)))
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		count := 0
+		if err := q.Model(&user{}).Count(&count).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, usersCountResponse{Count: count})
+	}
+}
+
+// filterUsers narrows the users index by the filters in the query string, the
+// email filter is limited to administrators since guests can't see emails
+func filterUsers(r *http.Request, q *gorm.DB) (*gorm.DB, map[string][]string) {
+	errs := map[string][]string{}
+	params := r.URL.Query()
+
+	if s := params.Get("email"); s != "" {
+		if viewer := currentUser(r); viewer == nil || !viewer.Admin {
+			errs["email"] = append(errs["email"], "The email filter is only available to administrators")
+		}
+		q = q.Where("lower(email) = ?", strings.ToLower(normalizeEmail(s)))
+	}
+	if s := params.Get("created_after"); s != "" {
+		after, err := parseDate(s)
+		if err != nil {
+			errs["created_after"] = append(errs["created_after"], "The created_after field must be a date or an RFC 3339 timestamp")
+		}
+		q = q.Where("created_at >= ?", after)
+	}
+	if s := params.Get("created_before"); s != "" {
+		before, err := parseDate(s)
 		if err != nil {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			w.Write([]byte(`{"error": "method not allowed"}`))
+			errs["created_before"] = append(errs["created_before"], "The created_before field must be a date or an RFC 3339 timestamp")
+		}
+		q = q.Where("created_at < ?", before)
+	}
+
+	return q, errs
+}
+
+func usersShow(db *gorm.DB) http.HandlerFunc {
+	type userShowResponse struct {
+		User interface{} `json:"user"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		includes, errs := parseIncludes(r, userIncludes)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := applyIncludes(dbFor(r, db), includes, userIncludes)
+		u := user{}
+
+		// /users/@jason looks the user up by username instead of ID
+		if s := param(r, "id"); strings.HasPrefix(s, "@") {
+			if tx.Where("username = ?", normalizeUsername(s)).First(&u).RecordNotFound() {
+				writeError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			setVersionHeaders(w, r, u, currentUser(r))
+			writeJSON(w, http.StatusOK, userShowResponse{User: presentUser(dbFor(r, db), u, currentUser(r))})
 			return
 		}
 
-		// define the rules
-		rules := govalidator.MapData{
-			"email":    []string{"required", "min:4", "max:30", "email"},
-			"password": []string{"required", "min:8", "max:255"},
+		if parseAPIID(param(r, "id")).where(tx).First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
 		}
 
-		// options for the validator
-		opts := govalidator.Options{
-			Request: r,
-			Data:    &req,
-			Rules:   rules,
+		setVersionHeaders(w, r, u, currentUser(r))
+		writeJSON(w, http.StatusOK, userShowResponse{User: presentUser(dbFor(r, db), u, currentUser(r))})
+	}
+}
+
+// userProfileRules are the rules for the optional profile fields, they can
+// be given when signing up and cleared again with an empty string
+var userProfileRules = govalidator.MapData{
+	"name":     []string{"max:100"},
+	"bio":      []string{"max:500"},
+	"location": []string{"max:100"},
+	"website":  []string{"max:255", "url"},
+	"twitter":  []string{"regex:^[A-Za-z0-9_]{1,15}$"},
+	"github":   []string{"max:39", "alpha_dash"},
+}
+
+// userUpdateRules are the rules for the fields a user can change, a field is
+// only validated when it is present in the request
+var userUpdateRules = mergeRules(govalidator.MapData{
+	"email":    []string{"min:4", "max:30", "email"},
+	"username": usernameRules,
+}, userProfileRules)
+
+// mergeRules combines sets of rules for different fields into one
+func mergeRules(sets ...govalidator.MapData) govalidator.MapData {
+	merged := govalidator.MapData{}
+	for _, set := range sets {
+		for field, rules := range set {
+			merged[field] = rules
 		}
+	}
+	return merged
+}
 
-		// create the validator
-		v := govalidator.New(opts)
+func usersUpdate(db *gorm.DB) http.HandlerFunc {
+	type userUpdateRequest struct {
+		Email    *string `json:"email"`
+		Username *string `json:"username"`
+		Name     *string `json:"name"`
+		Bio      *string `json:"bio"`
+		Location *string `json:"location"`
+		Website  *string `json:"website"`
+		Twitter  *string `json:"twitter"`
+		GitHub   *string `json:"github"`
+	}
 
-		// actually validate the request
-		e := v.ValidateJSON()
-		if len(e) >= 1 {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			err := map[string]interface{}{"errors": e}
-			json.NewEncoder(w).Encode(err)
+	type userUpdateResponse struct {
+		User interface{} `json:"user"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		u := user{}
+		if parseAPIID(param(r, "id")).where(tx).First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "user not found")
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		viewer := currentUser(r)
+		if !canManage(viewer, u) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		if preconditionFailed(r, u) {
+			writeError(w, http.StatusPreconditionFailed, "the user has changed since it was fetched")
+			return
+		}
 
-		// read the body from the response
-		body, _ := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
-		json.Unmarshal(body, &req)
+		body := json.RawMessage{}
+		if err := decodeJSON(r, &body); err != nil {
+			writeAppError(w, err)
+			return
+		}
 
-		// convert the request into a user struct
-		hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.MinCost)
+		// find out which fields were sent so only those are validated
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			writeAppError(w, invalidJSON(err))
+			return
+		}
 
-		newUser := user{
-			Email:    req.Email,
-			Password: string(hash),
+		errs := map[string][]string{}
+		rules := govalidator.MapData{}
+		for field := range fields {
+			fieldRules, ok := userUpdateRules[field]
+			if !ok {
+				errs[field] = append(errs[field], fmt.Sprintf("The %v field cannot be updated", field))
+				continue
+			}
+			if _, ok := userProfileRules[field]; ok {
+				rules[field] = fieldRules
+				continue
+			}
+			rules[field] = append([]string{"required"}, fieldRules...)
+		}
+		if len(rules) >= 1 {
+			v := govalidator.New(govalidator.Options{Data: &fields, Rules: rules})
+			for field, messages := range v.ValidateStruct() {
+				errs[field] = append(errs[field], messages...)
+			}
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
 		}
 
-		// persist the user
-		db.FirstOrCreate(&user{}, newUser)
-		db.First(&newUser)
+		req := userUpdateRequest{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeAppError(w, invalidJSON(err))
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if req.Email != nil {
+			*req.Email = normalizeEmail(*req.Email)
+		}
+		if req.Email != nil && *req.Email != u.Email {
+			// only administrators may set an email directly, checked first so
+			// nobody else can probe which emails are taken
+			if !viewer.Admin {
+				writeValidationErrors(w, map[string][]string{
+					"email": {"The email can only be changed through POST /me/email"},
+				})
+				return
+			}
+			taken := 0
+			tx.Unscoped().Model(&user{}).Where("lower(email) = ? AND id <> ?", strings.ToLower(*req.Email), u.ID).Count(&taken)
+			if taken >= 1 {
+				writeValidationErrors(w, map[string][]string{
+					"email": {"The email has already been taken"},
+				})
+				return
+			}
+			updates["email"] = *req.Email
+		}
+		if req.Username != nil {
+			*req.Username = normalizeUsername(*req.Username)
+		}
+		if req.Username != nil && (u.Username == nil || *req.Username != *u.Username) {
+			if !usernameChangeAllowed(u, viewer, time.Now()) {
+				writeValidationErrors(w, map[string][]string{
+					"username": {fmt.Sprintf("The username can only be changed once every %v", usernameCooldown)},
+				})
+				return
+			}
+			taken := 0
+			tx.Unscoped().Model(&user{}).Where("username = ? AND id <> ?", *req.Username, u.ID).Count(&taken)
+			if taken >= 1 {
+				writeValidationErrors(w, map[string][]string{
+					"username": {"The username has already been taken"},
+				})
+				return
+			}
+			updates["username"] = *req.Username
+			updates["username_changed_at"] = time.Now()
+		}
+		for column, value := range map[string]*string{"name": req.Name, "bio": req.Bio, "location": req.Location, "website": req.Website, "twitter": req.Twitter, "git_hub": req.GitHub} {
+			if value != nil {
+				updates[column] = *value
+			}
+		}
 
-		resp := userStoreResponse{
-			ID: newUser.ID,
+		if len(updates) >= 1 {
+			// the update only applies to the version checked above, so a
+			// change landing in between is not lost either
+			q := tx.Model(&u)
+			if conditional(r) {
+				q = q.Where("updated_at = ?", u.UpdatedAt)
+			}
+			q = q.Updates(updates)
+			if q.Error != nil {
+				logError(r, q.Error)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			if q.RowsAffected == 0 && conditional(r) {
+				writeError(w, http.StatusPreconditionFailed, "the user has changed since it was fetched")
+				return
+			}
 		}
 
-		data, _ := json.Marshal(resp)
+		setVersionHeaders(w, r, u, viewer)
+		writeJSON(w, http.StatusOK, userUpdateResponse{User: presentUser(dbFor(r, db), u, viewer)})
+	}
+}
+
+// usersDestroy soft deletes the user, the row is kept so it can be restored,
+// and cascades the delete to the relations in the policy
+func usersDestroy(db *gorm.DB, cascade cascadePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		u := user{}
+		if parseAPIID(param(r, "id")).where(tx).First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+
+		if !canManage(currentUser(r), u) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		err := transaction(tx, func(tx *gorm.DB) error {
+			if err := tx.Delete(&u).Error; err != nil {
+				return err
+			}
+			return cascade.apply(tx, []uint{u.ID}, time.Now())
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// usersRestore undoes a soft delete
+func usersRestore(db *gorm.DB) http.HandlerFunc {
+	type userRestoreResponse struct {
+		User interface{} `json:"user"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db).Unscoped()
+		u := user{}
+		if parseAPIID(param(r, "id")).where(tx).Where("deleted_at IS NOT NULL").First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "deleted user not found")
+			return
+		}
+
+		tx.Model(&u).Update("deleted_at", nil)
+
+		writeJSON(w, http.StatusOK, userRestoreResponse{User: presentUser(dbFor(r, db), u, currentUser(r))})
+	}
+}
+
+// userStoreRules are shared by every signup rather than rebuilt per request
+var userStoreRules = mergeRules(govalidator.MapData{
+	"email":    []string{"required", "min:4", "max:30", "email"},
+	"password": []string{"required", "min:8", "max:255"},
+	"username": usernameRules,
+}, userProfileRules)
+
+// isUniqueViolation reports whether the database rejected a write because
+// of a unique index
+func isUniqueViolation(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "duplicate key") || strings.Contains(msg, "Duplicate entry")
+}
+
+// responseBuffers are reused to encode responses on hot paths
+var responseBuffers = sync.Pool{
+	New: func() interface{} { return bytes.NewBuffer(make([]byte, 0, 512)) },
+}
+
+// userStoreRequest is a new user as sent to POST /users, and as a row of a
+// bulk import
+type userStoreRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Bio      string `json:"bio"`
+	Location string `json:"location"`
+	Website  string `json:"website"`
+	Twitter  string `json:"twitter"`
+	GitHub   string `json:"github"`
+}
+
+// newUser converts a validated request into the user to persist
+func (req userStoreRequest) newUser() user {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
+
+	u := user{
+		Email:    normalizeEmail(req.Email),
+		Password: string(hash),
+		Name:     req.Name,
+		Bio:      req.Bio,
+		Location: req.Location,
+		Website:  req.Website,
+		Twitter:  req.Twitter,
+		GitHub:   req.GitHub,
+	}
+	if req.Username != "" {
+		username := normalizeUsername(req.Username)
+		u.Username = &username
+	}
+	return u
+}
+
+// takenField names the field whose unique index the error violated
+func takenField(err error) string {
+	if strings.Contains(err.Error(), "username") {
+		return "username"
+	}
+	return "email"
+}
+
+func usersStore(db *gorm.DB) http.HandlerFunc {
+	type userStoreResponse struct {
+		ID apiID `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := userStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: userStoreRules,
+		})
+
+		// actually validate the request
+		stop := startPhase(r, "validation")
+		e := v.ValidateStruct()
+		stop()
+		if len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		// convert the request into a user struct
+		stop = startPhase(r, "hashing")
+		newUser := req.newUser()
+		stop()
+
+		// persist the user, the unique index on email decides whether the
+		// address is taken so two signups racing each other can't both win
+		tx := dbFor(r, db)
+		if err := tx.Create(&newUser).Error; err != nil {
+			if isUniqueViolation(err) {
+				field := takenField(err)
+				writeAppError(w, &appError{
+					Status:  http.StatusConflict,
+					Code:    field + "_taken",
+					Message: "The " + field + " has already been taken",
+					Fields:  map[string][]string{field: {"already taken"}},
+				})
+				return
+			}
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		stop = startPhase(r, "serialization")
+		buf := responseBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		json.NewEncoder(buf).Encode(userStoreResponse{ID: idOf(newUser)})
+		stop()
+		w.Header().Set("Content-type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		w.Write(data)
+		w.Write(buf.Bytes())
+		responseBuffers.Put(buf)
 	}
 }
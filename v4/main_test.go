@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func getDB() *gorm.DB {
@@ -19,6 +24,46 @@ func getDB() *gorm.DB {
 	return db
 }
 
+var (
+	sharedDB     *gorm.DB
+	sharedDBOnce sync.Once
+)
+
+// testTx returns a transaction on a database migrated once for the whole run,
+// rolling it back at the end of the test leaves the schema without the rows.
+// Handlers that start their own transaction get a savepoint inside it.
+func testTx(t testing.TB) (*gorm.DB, func()) {
+	sharedDBOnce.Do(func() {
+		sharedDB = getDB()
+		// every connection to :memory: opens a separate, empty database
+		sharedDB.DB().SetMaxOpenConns(1)
+		migrate(sharedDB)
+	})
+
+	tx := sharedDB.Begin()
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	return tx, func() { tx.Rollback() }
+}
+
+// createUser persists a user with the given credentials for tests that need one
+func createUser(db *gorm.DB, email, password string) user {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	u := user{Email: email, Password: string(hash)}
+	db.Create(&u)
+	return u
+}
+
+// login issues a bearer token for the user and returns the Authorization header value
+func login(db *gorm.DB, u user) string {
+	plain, err := issueToken(db, u)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return "Bearer " + plain
+}
+
 func TestUsersAreStoredInDatabase(t *testing.T) {
 	// Arrange
 	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer([]byte(`{"email":"jason@mccallister.io","password":"somePassword1!"}`)))
@@ -26,8 +71,8 @@ func TestUsersAreStoredInDatabase(t *testing.T) {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	db := getDB()
-	db.AutoMigrate(&user{})
+	db, rollback := testTx(t)
+	defer rollback()
 	handler := http.HandlerFunc(usersStore(db))
 	user := user{}
 
@@ -47,14 +92,58 @@ func TestUsersAreStoredInDatabase(t *testing.T) {
 	}
 }
 
+func TestStoringAUserRespondsWithItsID(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "first@example.com", "somePassword1!")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"second@example.com","password":"somePassword1!"}`)))
+
+	// Assert
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"id":2}` {
+		t.Errorf("expected the new user ID in the response, got %v instead", body)
+	}
+}
+
+func TestRegisteringATakenEmailIsAConflict(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "taken@example.com", "somePassword1!")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"taken@example.com","password":"differentPassword1!"}`)))
+
+	// Assert
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusConflict, status)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `"code":"email_taken"`) || !strings.Contains(body, `"errors":{"email":["already taken"]}`) {
+		t.Errorf("expected the email to be reported as taken, got %v instead", body)
+	}
+	count := 0
+	db.Model(&user{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected only the original user to exist, got %v users instead", count)
+	}
+}
+
 func TestEmailAndPasswordAreRequired(t *testing.T) {
 	// Arrange
-	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer([]byte(`{"not":"an email","or":"password"}`)))
+	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer([]byte(`{}`)))
 	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(usersStore(getDB()))
+	db, rollback := testTx(t)
+	defer rollback()
+	handler := http.HandlerFunc(usersStore(db))
 
 	// Act
 	handler.ServeHTTP(rr, req)
@@ -84,37 +173,514 @@ func TestEmptyPostRequestReturnsAnError(t *testing.T) {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(usersStore(getDB()))
+	db, rollback := testTx(t)
+	defer rollback()
+	handler := http.HandlerFunc(usersStore(db))
 
 	// Act
 	handler.ServeHTTP(rr, req)
 
 	// Assert
-	if status := rr.Code; status != http.StatusUnprocessableEntity {
-		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusBadRequest, status)
 	}
 }
 
-func TestRequiresPOSTRequest(t *testing.T) {
+func TestUsersRouteDispatchesByMethod(t *testing.T) {
 	// Arrange
-	req, err := http.NewRequest("GET", "/users", nil)
+	db, rollback := testTx(t)
+	defer rollback()
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/users", access: accessOptional, handler: usersIndex(db)},
+		{method: http.MethodPost, path: "/users", handler: usersStore(db)},
+	})
+	created := httptest.NewRecorder()
+	listed := httptest.NewRecorder()
+	rejected := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(created, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"jason@mccallister.io","password":"somePassword1!"}`)))
+	rt.ServeHTTP(listed, httptest.NewRequest("GET", "/users", nil))
+	rt.ServeHTTP(rejected, httptest.NewRequest("PUT", "/users", nil))
+
+	// Assert
+	if created.Code != http.StatusCreated {
+		t.Errorf("expected POST to create a user, got %v %v instead", created.Code, created.Body.String())
+	}
+	if listed.Code != http.StatusOK || !strings.Contains(listed.Body.String(), `"users":[{`) {
+		t.Errorf("expected GET to list the users, got %v %v instead", listed.Code, listed.Body.String())
+	}
+	if rejected.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusMethodNotAllowed, rejected.Code)
+	}
+	if rejected.Header().Get("content-type") != problemContentType {
+		t.Errorf("expected the content-text to be %v, got %v instead", problemContentType, rejected.Header().Get("content-type"))
+	}
+	if !strings.Contains(rejected.Body.String(), `"code":"method_not_allowed"`) {
+		t.Errorf("expected the JSON response to contain %v, got %v instead", "method_not_allowed", rejected.Body.String())
+	}
+	if allow := rejected.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("expected the Allow header to be %v, got %v instead", "GET, HEAD, OPTIONS, POST", allow)
+	}
+}
+
+func TestUsersCanBeShownByID(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("GET", fmt.Sprintf("/users/%v", u.ID), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(usersStore(getDB()))
+	handler := newRouter()
+	handler.handle(http.MethodGet, "/users/{id}", usersShow(db))
 
 	// Act
 	handler.ServeHTTP(rr, req)
 
 	// Assert
-	if status := rr.Code; status != http.StatusMethodNotAllowed {
-		t.Errorf("expected the status code to be %v, got %v instead", http.StatusMethodNotAllowed, status)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
 	}
 	if rr.Header().Get("content-type") != "application/json" {
 		t.Errorf("expected the content-text to be %v, got %v instead", "application/json", rr.Header().Get("content-type"))
 	}
-	if !strings.Contains(rr.Body.String(), "method not allowed") {
-		t.Errorf("expected the JSON response to contain %v, got %v instead", "method not allowed", rr.Body.String())
+	if !strings.Contains(rr.Body.String(), fmt.Sprintf(`"id":%v`, u.ID)) {
+		t.Errorf("expected the JSON response to contain the user, got %v instead", rr.Body.String())
+	}
+}
+
+func TestShowingAMissingUserReturnsNotFound(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	req, err := http.NewRequest("GET", "/users/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := newRouter()
+	handler.handle(http.MethodGet, "/users/{id}", usersShow(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, status)
+	}
+	if !strings.Contains(rr.Body.String(), "user not found") {
+		t.Errorf("expected the JSON response to contain %v, got %v instead", "user not found", rr.Body.String())
+	}
+}
+
+func updateRouter(db *gorm.DB) *router {
+	rt := newRouter()
+	rt.handle(http.MethodPatch, "/users/{id}", requireAuth(db, usersUpdate(db)))
+	return rt
+}
+
+func TestAdministratorsCanUpdateEmailsDirectly(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@mccallister.io", "somePassword1!")
+	db.Model(&admin).Update("admin", true)
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), bytes.NewBuffer([]byte(`{"email":"jason@example.com"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, admin))
+	rr := httptest.NewRecorder()
+	handler := updateRouter(db)
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if !strings.Contains(rr.Body.String(), `"email":"jason@example.com"`) {
+		t.Errorf("expected the updated user to be returned, got %v instead", rr.Body.String())
+	}
+	db.First(&u, u.ID)
+	if u.Email != "jason@example.com" {
+		t.Errorf("expected the email to be updated, got %v instead", u.Email)
+	}
+}
+
+func TestUpdatingAMissingUserReturnsNotFound(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("PATCH", "/users/42", bytes.NewBuffer([]byte(`{"email":"jason@example.com"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	handler := updateRouter(db)
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, status)
+	}
+}
+
+func TestUpdatesOnlyValidateTheProvidedFields(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	createUser(db, "taken@mccallister.io", "somePassword1!")
+	auth := login(db, u)
+	handler := updateRouter(db)
+	bodies := map[string]string{
+		`{"email":"not an email"}`:         "The email field must be a valid email address",
+		`{"email":"taken@mccallister.io"}`: "The email can only be changed through POST /me/email",
+		`{"password":"changeViaTheAPI!1"}`: "The password field cannot be updated",
+		`{"email":""}`:                     "The email field is required",
+	}
+
+	for body, message := range bodies {
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), bytes.NewBuffer([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != http.StatusUnprocessableEntity {
+			t.Errorf("expected the status code to be %v for %v, got %v instead", http.StatusUnprocessableEntity, body, status)
+		}
+		if !strings.Contains(rr.Body.String(), message) {
+			t.Errorf("expected the %v validation error to be returned, got this instead\n:%v", message, rr.Body.String())
+		}
+	}
+}
+
+func TestUsersCannotUpdateOtherUsers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	other := createUser(db, "someone@else.io", "somePassword1!")
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("/users/%v", other.ID), bytes.NewBuffer([]byte(`{"email":"hijacked@example.com"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	handler := updateRouter(db)
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, status)
+	}
+}
+
+func TestFailedUserUpdatesAreInternalServerErrors(t *testing.T) {
+	// Arrange
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	db.Exec("CREATE TRIGGER users_readonly BEFORE UPDATE ON users BEGIN SELECT RAISE(ABORT, 'read only'); END")
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"name":"Jason"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	updateRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusInternalServerError, status)
+	}
+}
+
+func TestUsersCanBeSoftDeletedAndRestored(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@mccallister.io", "somePassword1!")
+	db.Model(&admin).Update("admin", true)
+	auth := login(db, admin)
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	handler := newRouter()
+	handler.handle(http.MethodDelete, "/users/{id}", requireAuth(db, usersDestroy(db, cascadePolicy{})))
+	handler.handle(http.MethodPost, "/users/{id}/restore", requireAdmin(db, usersRestore(db)))
+	del, err := http.NewRequest("DELETE", fmt.Sprintf("/users/%v", u.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	del.Header.Set("Authorization", auth)
+	restore, err := http.NewRequest("POST", fmt.Sprintf("/users/%v/restore", u.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore.Header.Set("Authorization", auth)
+
+	// Act
+	deleted := httptest.NewRecorder()
+	handler.ServeHTTP(deleted, del)
+	listed := httptest.NewRecorder()
+	usersIndex(db).ServeHTTP(listed, httptest.NewRequest("GET", "/users", nil))
+	restored := httptest.NewRecorder()
+	handler.ServeHTTP(restored, restore)
+
+	// Assert
+	if status := deleted.Code; status != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, status)
+	}
+	if strings.Contains(listed.Body.String(), fmt.Sprintf(`"id":%v`, u.ID)) {
+		t.Errorf("expected the deleted user to be excluded from the index, got %v instead", listed.Body.String())
+	}
+	if status := restored.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if db.First(&user{}, u.ID).RecordNotFound() {
+		t.Errorf("expected the user to be restored")
+	}
+}
+
+func TestRestoringAUserThatIsNotDeletedReturnsNotFound(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("POST", fmt.Sprintf("/users/%v/restore", u.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := newRouter()
+	handler.handle(http.MethodPost, "/users/{id}/restore", usersRestore(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, status)
+	}
+}
+
+func BenchmarkUsersStore(b *testing.B) {
+	db, rollback := testTx(b)
+	defer rollback()
+	handler := http.HandlerFunc(usersStore(db))
+	bodies := make([][]byte, b.N)
+	for i := range bodies {
+		bodies[i] = []byte(fmt.Sprintf(`{"email":"bench%v@example.com","password":"somePassword1!"}`, i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", bytes.NewReader(bodies[i])))
+		if rr.Code != http.StatusCreated {
+			b.Fatalf("expected the status code to be %v, got %v instead", http.StatusCreated, rr.Code)
+		}
+	}
+}
+
+func TestUsersIndexCanBeFilteredByCreationDate(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	old := createUser(db, "old@example.com", "somePassword1!")
+	createUser(db, "new@example.com", "somePassword1!")
+	db.Model(&old).UpdateColumn("created_at", time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users?created_after=2020-01-01", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `"id":2`) || strings.Contains(body, `"id":1`) {
+		t.Errorf("expected only the newer user, got %v instead", body)
+	}
+}
+
+func TestInvalidFiltersAreRejected(t *testing.T) {
+	tests := map[string]int{
+		"/users?created_after=yesterday":   http.StatusUnprocessableEntity,
+		"/users?email=someone@example.com": http.StatusUnprocessableEntity,
+		"/users?verified=true":             http.StatusBadRequest,
+	}
+
+	for target, expected := range tests {
+		// Arrange
+		db, rollback := testTx(t)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(usersIndex(db))
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		rollback()
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", target, expected, status)
+		}
+	}
+}
+
+func TestAdminsCanFilterUsersByEmail(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@example.com", "somePassword1!")
+	db.Model(&admin).UpdateColumn("admin", true)
+	createUser(db, "someone@example.com", "somePassword1!")
+	req := httptest.NewRequest("GET", "/users?email=someone@example.com", nil)
+	req.Header.Set("Authorization", login(db, admin))
+	rr := httptest.NewRecorder()
+	handler := optionalAuth(db, usersIndex(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if body := rr.Body.String(); !strings.Contains(body, "someone@example.com") || strings.Contains(body, "admin@example.com") {
+		t.Errorf("expected only the matching user, got %v instead", body)
+	}
+}
+
+func TestAdminsCanSearchUsersByEmail(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@example.com", "somePassword1!")
+	db.Model(&admin).UpdateColumn("admin", true)
+	createUser(db, "Jason@McCallister.io", "somePassword1!")
+	createUser(db, "jason_m@example.com", "somePassword1!")
+	named := createUser(db, "gopher@example.com", "somePassword1!")
+	db.Model(&named).UpdateColumn("name", "Gary Gopher")
+	handler := requireAdmin(db, usersSearch(db))
+
+	tests := map[string]string{
+		"mccallister": "Jason@McCallister.io",
+		"n_m":         "jason_m@example.com",
+		"gary":        "gopher@example.com",
+	}
+	for term, expected := range tests {
+		req := httptest.NewRequest("GET", "/users/search?q="+term, nil)
+		req.Header.Set("Authorization", login(db, admin))
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if body := rr.Body.String(); !strings.Contains(body, expected) || !strings.Contains(body, `"total":1`) {
+			t.Errorf("expected only %v when searching for %v, got %v instead", expected, term, body)
+		}
+	}
+}
+
+func TestSearchingUsersRequiresATerm(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersSearch(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users/search?q=+", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestProfilesCanBeGivenAtSignupAndUpdated(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	handler := http.HandlerFunc(usersStore(db))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"profile@example.com","password":"somePassword1!","name":"Jason","bio":"Gopher","website":"https://example.com"}`)))
+	u := user{}
+	db.First(&u)
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"bio":"","location":"Norfolk, VA"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	updateRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("expected the status code to be %v, got %v instead: %v", http.StatusOK, status, rr.Body.String())
+	}
+	db.First(&u, u.ID)
+	if u.Name != "Jason" || u.Website != "https://example.com" || u.Bio != "" || u.Location != "Norfolk, VA" {
+		t.Errorf("expected the profile to be stored and updated, got %+v instead", u)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `"location":"Norfolk, VA"`) || strings.Contains(body, "password") {
+		t.Errorf("expected the profile without the password in the response, got %v instead", body)
+	}
+}
+
+func TestProfileFieldsAreValidated(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(usersStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"profile@example.com","password":"somePassword1!","website":"not a url"}`)))
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestTwitterHandlesAreValidated(t *testing.T) {
+	for twitter, want := range map[string]int{
+		"":                 http.StatusCreated,
+		"go_pher1":         http.StatusCreated,
+		"go-pher":          http.StatusUnprocessableEntity,
+		"waytoolonghandle": http.StatusUnprocessableEntity,
+	} {
+		// Arrange
+		db, rollback := testTx(t)
+		rr := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"email":"profile@example.com","password":"somePassword1!","twitter":%q}`, twitter)
+
+		// Act
+		http.HandlerFunc(usersStore(db)).ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(body)))
+		rollback()
+
+		// Assert
+		if status := rr.Code; status != want {
+			t.Errorf("expected the status code for %q to be %v, got %v instead: %v", twitter, want, status, rr.Body.String())
+		}
 	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceExempt are the paths still served in maintenance, the health
+// checks so the instance isn't restarted and the switch so it can be
+// turned off
+var maintenanceExempt = map[string]bool{
+	"/healthz":           true,
+	"/readyz":            true,
+	"/admin/maintenance": true,
+}
+
+// maintenanceMode answers every request but the exempt ones with a 503 so
+// operators can take the database down without stopping the server. It is
+// switched on through /admin/maintenance or by creating the file, the file
+// works when the database is already down and admins can't sign in.
+type maintenanceMode struct {
+	on         int32
+	retryAfter int64
+	file       string
+}
+
+func newMaintenanceMode(file string, retryAfter time.Duration) *maintenanceMode {
+	return &maintenanceMode{file: file, retryAfter: int64(retryAfter / time.Second)}
+}
+
+// active reports whether the server is in maintenance
+func (m *maintenanceMode) active() bool {
+	if atomic.LoadInt32(&m.on) == 1 {
+		return true
+	}
+	if m.file == "" {
+		return false
+	}
+	_, err := os.Stat(m.file)
+	return err == nil
+}
+
+func (m *maintenanceMode) set(on bool, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		atomic.StoreInt64(&m.retryAfter, int64(retryAfter/time.Second))
+	}
+	value := int32(0)
+	if on {
+		value = 1
+	}
+	atomic.StoreInt32(&m.on, value)
+}
+
+func (m *maintenanceMode) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceExempt[r.URL.Path] || !m.active() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.FormatInt(atomic.LoadInt64(&m.retryAfter), 10))
+		writeAppError(w, &appError{Status: http.StatusServiceUnavailable, Code: "maintenance", Message: "the service is down for maintenance"})
+	})
+}
+
+type maintenanceResponse struct {
+	Maintenance bool  `json:"maintenance"`
+	RetryAfter  int64 `json:"retry_after"`
+}
+
+func (m *maintenanceMode) response() maintenanceResponse {
+	return maintenanceResponse{Maintenance: m.active(), RetryAfter: atomic.LoadInt64(&m.retryAfter)}
+}
+
+// maintenanceShow reports whether the server is in maintenance
+func maintenanceShow(m *maintenanceMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.response())
+	}
+}
+
+// maintenanceUpdate puts the server in maintenance, retry_after optionally
+// changes how many seconds clients are told to wait
+func maintenanceUpdate(m *maintenanceMode) http.HandlerFunc {
+	type maintenanceUpdateRequest struct {
+		RetryAfter int64 `json:"retry_after"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := maintenanceUpdateRequest{}
+		if r.ContentLength != 0 {
+			if err := decodeJSON(r, &req); err != nil {
+				writeAppError(w, err)
+				return
+			}
+		}
+		if req.RetryAfter < 0 {
+			writeValidationErrors(w, map[string][]string{"retry_after": {"The retry_after field must be a positive number of seconds"}})
+			return
+		}
+
+		m.set(true, time.Duration(req.RetryAfter)*time.Second)
+		writeJSON(w, http.StatusOK, m.response())
+	}
+}
+
+// maintenanceDestroy takes the server out of maintenance, unless the file
+// keeps it in
+func maintenanceDestroy(m *maintenanceMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.set(false, 0)
+		writeJSON(w, http.StatusOK, m.response())
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnlyHealthChecksAnswerDuringMaintenance(t *testing.T) {
+	// Arrange
+	m := newMaintenanceMode("", time.Minute)
+	m.set(true, 0)
+	handler := m.middleware(okHandler())
+
+	for path, expected := range map[string]int{"/healthz": http.StatusOK, "/admin/maintenance": http.StatusOK, "/users": http.StatusServiceUnavailable} {
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", path, expected, status)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("expected clients to retry after 60 seconds, got %v instead", got)
+	}
+	if !strings.Contains(rr.Body.String(), `"code":"maintenance"`) {
+		t.Errorf("expected the maintenance code, got %v instead", rr.Body.String())
+	}
+
+	m.set(false, 0)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected traffic to flow after maintenance, got %v instead", status)
+	}
+}
+
+func TestMaintenanceLastsWhileTheFileExists(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "maintenance")
+	m := newMaintenanceMode(file, time.Minute)
+	before := m.active()
+
+	// Act
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if before || !m.active() {
+		t.Errorf("expected maintenance to follow the file, got %v then %v instead", before, m.active())
+	}
+}
+
+func TestAdministratorsCanSwitchMaintenance(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	admin := createUser(db, "admin@mccallister.io", "somePassword1!")
+	db.Model(&admin).Update("admin", true)
+	auth := login(db, admin)
+	m := newMaintenanceMode("", time.Minute)
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodPut, path: "/admin/maintenance", access: accessAdmin, handler: maintenanceUpdate(m)},
+		{method: http.MethodDelete, path: "/admin/maintenance", access: accessAdmin, handler: maintenanceDestroy(m)},
+	})
+	req := httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"retry_after":120}`))
+	req.Header.Set("Authorization", auth)
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, req)
+
+	// Assert
+	if body := rr.Body.String(); rr.Code != http.StatusOK || body != `{"maintenance":true,"retry_after":120}` {
+		t.Errorf("expected maintenance to be switched on, got %v %v instead", rr.Code, body)
+	}
+	req = httptest.NewRequest("DELETE", "/admin/maintenance", nil)
+	req.Header.Set("Authorization", auth)
+	rr = httptest.NewRecorder()
+	rt.ServeHTTP(rr, req)
+	if m.active() {
+		t.Errorf("expected maintenance to be switched off, got %v instead", rr.Body.String())
+	}
+}
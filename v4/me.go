@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+func meShow(db *gorm.DB) http.HandlerFunc {
+	type meShowResponse struct {
+		User interface{} `json:"user"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		includes, errs := parseIncludes(r, userIncludes)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		u := currentUser(r)
+		if len(includes) >= 1 {
+			applyIncludes(dbFor(r, db), includes, userIncludes).First(u, u.ID)
+		}
+
+		writeJSON(w, http.StatusOK, meShowResponse{User: presentUser(dbFor(r, db), *u, u)})
+	}
+}
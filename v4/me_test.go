@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMeShowsTheLastLogin(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "jason@mccallister.io", "somePassword1!")
+	loginReq, err := http.NewRequest("POST", "/login", bytes.NewBuffer([]byte(`{"email":"jason@mccallister.io","password":"somePassword1!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginReq.RemoteAddr = "203.0.113.7:4321"
+	loginReq.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:70.0) Gecko/20100101 Firefox/70.0")
+	sessionsStore(db).ServeHTTP(httptest.NewRecorder(), loginReq)
+	u := user{}
+	db.Where("email = ?", "jason@mccallister.io").First(&u)
+	req, err := http.NewRequest("GET", "/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(requireAuth(db, meShow(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	for _, expected := range []string{`"last_login_ip":"203.0.113.7"`, `"last_login_device":"Firefox on Windows"`} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected the response to contain %v, got %v instead", expected, rr.Body.String())
+		}
+	}
+}
+
+func TestUserAgentsAreDescribed(t *testing.T) {
+	agents := map[string]string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.70 Safari/537.36":                "Chrome on macOS",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 13_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0 Mobile/15E148 Safari/604.1": "Safari on iOS",
+		"curl/7.64.1": "curl",
+		"":            "Unknown browser",
+	}
+
+	for ua, expected := range agents {
+		if got := describeUserAgent(ua); got != expected {
+			t.Errorf("expected %q to be described as %v, got %v instead", ua, expected, got)
+		}
+	}
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// meetup is an event a user organizes
+type meetup struct {
+	ID          uint      `gorm:"primary_key"`
+	Title       string    `gorm:"type:varchar(100)"`
+	Description string    `gorm:"type:varchar(2000)"`
+	StartsAt    time.Time `gorm:"index"`
+	Location    string    `gorm:"type:varchar(255)"`
+	OrganizerID uint      `gorm:"index"`
+	Capacity    int       `gorm:"not null;default:0"`
+	Recurrence  string    `gorm:"type:varchar(255)"`
+	VenueID     *uint     `gorm:"index"`
+	CancelledAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// meetupResponse is a meetup as the API shows it, the organizer is
+// identified the same way users are everywhere else. A capacity of zero
+// means there is no limit.
+type meetupResponse struct {
+	ID            uint       `json:"id"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description"`
+	StartsAt      time.Time  `json:"starts_at"`
+	Location      string     `json:"location"`
+	OrganizerID   apiID      `json:"organizer_id"`
+	Capacity      int        `json:"capacity"`
+	Recurrence    string     `json:"recurrence"`
+	VenueID       *uint      `json:"venue_id"`
+	Distance      *float64   `json:"distance_km,omitempty"`
+	Tags          []string   `json:"tags"`
+	CancelledAt   *time.Time `json:"cancelled_at"`
+	GoingCount    int        `json:"going_count"`
+	WaitlistCount int        `json:"waitlist_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func presentMeetup(m meetup, organizer user) meetupResponse {
+	return meetupResponse{
+		ID:          m.ID,
+		Title:       m.Title,
+		Description: m.Description,
+		StartsAt:    m.StartsAt,
+		Location:    m.Location,
+		OrganizerID: idOf(organizer),
+		Capacity:    m.Capacity,
+		Recurrence:  m.Recurrence,
+		VenueID:     m.VenueID,
+		Tags:        []string{},
+		CancelledAt: m.CancelledAt,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+	}
+}
+
+// presentMeetups loads the organizers, RSVP counts and tags of the meetups,
+// a query each rather than one per meetup. Deleted organizers are included so
+// their meetups still name them.
+func presentMeetups(db *gorm.DB, meetups []meetup) []meetupResponse {
+	ids := make([]uint, len(meetups))
+	organizerIDs := make([]uint, len(meetups))
+	for i, m := range meetups {
+		ids[i] = m.ID
+		organizerIDs[i] = m.OrganizerID
+	}
+	organizers := []user{}
+	db.Unscoped().Where("id IN (?)", organizerIDs).Find(&organizers)
+	byID := make(map[uint]user, len(organizers))
+	for _, u := range organizers {
+		byID[u.ID] = u
+	}
+
+	counts := rsvpCounts(db, ids)
+	tags := meetupTags(db, ids)
+
+	resp := make([]meetupResponse, len(meetups))
+	for i, m := range meetups {
+		organizer, ok := byID[m.OrganizerID]
+		if !ok {
+			organizer = user{ID: m.OrganizerID}
+		}
+		resp[i] = presentMeetup(m, organizer)
+		resp[i].GoingCount = counts[m.ID][rsvpGoing]
+		resp[i].WaitlistCount = counts[m.ID][rsvpWaitlisted]
+		if tags[m.ID] != nil {
+			resp[i].Tags = tags[m.ID]
+		}
+	}
+	return resp
+}
+
+// ownsMeetup reports whether the viewer may delete the meetup or choose its
+// co-organizers, which is limited to its organizer and administrators
+func ownsMeetup(viewer *user, m meetup) bool {
+	return viewer != nil && (viewer.Admin || viewer.ID == m.OrganizerID)
+}
+
+// canOrganize reports whether the viewer may change the meetup, which its
+// co-organizers can as well as its owners
+func canOrganize(db *gorm.DB, viewer *user, m meetup) bool {
+	if viewer == nil {
+		return false
+	}
+	if ownsMeetup(viewer, m) {
+		return true
+	}
+	n := 0
+	db.Model(&meetupOrganizer{}).Where("meetup_id = ? AND user_id = ?", m.ID, viewer.ID).Count(&n)
+	return n > 0
+}
+
+// meetupRules are the rules for the fields of a meetup, starts_at is an RFC
+// 3339 timestamp and recurrence an RRULE, which parseStartsAt and
+// parseRecurrence check since the validator has no rules for them. A
+// venue_id of zero takes the meetup off its venue.
+var meetupRules = govalidator.MapData{
+	"title":       []string{"required", "max:100"},
+	"description": []string{"max:2000"},
+	"starts_at":   []string{"required"},
+	"location":    []string{"max:255"},
+	"capacity":    []string{"numeric_between:0,100000"},
+	"recurrence":  []string{"max:255"},
+	"venue_id":    []string{"numeric"},
+	"tags":        []string{},
+}
+
+// normalizeRecurrence checks an RRULE and returns it the way it is stored,
+// an empty rule means the meetup doesn't recur
+func normalizeRecurrence(s string) (string, []string) {
+	if s == "" {
+		return "", nil
+	}
+	rc, err := parseRecurrence(s)
+	if err != nil {
+		return "", []string{"The recurrence field must be a supported RRULE: " + err.Error()}
+	}
+	return rc.String(), nil
+}
+
+func parseStartsAt(s string) (time.Time, []string) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, []string{"The starts_at field must be an RFC 3339 timestamp"}
+	}
+	return t, nil
+}
+
+// meetupsIndexParams are the query parameters the meetups index understands
+var meetupsIndexParams = []string{"page", "per_page", "sort", "from", "to", "near", "radius", "tag"}
+
+// defaultSearchRadius is how far from near meetups are found, in kilometres,
+// when no radius is given
+const defaultSearchRadius = 25
+
+// filterMeetups narrows the meetups to those with the tag, starting in
+// [from, to) and held at a venue within radius kilometres of near, a
+// "lat,lng" pair. A recurring meetup matches the dates once the series has
+// started. Venues are narrowed to a bounding box by the database first, the
+// exact distance is then worked out for the few left, which is returned by
+// venue.
+func filterMeetups(q *gorm.DB, r *http.Request) (*gorm.DB, map[uint]float64, map[string][]string) {
+	errs := map[string][]string{}
+	params := r.URL.Query()
+
+	if s := params.Get("tag"); s != "" {
+		q = taggedWith(q, s)
+	}
+	if s := params.Get("from"); s != "" {
+		from, err := parseDate(s)
+		if err != nil {
+			errs["from"] = append(errs["from"], "The from field must be a date or an RFC 3339 timestamp")
+		}
+		q = q.Where("meetups.starts_at >= ? OR meetups.recurrence <> ''", from)
+	}
+	if s := params.Get("to"); s != "" {
+		to, err := parseDate(s)
+		if err != nil {
+			errs["to"] = append(errs["to"], "The to field must be a date or an RFC 3339 timestamp")
+		}
+		q = q.Where("meetups.starts_at < ?", to)
+	}
+
+	near := params.Get("near")
+	if near == "" {
+		if params.Get("radius") != "" {
+			errs["radius"] = append(errs["radius"], "The radius field can only be used with near")
+		}
+		return q, nil, errs
+	}
+	lat, lng, ok := parseCoordinates(near)
+	if !ok {
+		errs["near"] = append(errs["near"], "The near field must be a latitude and longitude such as 36.85,-76.29")
+		return q, nil, errs
+	}
+	radius := float64(defaultSearchRadius)
+	if s := params.Get("radius"); s != "" {
+		var err error
+		if radius, err = strconv.ParseFloat(s, 64); err != nil || radius <= 0 || radius > 1000 {
+			errs["radius"] = append(errs["radius"], "The radius field must be a number of kilometres between 0 and 1000")
+			return q, nil, errs
+		}
+	}
+
+	venues := []venue{}
+	minLat, maxLat, minLng, maxLng := boundingBox(lat, lng, radius)
+	box := q.New().Where("latitude BETWEEN ? AND ?", minLat, maxLat)
+	if minLng > -180 || maxLng < 180 {
+		box = box.Where("longitude BETWEEN ? AND ?", minLng, maxLng)
+	}
+	box.Find(&venues)
+
+	distances := map[uint]float64{}
+	ids := []uint{}
+	for _, v := range venues {
+		if d := haversine(lat, lng, v.Latitude, v.Longitude); d <= radius {
+			distances[v.ID] = d
+			ids = append(ids, v.ID)
+		}
+	}
+	return q.Where("meetups.venue_id IN (?)", ids), distances, errs
+}
+
+func meetupsIndex(db *gorm.DB) http.HandlerFunc {
+	type meetupsIndexResponse struct {
+		Meetups    []meetupResponse `json:"meetups"`
+		Pagination pagination       `json:"pagination"`
+		Links      pageLinks        `json:"links"`
+	}
+
+	policy := listPolicyFor("/meetups")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, meetupsIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts, errs := parseListOptions(r, policy)
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort, errs := parseSort(r, policy)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts.Sort = sort
+
+		tx := dbFor(r, db)
+		q, distances, errs := filterMeetups(tx, r)
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		page := opts.paginate(q, &meetup{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		meetups := []meetup{}
+		opts.apply(q).Find(&meetups)
+
+		resp := meetupsIndexResponse{
+			Meetups:    presentMeetups(tx, meetups),
+			Pagination: page,
+			Links:      links,
+		}
+		if distances != nil {
+			for i, m := range meetups {
+				d := distances[*m.VenueID]
+				resp.Meetups[i].Distance = &d
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// filterWhen narrows a query of meetups to the upcoming or past ones as the
+// when parameter asks, by when they start. A recurring meetup counts as
+// upcoming.
+func filterWhen(q *gorm.DB, r *http.Request, now time.Time) (*gorm.DB, map[string][]string) {
+	switch when := r.URL.Query().Get("when"); when {
+	case "":
+		return q, nil
+	case "upcoming":
+		return q.Where("meetups.starts_at >= ? OR meetups.recurrence <> ''", now), nil
+	case "past":
+		return q.Where("meetups.starts_at < ? AND meetups.recurrence = ''", now), nil
+	default:
+		return q, map[string][]string{"when": {"The when field must be upcoming or past"}}
+	}
+}
+
+// userMeetupsIndexParams are the query parameters the list of a user's
+// meetups understands
+var userMeetupsIndexParams = []string{"page", "per_page", "sort", "when"}
+
+// userMeetupsIndex lists the meetups the user organizes or co-organizes,
+// past meetups are listed the most recent first unless sorted otherwise
+func userMeetupsIndex(db *gorm.DB) http.HandlerFunc {
+	type userMeetupsIndexResponse struct {
+		Meetups    []meetupResponse `json:"meetups"`
+		Pagination pagination       `json:"pagination"`
+		Links      pageLinks        `json:"links"`
+	}
+
+	policy := listPolicyFor("/users/{id}/meetups")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, userMeetupsIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := dbFor(r, db)
+		u := user{}
+		if parseAPIID(param(r, "id")).where(tx).First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+
+		opts, errs := parseListOptions(r, policy)
+		coOrganized := tx.New().Model(&meetupOrganizer{}).Select("meetup_id").Where("user_id = ?", u.ID)
+		q, whenErrs := filterWhen(tx.Where("organizer_id = ? OR meetups.id IN (?)", u.ID, coOrganized.SubQuery()), r, time.Now())
+		for field, messages := range whenErrs {
+			errs[field] = append(errs[field], messages...)
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort, errs := parseSort(r, policy)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		if r.URL.Query().Get("sort") == "" && r.URL.Query().Get("when") == "past" {
+			sort = "-starts_at"
+		}
+		opts.Sort = sort
+
+		page := opts.paginate(q, &meetup{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		meetups := []meetup{}
+		opts.apply(q).Find(&meetups)
+
+		writeJSON(w, http.StatusOK, userMeetupsIndexResponse{
+			Meetups:    presentMeetups(tx, meetups),
+			Pagination: page,
+			Links:      links,
+		})
+	}
+}
+
+func meetupsStore(db *gorm.DB) http.HandlerFunc {
+	type meetupStoreRequest struct {
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		StartsAt    string   `json:"starts_at"`
+		Location    string   `json:"location"`
+		Capacity    int      `json:"capacity"`
+		Recurrence  string   `json:"recurrence"`
+		VenueID     uint     `json:"venue_id"`
+		Tags        []string `json:"tags"`
+	}
+
+	type meetupStoreResponse struct {
+		Meetup meetupResponse `json:"meetup"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := meetupStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: meetupRules,
+		})
+		errs := v.ValidateStruct()
+		startsAt, messages := parseStartsAt(req.StartsAt)
+		if req.StartsAt != "" && len(messages) >= 1 {
+			errs["starts_at"] = append(errs["starts_at"], messages...)
+		}
+		recurrence, messages := normalizeRecurrence(req.Recurrence)
+		if len(messages) >= 1 {
+			errs["recurrence"] = append(errs["recurrence"], messages...)
+		}
+		var venueID *uint
+		if req.VenueID != 0 {
+			venueID = &req.VenueID
+		}
+		capacity, venueErrs := fitVenue(dbFor(r, db), venueID, req.Capacity)
+		for field, messages := range venueErrs {
+			errs[field] = append(errs[field], messages...)
+		}
+		tags, messages := normalizeTags(req.Tags)
+		if len(messages) >= 1 {
+			errs["tags"] = append(errs["tags"], messages...)
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		organizer := currentUser(r)
+		m := meetup{
+			Title:       req.Title,
+			Description: req.Description,
+			StartsAt:    startsAt,
+			Location:    req.Location,
+			OrganizerID: organizer.ID,
+			Capacity:    capacity,
+			Recurrence:  recurrence,
+			VenueID:     venueID,
+		}
+		err := transaction(dbFor(r, db), func(tx *gorm.DB) error {
+			if err := tx.Create(&m).Error; err != nil {
+				return err
+			}
+			return setMeetupTags(tx, m.ID, tags)
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		resp := presentMeetup(m, *organizer)
+		resp.Tags = tags
+		writeJSON(w, http.StatusCreated, meetupStoreResponse{Meetup: resp})
+	}
+}
+
+// decodePartial decodes the fields sent in the body into req, only those
+// fields are validated against the rules and fields without rules can't be
+// changed. The error is for a body that isn't a JSON object or has fields
+// of the wrong type.
+func decodePartial(r *http.Request, req interface{}, rules govalidator.MapData) (map[string][]string, error) {
+	body := json.RawMessage{}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, invalidJSON(err)
+	}
+
+	errs := map[string][]string{}
+	present := govalidator.MapData{}
+	for field := range fields {
+		fieldRules, ok := rules[field]
+		if !ok {
+			errs[field] = append(errs[field], fmt.Sprintf("The %v field cannot be updated", field))
+			continue
+		}
+		present[field] = fieldRules
+	}
+	if len(present) >= 1 {
+		v := govalidator.New(govalidator.Options{Data: &fields, Rules: present})
+		for field, messages := range v.ValidateStruct() {
+			errs[field] = append(errs[field], messages...)
+		}
+	}
+
+	// fields without rules were reported above, so only the types can fail
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, invalidJSON(err)
+	}
+	return errs, nil
+}
+
+// findMeetup loads the meetup named by the id path parameter
+func findMeetup(tx *gorm.DB, r *http.Request) (meetup, bool) {
+	m := meetup{}
+	id, err := strconv.ParseUint(param(r, "id"), 10, 64)
+	if err != nil {
+		return m, false
+	}
+	return m, !tx.First(&m, id).RecordNotFound()
+}
+
+func meetupsShow(db *gorm.DB) http.HandlerFunc {
+	// the detail of a meetup includes its program, the talks accepted for it
+	type meetupDetail struct {
+		meetupResponse
+		Talks []talkResponse `json:"talks"`
+	}
+	type meetupShowResponse struct {
+		Meetup meetupDetail `json:"meetup"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		talks := []talk{}
+		tx.Where("meetup_id = ? AND status = ?", m.ID, talkAccepted).Order("id asc").Find(&talks)
+
+		writeJSON(w, http.StatusOK, meetupShowResponse{Meetup: meetupDetail{
+			meetupResponse: presentMeetups(tx, []meetup{m})[0],
+			Talks:          presentTalks(tx, talks),
+		}})
+	}
+}
+
+// meetupsUpdate changes the meetup, raising or removing its capacity lets
+// people in from the waitlist
+func meetupsUpdate(db *gorm.DB, mail mailer) http.HandlerFunc {
+	type meetupUpdateRequest struct {
+		Title       *string   `json:"title"`
+		Description *string   `json:"description"`
+		StartsAt    *string   `json:"starts_at"`
+		Location    *string   `json:"location"`
+		Capacity    *int      `json:"capacity"`
+		Recurrence  *string   `json:"recurrence"`
+		VenueID     *uint     `json:"venue_id"`
+		Tags        *[]string `json:"tags"`
+	}
+
+	type meetupUpdateResponse struct {
+		Meetup meetupResponse `json:"meetup"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		req := meetupUpdateRequest{}
+		errs, err := decodePartial(r, &req, meetupRules)
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if req.StartsAt != nil && *req.StartsAt != "" {
+			startsAt, messages := parseStartsAt(*req.StartsAt)
+			if len(messages) >= 1 {
+				errs["starts_at"] = append(errs["starts_at"], messages...)
+			}
+			updates["starts_at"] = startsAt
+		}
+		if req.Recurrence != nil {
+			recurrence, messages := normalizeRecurrence(*req.Recurrence)
+			if len(messages) >= 1 {
+				errs["recurrence"] = append(errs["recurrence"], messages...)
+			}
+			updates["recurrence"] = recurrence
+		}
+		// the meetup has to fit the venue whether it moves or its capacity
+		// changes
+		if req.VenueID != nil || req.Capacity != nil {
+			venueID, capacity := m.VenueID, m.Capacity
+			if req.VenueID != nil {
+				venueID = req.VenueID
+				if *venueID == 0 {
+					venueID = nil
+				}
+				updates["venue_id"] = venueID
+			}
+			if req.Capacity != nil {
+				capacity = *req.Capacity
+			}
+			capacity, venueErrs := fitVenue(tx, venueID, capacity)
+			for field, messages := range venueErrs {
+				errs[field] = append(errs[field], messages...)
+			}
+			updates["capacity"] = capacity
+		}
+		var tags []string
+		if req.Tags != nil {
+			var messages []string
+			if tags, messages = normalizeTags(*req.Tags); len(messages) >= 1 {
+				errs["tags"] = append(errs["tags"], messages...)
+			}
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		for column, value := range map[string]*string{"title": req.Title, "description": req.Description, "location": req.Location} {
+			if value != nil {
+				updates[column] = *value
+			}
+		}
+
+		promoted := []rsvp{}
+		err = transaction(tx, func(tx *gorm.DB) error {
+			if req.Tags != nil {
+				if err := setMeetupTags(tx, m.ID, tags); err != nil {
+					return err
+				}
+			}
+			if len(updates) == 0 {
+				return nil
+			}
+			if err := tx.Model(&m).Updates(updates).Error; err != nil {
+				return err
+			}
+			var err error
+			promoted, err = promoteWaitlist(tx, m)
+			return err
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		notifyPromoted(tx, mail, m, promoted)
+
+		writeJSON(w, http.StatusOK, meetupUpdateResponse{Meetup: presentMeetups(tx, []meetup{m})[0]})
+	}
+}
+
+func meetupsDestroy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !ownsMeetup(currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		err := transaction(tx, func(tx *gorm.DB) error {
+			return destroyMeetups(tx, []uint{m.ID})
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// destroyMeetups deletes the meetups with everything that hangs off them, it
+// has to run inside a transaction
+func destroyMeetups(tx *gorm.DB, ids []uint) error {
+	if err := meetupPolicy.run(tx, meetupReactions, ids, time.Now()); err != nil {
+		return err
+	}
+	return tx.Where("id IN (?)", ids).Delete(&meetup{}).Error
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func meetupsRouter(db *gorm.DB) *router {
+	return meetupsRouterWithMailer(db, &recordingMailer{})
+}
+
+func meetupsRouterWithMailer(db *gorm.DB, mail mailer) *router {
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/meetups", handler: meetupsIndex(db)},
+		{method: http.MethodPost, path: "/meetups", access: accessUser, handler: meetupsStore(db)},
+		{method: http.MethodGet, path: "/meetups/{id}", handler: meetupsShow(db)},
+		{method: http.MethodPatch, path: "/meetups/{id}", access: accessUser, handler: meetupsUpdate(db, mail)},
+		{method: http.MethodDelete, path: "/meetups/{id}", access: accessUser, handler: meetupsDestroy(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/cancel", access: accessUser, handler: meetupsCancel(db, mail)},
+		{method: http.MethodGet, path: "/meetups/{id}/organizers", handler: organizersIndex(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/organizers", access: accessUser, handler: organizersStore(db, mail)},
+		{method: http.MethodDelete, path: "/meetups/{id}/organizers/{user}", access: accessUser, handler: organizersDestroy(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/attendees", handler: attendeesIndex(db)},
+		{method: http.MethodGet, path: "/meetups/{id}.ics", handler: meetupsCalendar(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/occurrences", handler: occurrencesIndex(db)},
+		{method: http.MethodPatch, path: "/meetups/{id}/occurrences/{date}", access: accessUser, handler: occurrencesUpdate(db)},
+		{method: http.MethodDelete, path: "/meetups/{id}/occurrences/{date}", access: accessUser, handler: occurrencesDestroy(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/talks", access: accessOptional, handler: talksIndex(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/talks", access: accessUser, handler: talksStore(db)},
+		{method: http.MethodPatch, path: "/meetups/{id}/talks/{talk}", access: accessUser, handler: talksUpdate(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/checkin", access: accessUser, handler: checkinsStore(db)},
+		{method: http.MethodGet, path: "/meetups/{id}/attendance", access: accessUser, handler: attendanceShow(db)},
+		{method: http.MethodPost, path: "/meetups/{id}/rsvp", access: accessUser, handler: rsvpsStore(db)},
+		{method: http.MethodDelete, path: "/meetups/{id}/rsvp", access: accessUser, handler: rsvpsDestroy(db, mail)},
+	})
+	return rt
+}
+
+// createMeetup persists a meetup organized by the user for tests that need one
+func createMeetup(db *gorm.DB, organizer user, title string, startsAt time.Time) meetup {
+	m := meetup{Title: title, StartsAt: startsAt, OrganizerID: organizer.ID}
+	db.Create(&m)
+	return m
+}
+
+func TestUsersCanOrganizeMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req := httptest.NewRequest("POST", "/meetups", strings.NewReader(`{"title":"Norfolk Gophers","starts_at":"2019-10-15T18:30:00-04:00","location":"757 Makerspace"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected the status code to be %v, got %v instead", http.StatusCreated, rr.Code)
+	}
+	resp := struct {
+		Meetup meetupResponse `json:"meetup"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Meetup.Title != "Norfolk Gophers" || resp.Meetup.OrganizerID.Key != u.ID {
+		t.Errorf("expected the meetup to be organized by the user, got %+v instead", resp.Meetup)
+	}
+	if !resp.Meetup.StartsAt.Equal(time.Date(2019, 10, 15, 22, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the meetup to start at 22:30 UTC, got %v instead", resp.Meetup.StartsAt)
+	}
+}
+
+func TestMeetupsAreValidated(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{name: "missing title", body: `{"starts_at":"2019-10-15T18:30:00Z"}`, field: "title"},
+		{name: "missing start", body: `{"title":"Norfolk Gophers"}`, field: "starts_at"},
+		{name: "bad start", body: `{"title":"Norfolk Gophers","starts_at":"next tuesday"}`, field: "starts_at"},
+		{name: "negative capacity", body: `{"title":"Norfolk Gophers","starts_at":"2019-10-15T18:30:00Z","capacity":-1}`, field: "capacity"},
+		{name: "long title", body: fmt.Sprintf(`{"title":%q,"starts_at":"2019-10-15T18:30:00Z"}`, strings.Repeat("a", 101)), field: "title"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, rollback := testTx(t)
+			defer rollback()
+			u := createUser(db, "jason@mccallister.io", "somePassword1!")
+			req := httptest.NewRequest("POST", "/meetups", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", login(db, u))
+			rr := httptest.NewRecorder()
+
+			// Act
+			meetupsRouter(db).ServeHTTP(rr, req)
+
+			// Assert
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), `"`+tt.field+`"`) {
+				t.Errorf("expected an error for %v, got %v instead", tt.field, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGuestsCanBrowseMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	later := createMeetup(db, u, "November", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	sooner := createMeetup(db, u, "October", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	index := httptest.NewRecorder()
+	show := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(index, httptest.NewRequest("GET", "/meetups", nil))
+	meetupsRouter(db).ServeHTTP(show, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v", later.ID), nil))
+
+	// Assert
+	list := struct {
+		Meetups []meetupResponse `json:"meetups"`
+	}{}
+	json.Unmarshal(index.Body.Bytes(), &list)
+	if len(list.Meetups) != 2 || list.Meetups[0].ID != sooner.ID || list.Meetups[1].ID != later.ID {
+		t.Errorf("expected the meetups in the order they start, got %v instead", index.Body.String())
+	}
+	if show.Code != http.StatusOK || !strings.Contains(show.Body.String(), `"title":"November"`) {
+		t.Errorf("expected the meetup to be shown, got %v %v instead", show.Code, show.Body.String())
+	}
+}
+
+func TestShowingAMissingMeetupReturnsNotFound(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", "/meetups/42", nil))
+
+	// Assert
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestOnlyTheOrganizerCanChangeAMeetup(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	other := createUser(db, "other@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	path := fmt.Sprintf("/meetups/%v", m.ID)
+	forbidden := httptest.NewRequest("PATCH", path, strings.NewReader(`{"title":"Hijacked"}`))
+	forbidden.Header.Set("Authorization", login(db, other))
+	allowed := httptest.NewRequest("PATCH", path, strings.NewReader(`{"title":"Norfolk Gophers: TDD","starts_at":"2019-10-16T18:30:00Z"}`))
+	allowed.Header.Set("Authorization", login(db, organizer))
+	forbiddenRR := httptest.NewRecorder()
+	allowedRR := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(forbiddenRR, forbidden)
+	meetupsRouter(db).ServeHTTP(allowedRR, allowed)
+
+	// Assert
+	if forbiddenRR.Code != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, forbiddenRR.Code)
+	}
+	if allowedRR.Code != http.StatusOK || !strings.Contains(allowedRR.Body.String(), `"title":"Norfolk Gophers: TDD"`) {
+		t.Errorf("expected the updated meetup to be returned, got %v %v instead", allowedRR.Code, allowedRR.Body.String())
+	}
+	db.First(&m, m.ID)
+	if m.Title != "Norfolk Gophers: TDD" || m.StartsAt.Day() != 16 {
+		t.Errorf("expected the title and start to be updated, got %+v instead", m)
+	}
+}
+
+func TestMeetupUpdatesAreValidated(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/meetups/%v", m.ID), strings.NewReader(`{"title":"","starts_at":"soon","organizer_id":2}`))
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, rr.Code)
+	}
+	for _, field := range []string{"title", "starts_at", "organizer_id"} {
+		if !strings.Contains(rr.Body.String(), `"`+field+`"`) {
+			t.Errorf("expected an error for %v, got %v instead", field, rr.Body.String())
+		}
+	}
+}
+
+func TestOrganizersCanDeleteMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v", m.ID), nil)
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, rr.Code)
+	}
+	if !db.First(&meetup{}, m.ID).RecordNotFound() {
+		t.Errorf("expected the meetup to be deleted")
+	}
+}
+
+func TestUsersListTheMeetupsTheyOrganize(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	other := createUser(db, "other@example.com", "somePassword1!")
+	now := time.Now()
+	createMeetup(db, u, "Long ago", now.AddDate(-1, 0, 0))
+	createMeetup(db, u, "Last month", now.AddDate(0, -1, 0))
+	createMeetup(db, u, "Next month", now.AddDate(0, 1, 0))
+	createMeetup(db, other, "Someone else's", now.AddDate(0, 1, 0))
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/users/{id}/meetups", handler: userMeetupsIndex(db)},
+	})
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "", expected: []string{"Long ago", "Last month", "Next month"}},
+		{query: "?when=upcoming", expected: []string{"Next month"}},
+		{query: "?when=past", expected: []string{"Last month", "Long ago"}},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/users/%v/meetups%v", u.ID, tt.query), nil))
+
+		// Assert
+		resp := struct {
+			Meetups []meetupResponse `json:"meetups"`
+		}{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		titles := []string{}
+		for _, m := range resp.Meetups {
+			titles = append(titles, m.Title)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(tt.expected) {
+			t.Errorf("expected %q to list %v, got %v instead", tt.query, tt.expected, titles)
+		}
+	}
+}
+
+func TestMeetupsCanBeSearchedByDateAndDistance(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	norfolk := venue{Name: "757 Makerspace", Address: "Norfolk, VA", Latitude: 36.8508, Longitude: -76.2859}
+	richmond := venue{Name: "Richmond", Address: "Richmond, VA", Latitude: 37.5407, Longitude: -77.4360}
+	db.Create(&norfolk)
+	db.Create(&richmond)
+	for _, m := range []struct {
+		title    string
+		startsAt time.Time
+		venueID  *uint
+	}{
+		{title: "October in Norfolk", startsAt: time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC), venueID: &norfolk.ID},
+		{title: "November in Norfolk", startsAt: time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC), venueID: &norfolk.ID},
+		{title: "October in Richmond", startsAt: time.Date(2019, 10, 16, 18, 30, 0, 0, time.UTC), venueID: &richmond.ID},
+		{title: "October online", startsAt: time.Date(2019, 10, 17, 18, 30, 0, 0, time.UTC)},
+	} {
+		db.Create(&meetup{Title: m.title, StartsAt: m.startsAt, OrganizerID: u.ID, VenueID: m.venueID})
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "?from=2019-10-01&to=2019-11-01", expected: []string{"October in Norfolk", "October in Richmond", "October online"}},
+		{query: "?near=36.85,-76.29", expected: []string{"October in Norfolk", "November in Norfolk"}},
+		{query: "?near=36.85,-76.29&radius=200&to=2019-11-01", expected: []string{"October in Norfolk", "October in Richmond"}},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", "/meetups"+tt.query, nil))
+
+		// Assert
+		resp := struct {
+			Meetups []meetupResponse `json:"meetups"`
+		}{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		titles := []string{}
+		for _, m := range resp.Meetups {
+			titles = append(titles, m.Title)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(tt.expected) {
+			t.Errorf("expected %q to find %v, got %v instead", tt.query, tt.expected, rr.Body.String())
+		}
+	}
+}
+
+func TestMeetupSearchesNeedValidCoordinates(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+
+	for _, query := range []string{"?near=north", "?near=91,0", "?near=36.85,-76.29&radius=-1", "?radius=5"} {
+		rr := httptest.NewRecorder()
+
+		// Act
+		meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", "/meetups"+query, nil))
+
+		// Assert
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected %q to be rejected, got %v instead", query, rr.Code)
+		}
+	}
+}
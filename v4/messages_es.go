@@ -0,0 +1,61 @@
+package main
+
+// spanishMessages are the Spanish translations of the messages problems are
+// written with, see catalog for the {name} placeholders
+var spanishMessages = map[string]map[string]string{
+	"es": {
+		// titles
+		"Bad Request":              "Solicitud incorrecta",
+		"Unauthorized":             "No autorizado",
+		"Forbidden":                "Prohibido",
+		"Not Found":                "No encontrado",
+		"Method Not Allowed":       "Método no permitido",
+		"Conflict":                 "Conflicto",
+		"Gone":                     "Ya no disponible",
+		"Request Entity Too Large": "Cuerpo de la solicitud demasiado grande",
+		"Unsupported Media Type":   "Tipo de contenido no admitido",
+		"Unprocessable Entity":     "Entidad no procesable",
+		"Too Many Requests":        "Demasiadas solicitudes",
+		"Internal Server Error":    "Error interno del servidor",
+		"Service Unavailable":      "Servicio no disponible",
+		"Gateway Timeout":          "Tiempo de espera agotado",
+
+		// details
+		"not found":                           "no encontrado",
+		"forbidden":                           "prohibido",
+		"unauthorized":                        "no autorizado",
+		"internal server error":               "error interno del servidor",
+		"The request has invalid fields":      "La solicitud tiene campos no válidos",
+		"the request took too long":           "la solicitud tardó demasiado",
+		"the service is down for maintenance": "el servicio está en mantenimiento",
+		"service is under heavy load":         "el servicio está sobrecargado",
+		"too many comments, try again later":  "demasiados comentarios, inténtalo más tarde",
+
+		// govalidator
+		"The {field} field is required":                                   "El campo {field} es obligatorio",
+		"The {field} may only contain letters, numbers, and dashes":       "El campo {field} solo puede contener letras, números y guiones",
+		"The {field} field must be a valid email address":                 "El campo {field} debe ser una dirección de correo válida",
+		"The {field} field must be maximum {max} char":                    "El campo {field} debe tener como máximo {max} caracteres",
+		"The {field} field must be minimum {min} char":                    "El campo {field} debe tener como mínimo {min} caracteres",
+		"The {field} field must be minimum {min} in size":                 "El campo {field} debe tener como mínimo {min} elementos",
+		"The {field} field must be numeric":                               "El campo {field} debe ser numérico",
+		"The {field} field must be numeric value between {min} and {max}": "El campo {field} debe ser un número entre {min} y {max}",
+		"The {field} field value can not be less than {min}":              "El campo {field} no puede ser menor que {min}",
+		"The {field} field must be one of {values}":                       "El campo {field} debe ser uno de {values}",
+		"The {field} field format is invalid":                             "El formato del campo {field} no es válido",
+
+		// handlers
+		"The {field} field must be a positive number":               "El campo {field} debe ser un número positivo",
+		"The {field} field must be a number":                        "El campo {field} debe ser un número",
+		"The {field} field must be an RFC 3339 timestamp":           "El campo {field} debe ser una fecha RFC 3339",
+		"The {field} field must be a date or an RFC 3339 timestamp": "El campo {field} debe ser una fecha o una fecha RFC 3339",
+		"The {field} field is not supported":                        "El campo {field} no está admitido",
+		"The {field} field cannot be updated":                       "El campo {field} no se puede actualizar",
+		"The email has already been taken":                          "El correo ya está en uso",
+		"The username has already been taken":                       "El nombre de usuario ya está en uso",
+		"The password is incorrect":                                 "La contraseña es incorrecta",
+		"The current password is incorrect":                         "La contraseña actual es incorrecta",
+		"The token is invalid or has expired":                       "El token no es válido o ha caducado",
+		"The user was not found":                                    "No se encontró el usuario",
+	},
+}
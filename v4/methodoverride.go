@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// methodOverrideHeader lets clients behind proxies that only pass GET and
+// POST send the method they meant
+const methodOverrideHeader = "X-HTTP-Method-Override"
+
+// overridableMethods are the methods a POST can be turned into
+var overridableMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// methodOverride routes a POST as the method in its X-HTTP-Method-Override
+// header. Only POSTs can carry an override, so a GET that a cache or crawler
+// might repeat can never delete anything, and an override to a method that
+// isn't allowed gets a 400.
+func methodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		override := strings.ToUpper(strings.TrimSpace(r.Header.Get(methodOverrideHeader)))
+		if override == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !overridableMethods[override] {
+			writeError(w, http.StatusBadRequest, "X-HTTP-Method-Override must be PUT, PATCH or DELETE")
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Method = override
+		r2.Header = r.Header.Clone()
+		r2.Header.Del(methodOverrideHeader)
+		next.ServeHTTP(w, r2)
+	})
+}
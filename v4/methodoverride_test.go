@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostsCanOverrideTheirMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		override string
+		status   int
+		routed   string
+	}{
+		{"delete", "POST", "DELETE", http.StatusOK, "DELETE"},
+		{"lower case", "POST", "patch", http.StatusOK, "PATCH"},
+		{"no override", "POST", "", http.StatusOK, "POST"},
+		{"not a post", "GET", "DELETE", http.StatusOK, "GET"},
+		{"not allowed", "POST", "CONNECT", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			routed, leftover := "", ""
+			h := methodOverride(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				routed, leftover = r.Method, r.Header.Get(methodOverrideHeader)
+			}))
+			req := httptest.NewRequest(tt.method, "/meetups/1", nil)
+			if tt.override != "" {
+				req.Header.Set(methodOverrideHeader, tt.override)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			h.ServeHTTP(rr, req)
+
+			// Assert
+			if rr.Code != tt.status {
+				t.Errorf("expected the status code to be %v, got %v instead", tt.status, rr.Code)
+			}
+			if routed != tt.routed {
+				t.Errorf("expected the request to be routed as %q, got %q instead", tt.routed, routed)
+			}
+			if routed != tt.method && leftover != "" {
+				t.Errorf("expected the override header to be consumed, got %v instead", leftover)
+			}
+		})
+	}
+}
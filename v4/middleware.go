@@ -0,0 +1,18 @@
+package main
+
+import "net/http"
+
+// middleware wraps a handler with behavior shared by many routes, such as
+// authentication or logging
+type middleware func(http.Handler) http.Handler
+
+// chain layers the middleware in the order given, the first one sees the
+// request first
+func chain(mw ...middleware) middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChainRunsMiddlewareInOrder(t *testing.T) {
+	// Arrange
+	order := []string{}
+	tag := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := chain(tag("first"), tag("second"), chain(tag("third")))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+
+	// Act
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// Assert
+	expected := "[first second third handler]"
+	if got := fmt.Sprint(order); got != expected {
+		t.Errorf("expected the middleware to run as %v, got %v instead", expected, got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// schemaVersion has to be bumped whenever a model changes, instances compare
+// it with the last version applied to tell whether migrations are pending
+const schemaVersion = 24
+
+// schemaMigration records a schema version once it has been applied
+type schemaMigration struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	AppliedAt time.Time
+}
+
+// migrationLock is held by the instance running migrations so instances
+// sharing a database don't migrate it at the same time, it expires in case
+// the holder dies halfway
+type migrationLock struct {
+	Name      string `gorm:"primary_key"`
+	Holder    string
+	ExpiresAt time.Time
+}
+
+const migrationLockName = "schema"
+
+// pendingMigrations reports whether the schema is older than this build
+func pendingMigrations(db *gorm.DB) bool {
+	if !db.HasTable(&schemaMigration{}) {
+		return true
+	}
+
+	latest := schemaMigration{}
+	db.Order("version desc").First(&latest)
+	return latest.Version < schemaVersion
+}
+
+// acquireMigrationLock takes the lock when it is free or has expired
+func acquireMigrationLock(db *gorm.DB, holder string, ttl time.Duration, now time.Time) bool {
+	db.AutoMigrate(&migrationLock{})
+
+	taken := db.Model(&migrationLock{}).
+		Where("name = ? AND expires_at < ?", migrationLockName, now).
+		Updates(map[string]interface{}{"holder": holder, "expires_at": now.Add(ttl)})
+	if taken.RowsAffected == 1 {
+		return true
+	}
+
+	return db.Create(&migrationLock{Name: migrationLockName, Holder: holder, ExpiresAt: now.Add(ttl)}).Error == nil
+}
+
+func releaseMigrationLock(db *gorm.DB, holder string) {
+	db.Where("name = ? AND holder = ?", migrationLockName, holder).Delete(&migrationLock{})
+}
+
+// runMigrations brings the schema up to date, when another instance holds
+// the lock it waits for it and then finds nothing left to do
+func runMigrations(db *gorm.DB, holder string) error {
+	for !acquireMigrationLock(db, holder, time.Minute, time.Now()) {
+		time.Sleep(time.Second)
+	}
+	defer releaseMigrationLock(db, holder)
+
+	if !pendingMigrations(db) {
+		return nil
+	}
+
+	migrate(db)
+	db.AutoMigrate(&schemaMigration{})
+	return db.Create(&schemaMigration{Version: schemaVersion, AppliedAt: time.Now()}).Error
+}
+
+// migrationHolder identifies this instance in the migration lock
+func migrationHolder() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%v:%v", host, os.Getpid())
+}
+
+// migrationGate keeps traffic away from the handlers while migrations run in
+// the background, /healthz keeps answering so the instance isn't restarted
+type migrationGate struct {
+	migrating int32
+}
+
+func (g *migrationGate) start() {
+	atomic.StoreInt32(&g.migrating, 1)
+}
+
+func (g *migrationGate) finish() {
+	atomic.StoreInt32(&g.migrating, 0)
+}
+
+func (g *migrationGate) isMigrating() bool {
+	return atomic.LoadInt32(&g.migrating) == 1
+}
+
+func (g *migrationGate) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.isMigrating() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", "5")
+		if r.URL.Path == "/healthz" {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "migrating"})
+			return
+		}
+		writeError(w, http.StatusServiceUnavailable, "service is migrating")
+	})
+}
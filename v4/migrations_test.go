@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMigrationsAreOnlyRunWhilePending(t *testing.T) {
+	// Arrange
+	db := getDB()
+	pendingBefore := pendingMigrations(db)
+
+	// Act
+	err := runMigrations(db, "test")
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pendingBefore {
+		t.Errorf("expected migrations to be pending on an empty database")
+	}
+	if pendingMigrations(db) {
+		t.Errorf("expected no migrations to be pending after running them")
+	}
+	if !db.HasTable(&user{}) {
+		t.Errorf("expected the users table to be created")
+	}
+}
+
+func TestTheMigrationLockIsHeldByOneInstance(t *testing.T) {
+	// Arrange
+	db := getDB()
+	now := time.Now()
+	acquireMigrationLock(db, "first", time.Minute, now)
+
+	// Act
+	whileHeld := acquireMigrationLock(db, "second", time.Minute, now)
+	afterExpiry := acquireMigrationLock(db, "second", time.Minute, now.Add(2*time.Minute))
+
+	// Assert
+	if whileHeld {
+		t.Errorf("expected the lock to be refused while it is held")
+	}
+	if !afterExpiry {
+		t.Errorf("expected an expired lock to be taken over")
+	}
+}
+
+func TestOnlyHealthzAnswersWhileMigrating(t *testing.T) {
+	// Arrange
+	gate := &migrationGate{}
+	gate.start()
+	handler := gate.middleware(okHandler())
+
+	for _, path := range []string{"/healthz", "/users"} {
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		// Assert
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", path, http.StatusServiceUnavailable, status)
+		}
+	}
+	healthz := httptest.NewRecorder()
+	handler.ServeHTTP(healthz, httptest.NewRequest("GET", "/healthz", nil))
+	if body := healthz.Body.String(); body != `{"status":"migrating"}` {
+		t.Errorf("expected the migrating status, got %v instead", body)
+	}
+
+	gate.finish()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected traffic to flow after migrating, got %v instead", status)
+	}
+}
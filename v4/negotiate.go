@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// encoder turns the JSON the handlers write into another representation,
+// json.Decoder gives it the body token by token
+type encoder struct {
+	mediaTypes []string
+	encode     func(dec *json.Decoder) ([]byte, error)
+}
+
+// encoders are the representations clients can ask for with Accept, in the
+// order ties are broken. JSON comes first so it wins when the client doesn't
+// mind.
+var encoders = []encoder{
+	{mediaTypes: []string{"application/json"}},
+	{mediaTypes: []string{"application/xml", "text/xml"}, encode: encodeXML},
+	{mediaTypes: []string{"application/msgpack", "application/x-msgpack"}, encode: encodeMsgpack},
+}
+
+// negotiate serves the JSON responses of the handlers in the representation
+// the Accept header prefers. Anything that isn't JSON, like calendars and
+// images, is passed through as it is written, and everything is when the
+// client asks for nothing it knows. JSON is indented for clients that ask
+// for it with ?pretty=1 or an indent parameter in Accept.
+func negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		enc, contentType := preferredEncoder(r.Header.Get("Accept"))
+		indent := requestedIndent(r)
+		if enc.encode == nil && indent == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if enc.encode == nil {
+			enc = encoder{encode: indentJSON(indent)}
+		}
+
+		jw := &jsonWriter{ResponseWriter: w}
+		next.ServeHTTP(jw, r)
+		jw.finish(func(status int, body []byte) []byte {
+			if len(body) == 0 {
+				return body
+			}
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			encoded, err := enc.encode(dec)
+			if err != nil {
+				logError(r, err)
+				return body
+			}
+			// problems keep saying they are problems in XML
+			isProblem := strings.HasPrefix(w.Header().Get("Content-Type"), problemContentType)
+			if isProblem && strings.HasSuffix(contentType, "/xml") {
+				contentType = "application/problem+xml"
+			} else if isProblem && contentType == "application/json" {
+				contentType = problemContentType
+			}
+			w.Header().Set("Content-Type", contentType)
+			return encoded
+		})
+	})
+}
+
+// preferredEncoder picks the encoder with the highest quality in the Accept
+// header and the media type it answers to, JSON when nothing matches
+func preferredEncoder(accept string) (encoder, string) {
+	best, bestType, bestQ := encoders[0], encoders[0].mediaTypes[0], 0.0
+	if accept == "" {
+		return best, bestType
+	}
+
+	for _, enc := range encoders {
+		for _, mediaType := range enc.mediaTypes {
+			if q := acceptQuality(accept, mediaType); q > bestQ {
+				best, bestType, bestQ = enc, mediaType, q
+			}
+		}
+	}
+	return best, bestType
+}
+
+// acceptQuality is the quality the Accept header gives the media type, the
+// most specific range that matches decides it
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		s := -1
+		switch {
+		case rng == mediaType:
+			s = 2
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			s = 1
+		case rng == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q
+}
+
+// maxIndent caps the indent clients can ask for
+const maxIndent = 8
+
+// requestedIndent is the indent for JSON the client asked for, ?pretty=1
+// indents by two spaces and Accept: application/json; indent=4 by four
+func requestedIndent(r *http.Request) string {
+	if pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty")); err == nil && pretty {
+		return "  "
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		if strings.ToLower(strings.TrimSpace(fields[0])) != "application/json" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "indent=") {
+				continue
+			}
+			if n, err := strconv.Atoi(param[len("indent="):]); err == nil && n > 0 {
+				if n > maxIndent {
+					n = maxIndent
+				}
+				return strings.Repeat(" ", n)
+			}
+		}
+	}
+	return ""
+}
+
+// indentJSON re-encodes the body as JSON indented by indent
+func indentJSON(indent string) func(dec *json.Decoder) ([]byte, error) {
+	return func(dec *json.Decoder) ([]byte, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		buf := bytes.Buffer{}
+		if err := json.Indent(&buf, raw, "", indent); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+}
+
+// encodeXML writes the body as XML under a <response> element, objects
+// become elements named after their keys and arrays repeat <item>
+func encodeXML(dec *json.Decoder) ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := writeXMLValue(dec, enc, xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXMLValue(dec *json.Decoder, enc *xml.Encoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for dec.More() {
+			child := xml.StartElement{Name: xml.Name{Local: "item"}}
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = xmlElement(key.(string))
+			}
+			if err := writeXMLValue(dec, enc, child); err != nil {
+				return err
+			}
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		return enc.EncodeElement("", start)
+	default:
+		return enc.EncodeElement(fmt.Sprint(t), start)
+	}
+}
+
+// xmlElement names the element after the key, keys that can't be element
+// names go in a key attribute of an <entry> instead
+func xmlElement(key string) xml.StartElement {
+	valid := key != "" && !strings.HasPrefix(strings.ToLower(key), "xml")
+	for i, c := range key {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || !(c == '-' || c == '.' || (c >= '0' && c <= '9'))) {
+			valid = false
+		}
+	}
+	if valid {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+	}
+}
+
+// encodeMsgpack writes the body as MessagePack, object keys are sorted as
+// the body is decoded into maps first
+func encodeMsgpack(dec *json.Decoder) ([]byte, error) {
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	if err := writeMsgpack(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported value %T", v)
+	}
+	return nil
+}
+
+// writeMsgpackHeader writes the type and length of a string, array or map in
+// its smallest form, small8 is 0 for the types without an 8 bit length
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, small8, small16, small32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case small8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(small8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(small16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(small32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		buf.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buf.WriteByte(byte(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func jsonHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(body))
+	})
+}
+
+func TestResponsesCanBeServedAsXML(t *testing.T) {
+	// Arrange
+	h := negotiate(jsonHandler(`{"meetup":{"title":"Go & Tell","tags":["go","tdd"],"venue":null,"capacity":30},"2019-10-15":true}`))
+	req := httptest.NewRequest("GET", "/meetups", nil)
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if got := rr.Header().Get("Content-Type"); got != "application/xml" {
+		t.Errorf("expected the response to be XML, got %v instead", got)
+	}
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected the status to be kept, got %v instead", rr.Code)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><meetup><title>Go &amp; Tell</title><tags><item>go</item><item>tdd</item></tags><venue></venue><capacity>30</capacity></meetup><entry key="2019-10-15">true</entry></response>`
+	if got := rr.Body.String(); got != expected {
+		t.Errorf("expected the body to be %v, got %v instead", expected, got)
+	}
+}
+
+func TestResponsesCanBeServedAsMessagePack(t *testing.T) {
+	// Arrange
+	h := negotiate(jsonHandler(`{"id":1,"name":"Jason","admin":false,"score":-1.5,"tags":[]}`))
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if got := rr.Header().Get("Content-Type"); got != "application/msgpack" {
+		t.Errorf("expected the response to be MessagePack, got %v instead", got)
+	}
+	expected := []byte{
+		0x85,
+		0xa5, 'a', 'd', 'm', 'i', 'n', 0xc2,
+		0xa2, 'i', 'd', 0x01,
+		0xa4, 'n', 'a', 'm', 'e', 0xa5, 'J', 'a', 's', 'o', 'n',
+		0xa5, 's', 'c', 'o', 'r', 'e', 0xcb, 0xbf, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa4, 't', 'a', 'g', 's', 0x90,
+	}
+	if got := rr.Body.Bytes(); !bytes.Equal(got, expected) {
+		t.Errorf("expected the body to be % x, got % x instead", expected, got)
+	}
+}
+
+func TestNegotiationLeavesOtherResponsesAlone(t *testing.T) {
+	tests := []struct {
+		name    string
+		accept  string
+		handler http.Handler
+		body    string
+	}{
+		{"no preference", "", jsonHandler(`{"ok":true}`), `{"ok":true}`},
+		{"json preferred", "application/xml;q=0.5, application/json", jsonHandler(`{"ok":true}`), `{"ok":true}`},
+		{"unknown type", "text/html", jsonHandler(`{"ok":true}`), `{"ok":true}`},
+		{"not json", "application/xml", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/calendar")
+			w.Write([]byte("BEGIN:VCALENDAR"))
+		}), "BEGIN:VCALENDAR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+
+			// Act
+			negotiate(tt.handler).ServeHTTP(rr, req)
+
+			// Assert
+			if got := rr.Body.String(); got != tt.body {
+				t.Errorf("expected the body to be %v, got %v instead", tt.body, got)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("expected Vary: Accept, got %v instead", got)
+			}
+		})
+	}
+}
+
+func TestNegotiationStreamsResponsesThatArentJSON(t *testing.T) {
+	// Arrange
+	req := httptest.NewRequest("GET", "/meetups/calendar.ics?pretty=1", nil)
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+	streamed := ""
+	handler := negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte("BEGIN:VCALENDAR"))
+		w.(http.Flusher).Flush()
+		streamed = rr.Body.String()
+	}))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if streamed != "BEGIN:VCALENDAR" {
+		t.Errorf("expected the calendar to be sent as it was written, got %q before the handler finished instead", streamed)
+	}
+}
+
+func TestJSONCanBePrettyPrinted(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		accept   string
+		expected string
+	}{
+		{"compact by default", "/meetups", "", `{"meetups":[{"id":1}]}`},
+		{"pretty flag", "/meetups?pretty=1", "", "{\n  \"meetups\": [\n    {\n      \"id\": 1\n    }\n  ]\n}\n"},
+		{"indent parameter", "/meetups", "application/json; indent=4", "{\n    \"meetups\": [\n        {\n            \"id\": 1\n        }\n    ]\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+
+			// Act
+			negotiate(jsonHandler(`{"meetups":[{"id":1}]}`)).ServeHTTP(rr, req)
+
+			// Assert
+			if got := rr.Body.String(); got != tt.expected {
+				t.Errorf("expected the body to be %q, got %q instead", tt.expected, got)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("expected the response to stay JSON, got %v instead", got)
+			}
+		})
+	}
+}
+
+func TestListingsAcceptThePrettyFlag(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rr := httptest.NewRecorder()
+
+	// Act
+	negotiate(meetupsRouter(db)).ServeHTTP(rr, httptest.NewRequest("GET", "/meetups?pretty=1", nil))
+
+	// Assert
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected pretty not to be rejected as a filter, got %v instead: %v", rr.Code, rr.Body.String())
+	}
+}
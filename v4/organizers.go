@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// meetupOrganizer makes a user a co-organizer of a meetup, who can change,
+// cancel and check people in to it as its organizer can
+type meetupOrganizer struct {
+	MeetupID  uint `gorm:"primary_key;auto_increment:false"`
+	UserID    uint `gorm:"primary_key;auto_increment:false;index"`
+	CreatedAt time.Time
+}
+
+// organizerStoreRules are the rules for adding a co-organizer
+var organizerStoreRules = govalidator.MapData{
+	"user_id": []string{"required"},
+}
+
+// organizersIndexResponse lists the organizer of a meetup first and then its
+// co-organizers in the order they were added
+type organizersIndexResponse struct {
+	Organizer    publicUser   `json:"organizer"`
+	CoOrganizers []publicUser `json:"co_organizers"`
+}
+
+// meetupOrganizers loads the organizer and co-organizers of the meetup
+func meetupOrganizers(db *gorm.DB, m meetup) organizersIndexResponse {
+	owner := user{}
+	if db.Unscoped().First(&owner, m.OrganizerID).RecordNotFound() {
+		owner = user{ID: m.OrganizerID}
+	}
+	users := []user{}
+	db.Joins("JOIN meetup_organizers ON meetup_organizers.user_id = users.id").
+		Where("meetup_organizers.meetup_id = ?", m.ID).
+		Order("meetup_organizers.created_at asc, users.id asc").
+		Find(&users)
+
+	resp := organizersIndexResponse{Organizer: newPublicUser(owner), CoOrganizers: make([]publicUser, len(users))}
+	for i, u := range users {
+		resp.CoOrganizers[i] = newPublicUser(u)
+	}
+	return resp
+}
+
+// organizersIndex lists who organizes a meetup
+func organizersIndex(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, meetupOrganizers(tx, m))
+	}
+}
+
+// organizersStore makes a user a co-organizer of the meetup and emails them
+// about it through the outbox, only the meetup's organizer can. Adding
+// someone twice changes nothing.
+func organizersStore(db *gorm.DB, mail mailer) http.HandlerFunc {
+	type organizerStoreRequest struct {
+		UserID apiID `json:"user_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !ownsMeetup(currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		// the validator can't see inside an apiID, so user_id is checked here
+		req := organizerStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		if req.UserID == (apiID{}) {
+			writeValidationErrors(w, map[string][]string{"user_id": {"The user_id field is required"}})
+			return
+		}
+		u := user{}
+		if req.UserID.where(tx).First(&u).RecordNotFound() {
+			writeValidationErrors(w, map[string][]string{"user_id": {"The user was not found"}})
+			return
+		}
+		if u.ID == m.OrganizerID {
+			writeValidationErrors(w, map[string][]string{"user_id": {"The user already organizes the meetup"}})
+			return
+		}
+
+		o := meetupOrganizer{}
+		if tx.Where("meetup_id = ? AND user_id = ?", m.ID, u.ID).First(&o).RecordNotFound() {
+			o = meetupOrganizer{MeetupID: m.ID, UserID: u.ID}
+			err := tx.Create(&o).Error
+			// losing a race to add the same person means they were added
+			// already, the outbox tells them about it only once
+			if err != nil && isUniqueViolation(err) {
+				err = tx.Where("meetup_id = ? AND user_id = ?", m.ID, u.ID).First(&o).Error
+			}
+			if err != nil {
+				logError(r, err)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
+
+		// the key includes when they were added so someone removed and added
+		// again is told again, adding them twice retries a mail that failed
+		deliver(tx, mail, outboxMessage{
+			Key:     fmt.Sprintf("co-organizer:%v:%v:%v", m.ID, u.ID, o.CreatedAt.Unix()),
+			UserID:  u.ID,
+			To:      u.Email,
+			Subject: "You're co-organizing " + m.Title,
+			Body:    fmt.Sprintf("You're now a co-organizer of %v on %v.", m.Title, m.StartsAt.Format(time.RFC1123)),
+		})
+
+		writeJSON(w, http.StatusCreated, meetupOrganizers(tx, m))
+	}
+}
+
+// organizersDestroy removes a co-organizer from the meetup, the meetup's
+// organizer can remove anyone and co-organizers themselves
+func organizersDestroy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		u := user{}
+		if parseAPIID(param(r, "user")).where(tx).First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		viewer := currentUser(r)
+		if viewer.ID != u.ID && !ownsMeetup(viewer, m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		if err := tx.Where("meetup_id = ? AND user_id = ?", m.ID, u.ID).Delete(&meetupOrganizer{}).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
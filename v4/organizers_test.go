@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrganizersCanAddCoOrganizers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	co := createUser(db, "co@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	mail := &recordingMailer{}
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/organizers", m.ID), strings.NewReader(fmt.Sprintf(`{"user_id":%v}`, co.ID)))
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouterWithMailer(db, mail).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected the status code to be %v, got %v %v instead", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	resp := organizersIndexResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.CoOrganizers) != 1 || resp.CoOrganizers[0].ID.Key != co.ID {
+		t.Errorf("expected the user to co-organize the meetup, got %v instead", rr.Body.String())
+	}
+	if mail.to != "co@example.com" {
+		t.Errorf("expected the co-organizer to be emailed, got %q instead", mail.to)
+	}
+}
+
+func TestCoOrganizersAreEmailedOnceThroughTheOutbox(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	co := createUser(db, "co@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	mail := &recordingMailer{}
+	token := login(db, organizer)
+
+	// Act
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/organizers", m.ID), strings.NewReader(fmt.Sprintf(`{"user_id":%v}`, co.ID)))
+		req.Header.Set("Authorization", token)
+		meetupsRouterWithMailer(db, mail).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	if mail.sent != 1 {
+		t.Errorf("expected the co-organizer to be emailed once, got %v emails instead", mail.sent)
+	}
+	sent := 0
+	db.Model(&outboxMessage{}).Where("user_id = ? AND sent_at IS NOT NULL", co.ID).Count(&sent)
+	if sent != 1 {
+		t.Errorf("expected the email to be recorded in the outbox, got %v instead", sent)
+	}
+}
+
+func TestCoOrganizersCanChangeButNotDeleteMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	co := createUser(db, "co@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&meetupOrganizer{MeetupID: m.ID, UserID: co.ID})
+	auth := login(db, co)
+	update := httptest.NewRequest("PATCH", fmt.Sprintf("/meetups/%v", m.ID), strings.NewReader(`{"title":"Norfolk Gophers: October"}`))
+	update.Header.Set("Authorization", auth)
+	updated := httptest.NewRecorder()
+	destroy := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v", m.ID), nil)
+	destroy.Header.Set("Authorization", auth)
+	destroyed := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(updated, update)
+	meetupsRouter(db).ServeHTTP(destroyed, destroy)
+
+	// Assert
+	if updated.Code != http.StatusOK {
+		t.Errorf("expected the update status code to be %v, got %v instead", http.StatusOK, updated.Code)
+	}
+	if destroyed.Code != http.StatusForbidden {
+		t.Errorf("expected the delete status code to be %v, got %v instead", http.StatusForbidden, destroyed.Code)
+	}
+}
+
+func TestOnlyTheOrganizerCanAddCoOrganizers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	co := createUser(db, "co@example.com", "somePassword1!")
+	other := createUser(db, "other@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&meetupOrganizer{MeetupID: m.ID, UserID: co.ID})
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/organizers", m.ID), strings.NewReader(fmt.Sprintf(`{"user_id":%v}`, other.ID)))
+	req.Header.Set("Authorization", login(db, co))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestCoOrganizersCanStepDown(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	co := createUser(db, "co@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&meetupOrganizer{MeetupID: m.ID, UserID: co.ID})
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v/organizers/%v", m.ID, co.ID), nil)
+	req.Header.Set("Authorization", login(db, co))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, rr.Code)
+	}
+	if canOrganize(db, &co, m) {
+		t.Errorf("expected the user to no longer organize the meetup")
+	}
+}
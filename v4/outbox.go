@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// outboxMessage records an email a job sends, the key names what it is about
+// so the same email is never sent twice, even by two instances at once
+type outboxMessage struct {
+	ID        uint   `gorm:"primary_key"`
+	Key       string `gorm:"type:varchar(100);unique_index"`
+	UserID    uint   `gorm:"index"`
+	To        string `gorm:"type:varchar(100)"`
+	Subject   string `gorm:"type:varchar(255)"`
+	Body      string `gorm:"type:text"`
+	SentAt    *time.Time
+	Error     string `gorm:"type:varchar(255)"`
+	CreatedAt time.Time
+}
+
+// deliver claims the message's key and sends it, it reports whether the
+// message was sent now. A message that was sent or is being sent is skipped.
+// A failed one stays in the outbox with its error until it is delivered
+// again, which claims it back by clearing the error.
+func deliver(db *gorm.DB, mail mailer, msg outboxMessage) bool {
+	if err := db.Create(&msg).Error; err != nil {
+		if !isUniqueViolation(err) {
+			logDBError(db, err)
+			return false
+		}
+		claim := db.Model(&outboxMessage{}).Where(&outboxMessage{Key: msg.Key}).Where("sent_at IS NULL AND error <> ''").UpdateColumn("error", "")
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			return false
+		}
+		if err := db.Where(&outboxMessage{Key: msg.Key}).First(&msg).Error; err != nil {
+			logDBError(db, err)
+			return false
+		}
+	}
+
+	if err := mail.send(msg.To, msg.Subject, msg.Body); err != nil {
+		logDBError(db, err)
+		db.Model(&msg).UpdateColumn("error", err.Error())
+		return false
+	}
+	db.Model(&msg).UpdateColumn("sent_at", time.Now())
+	return true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingMailer fails to send every email
+type failingMailer struct{}
+
+func (failingMailer) send(to, subject, body string) error {
+	return errors.New("smtp: connection refused")
+}
+
+func TestFailedMessagesAreRetried(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	msg := outboxMessage{Key: "reminder:1:1", To: "jason@mccallister.io", Subject: "Reminder", Body: "Tomorrow"}
+	mail := &recordingMailer{}
+	failed := deliver(db, failingMailer{}, msg)
+
+	// Act
+	sent := deliver(db, mail, msg)
+	again := deliver(db, mail, msg)
+
+	// Assert
+	if failed || !sent || again {
+		t.Errorf("expected only the retry to send the message, got %v %v %v instead", failed, sent, again)
+	}
+	if mail.sent != 1 {
+		t.Errorf("expected the message to be sent once, got %v instead", mail.sent)
+	}
+	stored := outboxMessage{}
+	db.Where(&outboxMessage{Key: msg.Key}).First(&stored)
+	if stored.SentAt == nil || stored.Error != "" {
+		t.Errorf("expected the message to be marked sent, got %+v instead", stored)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// preference is one setting a user saved, the value is stored as JSON
+type preference struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"unique_index:idx_preferences_user_key"`
+	Key       string `gorm:"type:varchar(50);unique_index:idx_preferences_user_key"`
+	Value     string `gorm:"type:varchar(255)"`
+	UpdatedAt time.Time
+}
+
+// preferenceDef is a setting users may save, with the value used until they
+// do and the check a new value has to pass
+type preferenceDef struct {
+	def      interface{}
+	validate func(v interface{}) error
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// knownPreferences are the only settings that can be saved
+var knownPreferences = map[string]preferenceDef{
+	"timezone": {def: "UTC", validate: func(v interface{}) error {
+		s, ok := v.(string)
+		if !ok || s == "" || s == "Local" || strings.HasPrefix(s, "/") {
+			return fmt.Errorf("must be a timezone such as Europe/Paris")
+		}
+		if _, err := time.LoadLocation(s); err != nil {
+			return fmt.Errorf("must be a timezone such as Europe/Paris")
+		}
+		return nil
+	}},
+	"locale": {def: "en", validate: func(v interface{}) error {
+		if s, ok := v.(string); !ok || !localePattern.MatchString(s) {
+			return fmt.Errorf("must be a locale such as en or en-US")
+		}
+		return nil
+	}},
+	"email_notifications": {def: true, validate: isBool},
+	"email_digest":        {def: false, validate: isBool},
+}
+
+func isBool(v interface{}) error {
+	if _, ok := v.(bool); !ok {
+		return fmt.Errorf("must be true or false")
+	}
+	return nil
+}
+
+// preferencesFor returns every known preference of the user, saved values
+// replace the defaults
+func preferencesFor(db *gorm.DB, u *user) map[string]interface{} {
+	prefs := map[string]interface{}{}
+	for key, p := range knownPreferences {
+		prefs[key] = p.def
+	}
+
+	saved := []preference{}
+	db.Where("user_id = ?", u.ID).Find(&saved)
+	for _, p := range saved {
+		var v interface{}
+		if _, known := knownPreferences[p.Key]; known && json.Unmarshal([]byte(p.Value), &v) == nil {
+			prefs[p.Key] = v
+		}
+	}
+	return prefs
+}
+
+type preferencesResponse struct {
+	Preferences map[string]interface{} `json:"preferences"`
+}
+
+func preferencesShow(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, preferencesResponse{Preferences: preferencesFor(dbFor(r, db), currentUser(r))})
+	}
+}
+
+// preferencesUpdate saves the preferences in the body, the ones left out
+// keep their value
+func preferencesUpdate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]interface{}{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+
+		errs := map[string][]string{}
+		keys := make([]string, 0, len(req))
+		for key, v := range req {
+			p, ok := knownPreferences[key]
+			if !ok {
+				errs[key] = append(errs[key], fmt.Sprintf("The %v preference is not supported", key))
+				continue
+			}
+			if err := p.validate(v); err != nil {
+				errs[key] = append(errs[key], fmt.Sprintf("The %v preference %v", key, err))
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort.Strings(keys)
+
+		u := currentUser(r)
+		tx := dbFor(r, db)
+		err := transaction(tx, func(tx *gorm.DB) error {
+			for _, key := range keys {
+				value, _ := json.Marshal(req[key])
+				p := preference{}
+				err := tx.Where(preference{UserID: u.ID, Key: key}).Assign(preference{Value: string(value)}).FirstOrCreate(&p).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, preferencesResponse{Preferences: preferencesFor(tx, u)})
+	}
+}
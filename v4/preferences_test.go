@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreferencesDefaultUntilSaved(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "prefs@example.com", "somePassword1!")
+	handler := requireAuth(db, preferencesUpdate(db))
+	req := httptest.NewRequest("PUT", "/me/preferences", strings.NewReader(`{"timezone":"Europe/Paris","email_notifications":false}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("expected the status code to be %v, got %v instead: %v", http.StatusOK, status, rr.Body.String())
+	}
+	resp := preferencesResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	expected := map[string]interface{}{"timezone": "Europe/Paris", "locale": "en", "email_notifications": false, "email_digest": false}
+	for key, value := range expected {
+		if resp.Preferences[key] != value {
+			t.Errorf("expected %v to be %v, got %v instead", key, value, resp.Preferences[key])
+		}
+	}
+	count := 0
+	db.Model(&preference{}).Where("user_id = ?", u.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("expected only the saved preferences to be stored, got %v instead", count)
+	}
+}
+
+func TestSavingAPreferenceAgainReplacesIt(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "prefs@example.com", "somePassword1!")
+	handler := requireAuth(db, preferencesUpdate(db))
+	bearer := login(db, u)
+
+	for _, locale := range []string{"fr", "de-DE"} {
+		req := httptest.NewRequest("PUT", "/me/preferences", strings.NewReader(`{"locale":"`+locale+`"}`))
+		req.Header.Set("Authorization", bearer)
+
+		// Act
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	if locale := preferencesFor(db, &u)["locale"]; locale != "de-DE" {
+		t.Errorf("expected the latest locale to be kept, got %v instead", locale)
+	}
+}
+
+func TestInvalidPreferencesAreRejected(t *testing.T) {
+	tests := []string{
+		`{"theme":"dark"}`,
+		`{"timezone":"Mars/Olympus_Mons"}`,
+		`{"locale":"english"}`,
+		`{"email_notifications":"yes"}`,
+	}
+
+	for _, body := range tests {
+		// Arrange
+		db, rollback := testTx(t)
+		u := createUser(db, "prefs@example.com", "somePassword1!")
+		handler := requireAuth(db, preferencesUpdate(db))
+		req := httptest.NewRequest("PUT", "/me/preferences", strings.NewReader(body))
+		req.Header.Set("Authorization", login(db, u))
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != http.StatusUnprocessableEntity {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", body, http.StatusUnprocessableEntity, status)
+		}
+		rollback()
+	}
+}
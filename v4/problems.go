@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// problemInstances fills in the instance of the problems the handlers send
+// with the path that was requested, the handlers only know their response
+// writer
+func problemInstances(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &problemWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		pw.finish(func(p *problem) {
+			if p.Instance == "" {
+				p.Instance = r.URL.RequestURI()
+			}
+		})
+	})
+}
+
+// problemWriter holds a problem back so it can be changed before it is
+// sent, every other response goes straight through
+type problemWriter struct {
+	http.ResponseWriter
+	status  int
+	problem *bytes.Buffer
+}
+
+func (pw *problemWriter) WriteHeader(status int) {
+	if strings.HasPrefix(pw.Header().Get("Content-Type"), problemContentType) {
+		pw.status = status
+		pw.problem = &bytes.Buffer{}
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *problemWriter) Write(b []byte) (int, error) {
+	if pw.problem != nil {
+		return pw.problem.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *problemWriter) Flush() {
+	if pw.problem != nil {
+		return
+	}
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the problem held back once edit has changed it
+func (pw *problemWriter) finish(edit func(p *problem)) {
+	if pw.problem == nil {
+		return
+	}
+
+	body := pw.problem.Bytes()
+	p := problem{}
+	if json.Unmarshal(body, &p) == nil {
+		edit(&p)
+		if data, err := json.Marshal(p); err == nil {
+			body = data
+		}
+	}
+	pw.Header().Del("Content-Length")
+	pw.ResponseWriter.WriteHeader(pw.status)
+	pw.ResponseWriter.Write(body)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorsAreProblemDetails(t *testing.T) {
+	// Arrange
+	h := problemInstances(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeValidationErrors(w, map[string][]string{"title": {"The title field is required"}})
+	}))
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/meetups?draft=true", nil))
+
+	// Assert
+	if got := rr.Header().Get("Content-Type"); got != problemContentType {
+		t.Errorf("expected the content type to be %v, got %v instead", problemContentType, got)
+	}
+	p := problem{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("expected a JSON problem, got %v instead", rr.Body.String())
+	}
+	if p.Type != "about:blank" || p.Title != "Unprocessable Entity" || p.Status != http.StatusUnprocessableEntity {
+		t.Errorf("expected a plain 422 problem, got %+v instead", p)
+	}
+	if p.Instance != "/meetups?draft=true" {
+		t.Errorf("expected the instance to be the requested URI, got %v instead", p.Instance)
+	}
+	if len(p.Errors["title"]) != 1 {
+		t.Errorf("expected the field errors to be listed, got %v instead", p.Errors)
+	}
+}
+
+func TestProblemsKeepTheirTypeInXML(t *testing.T) {
+	// Arrange
+	h := negotiate(problemInstances(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "meetup not found")
+	})))
+	req := httptest.NewRequest("GET", "/meetups/9", nil)
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if got := rr.Header().Get("Content-Type"); got != "application/problem+xml" {
+		t.Errorf("expected the content type to be application/problem+xml, got %v instead", got)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><type>about:blank</type><title>Not Found</title><status>404</status><code>not_found</code><detail>meetup not found</detail><instance>/meetups/9</instance></response>`
+	if got := rr.Body.String(); got != expected {
+		t.Errorf("expected the body to be %v, got %v instead", expected, got)
+	}
+}
+
+func TestErrorsCarryACode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		status   int
+		expected string
+	}{
+		{"generic", newAppError(http.StatusNotFound, "meetup not found"), http.StatusNotFound, "not_found"},
+		{"validation", newAppError(http.StatusUnprocessableEntity, "invalid"), http.StatusUnprocessableEntity, "validation_failed"},
+		{"specific", errEmailTaken(), http.StatusUnprocessableEntity, "email_taken"},
+		{"unexpected", json.Unmarshal([]byte("{"), &struct{}{}), http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rr := httptest.NewRecorder()
+
+			// Act
+			writeAppError(rr, tt.err)
+
+			// Assert
+			p := problem{}
+			json.Unmarshal(rr.Body.Bytes(), &p)
+			if rr.Code != tt.status || p.Status != tt.status || p.Code != tt.expected {
+				t.Errorf("expected a %v with the code %v, got %v %+v instead", tt.status, tt.expected, rr.Code, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const clientIPContextKey contextKey = "client_ip"
+
+// trustedProxies works out the address of the client behind the proxies in
+// front of the server. Only a trusted peer's X-Forwarded-For is believed,
+// read from the right so a client can't pass off a forged entry as its own,
+// with X-Real-IP as the fallback. Requests over a Unix socket come from a
+// proxy on the same host and are trusted as well.
+func trustedProxies(trusted []*net.IPNet) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := peerIP(r)
+			if parsed := net.ParseIP(ip); parsed == nil || containsIP(trusted, parsed) {
+				ip = forwardedIP(r, trusted, ip)
+			}
+
+			ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// forwardedIP is the first address in X-Forwarded-For from the right that
+// isn't a trusted proxy, or X-Real-IP when there is no X-Forwarded-For
+func forwardedIP(r *http.Request, trusted []*net.IPNet, peer string) string {
+	hops := []string{}
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return peer
+	}
+
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop.String()
+		if !containsIP(trusted, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// peerIP is the address of whoever opened the connection
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
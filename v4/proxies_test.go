@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPBehindTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded string
+		realIP    string
+		expected  string
+	}{
+		{name: "without a proxy", peer: "203.0.113.9:1234", expected: "203.0.113.9"},
+		{name: "ignores headers from untrusted peers", peer: "203.0.113.9:1234", forwarded: "198.51.100.1", expected: "203.0.113.9"},
+		{name: "takes the client from a trusted proxy", peer: "10.0.0.2:1234", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "skips trusted hops from the right", peer: "10.0.0.2:1234", forwarded: "198.51.100.1, 10.0.0.3", expected: "198.51.100.1"},
+		{name: "ignores what the client forged", peer: "10.0.0.2:1234", forwarded: "192.0.2.66, 198.51.100.1", expected: "198.51.100.1"},
+		{name: "stops at garbage", peer: "10.0.0.2:1234", forwarded: "198.51.100.1, garbage, 10.0.0.3", expected: "10.0.0.3"},
+		{name: "falls back to X-Real-IP", peer: "10.0.0.2:1234", realIP: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "trusts Unix sockets", peer: "@", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			trusted, _ := parseCIDRs("10.0.0.0/8")
+			got := ""
+			h := trustedProxies(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.peer
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			// Act
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			// Assert
+			if got != tt.expected {
+				t.Errorf("expected the client IP to be %v, got %v instead", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// qrVersions are the sizes of QR code the encoder knows, versions 1 to 5 at
+// error correction level L. Each fits its data in a single block, which
+// keeps the encoder small and is plenty for a ticket code.
+var qrVersions = []struct {
+	data, ec int
+}{
+	{data: 19, ec: 7},
+	{data: 34, ec: 10},
+	{data: 55, ec: 15},
+	{data: 80, ec: 20},
+	{data: 108, ec: 26},
+}
+
+var errQRTooLong = errors.New("qrcode: data too long")
+
+// qrCode encodes the data in byte mode as the modules of the smallest QR code
+// it fits, true being dark
+func qrCode(data []byte) ([][]bool, error) {
+	version := 0
+	for i, v := range qrVersions {
+		// 4 bits of mode and 8 of length come before the data
+		if len(data)+2 <= v.data {
+			version = i + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+	v := qrVersions[version-1]
+
+	codewords := qrDataCodewords(data, v.data)
+	codewords = append(codewords, reedSolomon(codewords, v.ec)...)
+
+	q := newQRMatrix(version)
+	q.drawCodewords(codewords)
+
+	// any mask is valid, the one with the lowest penalty is easiest to scan
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+
+	return q.modules, nil
+}
+
+// qrDataCodewords lays out the byte mode segment and pads it to capacity
+func qrDataCodewords(data []byte, capacity int) []byte {
+	bits := []bool{}
+	push := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>uint(i)&1 == 1)
+		}
+	}
+	push(0x4, 4)
+	push(len(data), 8)
+	for _, b := range data {
+		push(int(b), 8)
+	}
+	// a terminator of up to four zeros, then zeros to a byte boundary
+	for i := 0; i < 4 && len(bits) < capacity*8; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// gfExp and gfLog are the powers and logarithms of 2 in the field QR codes
+// use for error correction, GF(256) modulo x^8 + x^4 + x^3 + x^2 + 1
+var gfExp, gfLog = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// reedSolomon computes the n error correction codewords for the data
+func reedSolomon(data []byte, n int) []byte {
+	generator := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(generator)+1)
+		for j, c := range generator {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		generator = next
+	}
+
+	remainder := make([]byte, len(data)+n)
+	copy(remainder, data)
+	for i := range data {
+		coef := remainder[i]
+		if coef == 0 {
+			continue
+		}
+		for j, c := range generator {
+			remainder[i+j] ^= gfMul(c, coef)
+		}
+	}
+	return remainder[len(data):]
+}
+
+// qrMatrix is a QR code being drawn, reserved modules belong to the function
+// patterns and can't hold data or be masked
+type qrMatrix struct {
+	size     int
+	modules  [][]bool
+	reserved [][]bool
+}
+
+func newQRMatrix(version int) *qrMatrix {
+	size := 17 + 4*version
+	q := &qrMatrix{size: size, modules: make([][]bool, size), reserved: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.reserved[i] = make([]bool, size)
+	}
+
+	for _, corner := range [][2]int{{0, 0}, {0, size - 7}, {size - 7, 0}} {
+		q.drawFinder(corner[0], corner[1])
+	}
+	for i := 8; i < size-8; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	if version >= 2 {
+		center := size - 7
+		for dr := -2; dr <= 2; dr++ {
+			for dc := -2; dc <= 2; dc++ {
+				q.set(center+dr, center+dc, abs(dr) == 2 || abs(dc) == 2 || dr == 0 && dc == 0)
+			}
+		}
+	}
+	// the format is drawn once the mask is known, until then its modules
+	// are only reserved
+	q.drawFormat(0)
+
+	return q
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (q *qrMatrix) set(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.reserved[row][col] = true
+}
+
+// drawFinder draws a finder pattern with its top left corner at the row and
+// column, along with the light separator around it
+func (q *qrMatrix) drawFinder(row, col int) {
+	for dr := -1; dr <= 7; dr++ {
+		for dc := -1; dc <= 7; dc++ {
+			r, c := row+dr, col+dc
+			if r < 0 || r >= q.size || c < 0 || c >= q.size {
+				continue
+			}
+			inside := dr >= 0 && dr <= 6 && dc >= 0 && dc <= 6
+			ring := dr == 0 || dr == 6 || dc == 0 || dc == 6
+			core := dr >= 2 && dr <= 4 && dc >= 2 && dc <= 4
+			q.set(r, c, inside && (ring || core))
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information, the error
+// correction level L and the mask protected by a BCH code
+func (q *qrMatrix) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i uint) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(i, 8, bit(uint(i)))
+	}
+	q.set(7, 8, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(uint(i)))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(8, q.size-1-i, bit(uint(i)))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(q.size-15+i, 8, bit(uint(i)))
+	}
+	q.set(q.size-8, 8, true)
+}
+
+// qrFormatBits are the 15 format bits for level L and the mask
+func qrFormatBits(mask int) int {
+	data := 1<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawCodewords fills the modules that aren't reserved with the codewords,
+// in two module wide columns zigzagging up and down from the bottom right
+func (q *qrMatrix) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern is skipped entirely
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if q.reserved[row][col] || i >= len(codewords)*8 {
+					continue
+				}
+				q.modules[row][col] = codewords[i>>3]>>uint(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules the mask pattern selects, applying the
+// same mask again undoes it
+func (q *qrMatrix) applyMask(mask int) {
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.reserved[row][col] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (row+col)%2 == 0
+			case 1:
+				flip = row%2 == 0
+			case 2:
+				flip = col%3 == 0
+			case 3:
+				flip = (row+col)%3 == 0
+			case 4:
+				flip = (row/2+col/3)%2 == 0
+			case 5:
+				flip = row*col%2+row*col%3 == 0
+			case 6:
+				flip = (row*col%2+row*col%3)%2 == 0
+			case 7:
+				flip = ((row+col)%2+row*col%3)%2 == 0
+			}
+			if flip {
+				q.modules[row][col] = !q.modules[row][col]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan, following the four rules of
+// the QR code specification
+func (q *qrMatrix) penalty() int {
+	at := func(row, col int, transposed bool) bool {
+		if transposed {
+			return q.modules[col][row]
+		}
+		return q.modules[row][col]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true, false, false, false, false}
+
+	penalty, dark := 0, 0
+	for _, transposed := range []bool{false, true} {
+		for row := 0; row < q.size; row++ {
+			run := 1
+			for col := 1; col <= q.size; col++ {
+				if col < q.size && at(row, col, transposed) == at(row, col-1, transposed) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for col := 0; col+len(finderLike) <= q.size; col++ {
+				forward, backward := true, true
+				for k, want := range finderLike {
+					forward = forward && at(row, col+k, transposed) == want
+					backward = backward && at(row, col+len(finderLike)-1-k, transposed) == want
+				}
+				if forward {
+					penalty += 40
+				}
+				if backward {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.modules[row][col] {
+				dark++
+			}
+			if row > 0 && col > 0 {
+				c := q.modules[row][col]
+				if c == q.modules[row-1][col] && c == q.modules[row][col-1] && c == q.modules[row-1][col-1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (q.size * q.size)
+	return penalty + abs(percent-50)/5*10
+}
+
+// writeQRPNG draws the modules as a PNG, scale pixels to a module with the
+// four module quiet zone scanners need around the code
+func writeQRPNG(w io.Writer, modules [][]bool, scale int) error {
+	const quiet = 4
+	size := (len(modules) + 2*quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for row, line := range modules {
+		for col, dark := range line {
+			if !dark {
+				continue
+			}
+			for y := 0; y < scale; y++ {
+				for x := 0; x < scale; x++ {
+					img.SetGray((col+quiet)*scale+x, (row+quiet)*scale+y, color.Gray{Y: 0})
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReedSolomonMatchesTheSpecificationExample(t *testing.T) {
+	// Arrange
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	// Act
+	ec := reedSolomon(data, len(expected))
+
+	// Assert
+	if !bytes.Equal(ec, expected) {
+		t.Errorf("expected the error correction codewords to be %v, got %v instead", expected, ec)
+	}
+}
+
+func TestQRFormatBits(t *testing.T) {
+	// Arrange
+	expected := map[int]int{0: 0x77C4, 4: 0x662F, 7: 0x6976}
+
+	for mask, bits := range expected {
+		// Act
+		actual := qrFormatBits(mask)
+
+		// Assert
+		if actual != bits {
+			t.Errorf("expected the format bits of mask %v to be %015b, got %015b instead", mask, bits, actual)
+		}
+	}
+}
+
+func TestQRCodesUseTheSmallestVersionThatFits(t *testing.T) {
+	tests := []struct {
+		length int
+		size   int
+	}{
+		{length: 17, size: 21},
+		{length: 18, size: 25},
+		{length: 106, size: 37},
+	}
+
+	for _, tt := range tests {
+		// Act
+		modules, err := qrCode(bytes.Repeat([]byte("a"), tt.length))
+
+		// Assert
+		if err != nil || len(modules) != tt.size {
+			t.Errorf("expected %v bytes to take %v modules, got %v %v instead", tt.length, tt.size, len(modules), err)
+			continue
+		}
+		// the finder patterns' centers and the timing pattern next to them
+		for _, corner := range [][2]int{{3, 3}, {3, tt.size - 4}, {tt.size - 4, 3}} {
+			if !modules[corner[0]][corner[1]] {
+				t.Errorf("expected a finder pattern centered at %v", corner)
+			}
+		}
+		if !modules[6][8] || modules[6][9] {
+			t.Errorf("expected the timing pattern to alternate")
+		}
+	}
+
+	if _, err := qrCode(bytes.Repeat([]byte("a"), 107)); err != errQRTooLong {
+		t.Errorf("expected 107 bytes to be too long, got %v instead", err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setRateLimit tells clients how many more requests the limiter allows and
+// when it allows more, so well behaved ones can slow down before they are
+// turned away. Retry-After is only sent once nothing is left.
+func setRateLimit(w http.ResponseWriter, limit, remaining int, reset time.Time) {
+	if remaining < 0 {
+		remaining = 0
+	}
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if remaining == 0 {
+		h.Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+	}
+}
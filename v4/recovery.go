@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+)
+
+const requestIDContextKey contextKey = "request_id"
+
+// requestIDPattern is what a request ID passed in by a proxy has to look like
+// to be kept, anything else is replaced with one of ours
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDs names every request so its log lines can be found again, it
+// keeps the X-Request-ID a proxy in front already assigned and echoes it back
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDFrom returns the ID requestIDs gave the request
+func requestIDFrom(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// recoverPanics turns a panicking handler into a 500 with the usual error
+// envelope and logs the stack with the request ID. When the handler had
+// already started its response only the log is left to do.
+// http.ErrAbortHandler is passed on since it is how handlers ask for the
+// connection to be dropped.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("panic serving %v %v (request %v): %v\n%s", r.Method, r.URL.Path, requestIDFrom(r), err, debug.Stack())
+			if !hw.wroteHeader {
+				writeError(w, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+
+		next.ServeHTTP(hw, r)
+	})
+}
+
+// headerWriter remembers whether the response was started
+type headerWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (hw *headerWriter) WriteHeader(status int) {
+	hw.wroteHeader = true
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	hw.wroteHeader = true
+	return hw.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through so streamed responses aren't held back
+func (hw *headerWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
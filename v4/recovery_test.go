@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPanicsBecomeInternalServerErrors(t *testing.T) {
+	// Arrange
+	logs := bytes.Buffer{}
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	h := chain(requestIDs, recoverPanics)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusInternalServerError, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"detail":"internal server error"`) {
+		t.Errorf("expected a problem, got %v instead", rr.Body.String())
+	}
+	if !strings.Contains(logs.String(), "abc-123") || !strings.Contains(logs.String(), "boom") || !strings.Contains(logs.String(), "recovery_test.go") {
+		t.Errorf("expected the panic to be logged with its request ID and stack, got %v instead", logs.String())
+	}
+}
+
+func TestPanicsBecomeInternalServerErrorsWhenCompressing(t *testing.T) {
+	// Arrange
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+	h := chain(recoverPanics, compress(1024))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"partial":`))
+		panic("boom")
+	}))
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusInternalServerError, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"detail":"internal server error"`) || strings.Contains(rr.Body.String(), "partial") {
+		t.Errorf("expected only the problem, got %v instead", rr.Body.String())
+	}
+}
+
+func TestRequestIDsAreGeneratedWhenMissingOrInvalid(t *testing.T) {
+	// Arrange
+	h := requestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("X-Request-ID", "not a valid id\n")
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(rr, req)
+
+	// Assert
+	if id := rr.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("expected a generated request ID, got %q instead", id)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// recurrence is the subset of RFC 5545 recurrence rules meetups support:
+// FREQ of WEEKLY or MONTHLY, INTERVAL, COUNT, UNTIL, and for monthly meetups
+// a BYDAY with an ordinal such as 3TU for the third Tuesday or -1TH for the
+// last Thursday. Without BYDAY a monthly meetup falls on the day of the month
+// it started, skipping months without that day. Occurrences are computed in
+// UTC.
+type recurrence struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+	Ordinal  int
+	Weekday  time.Weekday
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRecurrence reads a rule such as "FREQ=MONTHLY;BYDAY=3TU;COUNT=12"
+func parseRecurrence(s string) (recurrence, error) {
+	rc := recurrence{Interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.ToUpper(s), "RRULE:"), ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rc, fmt.Errorf("%q is not a NAME=VALUE pair", part)
+		}
+
+		switch name, value := kv[0], kv[1]; name {
+		case "FREQ":
+			if value != "WEEKLY" && value != "MONTHLY" {
+				return rc, fmt.Errorf("FREQ must be WEEKLY or MONTHLY")
+			}
+			rc.Freq = value
+		case "INTERVAL", "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rc, fmt.Errorf("%v must be a positive number", name)
+			}
+			if name == "INTERVAL" {
+				rc.Interval = n
+			} else {
+				rc.Count = n
+			}
+		case "UNTIL":
+			until, err := time.Parse("20060102T150405Z", value)
+			if err != nil {
+				return rc, fmt.Errorf("UNTIL must be a UTC time such as 20201231T235959Z")
+			}
+			rc.Until = until
+		case "BYDAY":
+			if len(value) < 3 {
+				return rc, fmt.Errorf("BYDAY must be an ordinal and a day such as 3TU")
+			}
+			weekday, ok := icsWeekdays[value[len(value)-2:]]
+			n, err := strconv.Atoi(value[:len(value)-2])
+			if !ok || err != nil || n == 0 || n < -5 || n > 5 {
+				return rc, fmt.Errorf("BYDAY must be an ordinal and a day such as 3TU")
+			}
+			rc.Ordinal, rc.Weekday = n, weekday
+		default:
+			return rc, fmt.Errorf("%v is not supported", name)
+		}
+	}
+
+	if rc.Freq == "" {
+		return rc, fmt.Errorf("FREQ is required")
+	}
+	if rc.Ordinal != 0 && rc.Freq != "MONTHLY" {
+		return rc, fmt.Errorf("BYDAY is only supported for MONTHLY meetups")
+	}
+	if rc.Count != 0 && !rc.Until.IsZero() {
+		return rc, fmt.Errorf("COUNT and UNTIL cannot be combined")
+	}
+	return rc, nil
+}
+
+// String formats the rule the way it is stored and put in calendars
+func (rc recurrence) String() string {
+	parts := []string{"FREQ=" + rc.Freq}
+	if rc.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%v", rc.Interval))
+	}
+	if rc.Ordinal != 0 {
+		for code, weekday := range icsWeekdays {
+			if weekday == rc.Weekday {
+				parts = append(parts, fmt.Sprintf("BYDAY=%v%v", rc.Ordinal, code))
+			}
+		}
+	}
+	if rc.Count != 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%v", rc.Count))
+	}
+	if !rc.Until.IsZero() {
+		parts = append(parts, "UNTIL="+icsTime(rc.Until))
+	}
+	return strings.Join(parts, ";")
+}
+
+// maxRecurrenceSteps bounds how far ahead occurrences are looked for, a
+// weekly meetup reaches about a century
+const maxRecurrenceSteps = 5000
+
+// occurrences lists up to limit starts of the series that fall in [from, to),
+// the first occurrence is always the start itself
+func (rc recurrence) occurrences(start, from, to time.Time, limit int) []time.Time {
+	start = start.UTC()
+	found := []time.Time{}
+	n := 0
+	for step := 0; step < maxRecurrenceSteps && len(found) < limit; step++ {
+		t, ok := rc.step(start, step)
+		if !ok {
+			continue
+		}
+		if !t.Before(to) || (!rc.Until.IsZero() && t.After(rc.Until)) {
+			break
+		}
+		n++
+		if rc.Count != 0 && n > rc.Count {
+			break
+		}
+		if !t.Before(from) {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+// step returns the occurrence the given number of intervals after the start,
+// false when that month has no such day
+func (rc recurrence) step(start time.Time, step int) (time.Time, bool) {
+	if step == 0 {
+		return start, true
+	}
+	if rc.Freq == "WEEKLY" {
+		return start.AddDate(0, 0, 7*rc.Interval*step), true
+	}
+
+	// the first of the month the occurrence falls in
+	month := time.Date(start.Year(), start.Month()+time.Month(rc.Interval*step), 1, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+	days := month.AddDate(0, 1, -1).Day()
+
+	day := start.Day()
+	if rc.Ordinal > 0 {
+		day = 1 + (int(rc.Weekday)-int(month.Weekday())+7)%7 + 7*(rc.Ordinal-1)
+	} else if rc.Ordinal < 0 {
+		last := month.AddDate(0, 0, days-1)
+		day = days - (int(last.Weekday())-int(rc.Weekday)+7)%7 + 7*(rc.Ordinal+1)
+	}
+	if day < 1 || day > days {
+		return time.Time{}, false
+	}
+	return month.AddDate(0, 0, day-1), true
+}
+
+// meetupOccurrence changes or cancels one occurrence of a recurring meetup,
+// it is keyed by when the occurrence would have started. Fields left nil
+// keep the value of the meetup.
+type meetupOccurrence struct {
+	ID          uint      `gorm:"primary_key"`
+	MeetupID    uint      `gorm:"unique_index:idx_meetup_occurrences_meetup_occurs_at"`
+	OccursAt    time.Time `gorm:"unique_index:idx_meetup_occurrences_meetup_occurs_at"`
+	StartsAt    *time.Time
+	Title       *string `gorm:"type:varchar(100)"`
+	Description *string `gorm:"type:varchar(2000)"`
+	Location    *string `gorm:"type:varchar(255)"`
+	Cancelled   bool    `gorm:"not null;default:false"`
+	UpdatedAt   time.Time
+}
+
+// occurrenceResponse is one occurrence as the API shows it, date identifies
+// it in /meetups/{id}/occurrences/{date}
+type occurrenceResponse struct {
+	Date        string    `json:"date"`
+	StartsAt    time.Time `json:"starts_at"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	Cancelled   bool      `json:"cancelled"`
+}
+
+func presentOccurrence(m meetup, occursAt time.Time, o meetupOccurrence) occurrenceResponse {
+	resp := occurrenceResponse{
+		Date:        occursAt.UTC().Format("2006-01-02"),
+		StartsAt:    occursAt,
+		Title:       m.Title,
+		Description: m.Description,
+		Location:    m.Location,
+		Cancelled:   o.Cancelled,
+	}
+	if o.StartsAt != nil {
+		resp.StartsAt = *o.StartsAt
+	}
+	if o.Title != nil {
+		resp.Title = *o.Title
+	}
+	if o.Description != nil {
+		resp.Description = *o.Description
+	}
+	if o.Location != nil {
+		resp.Location = *o.Location
+	}
+	return resp
+}
+
+// occurrenceStarts lists up to limit starts of the meetup in [from, to), a
+// meetup that doesn't recur has one
+func occurrenceStarts(m meetup, from, to time.Time, limit int) ([]time.Time, error) {
+	if m.Recurrence != "" {
+		rc, err := parseRecurrence(m.Recurrence)
+		if err != nil {
+			return nil, err
+		}
+		return rc.occurrences(m.StartsAt, from, to, limit), nil
+	}
+
+	if m.StartsAt.Before(from) || !m.StartsAt.Before(to) {
+		return []time.Time{}, nil
+	}
+	return []time.Time{m.StartsAt.UTC()}, nil
+}
+
+// meetupOccurrences is occurrenceStarts with the changes made to each
+// occurrence applied
+func meetupOccurrences(db *gorm.DB, m meetup, from, to time.Time, limit int) ([]occurrenceResponse, error) {
+	starts, err := occurrenceStarts(m, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []meetupOccurrence{}
+	if len(starts) >= 1 {
+		db.Where("meetup_id = ? AND occurs_at IN (?)", m.ID, starts).Find(&changes)
+	}
+	byStart := make(map[int64]meetupOccurrence, len(changes))
+	for _, o := range changes {
+		byStart[o.OccursAt.Unix()] = o
+	}
+
+	resp := make([]occurrenceResponse, len(starts))
+	for i, start := range starts {
+		resp[i] = presentOccurrence(m, start, byStart[start.Unix()])
+	}
+	return resp, nil
+}
+
+// findOccurrence returns when the occurrence of the meetup on the date in
+// the path starts, before any change made to it
+func findOccurrence(m meetup, r *http.Request) (time.Time, bool) {
+	day, err := time.Parse("2006-01-02", param(r, "date"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	starts, err := occurrenceStarts(m, day, day.AddDate(0, 0, 1), 1)
+	if err != nil || len(starts) == 0 {
+		return time.Time{}, false
+	}
+	return starts[0], true
+}
+
+// occurrencesIndexParams are the query parameters the occurrence list understands
+var occurrencesIndexParams = []string{"from", "to", "limit"}
+
+// occurrencesIndex materializes the upcoming occurrences of a meetup, from
+// defaults to now and to a year after from
+func occurrencesIndex(db *gorm.DB) http.HandlerFunc {
+	type occurrencesIndexResponse struct {
+		Occurrences []occurrenceResponse `json:"occurrences"`
+	}
+
+	policy := listPolicyFor("/meetups/{id}/occurrences")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, occurrencesIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		errs := map[string][]string{}
+		params := r.URL.Query()
+		from := time.Now()
+		if s := params.Get("from"); s != "" {
+			t, err := parseDate(s)
+			if err != nil {
+				errs["from"] = append(errs["from"], "The from field must be a date or an RFC 3339 timestamp")
+			}
+			from = t
+		}
+		to := from.AddDate(1, 0, 0)
+		if s := params.Get("to"); s != "" {
+			t, err := parseDate(s)
+			if err != nil {
+				errs["to"] = append(errs["to"], "The to field must be a date or an RFC 3339 timestamp")
+			}
+			to = t
+		}
+		limit := policy.DefaultPerPage
+		if s := params.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				errs["limit"] = append(errs["limit"], "The limit field must be a positive number")
+			}
+			limit = n
+		}
+		if limit > policy.MaxPerPage {
+			limit = policy.MaxPerPage
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		occurrences, err := meetupOccurrences(tx, m, from, to, limit)
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, occurrencesIndexResponse{Occurrences: occurrences})
+	}
+}
+
+// occurrenceUpdateRules are the fields of a single occurrence that can be
+// changed, the rest belong to the series
+var occurrenceUpdateRules = govalidator.MapData{
+	"title":       meetupRules["title"],
+	"description": meetupRules["description"],
+	"starts_at":   meetupRules["starts_at"],
+	"location":    meetupRules["location"],
+}
+
+// occurrencesUpdate changes one occurrence of a recurring meetup without
+// touching the rest of the series
+func occurrencesUpdate(db *gorm.DB) http.HandlerFunc {
+	type occurrenceUpdateRequest struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		StartsAt    *string `json:"starts_at"`
+		Location    *string `json:"location"`
+	}
+
+	type occurrenceUpdateResponse struct {
+		Occurrence occurrenceResponse `json:"occurrence"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		occursAt, ok := findOccurrence(m, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "occurrence not found")
+			return
+		}
+
+		req := occurrenceUpdateRequest{}
+		errs, err := decodePartial(r, &req, occurrenceUpdateRules)
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+		o := meetupOccurrence{}
+		tx.Where(meetupOccurrence{MeetupID: m.ID, OccursAt: occursAt}).FirstOrInit(&o)
+		if req.StartsAt != nil && *req.StartsAt != "" {
+			startsAt, messages := parseStartsAt(*req.StartsAt)
+			if len(messages) >= 1 {
+				errs["starts_at"] = append(errs["starts_at"], messages...)
+			}
+			o.StartsAt = &startsAt
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		if req.Title != nil {
+			o.Title = req.Title
+		}
+		if req.Description != nil {
+			o.Description = req.Description
+		}
+		if req.Location != nil {
+			o.Location = req.Location
+		}
+
+		if err := tx.Save(&o).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, occurrenceUpdateResponse{Occurrence: presentOccurrence(m, occursAt, o)})
+	}
+}
+
+// occurrencesDestroy cancels one occurrence of a recurring meetup, it stays
+// in the list of occurrences marked as cancelled
+func occurrencesDestroy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		occursAt, ok := findOccurrence(m, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "occurrence not found")
+			return
+		}
+
+		o := meetupOccurrence{}
+		err := tx.Where(meetupOccurrence{MeetupID: m.ID, OccursAt: occursAt}).Assign(meetupOccurrence{Cancelled: true}).FirstOrCreate(&o).Error
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
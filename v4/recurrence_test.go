@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecurrenceRulesGenerateOccurrences(t *testing.T) {
+	start := time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC)
+	tests := []struct {
+		rule     string
+		start    time.Time
+		expected []string
+	}{
+		{rule: "FREQ=WEEKLY;INTERVAL=2;COUNT=3", start: start, expected: []string{"2019-10-15", "2019-10-29", "2019-11-12"}},
+		{rule: "FREQ=MONTHLY;BYDAY=3TU", start: start, expected: []string{"2019-10-15", "2019-11-19", "2019-12-17", "2020-01-21"}},
+		{rule: "FREQ=MONTHLY;BYDAY=-1TH", start: time.Date(2019, 10, 31, 18, 30, 0, 0, time.UTC), expected: []string{"2019-10-31", "2019-11-28", "2019-12-26", "2020-01-30"}},
+		{rule: "FREQ=MONTHLY", start: time.Date(2019, 10, 31, 18, 30, 0, 0, time.UTC), expected: []string{"2019-10-31", "2019-12-31", "2020-01-31", "2020-03-31"}},
+		{rule: "FREQ=WEEKLY;UNTIL=20191030T000000Z", start: start, expected: []string{"2019-10-15", "2019-10-22", "2019-10-29"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			// Arrange
+			rc, err := parseRecurrence(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Act
+			occurrences := rc.occurrences(tt.start, tt.start, tt.start.AddDate(1, 0, 0), 4)
+
+			// Assert
+			dates := []string{}
+			for _, o := range occurrences {
+				dates = append(dates, o.Format("2006-01-02"))
+			}
+			if fmt.Sprint(dates) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected the occurrences to be %v, got %v instead", tt.expected, dates)
+			}
+		})
+	}
+}
+
+func TestUnsupportedRecurrenceRulesAreRejected(t *testing.T) {
+	for _, rule := range []string{"", "FREQ=DAILY", "FREQ=WEEKLY;BYDAY=1TU", "FREQ=MONTHLY;BYDAY=TU", "FREQ=WEEKLY;COUNT=2;UNTIL=20191030T000000Z", "FREQ=WEEKLY;BYSETPOS=1", "FREQ=WEEKLY;INTERVAL=0"} {
+		if _, err := parseRecurrence(rule); err == nil {
+			t.Errorf("expected %q to be rejected", rule)
+		}
+	}
+}
+
+func TestSingleOccurrencesCanBeChangedAndCancelled(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	auth := login(db, organizer)
+	rt := meetupsRouter(db)
+	store := httptest.NewRequest("POST", "/meetups", strings.NewReader(`{"title":"Norfolk Gophers","starts_at":"2019-10-15T18:30:00Z","recurrence":"freq=monthly;byday=3tu"}`))
+	store.Header.Set("Authorization", auth)
+	stored := httptest.NewRecorder()
+	rt.ServeHTTP(stored, store)
+	created := struct {
+		Meetup meetupResponse `json:"meetup"`
+	}{}
+	json.Unmarshal(stored.Body.Bytes(), &created)
+	id := created.Meetup.ID
+	moved := httptest.NewRequest("PATCH", fmt.Sprintf("/meetups/%v/occurrences/2019-11-19", id), strings.NewReader(`{"starts_at":"2019-11-20T18:30:00Z","location":"Online"}`))
+	moved.Header.Set("Authorization", auth)
+	cancelled := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v/occurrences/2019-12-17", id), nil)
+	cancelled.Header.Set("Authorization", auth)
+	missing := httptest.NewRequest("DELETE", fmt.Sprintf("/meetups/%v/occurrences/2019-12-18", id), nil)
+	missing.Header.Set("Authorization", auth)
+	missingRR := httptest.NewRecorder()
+	index := httptest.NewRecorder()
+	ics := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(httptest.NewRecorder(), moved)
+	rt.ServeHTTP(httptest.NewRecorder(), cancelled)
+	rt.ServeHTTP(missingRR, missing)
+	rt.ServeHTTP(index, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/occurrences?from=2019-10-01&limit=3", id), nil))
+	rt.ServeHTTP(ics, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v.ics", id), nil))
+
+	// Assert
+	if created.Meetup.Recurrence != "FREQ=MONTHLY;BYDAY=3TU" {
+		t.Errorf("expected the rule to be normalized, got %v instead", created.Meetup.Recurrence)
+	}
+	if missingRR.Code != http.StatusNotFound {
+		t.Errorf("expected a date without an occurrence to be %v, got %v instead", http.StatusNotFound, missingRR.Code)
+	}
+	resp := struct {
+		Occurrences []occurrenceResponse `json:"occurrences"`
+	}{}
+	json.Unmarshal(index.Body.Bytes(), &resp)
+	if len(resp.Occurrences) != 3 {
+		t.Fatalf("expected three occurrences, got %v instead", index.Body.String())
+	}
+	if o := resp.Occurrences[1]; o.Date != "2019-11-19" || o.StartsAt.Day() != 20 || o.Location != "Online" || o.Title != "Norfolk Gophers" {
+		t.Errorf("expected the November occurrence to be moved online, got %+v instead", o)
+	}
+	if !resp.Occurrences[2].Cancelled || resp.Occurrences[0].Cancelled {
+		t.Errorf("expected only the December occurrence to be cancelled, got %+v instead", resp.Occurrences)
+	}
+	for _, expected := range []string{"RRULE:FREQ=MONTHLY;BYDAY=3TU\r\n", "EXDATE:20191217T183000Z\r\n", "RECURRENCE-ID:20191119T183000Z\r\n", "DTSTART:20191120T183000Z\r\n"} {
+		if !strings.Contains(ics.Body.String(), expected) {
+			t.Errorf("expected the calendar to contain %q, got %q instead", expected, ics.Body.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// reminderWindows are how long before a meetup starts the people going are
+// reminded of it, from the earliest
+var reminderWindows = []struct {
+	kind   string
+	before time.Duration
+}{
+	{kind: "reminder_24h", before: 24 * time.Hour},
+	{kind: "reminder_1h", before: time.Hour},
+}
+
+// sendReminders emails the people going to the meetups starting within a
+// reminder window, each occurrence of a recurring meetup on its own. Only the
+// latest window a meetup is in counts, so a meetup organized at short notice
+// gets one reminder rather than a burst of them. Cancelled meetups, and people
+// who turned email notifications off, aren't reminded.
+func sendReminders(db *gorm.DB, mail mailer, now time.Time) int {
+	horizon := now.Add(reminderWindows[0].before)
+	meetups := []meetup{}
+	if err := db.Where("cancelled_at IS NULL").Where("recurrence <> '' OR (starts_at > ? AND starts_at <= ?)", now, horizon).Find(&meetups).Error; err != nil {
+		log.Println(err)
+		return 0
+	}
+
+	sent := 0
+	for _, m := range meetups {
+		occurrences, err := meetupOccurrences(db, m, now, horizon.Add(time.Second), 10)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, o := range occurrences {
+			if o.Cancelled || !o.StartsAt.After(now) || o.StartsAt.After(horizon) {
+				continue
+			}
+			kind := ""
+			for _, w := range reminderWindows {
+				if !o.StartsAt.After(now.Add(w.before)) {
+					kind = w.kind
+				}
+			}
+			sent += remindAttendees(db, mail, m, o, kind)
+		}
+	}
+	return sent
+}
+
+// remindAttendees emails the reminder of the kind for the occurrence to the
+// people going who want email notifications
+func remindAttendees(db *gorm.DB, mail mailer, m meetup, o occurrenceResponse, kind string) int {
+	ids := []uint{}
+	db.Model(&rsvp{}).Where("meetup_id = ? AND status = ?", m.ID, rsvpGoing).Pluck("user_id", &ids)
+	if len(ids) == 0 {
+		return 0
+	}
+	optedOut := []uint{}
+	db.Model(&preference{}).Where("user_id IN (?)", ids).Where(&preference{Key: "email_notifications", Value: "false"}).Pluck("user_id", &optedOut)
+	skip := make(map[uint]bool, len(optedOut))
+	for _, id := range optedOut {
+		skip[id] = true
+	}
+
+	users := []user{}
+	db.Where("id IN (?)", ids).Find(&users)
+	body := fmt.Sprintf("%v starts on %v.", o.Title, o.StartsAt.Format(time.RFC1123))
+	if o.Location != "" {
+		body += fmt.Sprintf("\nLocation: %v", o.Location)
+	}
+
+	sent := 0
+	for _, u := range users {
+		if skip[u.ID] {
+			continue
+		}
+		msg := outboxMessage{
+			Key:     fmt.Sprintf("%v:%v:%v:%v", kind, m.ID, o.Date, u.ID),
+			UserID:  u.ID,
+			To:      u.Email,
+			Subject: "Reminder: " + o.Title,
+			Body:    body,
+		}
+		if deliver(db, mail, msg) {
+			sent++
+		}
+	}
+	return sent
+}
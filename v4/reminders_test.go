@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemindersAreSentOncePerWindow(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	going := createUser(db, "going@example.com", "somePassword1!")
+	waitlisted := createUser(db, "waitlisted@example.com", "somePassword1!")
+	startsAt := time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC)
+	m := createMeetup(db, organizer, "Norfolk Gophers", startsAt)
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: m.ID, UserID: waitlisted.ID, Status: rsvpWaitlisted})
+	mail := &recordingMailer{}
+	counts := []int{}
+
+	// Act
+	for _, before := range []time.Duration{25 * time.Hour, 23 * time.Hour, 22 * time.Hour, 30 * time.Minute, 10 * time.Minute} {
+		counts = append(counts, sendReminders(db, mail, startsAt.Add(-before)))
+	}
+
+	// Assert
+	expected := []int{0, 1, 0, 1, 0}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Errorf("expected %v reminders to be sent, got %v instead", expected, counts)
+			break
+		}
+	}
+	if mail.sent != 2 || mail.to != "going@example.com" || mail.subject != "Reminder: Norfolk Gophers" {
+		t.Errorf("expected two reminders to the person going, got %v to %v instead", mail.sent, mail.to)
+	}
+	outbox := 0
+	db.Model(&outboxMessage{}).Where("sent_at IS NOT NULL").Count(&outbox)
+	if outbox != 2 {
+		t.Errorf("expected the reminders to be recorded in the outbox, got %v instead", outbox)
+	}
+}
+
+func TestRemindersRespectNotificationPreferences(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	quiet := createUser(db, "quiet@example.com", "somePassword1!")
+	startsAt := time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC)
+	m := createMeetup(db, organizer, "Norfolk Gophers", startsAt)
+	db.Create(&rsvp{MeetupID: m.ID, UserID: quiet.ID, Status: rsvpGoing})
+	db.Create(&preference{UserID: quiet.ID, Key: "email_notifications", Value: "false"})
+	mail := &recordingMailer{}
+
+	// Act
+	sent := sendReminders(db, mail, startsAt.Add(-time.Hour))
+
+	// Assert
+	if sent != 0 || mail.sent != 0 {
+		t.Errorf("expected no reminder, got %v instead", mail.sent)
+	}
+}
+
+func TestRecurringMeetupsAreRemindedOfEachOccurrence(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	going := createUser(db, "going@example.com", "somePassword1!")
+	m := meetup{Title: "Weekly", StartsAt: time.Date(2019, 10, 1, 18, 30, 0, 0, time.UTC), OrganizerID: organizer.ID, Recurrence: "FREQ=WEEKLY"}
+	db.Create(&m)
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	mail := &recordingMailer{}
+
+	// Act
+	sent := sendReminders(db, mail, time.Date(2019, 10, 15, 17, 45, 0, 0, time.UTC))
+
+	// Assert
+	if sent != 1 || mail.to != "going@example.com" {
+		t.Errorf("expected the third occurrence to be reminded, got %v instead", sent)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// writeJSON encodes v as the JSON body of the response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// problem is an RFC 7807 problem details object, every error response is
+// one. The code identifies the error for clients, see appError, and Errors
+// lists what is wrong with each field of the request.
+type problem struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Code     string              `json:"code"`
+	Detail   string              `json:"detail,omitempty"`
+	Instance string              `json:"instance,omitempty"`
+	Errors   map[string][]string `json:"errors,omitempty"`
+}
+
+// problemContentType is the media type of problem details
+const problemContentType = "application/problem+json"
+
+// writeProblem sends the problem, its type and title default to the ones
+// RFC 7807 gives problems that need nothing more than their status. The
+// instance is filled in by the problemInstances middleware.
+func writeProblem(w http.ResponseWriter, p problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		log.Println(err)
+		data = []byte(`{"type":"about:blank","title":"Internal Server Error","status":500,"code":"internal_error"}`)
+		p.Status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-type", problemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(data)
+}
+
+// writeError sends a problem with the status' generic code and the message
+// as its detail
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeAppError(w, newAppError(status, message))
+}
+
+// writeErrors sends a problem listing the errors of each field
+func writeErrors(w http.ResponseWriter, status int, errs map[string][]string) {
+	e := newAppError(status, "The request has invalid fields")
+	e.Fields = errs
+	writeAppError(w, e)
+}
+
+// writeValidationErrors sends the errors envelope used for invalid input
+func writeValidationErrors(w http.ResponseWriter, errs map[string][]string) {
+	writeErrors(w, http.StatusUnprocessableEntity, errs)
+}
+
+// noStore keeps caches from storing the responses, for routes serving
+// personal or administrative data
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
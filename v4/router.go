@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+const paramsContextKey contextKey = "params"
+
+// router dispatches requests by method and path, a pattern segment written
+// as {name} matches any single path segment and captures it as a parameter.
+// A parameter can be followed by a literal suffix, {id}.ics matches 42.ics
+// and captures 42.
+type router struct {
+	routes []route
+	groups []*routeGroup
+}
+
+// route is a registered handler, wrapped is the handler with the middleware
+// of its groups applied, built as routes and groups are registered rather
+// than on every request
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	suffixes int
+	handler  http.Handler
+	wrapped  http.Handler
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle registers the handler for the method and pattern
+func (rt *router) handle(method, pattern string, h http.HandlerFunc) {
+	segments := splitPath(pattern)
+	suffixes := 0
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, "{") && !strings.HasSuffix(segment, "}") {
+			suffixes++
+		}
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		suffixes: suffixes,
+		handler:  h,
+	})
+	added := &rt.routes[len(rt.routes)-1]
+	added.wrapped = rt.wrap(added)
+}
+
+// routeGroup is the routes under a path prefix, its middleware wraps every
+// one of them whether they were registered through the group or not. The
+// middleware of a group wraps that of the groups nested in it.
+type routeGroup struct {
+	rt         *router
+	prefix     string
+	segments   []string
+	middleware []middleware
+}
+
+// group starts a group of the routes under the prefix
+func (rt *router) group(prefix string, mw ...middleware) *routeGroup {
+	g := &routeGroup{rt: rt, prefix: path.Join("/", prefix), segments: splitPath(prefix), middleware: mw}
+	rt.groups = append(rt.groups, g)
+	rt.rewrap()
+	return g
+}
+
+// group starts a group nested in this one, its prefix is relative to this
+// group's
+func (g *routeGroup) group(prefix string, mw ...middleware) *routeGroup {
+	return g.rt.group(path.Join(g.prefix, prefix), mw...)
+}
+
+// use adds middleware to the group, routes registered before it get it too
+func (g *routeGroup) use(mw ...middleware) {
+	g.middleware = append(g.middleware, mw...)
+	g.rt.rewrap()
+}
+
+// handle registers the handler for the method and the pattern relative to
+// the group's prefix
+func (g *routeGroup) handle(method, pattern string, h http.HandlerFunc) {
+	g.rt.handle(method, path.Join(g.prefix, pattern), h)
+}
+
+// wrap applies the middleware of the groups the route is in, the group with
+// the shortest prefix outermost
+func (rt *router) wrap(matched *route) http.Handler {
+	h := matched.handler
+	for i := len(matched.segments); i >= 0; i-- {
+		for j := len(rt.groups) - 1; j >= 0; j-- {
+			g := rt.groups[j]
+			if len(g.segments) != i || !hasSegments(matched.segments, g.segments) {
+				continue
+			}
+			h = chain(g.middleware...)(h)
+		}
+	}
+	return h
+}
+
+// rewrap rebuilds the handlers of the routes registered so far, a group or
+// middleware added after them applies to them too
+func (rt *router) rewrap() {
+	for i := range rt.routes {
+		rt.routes[i].wrapped = rt.wrap(&rt.routes[i])
+	}
+}
+
+func hasSegments(segments, prefix []string) bool {
+	if len(prefix) > len(segments) {
+		return false
+	}
+	for i := range prefix {
+		if segments[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP dispatches the request to the best matching route. HEAD requests
+// are served by the GET route, the server drops the body, and OPTIONS
+// requests are answered from the routing table.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := splitPath(r.URL.Path)
+	allowed := []string{}
+
+	var best *route
+	var bestParams map[string]string
+	for i := range rt.routes {
+		params, ok := rt.routes[i].match(path)
+		if !ok {
+			continue
+		}
+		method := rt.routes[i].method
+		allowed = append(allowed, method, http.MethodOptions)
+		if method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+		if method != r.Method && (r.Method != http.MethodHead || method != http.MethodGet) {
+			continue
+		}
+
+		// literal segments win over parameters, so /users/search is not
+		// captured by /users/{id}, and suffixed parameters over bare ones
+		// so /meetups/42.ics is not either
+		if best == nil || len(params) < len(bestParams) || (len(params) == len(bestParams) && rt.routes[i].suffixes > best.suffixes) {
+			best = &rt.routes[i]
+			bestParams = params
+		}
+	}
+
+	if best == nil {
+		if len(allowed) > 0 && r.Method == http.MethodOptions {
+			rt.options(w, r, allowed)
+			return
+		}
+		if len(allowed) > 0 {
+			rt.methodNotAllowed(w, r, allowed)
+			return
+		}
+		rt.notFound(w, r)
+		return
+	}
+
+	if matched, ok := r.Context().Value(matchedRouteContextKey).(*string); ok {
+		*matched = best.pattern
+	}
+	ctx := context.WithValue(r.Context(), paramsContextKey, bestParams)
+	best.wrapped.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// notFound answers requests for paths no route matches
+func (rt *router) notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+// methodNotAllowed answers requests for a path that only has routes for
+// other methods, the Allow header lists them
+func (rt *router) methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", allowHeader(allowed))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%v is not allowed, use %v", r.Method, allowHeader(allowed)))
+}
+
+// options tells the client which methods the path allows, CORS preflight
+// requests are answered the same way
+func (rt *router) options(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", allowHeader(allowed))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowHeader lists the methods once each in alphabetical order
+func allowHeader(methods []string) string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, m := range methods {
+		if !seen[m] {
+			seen[m] = true
+			unique = append(unique, m)
+		}
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ", ")
+}
+
+func (rt route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, segment := range rt.segments {
+		if strings.HasPrefix(segment, "{") {
+			end := strings.Index(segment, "}")
+			suffix := segment[end+1:]
+			if len(path[i]) <= len(suffix) || !strings.HasSuffix(path[i], suffix) {
+				return nil, false
+			}
+			params[segment[1:end]] = strings.TrimSuffix(path[i], suffix)
+			continue
+		}
+		if segment != path[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// param returns the named path parameter captured by the router
+func param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsContextKey).(map[string]string)
+	return params[name]
+}
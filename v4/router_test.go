@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterCapturesPathParameters(t *testing.T) {
+	// Arrange
+	captured := ""
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		captured = param(r, "id")
+	})
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", "/users/42/", nil))
+
+	// Assert
+	if captured != "42" {
+		t.Errorf("expected the id parameter to be %v, got %v instead", "42", captured)
+	}
+}
+
+func TestRouterPrefersLiteralSegments(t *testing.T) {
+	// Arrange
+	matched := ""
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		matched = "show"
+	})
+	rt.handle(http.MethodGet, "/users/search", func(w http.ResponseWriter, r *http.Request) {
+		matched = "search"
+	})
+
+	// Act
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/search", nil))
+
+	// Assert
+	if matched != "search" {
+		t.Errorf("expected the literal route to match, got %v instead", matched)
+	}
+}
+
+func TestRouterRejectsUnknownPathsAndMethods(t *testing.T) {
+	// Arrange
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	rt.handle(http.MethodDelete, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	missing := httptest.NewRecorder()
+	wrongMethod := httptest.NewRecorder()
+	head := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(missing, httptest.NewRequest("GET", "/teams", nil))
+	rt.ServeHTTP(wrongMethod, httptest.NewRequest("POST", "/users/1", nil))
+	rt.ServeHTTP(head, httptest.NewRequest("HEAD", "/users/1", nil))
+
+	// Assert
+	if status := missing.Code; status != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, status)
+	}
+	if ct := missing.Header().Get("Content-Type"); ct != problemContentType {
+		t.Errorf("expected a problem for the unknown path, got %v instead", ct)
+	}
+	if status := wrongMethod.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusMethodNotAllowed, status)
+	}
+	if allow := wrongMethod.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("expected the Allow header to be %v, got %v instead", "DELETE, GET, HEAD, OPTIONS", allow)
+	}
+	if status := head.Code; status != http.StatusOK {
+		t.Errorf("expected HEAD to be served by the GET route, got %v instead", status)
+	}
+}
+
+func TestRouterMatchesSuffixedParameters(t *testing.T) {
+	// Arrange
+	matched, captured := "", ""
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/meetups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		matched = "show"
+	})
+	rt.handle(http.MethodGet, "/meetups/{id}.ics", func(w http.ResponseWriter, r *http.Request) {
+		matched, captured = "ics", param(r, "id")
+	})
+	missing := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/meetups/42.ics", nil))
+	rt.ServeHTTP(missing, httptest.NewRequest("GET", "/meetups/.ics/extra", nil))
+
+	// Assert
+	if matched != "ics" || captured != "42" {
+		t.Errorf("expected the suffixed route to capture 42, got %v %v instead", matched, captured)
+	}
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, missing.Code)
+	}
+}
+
+func TestRouterGroupsShareAPrefixAndMiddleware(t *testing.T) {
+	// Arrange
+	order := []string{}
+	tag := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	rt := newRouter()
+	admin := rt.group("/admin", tag("admin"))
+	users := admin.group("users", tag("users"))
+	users.handle(http.MethodGet, "/{id}", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler "+param(r, "id"))
+	})
+	rt.handle(http.MethodGet, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "public")
+	})
+
+	// Act
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/users/42", nil))
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+
+	// Assert
+	expected := "[admin users handler 42 public]"
+	if got := fmt.Sprint(order); got != expected {
+		t.Errorf("expected the middleware to run as %v, got %v instead", expected, got)
+	}
+}
+
+func TestRouterGroupsWrapRoutesRegisteredDirectly(t *testing.T) {
+	// Arrange
+	rt := newRouter()
+	rt.group("/admin", noStore)
+	rt.handle(http.MethodGet, "/admin/stats", func(w http.ResponseWriter, r *http.Request) {})
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/stats", nil))
+
+	// Assert
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the group's middleware to run, got %v instead", rr.Header())
+	}
+}
+
+func TestRouterBuildsGroupMiddlewareOnce(t *testing.T) {
+	// Arrange
+	built := 0
+	counted := func(next http.Handler) http.Handler {
+		built++
+		return noStore(next)
+	}
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/admin/stats", func(w http.ResponseWriter, r *http.Request) {})
+	rt.group("/admin").use(counted)
+	built = 0
+	rr := httptest.NewRecorder()
+
+	// Act
+	for i := 0; i < 3; i++ {
+		rr = httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/stats", nil))
+	}
+
+	// Assert
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected middleware added after the route to wrap it, got %v instead", rr.Header())
+	}
+	if built != 0 {
+		t.Errorf("expected the middleware to be built when it was added, got %v builds while serving instead", built)
+	}
+}
+
+func TestRouterAnswersOptionsFromTheRoutes(t *testing.T) {
+	// Arrange
+	called := false
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/meetups/{id}", func(w http.ResponseWriter, r *http.Request) { called = true })
+	rt.handle(http.MethodPatch, "/meetups/{id}", func(w http.ResponseWriter, r *http.Request) { called = true })
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("OPTIONS", "/meetups/1", nil))
+
+	// Assert
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNoContent, rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, PATCH" {
+		t.Errorf("expected the Allow header to be %v, got %v instead", "GET, HEAD, OPTIONS, PATCH", allow)
+	}
+	if called {
+		t.Errorf("expected no handler to run for OPTIONS")
+	}
+}
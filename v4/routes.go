@@ -0,0 +1,298 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// access is who may call a route
+type access int
+
+const (
+	accessPublic access = iota
+	accessOptional
+	accessUser
+	accessAdmin
+)
+
+// routeDef declares a route once, the router registration and the OpenAPI
+// document are both generated from it. The rules are the same ones the
+// handler validates its body with so the documented fields can't drift
+// from the validated ones. Partial routes validate only the fields sent, as
+// decodePartial does, so none of their fields are documented as required.
+// Routes slated for removal are marked deprecated,
+// with the date they go away as the sunset when it is known. The cache is the
+// route's Cache-Control, cachePolicy picks one when it is left blank. The
+// maxBody is the most its request body may hold, main gives the write
+// routes without one MAX_BODY_BYTES and unlimitedBody leaves the body alone. The
+// consumes are the media types its body may be, main makes the write routes
+// without any consume jsonOnly.
+type routeDef struct {
+	method     string
+	path       string
+	summary    string
+	access     access
+	rules      govalidator.MapData
+	partial    bool
+	status     int
+	deprecated bool
+	sunset     string
+	cache      string
+	maxBody    int64
+	consumes   []string
+	handler    http.HandlerFunc
+}
+
+// unlimitedBody is the maxBody of routes that stream their body or limit it
+// themselves
+const unlimitedBody int64 = -1
+
+// publicListingCache lets clients and shared caches keep the public listings
+// for a minute, they change rarely and are fetched often
+const publicListingCache = "public, max-age=60"
+
+// cachePolicy is the Cache-Control of the route. Unless it declares its own,
+// changes and anything behind a sign in are never stored, responses that
+// depend on who is asking are only kept by the client, and the rest can be
+// kept by anyone as long as it is revalidated first.
+func cachePolicy(d routeDef) string {
+	switch {
+	case d.cache != "":
+		return d.cache
+	case d.method != http.MethodGet || d.access == accessUser || d.access == accessAdmin:
+		return "no-store"
+	case d.access == accessOptional:
+		return "private, no-cache"
+	default:
+		return "no-cache"
+	}
+}
+
+// cacheControl sets the Cache-Control unless a route group set one already,
+// handlers can still override it
+func cacheControl(policy string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", policy)
+		}
+		next(w, r)
+	}
+}
+
+// registerRoutes adds every route to the router behind its access check
+func registerRoutes(rt *router, db *gorm.DB, defs []routeDef) {
+	for _, d := range defs {
+		h := d.handler
+		if len(d.consumes) > 0 {
+			h = requireContentType(d.consumes, h)
+		}
+		if d.maxBody > 0 {
+			h = limitBody(d.maxBody, h)
+		}
+		if d.deprecated {
+			h = deprecatedRoute(d, h)
+		}
+		switch d.access {
+		case accessOptional:
+			h = optionalAuth(db, h)
+		case accessUser:
+			h = requireAuth(db, h)
+		case accessAdmin:
+			h = requireAdmin(db, h)
+		}
+		rt.handle(d.method, d.path, cacheControl(cachePolicy(d), h))
+	}
+}
+
+// openAPIDocument describes the routes as an OpenAPI 3 document
+func openAPIDocument(defs []routeDef) map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	for _, d := range defs {
+		if paths[d.path] == nil {
+			paths[d.path] = map[string]interface{}{}
+		}
+
+		status := d.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		op := map[string]interface{}{
+			"summary": d.summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): map[string]string{"description": http.StatusText(status)},
+			},
+		}
+		if d.deprecated {
+			op["deprecated"] = true
+		}
+		if d.access == accessUser || d.access == accessAdmin {
+			op["security"] = []map[string][]string{{"bearer": {}}}
+		}
+		if params := pathParameters(d.path); len(params) > 0 {
+			op["parameters"] = params
+		}
+		if d.rules != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": rulesSchema(documentedRules(d))},
+				},
+			}
+		}
+
+		paths[d.path][strings.ToLower(d.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]string{"title": "Users API", "version": strconv.Itoa(currentAPIVersion)},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]string{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func pathParameters(path string) []map[string]interface{} {
+	params := []map[string]interface{}{}
+	for _, segment := range splitPath(path) {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     segment[1 : len(segment)-1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+// documentedRules are the rules of the route as clients see them, a partial
+// route only checks the fields that were sent so it requires none of them
+func documentedRules(d routeDef) govalidator.MapData {
+	if !d.partial {
+		return d.rules
+	}
+	rules := govalidator.MapData{}
+	for field, fieldRules := range d.rules {
+		rules[field] = []string{}
+		for _, rule := range fieldRules {
+			if rule != "required" {
+				rules[field] = append(rules[field], rule)
+			}
+		}
+	}
+	return rules
+}
+
+// rulesSchema converts validation rules into a JSON schema for the body
+func rulesSchema(rules govalidator.MapData) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for field, fieldRules := range rules {
+		property := map[string]interface{}{"type": "string"}
+		for _, rule := range fieldRules {
+			switch {
+			case rule == "required":
+				required = append(required, field)
+			case rule == "email":
+				property["format"] = "email"
+			case strings.HasPrefix(rule, "min:"):
+				property["minLength"], _ = strconv.Atoi(strings.TrimPrefix(rule, "min:"))
+			case strings.HasPrefix(rule, "max:"):
+				property["maxLength"], _ = strconv.Atoi(strings.TrimPrefix(rule, "max:"))
+			case strings.HasPrefix(rule, "regex:"):
+				property["pattern"] = strings.TrimPrefix(rule, "regex:")
+			}
+		}
+		properties[field] = property
+	}
+	sort.Strings(required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fieldRules is the client side view of the rules for one field, checks
+// lists the rules only the server can enforce such as reserved usernames
+type fieldRules struct {
+	Required bool     `json:"required"`
+	Min      *int     `json:"min,omitempty"`
+	Max      *int     `json:"max,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Checks   []string `json:"checks,omitempty"`
+}
+
+// endpointRules are the body rules of a route
+type endpointRules struct {
+	Method string                `json:"method"`
+	Path   string                `json:"path"`
+	Fields map[string]fieldRules `json:"fields"`
+}
+
+// describeRules converts validation rules into their client side metadata
+func describeRules(rules govalidator.MapData) map[string]fieldRules {
+	fields := map[string]fieldRules{}
+	for field, fieldRulesList := range rules {
+		f := fieldRules{}
+		for _, rule := range fieldRulesList {
+			switch {
+			case rule == "required":
+				f.Required = true
+			case rule == "email", rule == "url":
+				f.Format = rule
+			case strings.HasPrefix(rule, "min:"):
+				n, _ := strconv.Atoi(strings.TrimPrefix(rule, "min:"))
+				f.Min = &n
+			case strings.HasPrefix(rule, "max:"):
+				n, _ := strconv.Atoi(strings.TrimPrefix(rule, "max:"))
+				f.Max = &n
+			case strings.HasPrefix(rule, "regex:"):
+				f.Pattern = strings.TrimPrefix(rule, "regex:")
+			default:
+				f.Checks = append(f.Checks, rule)
+			}
+		}
+		fields[field] = f
+	}
+	return fields
+}
+
+// validationShow lists the body rules of every route that validates one,
+// taken from the same rule sets the handlers enforce
+func validationShow(defs []routeDef) http.HandlerFunc {
+	type validationShowResponse struct {
+		Endpoints []endpointRules `json:"endpoints"`
+	}
+
+	resp := validationShowResponse{Endpoints: []endpointRules{}}
+	for _, d := range defs {
+		if d.rules != nil {
+			resp.Endpoints = append(resp.Endpoints, endpointRules{Method: d.method, Path: d.path, Fields: describeRules(documentedRules(d))})
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func openAPIShow(defs []routeDef) http.HandlerFunc {
+	doc := openAPIDocument(defs)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, doc)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRegisteredRoutesRequireTheirAccess(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/open", handler: okHandler().ServeHTTP},
+		{method: http.MethodGet, path: "/private", access: accessUser, handler: okHandler().ServeHTTP},
+	})
+	tests := map[string]int{
+		"/open":    http.StatusOK,
+		"/private": http.StatusUnauthorized,
+	}
+
+	for path, expected := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		// Assert
+		if status := rr.Code; status != expected {
+			t.Errorf("expected the status code for %v to be %v, got %v instead", path, expected, status)
+		}
+	}
+}
+
+func TestTheOpenAPIDocumentIsGeneratedFromTheRoutes(t *testing.T) {
+	// Arrange
+	defs := []routeDef{
+		{method: http.MethodPost, path: "/users", rules: userStoreRules, status: http.StatusCreated},
+		{method: http.MethodDelete, path: "/users/{id}", access: accessUser, status: http.StatusNoContent},
+	}
+
+	// Act
+	doc := openAPIDocument(defs)
+
+	// Assert
+	paths := doc["paths"].(map[string]map[string]interface{})
+	store := paths["/users"]["post"].(map[string]interface{})
+	schema := store["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	if required := schema["required"]; !reflect.DeepEqual(required, []string{"email", "password"}) {
+		t.Errorf("expected email and password to be required, got %v instead", required)
+	}
+	if _, ok := store["responses"].(map[string]interface{})["201"]; !ok {
+		t.Errorf("expected a 201 response, got %v instead", store["responses"])
+	}
+	destroy := paths["/users/{id}"]["delete"].(map[string]interface{})
+	if _, ok := destroy["security"]; !ok {
+		t.Errorf("expected the delete route to require a bearer token")
+	}
+	if params := destroy["parameters"].([]map[string]interface{}); len(params) != 1 || params[0]["name"] != "id" {
+		t.Errorf("expected the id path parameter, got %v instead", params)
+	}
+}
+
+func TestValidationMetadataIsGeneratedFromTheRules(t *testing.T) {
+	// Arrange
+	defs := []routeDef{
+		{method: http.MethodPost, path: "/users", rules: userStoreRules, status: http.StatusCreated},
+		{method: http.MethodGet, path: "/users"},
+	}
+	rr := httptest.NewRecorder()
+
+	// Act
+	validationShow(defs).ServeHTTP(rr, httptest.NewRequest("GET", "/meta/validation", nil))
+
+	// Assert
+	resp := struct {
+		Endpoints []endpointRules `json:"endpoints"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Endpoints) != 1 || resp.Endpoints[0].Method != "POST" || resp.Endpoints[0].Path != "/users" {
+		t.Fatalf("expected only the endpoint with rules to be listed, got %+v instead", resp.Endpoints)
+	}
+	email := resp.Endpoints[0].Fields["email"]
+	if !email.Required || email.Format != "email" || email.Min == nil || *email.Min != 4 || email.Max == nil || *email.Max != 30 {
+		t.Errorf("expected the email rules to be mirrored, got %+v instead", email)
+	}
+	username := resp.Endpoints[0].Fields["username"]
+	if username.Required || username.Pattern == "" || !reflect.DeepEqual(username.Checks, []string{"username_not_reserved"}) {
+		t.Errorf("expected the username to be optional with a pattern and a server check, got %+v instead", username)
+	}
+	if website := resp.Endpoints[0].Fields["website"]; website.Format != "url" {
+		t.Errorf("expected the website to be formatted as a url, got %+v instead", website)
+	}
+}
+
+func TestPartialRoutesDocumentNoRequiredFields(t *testing.T) {
+	// Arrange
+	defs := []routeDef{{method: http.MethodPatch, path: "/meetups/{id}", rules: meetupRules, partial: true}}
+	rr := httptest.NewRecorder()
+
+	// Act
+	doc := openAPIDocument(defs)
+	validationShow(defs).ServeHTTP(rr, httptest.NewRequest("GET", "/meta/validation", nil))
+
+	// Assert
+	update := doc["paths"].(map[string]map[string]interface{})["/meetups/{id}"]["patch"].(map[string]interface{})
+	schema := update["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	if required, ok := schema["required"]; ok {
+		t.Errorf("expected no field to be required, got %v instead", required)
+	}
+	resp := struct {
+		Endpoints []endpointRules `json:"endpoints"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if title := resp.Endpoints[0].Fields["title"]; title.Required || title.Max == nil {
+		t.Errorf("expected the title to be optional but still limited, got %+v instead", title)
+	}
+}
+
+func TestRoutesDeclareTheirCachePolicy(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rt := newRouter()
+	rt.group("/me", noStore)
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/listing", cache: publicListingCache, handler: okHandler().ServeHTTP},
+		{method: http.MethodGet, path: "/open", handler: okHandler().ServeHTTP},
+		{method: http.MethodGet, path: "/viewer", access: accessOptional, handler: okHandler().ServeHTTP},
+		{method: http.MethodGet, path: "/private", access: accessUser, handler: okHandler().ServeHTTP},
+		{method: http.MethodPost, path: "/open", handler: okHandler().ServeHTTP},
+		{method: http.MethodGet, path: "/me/calendar.ics", cache: "no-cache", handler: okHandler().ServeHTTP},
+	})
+	tests := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{"GET", "/listing", publicListingCache},
+		{"GET", "/open", "no-cache"},
+		{"GET", "/viewer", "private, no-cache"},
+		{"GET", "/private", "no-store"},
+		{"POST", "/open", "no-store"},
+		{"GET", "/me/calendar.ics", "no-store"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		rt.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+		// Assert
+		if got := rr.Header().Get("Cache-Control"); got != tt.expected {
+			t.Errorf("expected %v %v to be served with Cache-Control %q, got %q instead", tt.method, tt.path, tt.expected, got)
+		}
+	}
+}
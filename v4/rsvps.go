@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// the statuses of an RSVP, people who RSVP to a full meetup are waitlisted
+// and let in in the order they RSVPed as places free up
+const (
+	rsvpGoing      = "going"
+	rsvpWaitlisted = "waitlisted"
+)
+
+// rsvp records that a user is going to a meetup, a user can only RSVP to a
+// meetup once. CheckedInAt is when they arrived at the door.
+type rsvp struct {
+	ID          uint   `gorm:"primary_key"`
+	MeetupID    uint   `gorm:"unique_index:idx_rsvps_meetup_user"`
+	UserID      uint   `gorm:"unique_index:idx_rsvps_meetup_user;index"`
+	Status      string `gorm:"type:varchar(20);not null;default:'going'"`
+	CheckedInAt *time.Time
+	CreatedAt   time.Time
+}
+
+// rsvpCounts counts the RSVPs of each of the meetups by status in one query,
+// the RSVPs of deleted users are left out like they are from the attendees
+func rsvpCounts(db *gorm.DB, ids []uint) map[uint]map[string]int {
+	type count struct {
+		MeetupID uint
+		Status   string
+		Total    int
+	}
+	counts := []count{}
+	db.Model(&rsvp{}).Select("rsvps.meetup_id, rsvps.status, count(*) as total").
+		Joins("JOIN users ON users.id = rsvps.user_id AND users.deleted_at IS NULL").
+		Where("rsvps.meetup_id IN (?)", ids).Group("rsvps.meetup_id, rsvps.status").Scan(&counts)
+
+	byID := make(map[uint]map[string]int, len(ids))
+	for _, c := range counts {
+		if byID[c.MeetupID] == nil {
+			byID[c.MeetupID] = map[string]int{}
+		}
+		byID[c.MeetupID][c.Status] = c.Total
+	}
+	return byID
+}
+
+// promoteWaitlist lets waitlisted people in while the meetup has room, in the
+// order they RSVPed, and returns the RSVPs it promoted
+func promoteWaitlist(tx *gorm.DB, m meetup) ([]rsvp, error) {
+	if err := lockMeetup(tx, m.ID); err != nil {
+		return nil, err
+	}
+	q := tx.Where("meetup_id = ? AND status = ?", m.ID, rsvpWaitlisted).Order("id asc")
+	if m.Capacity > 0 {
+		going := 0
+		if err := tx.Model(&rsvp{}).Where("meetup_id = ? AND status = ?", m.ID, rsvpGoing).Count(&going).Error; err != nil {
+			return nil, err
+		}
+		if going >= m.Capacity {
+			return nil, nil
+		}
+		q = q.Limit(m.Capacity - going)
+	}
+
+	promoted := []rsvp{}
+	if err := q.Find(&promoted).Error; err != nil || len(promoted) == 0 {
+		return nil, err
+	}
+	ids := make([]uint, len(promoted))
+	for i, rv := range promoted {
+		ids[i] = rv.ID
+	}
+	return promoted, tx.Model(&rsvp{}).Where("id IN (?)", ids).Update("status", rsvpGoing).Error
+}
+
+// lockMeetup holds the meetup's row until the transaction ends, so the places
+// left are counted and taken by one transaction at a time
+func lockMeetup(tx *gorm.DB, id uint) error {
+	return lockForUpdate(tx).Select("id").First(&meetup{}, id).Error
+}
+
+// notifyPromoted emails the people let in from the waitlist, failures are
+// logged since their place is already taken either way. Nobody is emailed
+// about a cancelled meetup, they were told it is off when it was cancelled.
+func notifyPromoted(db *gorm.DB, mail mailer, m meetup, promoted []rsvp) {
+	if len(promoted) == 0 || m.CancelledAt != nil {
+		return
+	}
+	ids := make([]uint, len(promoted))
+	for i, rv := range promoted {
+		ids[i] = rv.UserID
+	}
+	users := []user{}
+	db.Where("id IN (?)", ids).Find(&users)
+
+	for _, u := range users {
+		body := fmt.Sprintf("A place opened up, you're now going to %v on %v.", m.Title, m.StartsAt.Format(time.RFC1123))
+		if err := mail.send(u.Email, "You're off the waitlist", body); err != nil {
+			logDBError(db, err)
+		}
+	}
+}
+
+// rsvpResponse carries the ticket to show at the door when the user is going
+type rsvpResponse struct {
+	Meetup meetupResponse `json:"meetup"`
+	Status *string        `json:"status"`
+	Ticket string         `json:"ticket,omitempty"`
+}
+
+// rsvpsStore says the signed in user is going, or puts them on the waitlist
+// when the meetup is full. Saying so again changes nothing so clients can
+// safely retry. Cancelled meetups can't be RSVPed to.
+func rsvpsStore(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		if m.CancelledAt != nil {
+			writeAppError(w, &appError{Status: http.StatusConflict, Code: "meetup_cancelled", Message: "meetup is cancelled"})
+			return
+		}
+
+		u := currentUser(r)
+		rv := rsvp{}
+		err := transaction(tx, func(tx *gorm.DB) error {
+			if !tx.Where("meetup_id = ? AND user_id = ?", m.ID, u.ID).First(&rv).RecordNotFound() {
+				return nil
+			}
+
+			rv = rsvp{MeetupID: m.ID, UserID: u.ID, Status: rsvpGoing}
+			if m.Capacity > 0 {
+				// otherwise two RSVPs could both count the last place free
+				if err := lockMeetup(tx, m.ID); err != nil {
+					return err
+				}
+				going := 0
+				if err := tx.Model(&rsvp{}).Where("meetup_id = ? AND status = ?", m.ID, rsvpGoing).Count(&going).Error; err != nil {
+					return err
+				}
+				if going >= m.Capacity {
+					rv.Status = rsvpWaitlisted
+				}
+			}
+			return tx.Create(&rv).Error
+		})
+		// a retry racing the first request loses on the unique index, which
+		// leaves the user with the RSVP the first one made
+		if err != nil && isUniqueViolation(err) {
+			err = tx.Where("meetup_id = ? AND user_id = ?", m.ID, u.ID).First(&rv).Error
+		}
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		resp := rsvpResponse{Meetup: presentMeetups(tx, []meetup{m})[0], Status: &rv.Status}
+		if rv.Status == rsvpGoing {
+			resp.Ticket = ticketCode(m.ID, u.ID)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// rsvpsDestroy says the signed in user is no longer going, it succeeds
+// whether or not they had RSVPed. A place they free up goes to the first
+// person on the waitlist.
+func rsvpsDestroy(db *gorm.DB, mail mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		promoted := []rsvp{}
+		err := transaction(tx, func(tx *gorm.DB) error {
+			if err := tx.Where("meetup_id = ? AND user_id = ?", m.ID, currentUser(r).ID).Delete(&rsvp{}).Error; err != nil {
+				return err
+			}
+			var err error
+			promoted, err = promoteWaitlist(tx, m)
+			return err
+		})
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		notifyPromoted(tx, mail, m, promoted)
+
+		writeJSON(w, http.StatusOK, rsvpResponse{Meetup: presentMeetups(tx, []meetup{m})[0]})
+	}
+}
+
+// attendeesIndexParams are the query parameters the attendee list understands
+var attendeesIndexParams = []string{"page", "per_page", "status"}
+
+// attendeesIndex lists the people who RSVPed to a meetup, those going first
+// and then the waitlist in the order it is let in. status narrows the list to
+// one of them.
+func attendeesIndex(db *gorm.DB) http.HandlerFunc {
+	type attendee struct {
+		User     publicUser `json:"user"`
+		Status   string     `json:"status"`
+		RSVPedAt time.Time  `json:"rsvped_at"`
+	}
+	type attendeesIndexResponse struct {
+		Attendees     []attendee `json:"attendees"`
+		GoingCount    int        `json:"going_count"`
+		WaitlistCount int        `json:"waitlist_count"`
+		Pagination    pagination `json:"pagination"`
+		Links         pageLinks  `json:"links"`
+	}
+
+	policy := listPolicyFor("/meetups/{id}/attendees")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, attendeesIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		opts, errs := parseListOptions(r, policy)
+		// deleted users keep their RSVP in case they are restored, but
+		// aren't listed
+		q := tx.Joins("JOIN users ON users.id = rsvps.user_id AND users.deleted_at IS NULL").Where("rsvps.meetup_id = ?", m.ID)
+		switch status := r.URL.Query().Get("status"); status {
+		case "":
+		case rsvpGoing, rsvpWaitlisted:
+			q = q.Where("rsvps.status = ?", status)
+		default:
+			errs["status"] = append(errs["status"], fmt.Sprintf("The status field must be %v or %v", rsvpGoing, rsvpWaitlisted))
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		page := opts.paginate(q, &rsvp{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		rsvps := []rsvp{}
+		opts.apply(q).Select("rsvps.*").Find(&rsvps)
+
+		ids := make([]uint, len(rsvps))
+		for i, rv := range rsvps {
+			ids[i] = rv.UserID
+		}
+		users := []user{}
+		tx.Where("id IN (?)", ids).Find(&users)
+		byID := make(map[uint]user, len(users))
+		for _, u := range users {
+			byID[u.ID] = u
+		}
+
+		counts := rsvpCounts(tx, []uint{m.ID})[m.ID]
+		resp := attendeesIndexResponse{
+			Attendees:     make([]attendee, len(rsvps)),
+			GoingCount:    counts[rsvpGoing],
+			WaitlistCount: counts[rsvpWaitlisted],
+			Pagination:    page,
+			Links:         links,
+		}
+		for i, rv := range rsvps {
+			resp.Attendees[i] = attendee{User: newPublicUser(byID[rv.UserID]), Status: rv.Status, RSVPedAt: rv.CreatedAt}
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// rsvpsIndexParams are the query parameters the list of the user's RSVPs
+// understands
+var rsvpsIndexParams = []string{"page", "per_page", "sort", "when"}
+
+// rsvpsIndex lists the meetups the signed in user RSVPed to, with their
+// ticket when they are going. Past meetups are listed the most recent first
+// unless sorted otherwise.
+func rsvpsIndex(db *gorm.DB) http.HandlerFunc {
+	type rsvpsIndexResponse struct {
+		RSVPs      []rsvpResponse `json:"rsvps"`
+		Pagination pagination     `json:"pagination"`
+		Links      pageLinks      `json:"links"`
+	}
+
+	policy := listPolicyFor("/me/rsvps")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, rsvpsIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := dbFor(r, db)
+		u := currentUser(r)
+		opts, errs := parseListOptions(r, policy)
+		q := tx.Joins("JOIN meetups ON meetups.id = rsvps.meetup_id").Where("rsvps.user_id = ?", u.ID)
+		q, whenErrs := filterWhen(q, r, time.Now())
+		for field, messages := range whenErrs {
+			errs[field] = append(errs[field], messages...)
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort, errs := parseSort(r, policy)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		if r.URL.Query().Get("sort") == "" && r.URL.Query().Get("when") == "past" {
+			sort = "-starts_at"
+		}
+		opts.Sort = sort
+
+		page := opts.paginate(q, &rsvp{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		rsvps := []rsvp{}
+		opts.apply(q).Select("rsvps.*").Find(&rsvps)
+
+		ids := make([]uint, len(rsvps))
+		for i, rv := range rsvps {
+			ids[i] = rv.MeetupID
+		}
+		meetups := []meetup{}
+		tx.Where("id IN (?)", ids).Find(&meetups)
+		byID := make(map[uint]meetupResponse, len(meetups))
+		for _, m := range presentMeetups(tx, meetups) {
+			byID[m.ID] = m
+		}
+
+		resp := rsvpsIndexResponse{RSVPs: make([]rsvpResponse, len(rsvps)), Pagination: page, Links: links}
+		for i, rv := range rsvps {
+			status := rv.Status
+			resp.RSVPs[i] = rsvpResponse{Meetup: byID[rv.MeetupID], Status: &status}
+			if rv.Status == rsvpGoing {
+				resp.RSVPs[i].Ticket = ticketCode(rv.MeetupID, u.ID)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
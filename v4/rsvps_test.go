@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRSVPsAreIdempotent(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	attendee := createUser(db, "attendee@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	auth := login(db, attendee)
+	path := fmt.Sprintf("/meetups/%v/rsvp", m.ID)
+	responses := []*httptest.ResponseRecorder{}
+
+	// Act
+	for _, method := range []string{"POST", "POST", "DELETE", "DELETE"} {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		meetupsRouter(db).ServeHTTP(rr, req)
+		responses = append(responses, rr)
+	}
+
+	// Assert
+	for i, expected := range []int{1, 1, 0, 0} {
+		resp := struct {
+			Meetup meetupResponse `json:"meetup"`
+		}{}
+		json.Unmarshal(responses[i].Body.Bytes(), &resp)
+		if responses[i].Code != http.StatusOK || resp.Meetup.GoingCount != expected {
+			t.Errorf("expected request %v to leave %v going, got %v %v instead", i, expected, responses[i].Code, responses[i].Body.String())
+		}
+	}
+}
+
+func TestGoingCountsAreIncludedInMeetupLists(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	popular := createMeetup(db, organizer, "Popular", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	createMeetup(db, organizer, "Quiet", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	for _, email := range []string{"one@example.com", "two@example.com"} {
+		u := createUser(db, email, "somePassword1!")
+		db.Create(&rsvp{MeetupID: popular.ID, UserID: u.ID})
+	}
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", "/meetups", nil))
+
+	// Assert
+	resp := struct {
+		Meetups []meetupResponse `json:"meetups"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Meetups) != 2 || resp.Meetups[0].GoingCount != 2 || resp.Meetups[1].GoingCount != 0 {
+		t.Errorf("expected two going to the first meetup and none to the second, got %v instead", rr.Body.String())
+	}
+}
+
+func TestGoingCountsLeaveOutDeletedUsers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	kept := createUser(db, "kept@example.com", "somePassword1!")
+	deleted := createUser(db, "deleted@example.com", "somePassword1!")
+	db.Create(&rsvp{MeetupID: m.ID, UserID: kept.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: m.ID, UserID: deleted.ID, Status: rsvpGoing})
+	db.Delete(&deleted)
+
+	// Act
+	counts := rsvpCounts(db, []uint{m.ID})
+
+	// Assert
+	if got := counts[m.ID][rsvpGoing]; got != 1 {
+		t.Errorf("expected only the user who wasn't deleted to be counted, got %v instead", got)
+	}
+}
+
+func TestRSVPingToAMissingMeetupReturnsNotFound(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req := httptest.NewRequest("POST", "/meetups/42/rsvp", nil)
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestFullMeetupsWaitlistAndPromoteInOrder(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Model(&m).Update("capacity", 1)
+	mail := &recordingMailer{}
+	rt := meetupsRouterWithMailer(db, mail)
+	path := fmt.Sprintf("/meetups/%v/rsvp", m.ID)
+	statuses := []string{}
+	auths := []string{}
+	for _, email := range []string{"first@example.com", "second@example.com", "third@example.com"} {
+		auths = append(auths, login(db, createUser(db, email, "somePassword1!")))
+	}
+
+	// Act
+	for _, auth := range auths {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, req)
+		resp := rsvpResponse{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if resp.Status != nil {
+			statuses = append(statuses, *resp.Status)
+		}
+	}
+	cancel := httptest.NewRequest("DELETE", path, nil)
+	cancel.Header.Set("Authorization", auths[0])
+	rt.ServeHTTP(httptest.NewRecorder(), cancel)
+
+	// Assert
+	expected := []string{rsvpGoing, rsvpWaitlisted, rsvpWaitlisted}
+	if fmt.Sprint(statuses) != fmt.Sprint(expected) {
+		t.Errorf("expected the statuses to be %v, got %v instead", expected, statuses)
+	}
+	going := []rsvp{}
+	db.Where("meetup_id = ? AND status = ?", m.ID, rsvpGoing).Find(&going)
+	if len(going) != 1 || going[0].UserID != organizer.ID+2 {
+		t.Errorf("expected the second person to take the freed place, got %+v instead", going)
+	}
+	if mail.to != "second@example.com" {
+		t.Errorf("expected the second person to be told they are going, got %v instead", mail.to)
+	}
+}
+
+func TestRaisingTheCapacityLetsPeopleOffTheWaitlist(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	for _, email := range []string{"first@example.com", "second@example.com", "third@example.com"} {
+		u := createUser(db, email, "somePassword1!")
+		db.Create(&rsvp{MeetupID: m.ID, UserID: u.ID, Status: rsvpWaitlisted})
+	}
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/meetups/%v", m.ID), strings.NewReader(`{"capacity":2}`))
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	resp := struct {
+		Meetup meetupResponse `json:"meetup"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Meetup.Capacity != 2 || resp.Meetup.GoingCount != 2 || resp.Meetup.WaitlistCount != 1 {
+		t.Errorf("expected two going and one waitlisted, got %v instead", rr.Body.String())
+	}
+}
+
+func TestAttendeesAreListedGoingFirstThenWaitlisted(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	waitlisted := createUser(db, "waitlisted@example.com", "somePassword1!")
+	db.Create(&rsvp{MeetupID: m.ID, UserID: waitlisted.ID, Status: rsvpWaitlisted})
+	going := createUser(db, "going@example.com", "somePassword1!")
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	deleted := createUser(db, "deleted@example.com", "somePassword1!")
+	db.Create(&rsvp{MeetupID: m.ID, UserID: deleted.ID, Status: rsvpGoing})
+	db.Delete(&deleted)
+	all := httptest.NewRecorder()
+	filtered := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(all, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/attendees", m.ID), nil))
+	meetupsRouter(db).ServeHTTP(filtered, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/attendees?status=waitlisted", m.ID), nil))
+
+	// Assert
+	type response struct {
+		Attendees []struct {
+			User   publicUser `json:"user"`
+			Status string     `json:"status"`
+		} `json:"attendees"`
+		Pagination pagination `json:"pagination"`
+	}
+	allResp := response{}
+	json.Unmarshal(all.Body.Bytes(), &allResp)
+	if len(allResp.Attendees) != 2 || allResp.Attendees[0].User.ID.Key != going.ID || allResp.Attendees[1].Status != rsvpWaitlisted {
+		t.Errorf("expected the going attendee before the waitlisted one, got %v instead", all.Body.String())
+	}
+	if strings.Contains(all.Body.String(), "@example.com") {
+		t.Errorf("expected only public fields, got %v instead", all.Body.String())
+	}
+	filteredResp := response{}
+	json.Unmarshal(filtered.Body.Bytes(), &filteredResp)
+	if len(filteredResp.Attendees) != 1 || filteredResp.Attendees[0].User.ID.Key != waitlisted.ID || filteredResp.Pagination.Total != 1 {
+		t.Errorf("expected only the waitlisted attendee, got %v instead", filtered.Body.String())
+	}
+}
+
+func TestAttendeesCanOnlyBeFilteredByAKnownStatus(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v/attendees?status=maybe", m.ID), nil))
+
+	// Assert
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestUsersListTheMeetupsTheyRSVPedTo(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	u := createUser(db, "attendee@example.com", "somePassword1!")
+	now := time.Now()
+	past := createMeetup(db, organizer, "Last month", now.AddDate(0, -1, 0))
+	upcoming := createMeetup(db, organizer, "Next month", now.AddDate(0, 1, 0))
+	createMeetup(db, organizer, "Not going", now.AddDate(0, 2, 0))
+	db.Create(&rsvp{MeetupID: past.ID, UserID: u.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: upcoming.ID, UserID: u.ID, Status: rsvpWaitlisted})
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/me/rsvps", access: accessUser, handler: rsvpsIndex(db)},
+	})
+	req := httptest.NewRequest("GET", "/me/rsvps?when=upcoming", nil)
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, req)
+
+	// Assert
+	resp := struct {
+		RSVPs      []rsvpResponse `json:"rsvps"`
+		Pagination pagination     `json:"pagination"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.RSVPs) != 1 || resp.RSVPs[0].Meetup.Title != "Next month" || *resp.RSVPs[0].Status != rsvpWaitlisted || resp.RSVPs[0].Ticket != "" {
+		t.Errorf("expected the upcoming meetup without a ticket, got %v instead", rr.Body.String())
+	}
+	if resp.Pagination.Total != 1 {
+		t.Errorf("expected a total of 1, got %v instead", resp.Pagination.Total)
+	}
+}
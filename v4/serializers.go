@@ -0,0 +1,232 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// publicUser is how a user appears to guests and to other users
+type publicUser struct {
+	ID        apiID             `json:"id"`
+	Username  *string           `json:"username"`
+	Name      string            `json:"name"`
+	Bio       string            `json:"bio"`
+	Location  string            `json:"location"`
+	Website   string            `json:"website"`
+	Twitter   string            `json:"twitter"`
+	GitHub    string            `json:"github"`
+	AvatarURL string            `json:"avatar_url"`
+	Avatars   map[string]string `json:"avatars"`
+	Gravatar  string            `json:"gravatar_url,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	// Meetups is only sent for ?include=meetups
+	Meetups *[]meetupResponse `json:"meetups,omitempty"`
+	// Posts is only sent for ?include=posts
+	Posts *[]commentResponse `json:"posts,omitempty"`
+}
+
+// privateUser is how users see their own account
+type privateUser struct {
+	publicUser
+	Email           string     `json:"email"`
+	PendingEmail    string     `json:"pending_email,omitempty"`
+	LastLoginAt     *time.Time `json:"last_login_at"`
+	LastLoginIP     string     `json:"last_login_ip"`
+	LastLoginDevice string     `json:"last_login_device"`
+}
+
+// adminUser is how administrators see any account, who created and last
+// updated it are shown by ID like every other user
+type adminUser struct {
+	privateUser
+	Admin     bool       `json:"admin"`
+	CreatedBy *apiID     `json:"created_by"`
+	UpdatedBy *apiID     `json:"updated_by"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+func newPublicUser(u user) publicUser {
+	p := publicUser{
+		ID:        idOf(u),
+		Username:  u.Username,
+		Name:      u.Name,
+		Bio:       u.Bio,
+		Location:  u.Location,
+		Website:   u.Website,
+		Twitter:   u.Twitter,
+		GitHub:    u.GitHub,
+		AvatarURL: u.AvatarURL,
+		Avatars:   avatarVariantURLs(u),
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+	if u.AvatarURL == "" && gravatarFallback {
+		p.Gravatar = gravatarURL(u.Email)
+	}
+	return p
+}
+
+func newPrivateUser(u user) privateUser {
+	return privateUser{
+		publicUser:      newPublicUser(u),
+		Email:           u.Email,
+		PendingEmail:    u.PendingEmail,
+		LastLoginAt:     u.LastLoginAt,
+		LastLoginIP:     u.LastLoginIP,
+		LastLoginDevice: u.LastLoginDevice,
+	}
+}
+
+func newAdminUser(u user, actors map[uint]apiID) adminUser {
+	return adminUser{
+		privateUser: newPrivateUser(u),
+		Admin:       u.Admin,
+		CreatedBy:   actorID(u.CreatedByID, actors),
+		UpdatedBy:   actorID(u.UpdatedByID, actors),
+		DeletedAt:   u.DeletedAt,
+	}
+}
+
+// presentUser picks the representation of the user the viewer is allowed to
+// see, the viewer is nil for guests
+func presentUser(db *gorm.DB, u user, viewer *user) interface{} {
+	return presentUsers(db, []user{u}, viewer)[0]
+}
+
+func presentUsers(db *gorm.DB, users []user, viewer *user) []interface{} {
+	var actors map[uint]apiID
+	if viewer != nil && viewer.Admin {
+		actors = actorIDs(db, users)
+	}
+	organized := organizedMeetups(db, users)
+	posted := postedComments(db, users)
+	out := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		out = append(out, presentUserWith(u, viewer, actors, userRelations{meetups: organized[u.ID], posts: posted[u.ID]}))
+	}
+	return out
+}
+
+// userRelations are the related resources included with a user, a nil one
+// wasn't asked for while an empty one means the user has none
+type userRelations struct {
+	meetups []meetupResponse
+	posts   []commentResponse
+}
+
+func presentUserWith(u user, viewer *user, actors map[uint]apiID, rel userRelations) interface{} {
+	switch {
+	case viewer != nil && viewer.Admin:
+		a := newAdminUser(u, actors)
+		includeRelations(&a.publicUser, rel)
+		return a
+	case viewer != nil && viewer.ID == u.ID:
+		p := newPrivateUser(u)
+		includeRelations(&p.publicUser, rel)
+		return p
+	default:
+		p := newPublicUser(u)
+		includeRelations(&p, rel)
+		return p
+	}
+}
+
+// includeRelations adds the related resources that were asked for to the user
+func includeRelations(p *publicUser, rel userRelations) {
+	if rel.meetups != nil {
+		p.Meetups = &rel.meetups
+	}
+	if rel.posts != nil {
+		p.Posts = &rel.posts
+	}
+}
+
+// organizedMeetups presents the meetups preloaded for ?include=meetups by
+// organizer, all of them at once. Users loaded without them get none.
+func organizedMeetups(db *gorm.DB, users []user) map[uint][]meetupResponse {
+	all := []meetup{}
+	loaded := map[uint]bool{}
+	for _, u := range users {
+		if u.Meetups != nil {
+			all = append(all, u.Meetups...)
+			loaded[u.ID] = true
+		}
+	}
+	organized := map[uint][]meetupResponse{}
+	if len(loaded) == 0 {
+		return organized
+	}
+	for id := range loaded {
+		organized[id] = []meetupResponse{}
+	}
+	for _, m := range presentMeetups(db, all) {
+		organized[m.OrganizerID.Key] = append(organized[m.OrganizerID.Key], m)
+	}
+	return organized
+}
+
+// postedComments presents the comments preloaded for ?include=posts by
+// author, loading their authors in one query. Users loaded without them get
+// none.
+func postedComments(db *gorm.DB, users []user) map[uint][]commentResponse {
+	all := []comment{}
+	posted := map[uint][]commentResponse{}
+	for _, u := range users {
+		if u.Posts != nil {
+			all = append(all, u.Posts...)
+			posted[u.ID] = []commentResponse{}
+		}
+	}
+	if len(posted) == 0 {
+		return posted
+	}
+	for i, c := range presentComments(db, all) {
+		posted[all[i].AuthorID] = append(posted[all[i].AuthorID], c)
+	}
+	return posted
+}
+
+// actorIDs loads the IDs of the users who created and last updated the
+// users, keyed by their key
+func actorIDs(db *gorm.DB, users []user) map[uint]apiID {
+	keys := []uint{}
+	for _, u := range users {
+		if u.CreatedByID != nil {
+			keys = append(keys, *u.CreatedByID)
+		}
+		if u.UpdatedByID != nil {
+			keys = append(keys, *u.UpdatedByID)
+		}
+	}
+	if len(keys) == 0 {
+		return map[uint]apiID{}
+	}
+	return loadActorIDs(db.Where("id IN (?)", keys))
+}
+
+// loadActorIDs loads the IDs of the users the query matches, deleted ones
+// included since they may still be named as an actor
+func loadActorIDs(query *gorm.DB) map[uint]apiID {
+	actors := []user{}
+	query.Unscoped().Select("id, uuid").Find(&actors)
+	ids := make(map[uint]apiID, len(actors))
+	for _, a := range actors {
+		ids[a.ID] = idOf(a)
+	}
+	return ids
+}
+
+// actorID is the ID of the user with the key, users that are gone for good
+// are left out rather than shown by key
+func actorID(key *uint, actors map[uint]apiID) *apiID {
+	if key == nil {
+		return nil
+	}
+	id, ok := actors[*key]
+	if !ok {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsersCannotSeeOtherUsersEmailsInTheIndex(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	viewer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	createUser(db, "someone@else.io", "somePassword1!")
+	req, err := http.NewRequest("GET", "/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", login(db, viewer))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(optionalAuth(db, usersIndex(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if strings.Contains(rr.Body.String(), "someone@else.io") {
+		t.Errorf("expected other users emails to be hidden, got %v instead", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "jason@mccallister.io") {
+		t.Errorf("expected the viewers own email to be returned, got %v instead", rr.Body.String())
+	}
+}
+
+func TestGuestsCannotSeeEmailsInTheIndex(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "jason@mccallister.io", "somePassword1!")
+	req, err := http.NewRequest("GET", "/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(optionalAuth(db, usersIndex(db)))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if strings.Contains(rr.Body.String(), "email") {
+		t.Errorf("expected emails to be hidden from guests, got %v instead", rr.Body.String())
+	}
+}
+
+func TestTheRepresentationDependsOnTheViewer(t *testing.T) {
+	// Arrange
+	owner := user{ID: 1, Email: "jason@mccallister.io"}
+	other := user{ID: 2, Email: "someone@else.io"}
+	admin := user{ID: 3, Email: "admin@mccallister.io", Admin: true}
+
+	// Act
+	guestView := presentUser(nil, owner, nil)
+	otherView := presentUser(nil, owner, &other)
+	ownerView := presentUser(nil, owner, &owner)
+	adminView := presentUser(nil, owner, &admin)
+
+	// Assert
+	if _, ok := guestView.(publicUser); !ok {
+		t.Errorf("expected guests to get a public user, got %T instead", guestView)
+	}
+	if _, ok := otherView.(publicUser); !ok {
+		t.Errorf("expected other users to get a public user, got %T instead", otherView)
+	}
+	if _, ok := ownerView.(privateUser); !ok {
+		t.Errorf("expected the owner to get a private user, got %T instead", ownerView)
+	}
+	if v, ok := adminView.(adminUser); !ok || v.Email != owner.Email {
+		t.Errorf("expected admins to get an admin user with the email, got %#v instead", adminView)
+	}
+}
+
+func TestStoredUsersNeverExposeThePasswordHash(t *testing.T) {
+	// Arrange
+	u := user{ID: 1, Email: "jason@mccallister.io", Password: "$2a$04$hash", Admin: true}
+
+	// Act
+	data, err := json.Marshal(presentUser(nil, u, &u))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if strings.Contains(string(data), "hash") {
+		t.Errorf("expected the password hash to be omitted, got %v instead", string(data))
+	}
+}
+
+func TestUsersWithoutAnAvatarFallBackToGravatar(t *testing.T) {
+	// Arrange
+	defer func() { gravatarFallback = false }()
+	withoutAvatar := user{Email: " Jason@McCallister.io"}
+	withAvatar := user{Email: "jason@mccallister.io", AvatarURL: "/avatars/1-abc.png"}
+
+	// Act
+	disabled := newPublicUser(withoutAvatar)
+	gravatarFallback = true
+	enabled := newPublicUser(withoutAvatar)
+	uploaded := newPublicUser(withAvatar)
+
+	// Assert
+	if disabled.Gravatar != "" {
+		t.Errorf("expected no gravatar while the fallback is off, got %v instead", disabled.Gravatar)
+	}
+	if expected := "https://www.gravatar.com/avatar/9c77418009756c7c1da9f9b96177c4a1?d=identicon"; enabled.Gravatar != expected {
+		t.Errorf("expected the gravatar of the lower cased email, got %v instead", enabled.Gravatar)
+	}
+	if uploaded.Gravatar != "" {
+		t.Errorf("expected no gravatar for users with an avatar, got %v instead", uploaded.Gravatar)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// priority ranks requests by how important it is to keep serving them when
+// the server is overloaded, higher priorities are shed last
+type priority int
+
+const (
+	priorityExport priority = iota
+	priorityRead
+	priorityWrite
+	priorityAuth
+	priorityHealth
+)
+
+// authPaths are the routes that let people sign up and sign in
+var authPaths = map[string]bool{
+	"/login":            true,
+	"/logout":           true,
+	"/me/password":      true,
+	"/account/restore":  true,
+	"/me/email/confirm": true,
+}
+
+// classify assigns the request a priority from its method and path
+func classify(r *http.Request) priority {
+	switch {
+	case r.URL.Path == "/healthz", r.URL.Path == "/readyz":
+		return priorityHealth
+	case authPaths[r.URL.Path], r.URL.Path == "/users" && r.Method == http.MethodPost:
+		return priorityAuth
+	case strings.Contains(r.URL.Path, "/export"):
+		return priorityExport
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return priorityRead
+	default:
+		return priorityWrite
+	}
+}
+
+// loadShedder turns away low priority requests as the server becomes
+// overloaded, measured by the requests in flight and the recent latency
+type loadShedder struct {
+	maxInFlight int
+	maxLatency  time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	latency  time.Duration
+}
+
+func newLoadShedder(maxInFlight int, maxLatency time.Duration) *loadShedder {
+	return &loadShedder{maxInFlight: maxInFlight, maxLatency: maxLatency}
+}
+
+// pressure is how close the server is to its limits, 1 means at capacity
+func (ls *loadShedder) pressure() float64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	p := float64(ls.inFlight) / float64(ls.maxInFlight)
+	if l := float64(ls.latency) / float64(ls.maxLatency); l > p {
+		p = l
+	}
+	return p
+}
+
+// minimumPriority is the lowest priority still served at the pressure
+func minimumPriority(pressure float64) priority {
+	switch {
+	case pressure < 0.5:
+		return priorityExport
+	case pressure < 0.75:
+		return priorityRead
+	case pressure < 0.9:
+		return priorityWrite
+	case pressure < 1:
+		return priorityAuth
+	default:
+		return priorityHealth
+	}
+}
+
+func (ls *loadShedder) start() {
+	ls.mu.Lock()
+	ls.inFlight++
+	ls.mu.Unlock()
+}
+
+// finish records the request latency as an exponentially weighted average
+func (ls *loadShedder) finish(d time.Duration) {
+	ls.mu.Lock()
+	ls.inFlight--
+	ls.latency = ls.latency*9/10 + d/10
+	ls.mu.Unlock()
+}
+
+func (ls *loadShedder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if classify(r) < minimumPriority(ls.pressure()) {
+			shedRequestsMetric.Add(1)
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusServiceUnavailable, "service is under heavy load")
+			return
+		}
+
+		start := time.Now()
+		ls.start()
+		defer func() { ls.finish(time.Since(start)) }()
+
+		next.ServeHTTP(w, r)
+	})
+}
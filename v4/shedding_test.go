@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestsAreClassifiedByPriority(t *testing.T) {
+	requests := map[*http.Request]priority{
+		httptest.NewRequest("GET", "/healthz", nil):                          priorityHealth,
+		httptest.NewRequest("POST", "/login", nil):                           priorityAuth,
+		httptest.NewRequest("POST", "/users", nil):                           priorityAuth,
+		httptest.NewRequest("PATCH", "/users/1", nil):                        priorityWrite,
+		httptest.NewRequest("GET", "/users/1", nil):                          priorityRead,
+		httptest.NewRequest("GET", "/admin/users/export?format=ndjson", nil): priorityExport,
+	}
+
+	for r, expected := range requests {
+		if got := classify(r); got != expected {
+			t.Errorf("expected %v %v to have priority %v, got %v instead", r.Method, r.URL.Path, expected, got)
+		}
+	}
+}
+
+func TestLowPriorityRequestsAreShedFirst(t *testing.T) {
+	// Arrange
+	ls := newLoadShedder(10, time.Second)
+	handler := ls.middleware(okHandler())
+	for i := 0; i < 8; i++ {
+		ls.start()
+	}
+
+	// Act
+	read := httptest.NewRecorder()
+	handler.ServeHTTP(read, httptest.NewRequest("GET", "/users/1", nil))
+	signup := httptest.NewRecorder()
+	handler.ServeHTTP(signup, httptest.NewRequest("POST", "/users", nil))
+
+	// Assert
+	if status := read.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusServiceUnavailable, status)
+	}
+	if status := signup.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+}
+
+func TestHealthChecksAreNeverShed(t *testing.T) {
+	// Arrange
+	ls := newLoadShedder(10, time.Second)
+	handler := ls.middleware(okHandler())
+	ls.start()
+	ls.finish(100 * time.Second)
+
+	// Act
+	health := httptest.NewRecorder()
+	handler.ServeHTTP(health, httptest.NewRequest("GET", "/healthz", nil))
+	login := httptest.NewRecorder()
+	handler.ServeHTTP(login, httptest.NewRequest("POST", "/login", nil))
+
+	// Assert
+	if status := health.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if status := login.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusServiceUnavailable, status)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serveUntil serves on the listener until a signal arrives on stop, then
+// stops accepting connections and gives the requests in flight up to the
+// timeout to finish. Connections still open after that are cut off. A server
+// with a TLS configuration serves HTTPS with its certificates.
+func serveUntil(srv *http.Server, l net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ServeTLS(l, "", "")
+			return
+		}
+		errs <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("received %v, draining requests for up to %v", sig, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return err
+	}
+	return nil
+}
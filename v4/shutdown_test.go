@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsRequestsInFlight(t *testing.T) {
+	// Arrange
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() { done <- serveUntil(srv, l, stop, time.Second) }()
+	status := make(chan int)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	// Act
+	<-started
+	stop <- os.Interrupt
+
+	// Assert
+	if code := <-status; code != http.StatusNoContent {
+		t.Errorf("expected the request in flight to finish, got %v instead", code)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected the server to shut down cleanly, got %v instead", err)
+	}
+	if _, err := http.Get("http://" + l.Addr().String()); err == nil {
+		t.Errorf("expected new connections to be refused after the shutdown")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+)
+
+const matchedRouteContextKey contextKey = "matched_route"
+
+// slowRequestsMetric counts the slow requests by route, published with
+// expvar at /admin/debug/vars
+var slowRequestsMetric = expvar.NewMap("slow_requests")
+
+// slowRequests logs a warning for every request that takes longer than
+// threshold, with its route, status and the time spent in the database. It
+// needs to run inside serverTiming to see the database time.
+func slowRequests(threshold time.Duration) middleware {
+	return func(next http.Handler) http.Handler {
+		if threshold <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			matched := ""
+			cw := &countingWriter{ResponseWriter: w, status: http.StatusOK}
+			ctx := context.WithValue(r.Context(), matchedRouteContextKey, &matched)
+
+			next.ServeHTTP(cw, r.WithContext(ctx))
+
+			elapsed := time.Since(start)
+			if elapsed < threshold {
+				return
+			}
+			// routes rather than paths so IDs don't make every request its
+			// own entry
+			route := r.Method + " " + matched
+			if matched == "" {
+				route = "unmatched"
+			}
+			var db time.Duration
+			if t := timingsFrom(r); t != nil {
+				db = t.phase("db")
+			}
+			slowRequestsMetric.Add(route, 1)
+			log.Printf("slow request: %v took %.2fms, %.2fms in the database, status %v, request %v",
+				route, milliseconds(elapsed), milliseconds(db), cw.status, requestIDFrom(r))
+		})
+	}
+}
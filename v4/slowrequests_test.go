@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestsAreLoggedByRoute(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/meetups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		defer startPhase(r, "db")()
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	})
+	rt.handle(http.MethodGet, "/tags", func(w http.ResponseWriter, r *http.Request) {})
+	handler := serverTiming(slowRequests(10 * time.Millisecond)(rt))
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/meetups/42", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tags", nil))
+
+	// Assert
+	line := logs.String()
+	if !strings.Contains(line, "slow request: GET /meetups/{id}") || !strings.Contains(line, "status 418") {
+		t.Errorf("expected the slow request to be logged with its route and status, got %v instead", line)
+	}
+	if strings.Contains(line, "0.00ms in the database") {
+		t.Errorf("expected the database time to be logged, got %v instead", line)
+	}
+	if strings.Contains(line, "/tags") {
+		t.Errorf("expected fast requests not to be logged, got %v instead", line)
+	}
+	if got := slowRequestsMetric.Get("GET /meetups/{id}"); got == nil || got.String() == "0" {
+		t.Errorf("expected the slow request to be counted, got %v instead", got)
+	}
+}
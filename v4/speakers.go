@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// speakersShow shows the public profile of a user who has spoken, or is due
+// to speak, at a meetup along with their accepted talks, the most recent
+// first. Users without an accepted talk aren't speakers.
+func speakersShow(db *gorm.DB) http.HandlerFunc {
+	type talkMeetup struct {
+		ID       uint      `json:"id"`
+		Title    string    `json:"title"`
+		StartsAt time.Time `json:"starts_at"`
+	}
+	type speakerTalk struct {
+		ID       uint       `json:"id"`
+		Title    string     `json:"title"`
+		Abstract string     `json:"abstract"`
+		Duration int        `json:"duration"`
+		Meetup   talkMeetup `json:"meetup"`
+	}
+	type speaker struct {
+		publicUser
+		Talks []speakerTalk `json:"talks"`
+	}
+	type speakerShowResponse struct {
+		Speaker speaker `json:"speaker"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		u := user{}
+		if parseAPIID(param(r, "id")).where(tx).First(&u).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "speaker not found")
+			return
+		}
+
+		talks := []talk{}
+		tx.Joins("JOIN meetups ON meetups.id = talks.meetup_id").
+			Where("talks.speaker_id = ? AND talks.status = ?", u.ID, talkAccepted).
+			Order("meetups.starts_at desc").
+			Select("talks.*").
+			Find(&talks)
+		if len(talks) == 0 {
+			writeError(w, http.StatusNotFound, "speaker not found")
+			return
+		}
+
+		ids := make([]uint, len(talks))
+		for i, t := range talks {
+			ids[i] = t.MeetupID
+		}
+		meetups := []meetup{}
+		tx.Where("id IN (?)", ids).Find(&meetups)
+		byID := make(map[uint]meetup, len(meetups))
+		for _, m := range meetups {
+			byID[m.ID] = m
+		}
+
+		resp := speakerShowResponse{Speaker: speaker{publicUser: newPublicUser(u), Talks: make([]speakerTalk, len(talks))}}
+		for i, t := range talks {
+			m := byID[t.MeetupID]
+			resp.Speaker.Talks[i] = speakerTalk{
+				ID:       t.ID,
+				Title:    t.Title,
+				Abstract: t.Abstract,
+				Duration: t.Duration,
+				Meetup:   talkMeetup{ID: m.ID, Title: m.Title, StartsAt: m.StartsAt},
+			}
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func speakersRouter(db *gorm.DB) *router {
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/speakers/{id}", handler: speakersShow(db)},
+	})
+	return rt
+}
+
+func TestSpeakersAreShownWithTheirAcceptedTalks(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	speaker := createUser(db, "speaker@example.com", "somePassword1!")
+	db.Model(&speaker).Updates(map[string]interface{}{"bio": "Gopher", "twitter": "gopher", "git_hub": "gopher"})
+	october := createMeetup(db, organizer, "October", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	november := createMeetup(db, organizer, "November", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	db.Create(&talk{MeetupID: october.ID, SpeakerID: speaker.ID, Title: "Modules", Duration: 20, Status: talkAccepted})
+	db.Create(&talk{MeetupID: november.ID, SpeakerID: speaker.ID, Title: "Generics", Duration: 20, Status: talkAccepted})
+	db.Create(&talk{MeetupID: november.ID, SpeakerID: speaker.ID, Title: "Rejected", Duration: 20, Status: talkRejected})
+	rr := httptest.NewRecorder()
+
+	// Act
+	speakersRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/speakers/%v", speaker.ID), nil))
+
+	// Assert
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, rr.Code)
+	}
+	resp := struct {
+		Speaker struct {
+			Bio     string `json:"bio"`
+			Twitter string `json:"twitter"`
+			GitHub  string `json:"github"`
+			Talks   []struct {
+				Title  string `json:"title"`
+				Meetup struct {
+					Title string `json:"title"`
+				} `json:"meetup"`
+			} `json:"talks"`
+		} `json:"speaker"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Speaker.Bio != "Gopher" || resp.Speaker.Twitter != "gopher" || resp.Speaker.GitHub != "gopher" {
+		t.Errorf("expected the speaker's profile, got %v instead", rr.Body.String())
+	}
+	if len(resp.Speaker.Talks) != 2 || resp.Speaker.Talks[0].Title != "Generics" || resp.Speaker.Talks[1].Meetup.Title != "October" {
+		t.Errorf("expected the accepted talks newest first, got %v instead", rr.Body.String())
+	}
+}
+
+func TestUsersWithoutAcceptedTalksAreNotSpeakers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "October", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&talk{MeetupID: m.ID, SpeakerID: organizer.ID, Title: "Pending", Duration: 20, Status: talkSubmitted})
+	rr := httptest.NewRecorder()
+
+	// Act
+	speakersRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/speakers/%v", organizer.ID), nil))
+
+	// Assert
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, rr.Code)
+	}
+}
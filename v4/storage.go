@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storage keeps uploaded files and returns the URL each one is served from,
+// local disk is the only implementation so far
+type storage interface {
+	put(name string, r io.Reader) (string, error)
+	remove(url string) error
+}
+
+// localStorage writes files into dir and serves them under baseURL
+type localStorage struct {
+	dir     string
+	baseURL string
+}
+
+func newLocalStorage(dir, baseURL string) *localStorage {
+	return &localStorage{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// put writes the file to a temporary name first so a failed upload never
+// leaves half a file behind
+func (s *localStorage) put(name string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, filepath.Base(name))); err != nil {
+		return "", err
+	}
+
+	return s.baseURL + "/" + filepath.Base(name), nil
+}
+
+// remove deletes the file put served from url, URLs it didn't hand out and
+// files already gone are ignored
+func (s *localStorage) remove(url string) error {
+	if !strings.HasPrefix(url, s.baseURL+"/") {
+		return nil
+	}
+	err := os.Remove(filepath.Join(s.dir, filepath.Base(strings.TrimPrefix(url, s.baseURL+"/"))))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// serve returns the handler for the files under baseURL, the name is taken
+// from the {name} path parameter
+func (s *localStorage) serve() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(param(r, "name"))
+		if strings.HasPrefix(name, ".") {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(s.dir, name))
+	}
+}
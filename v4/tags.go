@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxMeetupTags caps how many tags a meetup can have
+const maxMeetupTags = 10
+
+// tagPattern is what a tag looks like once normalized, lowercase words
+// joined by hyphens such as "golang" or "web-dev"
+var tagPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// tag categorizes meetups, tags are created the first time a meetup uses one
+type tag struct {
+	ID        uint   `gorm:"primary_key"`
+	Name      string `gorm:"type:varchar(30);unique_index"`
+	CreatedAt time.Time
+}
+
+// meetupTag links a meetup to one of its tags
+type meetupTag struct {
+	MeetupID uint `gorm:"primary_key;auto_increment:false"`
+	TagID    uint `gorm:"primary_key;auto_increment:false;index"`
+}
+
+// normalizeTags lowercases the tags and drops duplicates, keeping the order
+// they were given in
+func normalizeTags(tags []string) ([]string, []string) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, t := range tags {
+		name := strings.ToLower(strings.TrimSpace(t))
+		if len(name) > 30 || !tagPattern.MatchString(name) {
+			return nil, []string{fmt.Sprintf("The tag %q must be up to 30 letters, digits and hyphens", t)}
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) > maxMeetupTags {
+		return nil, []string{fmt.Sprintf("A meetup can have up to %v tags", maxMeetupTags)}
+	}
+	return names, nil
+}
+
+// setMeetupTags replaces the tags of the meetup, creating the ones that
+// don't exist yet
+func setMeetupTags(tx *gorm.DB, meetupID uint, names []string) error {
+	if err := tx.Where("meetup_id = ?", meetupID).Delete(&meetupTag{}).Error; err != nil {
+		return err
+	}
+	for _, name := range names {
+		t := tag{}
+		if err := tx.Where(tag{Name: name}).FirstOrCreate(&t).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&meetupTag{MeetupID: meetupID, TagID: t.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// meetupTags loads the tag names of each of the meetups in one query, in
+// alphabetical order
+func meetupTags(db *gorm.DB, ids []uint) map[uint][]string {
+	type row struct {
+		MeetupID uint
+		Name     string
+	}
+	rows := []row{}
+	db.Table("meetup_tags").Select("meetup_tags.meetup_id, tags.name").
+		Joins("JOIN tags ON tags.id = meetup_tags.tag_id").
+		Where("meetup_tags.meetup_id IN (?)", ids).
+		Scan(&rows)
+
+	byID := make(map[uint][]string, len(ids))
+	for _, r := range rows {
+		byID[r.MeetupID] = append(byID[r.MeetupID], r.Name)
+	}
+	for _, names := range byID {
+		sort.Strings(names)
+	}
+	return byID
+}
+
+// taggedWith narrows a query of meetups to those with the tag
+func taggedWith(q *gorm.DB, name string) *gorm.DB {
+	tagged := q.New().Table("meetup_tags").Select("meetup_tags.meetup_id").
+		Joins("JOIN tags ON tags.id = meetup_tags.tag_id").
+		Where("tags.name = ?", strings.ToLower(name))
+	return q.Where("meetups.id IN (?)", tagged.SubQuery())
+}
+
+// tagsIndexParams are the query parameters the tag list understands
+var tagsIndexParams = []string{"page", "per_page", "sort"}
+
+// tagsIndex lists the tags with how many meetups use each, the most used
+// first
+func tagsIndex(db *gorm.DB) http.HandlerFunc {
+	type tagResponse struct {
+		Name        string `json:"name"`
+		MeetupCount int    `json:"meetup_count"`
+	}
+	type tagsIndexResponse struct {
+		Tags       []tagResponse `json:"tags"`
+		Pagination pagination    `json:"pagination"`
+		Links      pageLinks     `json:"links"`
+	}
+
+	policy := listPolicyFor("/tags")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, tagsIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts, errs := parseListOptions(r, policy)
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort, errs := parseSort(r, policy)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts.Sort = sort
+
+		tx := dbFor(r, db)
+		page := opts.paginate(tx, &tag{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		tags := []tagResponse{}
+		q := tx.Table("tags").Select("tags.name, count(meetup_tags.meetup_id) as meetup_count").
+			Joins("LEFT JOIN meetup_tags ON meetup_tags.tag_id = tags.id").
+			Group("tags.id, tags.name")
+		opts.apply(q).Scan(&tags)
+
+		writeJSON(w, http.StatusOK, tagsIndexResponse{Tags: tags, Pagination: page, Links: links})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeetupsCanBeTagged(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	auth := login(db, u)
+	store := httptest.NewRequest("POST", "/meetups", strings.NewReader(`{"title":"Norfolk Gophers","starts_at":"2019-10-15T18:30:00Z","tags":["Golang","testing","golang"]}`))
+	store.Header.Set("Authorization", auth)
+	stored := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(stored, store)
+	resp := struct {
+		Meetup meetupResponse `json:"meetup"`
+	}{}
+	json.Unmarshal(stored.Body.Bytes(), &resp)
+	update := httptest.NewRequest("PATCH", fmt.Sprintf("/meetups/%v", resp.Meetup.ID), strings.NewReader(`{"tags":["golang","web-dev"]}`))
+	update.Header.Set("Authorization", auth)
+	updated := httptest.NewRecorder()
+	meetupsRouter(db).ServeHTTP(updated, update)
+
+	// Assert
+	if fmt.Sprint(resp.Meetup.Tags) != "[golang testing]" {
+		t.Errorf("expected the tags to be normalized, got %v instead", stored.Body.String())
+	}
+	json.Unmarshal(updated.Body.Bytes(), &resp)
+	if fmt.Sprint(resp.Meetup.Tags) != "[golang web-dev]" {
+		t.Errorf("expected the tags to be replaced, got %v instead", updated.Body.String())
+	}
+}
+
+func TestTagsMustBeWords(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req := httptest.NewRequest("POST", "/meetups", strings.NewReader(`{"title":"Norfolk Gophers","starts_at":"2019-10-15T18:30:00Z","tags":["go lang"]}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "tags") {
+		t.Errorf("expected the tag to be rejected, got %v %v instead", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMeetupsCanBeFilteredByTag(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	gophers := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	rustaceans := createMeetup(db, u, "Rustaceans", time.Date(2019, 10, 16, 18, 30, 0, 0, time.UTC))
+	setMeetupTags(db, gophers.ID, []string{"golang", "testing"})
+	setMeetupTags(db, rustaceans.ID, []string{"rust", "testing"})
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", "/meetups?tag=golang", nil))
+
+	// Assert
+	resp := struct {
+		Meetups []meetupResponse `json:"meetups"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Meetups) != 1 || resp.Meetups[0].Title != "Norfolk Gophers" {
+		t.Errorf("expected only the golang meetup, got %v instead", rr.Body.String())
+	}
+}
+
+func TestTagsAreListedWithTheirUsage(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	gophers := createMeetup(db, u, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	rustaceans := createMeetup(db, u, "Rustaceans", time.Date(2019, 10, 16, 18, 30, 0, 0, time.UTC))
+	setMeetupTags(db, gophers.ID, []string{"golang", "testing"})
+	setMeetupTags(db, rustaceans.ID, []string{"rust", "testing"})
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/tags", handler: tagsIndex(db)},
+	})
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", "/tags", nil))
+
+	// Assert
+	expected := `"tags":[{"name":"testing","meetup_count":2},{"name":"golang","meetup_count":1},{"name":"rust","meetup_count":1}]`
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("expected the body to contain %v, got %v instead", expected, rr.Body.String())
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// the statuses of a talk, organizers decide on submitted talks
+const (
+	talkSubmitted = "submitted"
+	talkAccepted  = "accepted"
+	talkRejected  = "rejected"
+)
+
+// talk is a proposal a user submits to speak at a meetup
+type talk struct {
+	ID        uint   `gorm:"primary_key"`
+	MeetupID  uint   `gorm:"index"`
+	SpeakerID uint   `gorm:"index"`
+	Title     string `gorm:"type:varchar(100)"`
+	Abstract  string `gorm:"type:varchar(2000)"`
+	Duration  int
+	Status    string `gorm:"type:varchar(20);not null;default:'submitted'"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// talkResponse is a talk as the API shows it, the duration is in minutes
+type talkResponse struct {
+	ID        uint      `json:"id"`
+	MeetupID  uint      `json:"meetup_id"`
+	SpeakerID apiID     `json:"speaker_id"`
+	Title     string    `json:"title"`
+	Abstract  string    `json:"abstract"`
+	Duration  int       `json:"duration"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// presentTalks loads the speakers of the talks in one query
+func presentTalks(db *gorm.DB, talks []talk) []talkResponse {
+	ids := make([]uint, len(talks))
+	for i, t := range talks {
+		ids[i] = t.SpeakerID
+	}
+	speakers := []user{}
+	db.Unscoped().Where("id IN (?)", ids).Find(&speakers)
+	byID := make(map[uint]user, len(speakers))
+	for _, u := range speakers {
+		byID[u.ID] = u
+	}
+
+	resp := make([]talkResponse, len(talks))
+	for i, t := range talks {
+		speaker, ok := byID[t.SpeakerID]
+		if !ok {
+			speaker = user{ID: t.SpeakerID}
+		}
+		resp[i] = talkResponse{
+			ID:        t.ID,
+			MeetupID:  t.MeetupID,
+			SpeakerID: idOf(speaker),
+			Title:     t.Title,
+			Abstract:  t.Abstract,
+			Duration:  t.Duration,
+			Status:    t.Status,
+			CreatedAt: t.CreatedAt,
+		}
+	}
+	return resp
+}
+
+// talkStoreRules are the rules for submitting a talk, the duration is in
+// minutes
+var talkStoreRules = govalidator.MapData{
+	"title":    []string{"required", "max:100"},
+	"abstract": []string{"required", "max:2000"},
+	"duration": []string{"required", "numeric_between:5,120"},
+}
+
+func talksStore(db *gorm.DB) http.HandlerFunc {
+	type talkStoreRequest struct {
+		Title    string `json:"title"`
+		Abstract string `json:"abstract"`
+		Duration int    `json:"duration"`
+	}
+
+	type talkStoreResponse struct {
+		Talk talkResponse `json:"talk"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		req := talkStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: talkStoreRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		t := talk{
+			MeetupID:  m.ID,
+			SpeakerID: currentUser(r).ID,
+			Title:     req.Title,
+			Abstract:  req.Abstract,
+			Duration:  req.Duration,
+			Status:    talkSubmitted,
+		}
+		if err := tx.Create(&t).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, talkStoreResponse{Talk: presentTalks(tx, []talk{t})[0]})
+	}
+}
+
+// talksIndex lists the talks submitted to a meetup, organizers see every
+// proposal and everyone else the accepted talks and their own proposals
+func talksIndex(db *gorm.DB) http.HandlerFunc {
+	type talksIndexResponse struct {
+		Talks []talkResponse `json:"talks"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+
+		q := tx.Where("meetup_id = ?", m.ID)
+		viewer := currentUser(r)
+		switch {
+		case canOrganize(tx, viewer, m):
+		case viewer != nil:
+			q = q.Where("status = ? OR speaker_id = ?", talkAccepted, viewer.ID)
+		default:
+			q = q.Where("status = ?", talkAccepted)
+		}
+		talks := []talk{}
+		q.Order("id asc").Find(&talks)
+
+		writeJSON(w, http.StatusOK, talksIndexResponse{Talks: presentTalks(tx, talks)})
+	}
+}
+
+// talkDecisionRules are the rules for an organizer deciding on a talk
+var talkDecisionRules = govalidator.MapData{
+	"status": []string{"required", fmt.Sprintf("in:%v,%v,%v", talkSubmitted, talkAccepted, talkRejected)},
+}
+
+// talksUpdate records an organizer accepting or rejecting a talk, it can be
+// put back to submitted to reconsider it
+func talksUpdate(db *gorm.DB) http.HandlerFunc {
+	type talkDecisionRequest struct {
+		Status string `json:"status"`
+	}
+
+	type talkUpdateResponse struct {
+		Talk talkResponse `json:"talk"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "meetup not found")
+			return
+		}
+		t := talk{}
+		id, err := strconv.ParseUint(param(r, "talk"), 10, 64)
+		if err != nil || tx.Where("meetup_id = ?", m.ID).First(&t, id).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "talk not found")
+			return
+		}
+		if !canOrganize(tx, currentUser(r), m) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		req := talkDecisionRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: talkDecisionRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		if err := tx.Model(&t).Update("status", req.Status).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, talkUpdateResponse{Talk: presentTalks(tx, []talk{t})[0]})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsersCanProposeTalks(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	speaker := createUser(db, "speaker@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/talks", m.ID), strings.NewReader(`{"title":"Table driven tests","abstract":"How and why","duration":20}`))
+	req.Header.Set("Authorization", login(db, speaker))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusCreated, rr.Code)
+	}
+	resp := struct {
+		Talk talkResponse `json:"talk"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Talk.Status != talkSubmitted || resp.Talk.Duration != 20 || resp.Talk.SpeakerID.Key != speaker.ID {
+		t.Errorf("expected a submitted talk by the speaker, got %v instead", rr.Body.String())
+	}
+}
+
+func TestTalksNeedAReasonableDuration(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/talks", m.ID), strings.NewReader(`{"title":"Everything","abstract":"All of it","duration":600}`))
+	req.Header.Set("Authorization", login(db, organizer))
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "duration") {
+		t.Errorf("expected the duration to be rejected, got %v %v instead", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOnlyOrganizersCanAcceptTalks(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	speaker := createUser(db, "speaker@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	tk := talk{MeetupID: m.ID, SpeakerID: speaker.ID, Title: "Table driven tests", Duration: 20, Status: talkSubmitted}
+	db.Create(&tk)
+	path := fmt.Sprintf("/meetups/%v/talks/%v", m.ID, tk.ID)
+	codes := []int{}
+
+	// Act
+	for _, u := range []user{speaker, organizer} {
+		req := httptest.NewRequest("PATCH", path, strings.NewReader(`{"status":"accepted"}`))
+		req.Header.Set("Authorization", login(db, u))
+		rr := httptest.NewRecorder()
+		meetupsRouter(db).ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	// Assert
+	if codes[0] != http.StatusForbidden || codes[1] != http.StatusOK {
+		t.Errorf("expected the speaker to be forbidden and the organizer allowed, got %v instead", codes)
+	}
+	db.First(&tk, tk.ID)
+	if tk.Status != talkAccepted {
+		t.Errorf("expected the talk to be accepted, got %v instead", tk.Status)
+	}
+}
+
+func TestAcceptedTalksAreShownWithTheMeetup(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	speaker := createUser(db, "speaker@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	for _, status := range []string{talkAccepted, talkSubmitted, talkRejected} {
+		db.Create(&talk{MeetupID: m.ID, SpeakerID: speaker.ID, Title: status, Duration: 20, Status: status})
+	}
+	rr := httptest.NewRecorder()
+
+	// Act
+	meetupsRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/meetups/%v", m.ID), nil))
+
+	// Assert
+	resp := struct {
+		Meetup struct {
+			Title string         `json:"title"`
+			Talks []talkResponse `json:"talks"`
+		} `json:"meetup"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Meetup.Title != "Norfolk Gophers" || len(resp.Meetup.Talks) != 1 || resp.Meetup.Talks[0].Status != talkAccepted {
+		t.Errorf("expected only the accepted talk with the meetup, got %v instead", rr.Body.String())
+	}
+}
+
+func TestTalkProposalsAreOnlyListedForOrganizersAndTheirSpeakers(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	speaker := createUser(db, "speaker@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&talk{MeetupID: m.ID, SpeakerID: organizer.ID, Title: "Keynote", Duration: 30, Status: talkAccepted})
+	db.Create(&talk{MeetupID: m.ID, SpeakerID: speaker.ID, Title: "Lightning", Duration: 5, Status: talkSubmitted})
+	path := fmt.Sprintf("/meetups/%v/talks", m.ID)
+	counts := []int{}
+
+	// Act
+	for _, auth := range []string{"", login(db, speaker), login(db, organizer)} {
+		req := httptest.NewRequest("GET", path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		meetupsRouter(db).ServeHTTP(rr, req)
+		resp := struct {
+			Talks []talkResponse `json:"talks"`
+		}{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		counts = append(counts, len(resp.Talks))
+	}
+
+	// Assert
+	if counts[0] != 1 || counts[1] != 2 || counts[2] != 2 {
+		t.Errorf("expected 1 talk for guests and 2 for the speaker and organizer, got %v instead", counts)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const tenantDBContextKey contextKey = "tenant_db"
+
+// tenantPattern keeps tenant IDs safe to put in a file name or database name
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// tenantRegistry gives every known tenant a database of its own, opened and
+// migrated the first time the tenant is seen. Only capacity pools are kept
+// open, the least recently used idle pool is closed to make room. Tenants
+// outside known are turned away so clients can't create databases.
+type tenantRegistry struct {
+	driver   string
+	dsn      string
+	capacity int
+	holder   string
+	known    []string
+	open     func(driver, dsn string) (*gorm.DB, error)
+
+	mu      sync.Mutex
+	pools   map[string]*list.Element
+	lru     *list.List
+	opening map[string]chan struct{}
+}
+
+// tenantPool is an open tenant database, refs counts the requests using it
+// so it is never closed underneath one
+type tenantPool struct {
+	tenant   string
+	db       *gorm.DB
+	refs     int
+	lastUsed time.Time
+}
+
+// newTenantRegistry opens the databases of the known tenants by putting the
+// tenant ID into the dsn pattern, such as "file:%v.db"
+func newTenantRegistry(driver, dsn string, capacity int, known []string) *tenantRegistry {
+	return &tenantRegistry{
+		driver:   driver,
+		dsn:      dsn,
+		capacity: capacity,
+		holder:   migrationHolder(),
+		known:    known,
+		open:     openGorm,
+		pools:    map[string]*list.Element{},
+		lru:      list.New(),
+		opening:  map[string]chan struct{}{},
+	}
+}
+
+// errUnknownTenant is returned for a tenant that isn't in the registry
+var errUnknownTenant = errors.New("unknown tenant")
+
+// isKnown reports whether the tenant is one of the registry's
+func (reg *tenantRegistry) isKnown(tenant string) bool {
+	for _, t := range reg.known {
+		if t == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantsFromEnv reads the known tenants from the comma separated TENANTS,
+// every one has to be a valid tenant ID
+func tenantsFromEnv() ([]string, error) {
+	tenants := []string{}
+	for _, tenant := range strings.Split(os.Getenv("TENANTS"), ",") {
+		tenant = strings.TrimSpace(tenant)
+		if tenant == "" {
+			continue
+		}
+		if !tenantPattern.MatchString(tenant) {
+			return nil, fmt.Errorf("invalid tenant ID %q", tenant)
+		}
+		tenants = append(tenants, tenant)
+	}
+	if len(tenants) == 0 {
+		return nil, errors.New("no tenants given")
+	}
+	return tenants, nil
+}
+
+func openGorm(driver, dsn string) (*gorm.DB, error) {
+	return gorm.Open(driver, dsn)
+}
+
+// acquire returns the tenant database and the function to call once the
+// request is done with it. A tenant's database is opened and migrated
+// outside the registry lock so other tenants aren't held up, requests for
+// the same tenant wait for the one opening it rather than migrating twice.
+func (reg *tenantRegistry) acquire(tenant string) (*gorm.DB, func(), error) {
+	if !reg.isKnown(tenant) {
+		return nil, nil, errUnknownTenant
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	el, ok := reg.pools[tenant]
+	for !ok {
+		if opened, busy := reg.opening[tenant]; busy {
+			reg.mu.Unlock()
+			<-opened
+			reg.mu.Lock()
+			el, ok = reg.pools[tenant]
+			continue
+		}
+
+		opened := make(chan struct{})
+		reg.opening[tenant] = opened
+		reg.mu.Unlock()
+		db, err := reg.openTenant(tenant)
+		reg.mu.Lock()
+		delete(reg.opening, tenant)
+		close(opened)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		el = reg.lru.PushFront(&tenantPool{tenant: tenant, db: db})
+		reg.pools[tenant] = el
+		ok = true
+		reg.evict(func(p *tenantPool) bool { return reg.lru.Len() > reg.capacity })
+	}
+
+	reg.lru.MoveToFront(el)
+	pool := el.Value.(*tenantPool)
+	pool.refs++
+	pool.lastUsed = time.Now()
+
+	release := func() {
+		reg.mu.Lock()
+		pool.refs--
+		pool.lastUsed = time.Now()
+		reg.mu.Unlock()
+	}
+	return pool.db, release, nil
+}
+
+// openTenant opens and migrates the tenant's database
+func (reg *tenantRegistry) openTenant(tenant string) (*gorm.DB, error) {
+	db, err := reg.open(reg.driver, fmt.Sprintf(reg.dsn, tenant))
+	if err != nil {
+		return nil, err
+	}
+	registerTimingCallbacks(db)
+	registerAuditCallbacks(db)
+	if err := runMigrations(db, reg.holder); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// evictIdle closes the pools nobody has used for longer than maxIdle, it is
+// meant to be run periodically
+func (reg *tenantRegistry) evictIdle(maxIdle time.Duration, now time.Time) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.evict(func(p *tenantPool) bool { return now.Sub(p.lastUsed) > maxIdle })
+}
+
+// evict closes unused pools from the least recently used end while should
+// says so, the caller has to hold the lock
+func (reg *tenantRegistry) evict(should func(p *tenantPool) bool) {
+	for el := reg.lru.Back(); el != nil; {
+		prev := el.Prev()
+		p := el.Value.(*tenantPool)
+		if p.refs == 0 && should(p) {
+			p.db.Close()
+			reg.lru.Remove(el)
+			delete(reg.pools, p.tenant)
+		}
+		el = prev
+	}
+}
+
+// close closes every tenant database nobody is using, for shutting down
+func (reg *tenantRegistry) close() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.evict(func(p *tenantPool) bool { return true })
+}
+
+// each runs fn against the database of every known tenant, for background
+// jobs. Tenants whose pool was closed are opened again, so no tenant misses
+// its erasures or reminders for having been idle.
+func (reg *tenantRegistry) each(fn func(db *gorm.DB)) {
+	for _, tenant := range reg.known {
+		db, release, err := reg.acquire(tenant)
+		if err != nil {
+			log.Printf("tenant %v: %v", tenant, err)
+			continue
+		}
+		fn(db)
+		release()
+	}
+}
+
+// middleware resolves the tenant from the X-Tenant-ID header and hands its
+// database to the handlers through dbFor, health checks don't need a tenant
+func (reg *tenantRegistry) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant := r.Header.Get("X-Tenant-ID")
+		if !tenantPattern.MatchString(tenant) {
+			writeError(w, http.StatusBadRequest, "a valid X-Tenant-ID header is required")
+			return
+		}
+
+		db, release, err := reg.acquire(tenant)
+		if err == errUnknownTenant {
+			writeError(w, http.StatusNotFound, "unknown tenant")
+			return
+		}
+		if err != nil {
+			log.Printf("tenant %v: %v", tenant, err)
+			writeError(w, http.StatusServiceUnavailable, "tenant database unavailable")
+			return
+		}
+		defer release()
+
+		ctx := context.WithValue(r.Context(), tenantDBContextKey, db)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tenantDBFrom returns the tenant database of the request, if any
+func tenantDBFrom(r *http.Request) *gorm.DB {
+	db, _ := r.Context().Value(tenantDBContextKey).(*gorm.DB)
+	return db
+}
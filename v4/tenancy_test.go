@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestRequestsNeedAValidTenant(t *testing.T) {
+	for _, tenant := range []string{"", "../etc/passwd", "UPPER"} {
+		// Arrange
+		reg := newTenantRegistry("sqlite3", "file:tenant-%v?mode=memory", 10, []string{"acme"})
+		handler := reg.middleware(okHandler())
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("expected the status code for tenant %q to be %v, got %v instead", tenant, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestTenantsHaveTheirOwnDatabase(t *testing.T) {
+	// Arrange
+	reg := newTenantRegistry("sqlite3", "file:isolated-%v?mode=memory&cache=shared", 10, []string{"acme", "globex"})
+	db := getDB()
+	rt := newRouter()
+	rt.handle(http.MethodPost, "/users", usersStore(db))
+	rt.handle(http.MethodGet, "/users", usersIndex(db))
+	handler := reg.middleware(rt)
+	signup := httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"tenant@example.com","password":"somePassword1!"}`))
+	signup.Header.Set("X-Tenant-ID", "acme")
+	handler.ServeHTTP(httptest.NewRecorder(), signup)
+
+	// Act
+	results := map[string]string{}
+	for _, tenant := range []string{"acme", "globex"} {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		results[tenant] = rr.Body.String()
+	}
+
+	// Assert
+	if !strings.Contains(results["acme"], `"total":1`) {
+		t.Errorf("expected the user in the acme database, got %v instead", results["acme"])
+	}
+	if !strings.Contains(results["globex"], `"total":0`) {
+		t.Errorf("expected the globex database to be empty, got %v instead", results["globex"])
+	}
+}
+
+func TestTheLeastRecentlyUsedIdleTenantIsClosed(t *testing.T) {
+	// Arrange
+	reg := newTenantRegistry("sqlite3", "file:lru-%v?mode=memory", 2, []string{"a", "b", "c"})
+	_, releaseA, _ := reg.acquire("a")
+	releaseA()
+	_, releaseB, _ := reg.acquire("b")
+	defer releaseB()
+
+	// Act
+	reg.acquire("c")
+
+	// Assert
+	if _, ok := reg.pools["a"]; ok {
+		t.Errorf("expected the least recently used tenant to be closed")
+	}
+	if _, ok := reg.pools["b"]; !ok {
+		t.Errorf("expected the tenant in use to stay open")
+	}
+}
+
+func TestIdleTenantsAreClosed(t *testing.T) {
+	// Arrange
+	reg := newTenantRegistry("sqlite3", "file:idle-%v?mode=memory", 10, []string{"a", "b"})
+	_, release, _ := reg.acquire("a")
+	release()
+	reg.acquire("b")
+
+	// Act
+	reg.evictIdle(time.Minute, time.Now().Add(time.Hour))
+
+	// Assert
+	if _, ok := reg.pools["a"]; ok {
+		t.Errorf("expected the idle tenant to be closed")
+	}
+	if _, ok := reg.pools["b"]; !ok {
+		t.Errorf("expected the tenant in use to stay open")
+	}
+}
+
+func TestUnknownTenantsGetNoDatabase(t *testing.T) {
+	// Arrange
+	opened := 0
+	reg := newTenantRegistry("sqlite3", "file:unknown-%v?mode=memory", 10, []string{"acme"})
+	reg.open = func(driver, dsn string) (*gorm.DB, error) {
+		opened++
+		return openGorm(driver, dsn)
+	}
+	handler := reg.middleware(okHandler())
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("X-Tenant-ID", "initech")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusNotFound, status)
+	}
+	if opened != 0 {
+		t.Errorf("expected no database to be opened, got %v instead", opened)
+	}
+}
+
+func TestBackgroundJobsReachClosedTenants(t *testing.T) {
+	// Arrange
+	reg := newTenantRegistry("sqlite3", "file:jobs-%v?mode=memory", 1, []string{"a", "b"})
+	_, release, _ := reg.acquire("a")
+	release()
+	reg.evictIdle(0, time.Now().Add(time.Hour))
+
+	// Act
+	visited := 0
+	reg.each(func(db *gorm.DB) {
+		if db.HasTable(&user{}) {
+			visited++
+		}
+	})
+
+	// Assert
+	if visited != 2 {
+		t.Errorf("expected every known tenant to be visited, got %v instead", visited)
+	}
+}
+
+func TestOpeningATenantDoesntHoldUpTheOthers(t *testing.T) {
+	// Arrange
+	reg := newTenantRegistry("sqlite3", "file:opening-%v?mode=memory", 10, []string{"slow", "fast"})
+	unblock := make(chan struct{})
+	reg.open = func(driver, dsn string) (*gorm.DB, error) {
+		if strings.Contains(dsn, "-slow?") {
+			<-unblock
+		}
+		return openGorm(driver, dsn)
+	}
+	go reg.acquire("slow")
+	defer close(unblock)
+
+	// Act
+	done := make(chan error)
+	go func() {
+		_, release, err := reg.acquire("fast")
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+
+	// Assert
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the fast tenant to open, got %v instead", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the fast tenant not to wait for the slow one")
+	}
+}
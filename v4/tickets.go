@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ticketKey signs the ticket codes handed to attendees, main replaces it with
+// TICKET_SECRET so tickets survive restarts and scan at any instance
+var ticketKey = randomKey()
+
+// ticketCode is the code on the ticket of a user going to a meetup, it names
+// both and is signed so the door can trust it without looking it up
+func ticketCode(meetupID, userID uint) string {
+	payload := fmt.Sprintf("%v.%v", meetupID, userID)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signTicket(payload))
+}
+
+// parseTicketCode checks the signature of a ticket code before trusting the
+// meetup and user it names
+func parseTicketCode(code string) (meetupID, userID uint, ok bool) {
+	i := strings.LastIndex(code, ".")
+	if i < 0 {
+		return 0, 0, false
+	}
+	payload := code[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(code[i+1:])
+	if err != nil || !hmac.Equal(sig, signTicket(payload)) {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	m, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	u, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint(m), uint(u), true
+}
+
+// signTicket truncates the signature to keep the code, and the QR code it is
+// drawn as, small
+func signTicket(payload string) []byte {
+	mac := hmac.New(sha256.New, ticketKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:12]
+}
+
+// ticketShow serves the signed in user's ticket to the meetup as a QR code to
+// scan at the door, people on the waitlist don't have one yet
+func ticketShow(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		m, ok := findMeetup(tx, r)
+		u := currentUser(r)
+		rv := rsvp{}
+		if !ok || tx.Where("meetup_id = ? AND user_id = ? AND status = ?", m.ID, u.ID, rsvpGoing).First(&rv).RecordNotFound() {
+			writeError(w, http.StatusNotFound, "ticket not found")
+			return
+		}
+
+		modules, err := qrCode([]byte(ticketCode(m.ID, u.ID)))
+		buf := bytes.Buffer{}
+		if err == nil {
+			err = writeQRPNG(&buf, modules, 8)
+		}
+		if err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "private")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
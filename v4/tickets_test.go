@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTicketCodesCannotBeForged(t *testing.T) {
+	// Arrange
+	code := ticketCode(4, 2)
+	forged := strings.Replace(code, "4.2.", "4.3.", 1)
+
+	// Act
+	meetupID, userID, ok := parseTicketCode(code)
+	_, _, forgedOK := parseTicketCode(forged)
+
+	// Assert
+	if !ok || meetupID != 4 || userID != 2 {
+		t.Errorf("expected the ticket to name meetup 4 and user 2, got %v %v %v instead", meetupID, userID, ok)
+	}
+	if forgedOK {
+		t.Errorf("expected the forged ticket %v to be rejected", forged)
+	}
+}
+
+func TestAttendeesCanDownloadTheirTicket(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	going := createUser(db, "going@example.com", "somePassword1!")
+	waitlisted := createUser(db, "waitlisted@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Create(&rsvp{MeetupID: m.ID, UserID: going.ID, Status: rsvpGoing})
+	db.Create(&rsvp{MeetupID: m.ID, UserID: waitlisted.ID, Status: rsvpWaitlisted})
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/me/rsvps/{id}/ticket.png", access: accessUser, handler: ticketShow(db)},
+	})
+	responses := []*httptest.ResponseRecorder{}
+
+	// Act
+	for _, u := range []user{going, waitlisted} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/me/rsvps/%v/ticket.png", m.ID), nil)
+		req.Header.Set("Authorization", login(db, u))
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, req)
+		responses = append(responses, rr)
+	}
+
+	// Assert
+	if rr := responses[0]; rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("expected a PNG, got %v %v instead", rr.Code, rr.Header().Get("Content-Type"))
+	} else if _, err := png.Decode(rr.Body); err != nil {
+		t.Errorf("expected a valid PNG, got %v instead", err)
+	}
+	if responses[1].Code != http.StatusNotFound {
+		t.Errorf("expected no ticket for the waitlist, got %v instead", responses[1].Code)
+	}
+}
+
+func TestTicketsCheckAttendeesIn(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	organizer := createUser(db, "jason@mccallister.io", "somePassword1!")
+	attendee := createUser(db, "attendee@example.com", "somePassword1!")
+	m := createMeetup(db, organizer, "Norfolk Gophers", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	other := createMeetup(db, organizer, "Another", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	rsvpReq := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/rsvp", m.ID), nil)
+	rsvpReq.Header.Set("Authorization", login(db, attendee))
+	rsvpRR := httptest.NewRecorder()
+	meetupsRouter(db).ServeHTTP(rsvpRR, rsvpReq)
+	ticket := struct {
+		Ticket string `json:"ticket"`
+	}{}
+	json.Unmarshal(rsvpRR.Body.Bytes(), &ticket)
+	auth := login(db, organizer)
+	codes := []int{}
+
+	// Act
+	for _, id := range []uint{other.ID, m.ID} {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/meetups/%v/checkin", id), strings.NewReader(fmt.Sprintf(`{"ticket":%q}`, ticket.Ticket)))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		meetupsRouter(db).ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	// Assert
+	if ticket.Ticket == "" || codes[0] != http.StatusUnprocessableEntity || codes[1] != http.StatusOK {
+		t.Errorf("expected the ticket %q to only check in at its meetup, got %v instead", ticket.Ticket, codes)
+	}
+}
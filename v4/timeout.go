@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// requestTimeout gives every request a deadline of d, once it passes the
+// queries the handler has left fail without running and the client gets a
+// 504 instead of whatever the handler made of them. Exports stream for as
+// long as they take so they get no deadline.
+func requestTimeout(d time.Duration) middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if classify(r) == priorityExport {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, before: w.Header().Clone()}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			if !tw.wroteHeader && ctx.Err() == context.DeadlineExceeded {
+				tw.WriteHeader(http.StatusOK)
+			}
+		})
+	}
+}
+
+// timeoutWriter swaps the response for a 504 when the handler only answers
+// after the deadline, what it writes then was built on queries that never ran.
+// The headers are put back as they were before the handler ran so its
+// Location or ETag don't end up on the 504.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	before      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	if tw.ctx.Err() == context.DeadlineExceeded {
+		tw.timedOut = true
+		h := tw.ResponseWriter.Header()
+		for k := range h {
+			delete(h, k)
+		}
+		for k, v := range tw.before {
+			h[k] = v
+		}
+		writeError(tw.ResponseWriter, http.StatusGatewayTimeout, "the request took too long")
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok && !tw.timedOut {
+		f.Flush()
+	}
+}
+
+// registerContextCallbacks fails the queries run through a handle returned
+// by dbFor once the request is cancelled or past its deadline. gorm can't
+// hand the context to the driver so a query already running is left to
+// finish.
+func registerContextCallbacks(db *gorm.DB) {
+	check := func(scope *gorm.Scope) {
+		ctx, ok := scope.Get("context")
+		if !ok {
+			return
+		}
+		if err := ctx.(context.Context).Err(); err != nil {
+			scope.Err(err)
+		}
+	}
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:begin_transaction").Register("context:create", check)
+	cb.Query().Before("gorm:query").Register("context:query", check)
+	cb.Update().Before("gorm:begin_transaction").Register("context:update", check)
+	cb.Delete().Before("gorm:begin_transaction").Register("context:delete", check)
+}
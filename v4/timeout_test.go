@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestsTimeOut(t *testing.T) {
+	// Arrange
+	handler := requestTimeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/meetups", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusGatewayTimeout, status)
+	}
+}
+
+func TestTimeoutsDropTheHandlersHeaders(t *testing.T) {
+	// Arrange
+	handler := requestTimeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/meetups/1")
+		w.Header().Set("ETag", `"abc"`)
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	rr := httptest.NewRecorder()
+	rr.Header().Set("X-Request-ID", "req-1")
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/meetups", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusGatewayTimeout, status)
+	}
+	if rr.Header().Get("Location") != "" || rr.Header().Get("ETag") != "" {
+		t.Errorf("expected the handler's headers to be dropped, got %v instead", rr.Header())
+	}
+	if got := rr.Header().Get("X-Request-ID"); got != "req-1" {
+		t.Errorf("expected the headers set before the handler to be kept, got %v instead", got)
+	}
+}
+
+func TestRequestsWithinTheDeadlineAreAnswered(t *testing.T) {
+	// Arrange
+	handler := requestTimeout(time.Second)(okHandler())
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/meetups", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+}
+
+func TestQueriesFailOnceTheRequestIsCancelled(t *testing.T) {
+	// Arrange
+	db := getDB()
+	migrate(db)
+	registerContextCallbacks(db)
+	createUser(db, "jason@mccallister.io", "somePassword1!")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/users", nil).WithContext(ctx)
+
+	// Act
+	err := dbFor(req, db).Find(&[]user{}).Error
+
+	// Assert
+	if err != context.Canceled {
+		t.Errorf("expected the query to be cancelled, got %v instead", err)
+	}
+	if err := db.Find(&[]user{}).Error; err != nil {
+		t.Errorf("expected queries outside a request to run, got %v instead", err)
+	}
+}
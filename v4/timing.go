@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const timingsContextKey contextKey = "timings"
+
+// timings accumulates how long each phase of a request took, phases are
+// reported in the order they first ran, along with the SQL of every query
+type timings struct {
+	mu      sync.Mutex
+	start   time.Time
+	names   []string
+	phases  map[string]time.Duration
+	queries []string
+}
+
+func newTimings() *timings {
+	return &timings{start: time.Now(), phases: map[string]time.Duration{}}
+}
+
+func (t *timings) add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.phases[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.phases[name] += d
+}
+
+func (t *timings) addQuery(sql string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.queries = append(t.queries, sql)
+}
+
+// snapshot copies the phases in milliseconds and the queries run so far
+func (t *timings) snapshot() (map[string]float64, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	phases := make(map[string]float64, len(t.phases))
+	for name, d := range t.phases {
+		phases[name] = milliseconds(d)
+	}
+	queries := make([]string, len(t.queries))
+	copy(queries, t.queries)
+
+	return phases, queries
+}
+
+// phase returns how long the named phase has taken so far
+func (t *timings) phase(name string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.phases[name]
+}
+
+// header formats the phases and the total so far as a Server-Timing value
+func (t *timings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parts := make([]string, 0, len(t.names)+1)
+	for _, name := range t.names {
+		parts = append(parts, fmt.Sprintf("%v;dur=%.2f", name, milliseconds(t.phases[name])))
+	}
+	parts = append(parts, fmt.Sprintf("total;dur=%.2f", milliseconds(time.Since(t.start))))
+
+	return strings.Join(parts, ", ")
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func timingsFrom(r *http.Request) *timings {
+	t, _ := r.Context().Value(timingsContextKey).(*timings)
+	return t
+}
+
+// startPhase starts timing a phase of the request and returns the function
+// that stops it, requests outside of serverTiming are not timed
+func startPhase(r *http.Request, name string) func() {
+	t := timingsFrom(r)
+	if t == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		t.add(name, time.Since(start))
+	}
+}
+
+// serverTiming collects phase timings for the request and reports them in a
+// Server-Timing header when the handler writes its response
+func serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := newTimings()
+		ctx := context.WithValue(r.Context(), timingsContextKey, t)
+
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, timings: t}, r.WithContext(ctx))
+	})
+}
+
+// timingWriter adds the Server-Timing header right before the headers are sent
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *timings
+	wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.Header().Set("Server-Timing", tw.timings.header())
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through so streamed responses aren't held back
+func (tw *timingWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// dbFor returns the database handle a handler should use for the request, the
+// tenant database when tenancy is on. It carries the request timings so
+// queries are counted in the db phase, and the signed in user so records are
+// stamped with who changed them.
+func dbFor(r *http.Request, db *gorm.DB) *gorm.DB {
+	if tdb := tenantDBFrom(r); tdb != nil {
+		db = tdb
+	}
+	if u := currentUser(r); u != nil {
+		db = db.Set("actor_id", u.ID)
+	}
+	db = db.Set("context", r.Context())
+
+	t := timingsFrom(r)
+	if t == nil {
+		return db
+	}
+	return db.Set("timings", t)
+}
+
+// registerTimingCallbacks times and records every query run through a handle
+// returned by dbFor
+func registerTimingCallbacks(db *gorm.DB) {
+	before := func(scope *gorm.Scope) {
+		scope.InstanceSet("timings:start", time.Now())
+	}
+	after := func(scope *gorm.Scope) {
+		t, ok := scope.Get("timings")
+		if !ok {
+			return
+		}
+		start, ok := scope.InstanceGet("timings:start")
+		if !ok {
+			return
+		}
+		t.(*timings).add("db", time.Since(start.(time.Time)))
+		if scope.SQL != "" {
+			t.(*timings).addQuery(scope.SQL)
+		}
+	}
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:begin_transaction").Register("timings:before_create", before)
+	cb.Create().After("gorm:commit_or_rollback_transaction").Register("timings:after_create", after)
+	cb.Query().Before("gorm:query").Register("timings:before_query", before)
+	cb.Query().After("gorm:after_query").Register("timings:after_query", after)
+	cb.RowQuery().Before("gorm:row_query").Register("timings:before_row_query", before)
+	cb.RowQuery().After("gorm:row_query").Register("timings:after_row_query", after)
+	cb.Update().Before("gorm:assign_updating_attributes").Register("timings:before_update", before)
+	cb.Update().After("gorm:commit_or_rollback_transaction").Register("timings:after_update", after)
+	cb.Delete().Before("gorm:begin_transaction").Register("timings:before_delete", before)
+	cb.Delete().After("gorm:commit_or_rollback_transaction").Register("timings:after_delete", after)
+}
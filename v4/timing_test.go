@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTimingReportsThePhases(t *testing.T) {
+	// Arrange
+	handler := serverTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stop := startPhase(r, "validation")
+		time.Sleep(time.Millisecond)
+		stop()
+		writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+	}))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	// Assert
+	header := rr.Header().Get("Server-Timing")
+	if !strings.HasPrefix(header, "validation;dur=") || !strings.Contains(header, ", total;dur=") {
+		t.Errorf("expected the validation phase and total to be reported, got %v instead", header)
+	}
+}
+
+func TestStoringAUserIsTimedByPhase(t *testing.T) {
+	// Arrange
+	db := getDB()
+	registerTimingCallbacks(db)
+	migrate(db)
+	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer([]byte(`{"email":"jason@mccallister.io","password":"somePassword1!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := serverTiming(usersStore(db))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	header := rr.Header().Get("Server-Timing")
+	for _, phase := range []string{"validation;dur=", "hashing;dur=", "db;dur=", "serialization;dur="} {
+		if !strings.Contains(header, phase) {
+			t.Errorf("expected the Server-Timing header to contain %v, got %v instead", phase, header)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig allows TLS 1.2 and up with forward secret AEAD ciphers only,
+// TLS 1.3 picks its own ciphers
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+}
+
+// loadTLSConfig loads the certificate and its key, it returns no
+// configuration when neither is given so the server speaks plain HTTP
+func loadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key have to be given together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newTLSConfig()
+	cfg.Certificates = []tls.Certificate{cert}
+	return cfg, nil
+}
+
+// redirectToHTTPS sends clients to the same URL over HTTPS, on the port of
+// the HTTPS address unless it is the default one. Reads are redirected
+// permanently, other methods with 308 so they aren't turned into GETs.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// acmeHosts splits the comma separated hostnames, spaces around them and
+// empty entries are dropped since the whitelist compares hosts exactly
+func acmeHosts(s string) []string {
+	hosts := []string{}
+	for _, host := range strings.Split(s, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// newCertManager provisions and renews Let's Encrypt certificates for the
+// hosts, keeping them in dir so restarts don't run into the rate limits
+func newCertManager(hosts []string, dir, email string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(dir),
+		Email:      email,
+	}
+}
+
+// certManagerTLSConfig serves the manager's certificates and answers its
+// TLS-ALPN-01 challenges, HTTP-01 challenges need its HTTPHandler on port 80
+func certManagerTLSConfig(m *autocert.Manager) *tls.Config {
+	cfg := newTLSConfig()
+	cfg.GetCertificate = m.GetCertificate
+	cfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	return cfg
+}
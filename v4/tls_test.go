@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self signed certificate for 127.0.0.1 and its
+// key to dir
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestServerSpeaksModernTLSOnly(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg, err := loadTLSConfig(writeTestCertificate(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), TLSConfig: cfg}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() { done <- serveUntil(srv, l, stop, time.Second) }()
+	client := func(maxVersion uint16) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion}}}
+	}
+
+	// Act
+	modern, modernErr := client(tls.VersionTLS12).Get("https://" + l.Addr().String())
+	_, legacyErr := client(tls.VersionTLS11).Get("https://" + l.Addr().String())
+	stop <- os.Interrupt
+	<-done
+
+	// Assert
+	if modernErr != nil || modern.StatusCode != http.StatusOK {
+		t.Errorf("expected a TLS 1.2 client to be served, got %v instead", modernErr)
+	}
+	if legacyErr == nil {
+		t.Errorf("expected a TLS 1.1 client to be turned away")
+	}
+}
+
+func TestTLSNeedsBothTheCertificateAndKey(t *testing.T) {
+	// Act
+	_, err := loadTLSConfig("cert.pem", "")
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected an error without the key")
+	}
+}
+
+func TestPlainHTTPIsRedirectedToHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		method    string
+		status    int
+		location  string
+	}{
+		{name: "on the default port", httpsAddr: ":443", method: "GET", status: http.StatusMovedPermanently, location: "https://example.com/meetups?page=2"},
+		{name: "on another port", httpsAddr: ":8443", method: "GET", status: http.StatusMovedPermanently, location: "https://example.com:8443/meetups?page=2"},
+		{name: "keeping the method", httpsAddr: ":443", method: "POST", status: http.StatusPermanentRedirect, location: "https://example.com/meetups?page=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rr := httptest.NewRecorder()
+
+			// Act
+			redirectToHTTPS(tt.httpsAddr).ServeHTTP(rr, httptest.NewRequest(tt.method, "http://example.com:80/meetups?page=2", nil))
+
+			// Assert
+			if rr.Code != tt.status || rr.Header().Get("Location") != tt.location {
+				t.Errorf("expected a %v to %v, got a %v to %v instead", tt.status, tt.location, rr.Code, rr.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestCertManagerAnswersChallengesAndRedirectsTheRest(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certs := newCertManager([]string{"example.com"}, dir, "")
+	h := certs.HTTPHandler(redirectToHTTPS(":443"))
+	challenge := httptest.NewRecorder()
+	other := httptest.NewRecorder()
+
+	// Act
+	h.ServeHTTP(challenge, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/unknown", nil))
+	h.ServeHTTP(other, httptest.NewRequest("GET", "http://example.com/meetups", nil))
+
+	// Assert
+	if challenge.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown challenge to be answered by the manager, got %v instead", challenge.Code)
+	}
+	if other.Code != http.StatusMovedPermanently {
+		t.Errorf("expected other requests to be redirected, got %v instead", other.Code)
+	}
+	if protos := certManagerTLSConfig(certs).NextProtos; protos[len(protos)-1] != "acme-tls/1" {
+		t.Errorf("expected TLS-ALPN-01 challenges to be answered, got %v instead", protos)
+	}
+}
+
+func TestACMEHostsAreTrimmed(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Act
+	hosts := acmeHosts("a.com, b.com,, ")
+	certs := newCertManager(hosts, dir, "")
+
+	// Assert
+	if fmt.Sprint(hosts) != "[a.com b.com]" {
+		t.Errorf("expected the hosts to be trimmed and the empty ones dropped, got %q instead", hosts)
+	}
+	if err := certs.HostPolicy(context.Background(), "b.com"); err != nil {
+		t.Errorf("expected b.com to be whitelisted, got %v instead", err)
+	}
+}
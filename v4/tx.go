@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+)
+
+// savepoints numbers savepoints so nested transactions never share a name
+var savepoints uint64
+
+// transaction runs fn in a transaction that is committed when fn returns nil
+// and rolled back otherwise. When db already is a transaction, such as the one
+// tests wrap around handlers, fn runs in a savepoint inside it instead.
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		name := fmt.Sprintf("sp_%v", atomic.AddUint64(&savepoints, 1))
+		if err := db.Exec("SAVEPOINT " + name).Error; err != nil {
+			return err
+		}
+		if err := fn(db); err != nil {
+			db.Exec("ROLLBACK TO SAVEPOINT " + name)
+			return err
+		}
+		return db.Exec("RELEASE SAVEPOINT " + name).Error
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// lockForUpdate makes the query lock the rows it reads until the transaction
+// ends. SQLite already locks the whole database for a writing transaction and
+// has no FOR UPDATE, so its queries are left alone.
+func lockForUpdate(tx *gorm.DB) *gorm.DB {
+	if tx.Dialect().GetName() == "sqlite3" {
+		return tx
+	}
+	return tx.Set("gorm:query_option", "FOR UPDATE")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestNestedTransactionsRollBackToTheirSavepoint(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	createUser(db, "outer@example.com", "somePassword1!")
+
+	// Act
+	err := transaction(db, func(tx *gorm.DB) error {
+		createUser(tx, "inner@example.com", "somePassword1!")
+		return errors.New("failed")
+	})
+
+	// Assert
+	if err == nil {
+		t.Errorf("expected the error to be returned")
+	}
+	count := 0
+	db.Model(&user{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected only the outer user to remain, got %v users instead", count)
+	}
+}
+
+func TestTestTransactionsAreIsolated(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		// Arrange
+		db, rollback := testTx(t)
+
+		// Act
+		u := createUser(db, "isolated@example.com", "somePassword1!")
+		rollback()
+
+		// Assert
+		if u.ID != 1 {
+			t.Errorf("expected every test to start from an empty table, got id %v instead", u.ID)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "strings"
+
+// the browsers and operating systems recognised in user agents, checked in
+// order because most user agents mention several of them
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	userAgentPlatforms = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeUserAgent turns a user agent header into a short human readable
+// description such as "Firefox on Windows"
+func describeUserAgent(ua string) string {
+	browser := "Unknown browser"
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, p := range userAgentPlatforms {
+		if strings.Contains(ua, p.token) {
+			return browser + " on " + p.name
+		}
+	}
+
+	return browser
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+// reservedUsernames can't be taken since they would be mistaken for the
+// service itself or clash with routes
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "api": true, "help": true,
+	"login": true, "logout": true, "me": true, "null": true, "root": true,
+	"search": true, "settings": true, "support": true, "system": true,
+}
+
+// usernameRules are the rules for a username, usernames are stored in lower
+// case so they are unique regardless of case
+var usernameRules = []string{"min:3", "max:30", "regex:^[a-zA-Z0-9_]+$", "username_not_reserved"}
+
+// usernameCooldown is how long users have to wait between username changes,
+// main replaces it with USERNAME_CHANGE_COOLDOWN
+var usernameCooldown = 30 * 24 * time.Hour
+
+func init() {
+	govalidator.AddCustomRule("username_not_reserved", func(field, rule, message string, value interface{}) error {
+		s, _ := value.(string)
+		if reservedUsernames[strings.ToLower(s)] {
+			return fmt.Errorf("The %v %q is reserved", field, s)
+		}
+		return nil
+	})
+}
+
+// normalizeUsername lowercases a username and strips a leading @
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// usernameChangeAllowed reports whether the user may pick a new username,
+// the first one can always be picked and administrators are never held back
+func usernameChangeAllowed(u user, viewer *user, now time.Time) bool {
+	if u.UsernameChangedAt == nil || (viewer != nil && viewer.Admin) {
+		return true
+	}
+	return now.Sub(*u.UsernameChangedAt) >= usernameCooldown
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsersCanBeShownByUsername(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	store := http.HandlerFunc(usersStore(db))
+	store.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"jason@example.com","password":"somePassword1!","username":"Jason_M"}`)))
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", usersShow(db))
+	rr := httptest.NewRecorder()
+
+	// Act
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", "/users/@jason_m", nil))
+
+	// Assert
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `"username":"jason_m"`) {
+		t.Errorf("expected the lowercased username, got %v instead", body)
+	}
+}
+
+func TestUsernamesAreValidated(t *testing.T) {
+	for _, username := range []string{"ab", "has space", "Admin", strings.Repeat("a", 31)} {
+		// Arrange
+		db, rollback := testTx(t)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(usersStore(db))
+		body := fmt.Sprintf(`{"email":"jason@example.com","password":"somePassword1!","username":%q}`, username)
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(body)))
+		rollback()
+
+		// Assert
+		if status := rr.Code; status != http.StatusUnprocessableEntity {
+			t.Errorf("expected the status code for %q to be %v, got %v instead", username, http.StatusUnprocessableEntity, status)
+		}
+	}
+}
+
+func TestTakenUsernamesAreAConflict(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	handler := http.HandlerFunc(usersStore(db))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"one@example.com","password":"somePassword1!","username":"gopher"}`)))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"two@example.com","password":"somePassword1!","username":"Gopher"}`)))
+
+	// Assert
+	if body := rr.Body.String(); rr.Code != http.StatusConflict || !strings.Contains(body, `"errors":{"username":["already taken"]}`) {
+		t.Errorf("expected the username to be reported as taken, got %v %v instead", rr.Code, body)
+	}
+}
+
+func TestUsernamesCanOnlyBeChangedAfterTheCooldown(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@example.com", "somePassword1!")
+	changedAt := time.Now().Add(-time.Hour)
+	db.Model(&u).UpdateColumns(map[string]interface{}{"username": "jason", "username_changed_at": changedAt})
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/users/%v", u.ID), strings.NewReader(`{"username":"jason2"}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	updateRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusUnprocessableEntity, status)
+	}
+	if !usernameChangeAllowed(user{UsernameChangedAt: &changedAt}, nil, changedAt.Add(usernameCooldown)) {
+		t.Errorf("expected the username to be changeable once the cooldown is over")
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// venue is a place meetups are held, anyone signed in can add one and it is
+// looked after by whoever added it. A capacity of zero means it isn't known.
+type venue struct {
+	ID          uint    `gorm:"primary_key"`
+	Name        string  `gorm:"type:varchar(100)"`
+	Address     string  `gorm:"type:varchar(255)"`
+	Capacity    int     `gorm:"not null;default:0"`
+	Latitude    float64 `gorm:"index:idx_venues_location"`
+	Longitude   float64 `gorm:"index:idx_venues_location"`
+	CreatedByID *uint
+	UpdatedByID *uint
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type venueResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	Capacity  int       `json:"capacity"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func presentVenue(v venue) venueResponse {
+	return venueResponse{
+		ID:        v.ID,
+		Name:      v.Name,
+		Address:   v.Address,
+		Capacity:  v.Capacity,
+		Latitude:  v.Latitude,
+		Longitude: v.Longitude,
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+	}
+}
+
+// canManageVenue reports whether the viewer may change the venue, which is
+// limited to whoever added it and administrators
+func canManageVenue(viewer *user, v venue) bool {
+	return viewer != nil && (viewer.Admin || (v.CreatedByID != nil && *v.CreatedByID == viewer.ID))
+}
+
+// venueRules are the rules for the fields of a venue, the coordinates are in
+// decimal degrees and their bounds are written as decimals so the validator
+// compares them as floats
+var venueRules = govalidator.MapData{
+	"name":      []string{"required", "max:100"},
+	"address":   []string{"required", "max:255"},
+	"capacity":  []string{"numeric_between:0,100000"},
+	"latitude":  []string{"numeric_between:-90.0,90.0"},
+	"longitude": []string{"numeric_between:-180.0,180.0"},
+}
+
+// findVenue loads the venue named by the id path parameter
+func findVenue(tx *gorm.DB, r *http.Request) (venue, bool) {
+	v := venue{}
+	id, err := strconv.ParseUint(param(r, "id"), 10, 64)
+	if err != nil {
+		return v, false
+	}
+	return v, !tx.First(&v, id).RecordNotFound()
+}
+
+// fitVenue checks a meetup fits in the venue it is held at and returns the
+// capacity it ends up with, a meetup without a capacity of its own takes
+// the venue's
+func fitVenue(tx *gorm.DB, venueID *uint, capacity int) (int, map[string][]string) {
+	if venueID == nil {
+		return capacity, nil
+	}
+	v := venue{}
+	if tx.First(&v, *venueID).RecordNotFound() {
+		return capacity, map[string][]string{"venue_id": {"The venue_id field must be an existing venue"}}
+	}
+	if v.Capacity == 0 {
+		return capacity, nil
+	}
+	if capacity == 0 {
+		return v.Capacity, nil
+	}
+	if capacity > v.Capacity {
+		return capacity, map[string][]string{"capacity": {fmt.Sprintf("The capacity field must not exceed the venue's capacity of %v", v.Capacity)}}
+	}
+	return capacity, nil
+}
+
+// earthRadius is the mean radius of the Earth in kilometres
+const earthRadius = 6371.0
+
+// haversine is the great circle distance in kilometres between two points
+func haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// boundingBox is a box around the point containing everything within radius
+// kilometres of it, the longitude spans the globe near the poles
+func boundingBox(lat, lng, radius float64) (minLat, maxLat, minLng, maxLng float64) {
+	dLat := radius / earthRadius * 180 / math.Pi
+	minLat, maxLat = math.Max(lat-dLat, -90), math.Min(lat+dLat, 90)
+	if minLat == -90 || maxLat == 90 {
+		return minLat, maxLat, -180, 180
+	}
+	dLng := dLat / math.Cos(lat*math.Pi/180)
+	minLng, maxLng = lng-dLng, lng+dLng
+	if minLng < -180 || maxLng > 180 {
+		return minLat, maxLat, -180, 180
+	}
+	return minLat, maxLat, minLng, maxLng
+}
+
+// parseCoordinates reads a "lat,lng" pair in decimal degrees
+func parseCoordinates(s string) (lat, lng float64, ok bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+	lng, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// venuesIndexParams are the query parameters the venues index understands
+var venuesIndexParams = []string{"page", "per_page", "sort"}
+
+func venuesIndex(db *gorm.DB) http.HandlerFunc {
+	type venuesIndexResponse struct {
+		Venues     []venueResponse `json:"venues"`
+		Pagination pagination      `json:"pagination"`
+		Links      pageLinks       `json:"links"`
+	}
+
+	policy := listPolicyFor("/venues")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, venuesIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts, errs := parseListOptions(r, policy)
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort, errs := parseSort(r, policy)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts.Sort = sort
+
+		tx := dbFor(r, db)
+		page := opts.paginate(tx, &venue{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		venues := []venue{}
+		opts.apply(tx).Find(&venues)
+
+		resp := venuesIndexResponse{Venues: make([]venueResponse, len(venues)), Pagination: page, Links: links}
+		for i, v := range venues {
+			resp.Venues[i] = presentVenue(v)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func venuesStore(db *gorm.DB) http.HandlerFunc {
+	type venueStoreRequest struct {
+		Name      string  `json:"name"`
+		Address   string  `json:"address"`
+		Capacity  int     `json:"capacity"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+
+	type venueStoreResponse struct {
+		Venue venueResponse `json:"venue"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := venueStoreRequest{}
+		if err := decodeJSON(r, &req); err != nil {
+			writeAppError(w, err)
+			return
+		}
+		v := govalidator.New(govalidator.Options{
+			Data:  &req,
+			Rules: venueRules,
+		})
+		if e := v.ValidateStruct(); len(e) >= 1 {
+			writeValidationErrors(w, e)
+			return
+		}
+
+		u := currentUser(r)
+		vn := venue{
+			Name:        req.Name,
+			Address:     req.Address,
+			Capacity:    req.Capacity,
+			Latitude:    req.Latitude,
+			Longitude:   req.Longitude,
+			CreatedByID: &u.ID,
+		}
+		if err := dbFor(r, db).Create(&vn).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, venueStoreResponse{Venue: presentVenue(vn)})
+	}
+}
+
+func venuesShow(db *gorm.DB) http.HandlerFunc {
+	type venueShowResponse struct {
+		Venue venueResponse `json:"venue"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, ok := findVenue(dbFor(r, db), r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "venue not found")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, venueShowResponse{Venue: presentVenue(v)})
+	}
+}
+
+// venuesUpdate changes the venue, its capacity can't drop below that of a
+// meetup held there
+func venuesUpdate(db *gorm.DB) http.HandlerFunc {
+	type venueUpdateRequest struct {
+		Name      *string  `json:"name"`
+		Address   *string  `json:"address"`
+		Capacity  *int     `json:"capacity"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+
+	type venueUpdateResponse struct {
+		Venue venueResponse `json:"venue"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		v, ok := findVenue(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "venue not found")
+			return
+		}
+		if !canManageVenue(currentUser(r), v) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		req := venueUpdateRequest{}
+		errs, err := decodePartial(r, &req, venueRules)
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+		if req.Capacity != nil && *req.Capacity > 0 {
+			largest := struct{ Capacity int }{}
+			tx.Model(&meetup{}).Select("max(capacity) as capacity").Where("venue_id = ?", v.ID).Scan(&largest)
+			if largest.Capacity > *req.Capacity {
+				errs["capacity"] = append(errs["capacity"], fmt.Sprintf("The capacity field must be at least %v to fit the meetups held there", largest.Capacity))
+			}
+		}
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		updates := map[string]interface{}{}
+		for column, value := range map[string]*string{"name": req.Name, "address": req.Address} {
+			if value != nil {
+				updates[column] = *value
+			}
+		}
+		for column, value := range map[string]*float64{"latitude": req.Latitude, "longitude": req.Longitude} {
+			if value != nil {
+				updates[column] = *value
+			}
+		}
+		if req.Capacity != nil {
+			updates["capacity"] = *req.Capacity
+		}
+
+		if len(updates) >= 1 {
+			if err := tx.Model(&v).Updates(updates).Error; err != nil {
+				logError(r, err)
+				writeError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, venueUpdateResponse{Venue: presentVenue(v)})
+	}
+}
+
+// venuesDestroy deletes a venue no meetup is held at
+func venuesDestroy(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := dbFor(r, db)
+		v, ok := findVenue(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "venue not found")
+			return
+		}
+		if !canManageVenue(currentUser(r), v) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		held := 0
+		tx.Model(&meetup{}).Where("venue_id = ?", v.ID).Count(&held)
+		if held >= 1 {
+			writeAppError(w, &appError{Status: http.StatusConflict, Code: "venue_in_use", Message: "meetups are held at the venue"})
+			return
+		}
+
+		if err := tx.Delete(&v).Error; err != nil {
+			logError(r, err)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// venueMeetupsIndexParams are the query parameters the list of a venue's
+// meetups understands
+var venueMeetupsIndexParams = []string{"page", "per_page", "sort"}
+
+// venueMeetupsIndex lists the meetups held at a venue, soonest first
+func venueMeetupsIndex(db *gorm.DB) http.HandlerFunc {
+	type venueMeetupsIndexResponse struct {
+		Meetups    []meetupResponse `json:"meetups"`
+		Pagination pagination       `json:"pagination"`
+		Links      pageLinks        `json:"links"`
+	}
+
+	policy := listPolicyFor("/venues/{id}/meetups")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := checkParams(r, venueMeetupsIndexParams); len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		tx := dbFor(r, db)
+		v, ok := findVenue(tx, r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "venue not found")
+			return
+		}
+
+		opts, errs := parseListOptions(r, policy)
+		if len(errs) >= 1 {
+			writeValidationErrors(w, errs)
+			return
+		}
+		sort, errs := parseSort(r, policy)
+		if len(errs) >= 1 {
+			writeErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		opts.Sort = sort
+
+		q := tx.Where("venue_id = ?", v.ID)
+		page := opts.paginate(q, &meetup{})
+		links := page.links(r)
+		setLinkHeader(w, links)
+		meetups := []meetup{}
+		opts.apply(q).Find(&meetups)
+
+		writeJSON(w, http.StatusOK, venueMeetupsIndexResponse{
+			Meetups:    presentMeetups(tx, meetups),
+			Pagination: page,
+			Links:      links,
+		})
+	}
+}
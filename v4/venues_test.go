@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func venuesRouter(db *gorm.DB) *router {
+	rt := newRouter()
+	registerRoutes(rt, db, []routeDef{
+		{method: http.MethodGet, path: "/venues", handler: venuesIndex(db)},
+		{method: http.MethodPost, path: "/venues", access: accessUser, handler: venuesStore(db)},
+		{method: http.MethodGet, path: "/venues/{id}", handler: venuesShow(db)},
+		{method: http.MethodPatch, path: "/venues/{id}", access: accessUser, handler: venuesUpdate(db)},
+		{method: http.MethodDelete, path: "/venues/{id}", access: accessUser, handler: venuesDestroy(db)},
+		{method: http.MethodGet, path: "/venues/{id}/meetups", handler: venueMeetupsIndex(db)},
+	})
+	return rt
+}
+
+func TestUsersCanAddVenues(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req := httptest.NewRequest("POST", "/venues", strings.NewReader(`{"name":"757 Makerspace","address":"1 Main St, Norfolk, VA","capacity":40,"latitude":36.85,"longitude":-76.29}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	venuesRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusCreated, rr.Code)
+	}
+	resp := struct {
+		Venue venueResponse `json:"venue"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Venue.Name != "757 Makerspace" || resp.Venue.Capacity != 40 || resp.Venue.Latitude != 36.85 {
+		t.Errorf("expected the venue to be returned, got %v instead", rr.Body.String())
+	}
+}
+
+func TestVenueCoordinatesMustBeOnTheGlobe(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	req := httptest.NewRequest("POST", "/venues", strings.NewReader(`{"name":"Nowhere","address":"Nowhere","latitude":91,"longitude":0}`))
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	venuesRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "latitude") {
+		t.Errorf("expected the latitude to be rejected, got %v %v instead", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMeetupsMustFitTheirVenue(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		status   int
+		expected int
+	}{
+		{name: "within the venue's capacity", capacity: 30, status: http.StatusCreated, expected: 30},
+		{name: "without a capacity of its own", capacity: 0, status: http.StatusCreated, expected: 40},
+		{name: "beyond the venue's capacity", capacity: 50, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, rollback := testTx(t)
+			defer rollback()
+			u := createUser(db, "jason@mccallister.io", "somePassword1!")
+			v := venue{Name: "757 Makerspace", Address: "Norfolk", Capacity: 40}
+			db.Create(&v)
+			body := fmt.Sprintf(`{"title":"Norfolk Gophers","starts_at":"2019-10-15T18:30:00Z","venue_id":%v,"capacity":%v}`, v.ID, tt.capacity)
+			req := httptest.NewRequest("POST", "/meetups", strings.NewReader(body))
+			req.Header.Set("Authorization", login(db, u))
+			rr := httptest.NewRecorder()
+
+			// Act
+			meetupsRouter(db).ServeHTTP(rr, req)
+
+			// Assert
+			if rr.Code != tt.status {
+				t.Errorf("expected the status code to be %v, got %v %v instead", tt.status, rr.Code, rr.Body.String())
+			}
+			resp := struct {
+				Meetup meetupResponse `json:"meetup"`
+			}{}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			if tt.status == http.StatusCreated && (resp.Meetup.Capacity != tt.expected || resp.Meetup.VenueID == nil || *resp.Meetup.VenueID != v.ID) {
+				t.Errorf("expected a capacity of %v at the venue, got %v instead", tt.expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestVenuesListTheirMeetups(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	v := venue{Name: "757 Makerspace", Address: "Norfolk"}
+	db.Create(&v)
+	here := createMeetup(db, u, "Here", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Model(&here).Update("venue_id", v.ID)
+	createMeetup(db, u, "Elsewhere", time.Date(2019, 11, 19, 18, 30, 0, 0, time.UTC))
+	rr := httptest.NewRecorder()
+
+	// Act
+	venuesRouter(db).ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/venues/%v/meetups", v.ID), nil))
+
+	// Assert
+	resp := struct {
+		Meetups []meetupResponse `json:"meetups"`
+	}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Meetups) != 1 || resp.Meetups[0].Title != "Here" {
+		t.Errorf("expected only the meetup held at the venue, got %v instead", rr.Body.String())
+	}
+}
+
+func TestVenuesWithMeetupsCannotBeDeleted(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	u := createUser(db, "jason@mccallister.io", "somePassword1!")
+	v := venue{Name: "757 Makerspace", Address: "Norfolk", CreatedByID: &u.ID}
+	db.Create(&v)
+	m := createMeetup(db, u, "Here", time.Date(2019, 10, 15, 18, 30, 0, 0, time.UTC))
+	db.Model(&m).Update("venue_id", v.ID)
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/venues/%v", v.ID), nil)
+	req.Header.Set("Authorization", login(db, u))
+	rr := httptest.NewRecorder()
+
+	// Act
+	venuesRouter(db).ServeHTTP(rr, req)
+
+	// Assert
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusConflict, rr.Code)
+	}
+}
+
+func TestHaversineDistances(t *testing.T) {
+	// Arrange
+	norfolkLat, norfolkLng := 36.8508, -76.2859
+	richmondLat, richmondLng := 37.5407, -77.4360
+
+	// Act
+	d := haversine(norfolkLat, norfolkLng, richmondLat, richmondLng)
+
+	// Assert
+	if d < 125 || d > 135 {
+		t.Errorf("expected Norfolk and Richmond to be about 130km apart, got %v instead", d)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// currentAPIVersion is the representation the handlers produce, responses
+// for older versions are derived from it by the version changes below so the
+// handlers never need to know about them
+const currentAPIVersion = 5
+
+// oldestAPIVersion is the oldest version clients may still ask for
+const oldestAPIVersion = 1
+
+// versionSunsets are the dates the older versions stop being served, from
+// API_VERSION_SUNSETS. Until then their responses carry a Sunset header and
+// afterwards requests for them get a 410.
+var versionSunsets = map[int]time.Time{}
+
+// parseVersionSunsets reads sunsets written as 1=2027-01-01,2=2027-06-30,
+// only versions older than the current one can be retired
+func parseVersionSunsets(s string) (map[int]time.Time, error) {
+	sunsets := map[int]time.Time{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		version, err := strconv.Atoi(strings.TrimPrefix(parts[0], "v"))
+		if err != nil || len(parts) != 2 || version < oldestAPIVersion || version >= currentAPIVersion {
+			return nil, fmt.Errorf("invalid API version sunset %q, want an older version and a date such as 1=2027-01-01", entry)
+		}
+		at, err := parseDate(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid API version sunset %q: %v", entry, err)
+		}
+		sunsets[version] = at
+	}
+	return sunsets, nil
+}
+
+// versionChange converts a response body of version into the representation
+// used by the version before it
+type versionChange struct {
+	version   int
+	downgrade func(status int, body interface{}) interface{}
+}
+
+// versionChanges lists every change to the representations, newest first
+var versionChanges = []versionChange{
+	// v5 replaced the error envelopes with RFC 7807 problem details
+	{version: 5, downgrade: errorEnvelopes},
+	// v4 introduced the error envelopes, before that every response was a
+	// single message
+	{version: 4, downgrade: messageOnlyErrors},
+}
+
+func errorEnvelopes(status int, body interface{}) interface{} {
+	m, ok := body.(map[string]interface{})
+	if !ok || status < http.StatusBadRequest {
+		return body
+	}
+	if _, ok := m["title"]; !ok {
+		return body
+	}
+
+	if errs, ok := m["errors"]; ok {
+		return map[string]interface{}{"errors": errs}
+	}
+	detail, _ := m["detail"].(string)
+	if detail == "" {
+		detail, _ = m["title"].(string)
+	}
+	return map[string]interface{}{"error": detail}
+}
+
+func messageOnlyErrors(status int, body interface{}) interface{} {
+	m, ok := body.(map[string]interface{})
+	if !ok || status < http.StatusBadRequest {
+		return body
+	}
+
+	if msg, ok := m["error"].(string); ok {
+		return map[string]string{"message": msg}
+	}
+
+	fields, ok := m["errors"].(map[string]interface{})
+	if !ok {
+		return body
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := []string{}
+	for _, name := range names {
+		list, _ := fields[name].([]interface{})
+		for _, msg := range list {
+			if s, ok := msg.(string); ok {
+				messages = append(messages, s)
+			}
+		}
+	}
+	return map[string]string{"message": strings.Join(messages, ", ")}
+}
+
+// requestedVersion reads the API version from a /v1/ style path prefix or
+// the Accept-Version header and returns the path without the prefix, def is
+// used when the request names neither
+func requestedVersion(r *http.Request, def int) (int, string, bool) {
+	version, path := def, r.URL.Path
+	fromHeader := r.Header.Get("Accept-Version") != ""
+	if fromHeader {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.Header.Get("Accept-Version"), "v"))
+		if err != nil {
+			return 0, path, false
+		}
+		version = n
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(segments[0]) > 1 && segments[0][0] == 'v' {
+		if n, err := strconv.Atoi(segments[0][1:]); err == nil {
+			if fromHeader && n != version {
+				return 0, path, false
+			}
+			version, path = n, "/"
+			if len(segments) == 2 {
+				path += segments[1]
+			}
+		}
+	}
+
+	return version, path, version >= oldestAPIVersion && version <= currentAPIVersion
+}
+
+// apiVersions serves the version of the API the client asked for, or the
+// one a registered client pinned. It has to wrap everything that looks at the
+// path so /v1/admin is treated as /admin
+func apiVersions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		def := currentAPIVersion
+		if c, ok := clientFor(r); ok {
+			r = withClient(r, c)
+			if c.Version != 0 {
+				def = c.Version
+			}
+		}
+
+		version, path, ok := requestedVersion(r, def)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unsupported API version")
+			return
+		}
+
+		u := *r.URL
+		u.Path = path
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = &u
+
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		sunset, sunsetting := versionSunsets[version]
+		if version == currentAPIVersion {
+			next.ServeHTTP(w, r2)
+			return
+		}
+
+		// the older versions only live on as downgrades of the current one
+		jw := &jsonWriter{ResponseWriter: w}
+		if sunsetting && !time.Now().Before(sunset) {
+			writeAppError(jw, &appError{
+				Status:  http.StatusGone,
+				Code:    "version_retired",
+				Message: fmt.Sprintf("API version %v was retired on %v, upgrade to version %v", version, sunset.Format("2006-01-02"), currentAPIVersion),
+			})
+		} else {
+			message := fmt.Sprintf("API version %v is deprecated, upgrade to version %v", version, currentAPIVersion)
+			if sunsetting {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+				message = fmt.Sprintf("API version %v is deprecated and will be retired on %v, upgrade to version %v", version, sunset.Format("2006-01-02"), currentAPIVersion)
+			}
+			warnDeprecated(w, r2, fmt.Sprintf("API version %v", version), message)
+			next.ServeHTTP(jw, r2)
+		}
+
+		jw.finish(func(status int, body []byte) []byte {
+			var v interface{}
+			if len(body) == 0 || json.Unmarshal(body, &v) != nil {
+				return body
+			}
+			for _, change := range versionChanges {
+				if change.version > version {
+					v = change.downgrade(status, v)
+				}
+			}
+			// problem details only exist in the current version
+			if strings.HasPrefix(w.Header().Get("Content-Type"), problemContentType) {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			data, _ := json.Marshal(v)
+			return data
+		})
+	})
+}
+
+// jsonWriter holds back JSON responses, problems included, so they can be
+// rewritten once the handler is done. Anything else, like calendars, images
+// and streams, goes straight through as it is written.
+type jsonWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+	json        *bytes.Buffer
+}
+
+func (jw *jsonWriter) WriteHeader(status int) {
+	if jw.wroteHeader {
+		return
+	}
+	jw.wroteHeader = true
+	contentType := jw.Header().Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, problemContentType) {
+		jw.status = status
+		jw.json = &bytes.Buffer{}
+		return
+	}
+	jw.ResponseWriter.WriteHeader(status)
+}
+
+func (jw *jsonWriter) Write(b []byte) (int, error) {
+	if !jw.wroteHeader {
+		jw.WriteHeader(http.StatusOK)
+	}
+	if jw.json != nil {
+		return jw.json.Write(b)
+	}
+	return jw.ResponseWriter.Write(b)
+}
+
+func (jw *jsonWriter) Flush() {
+	if jw.json != nil {
+		return
+	}
+	if f, ok := jw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the JSON held back once rewrite has changed it, rewrite may
+// still change the headers
+func (jw *jsonWriter) finish(rewrite func(status int, body []byte) []byte) {
+	if jw.json == nil {
+		return
+	}
+
+	body := rewrite(jw.status, jw.json.Bytes())
+	jw.Header().Del("Content-Length")
+	jw.ResponseWriter.WriteHeader(jw.status)
+	jw.ResponseWriter.Write(body)
+}
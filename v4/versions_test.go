@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOlderVersionsGetMessageOnlyErrors(t *testing.T) {
+	// Arrange
+	db, rollback := testTx(t)
+	defer rollback()
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/users/{id}", usersShow(db))
+	rt.handle(http.MethodGet, "/users", usersIndex(db))
+	handler := apiVersions(rt)
+
+	tests := map[string]string{
+		"/v1/users/99":     `{"message":"user not found"}`,
+		"/v1/users?page=0": `{"message":"The page field must be a positive number"}`,
+		"/v4/users/99":     `{"error":"user not found"}`,
+		"/v4/users?page=0": `{"errors":{"page":["The page field must be a positive number"]}}`,
+		"/users/99":        `{"type":"about:blank","title":"Not Found","status":404,"code":"not_found","detail":"user not found"}`,
+	}
+	for target, expected := range tests {
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+
+		// Assert
+		if body := strings.TrimSpace(rr.Body.String()); body != expected {
+			t.Errorf("expected %v to respond with %v, got %v instead", target, expected, body)
+		}
+	}
+}
+
+func TestTheVersionCanBeRequestedWithAHeader(t *testing.T) {
+	// Arrange
+	handler := apiVersions(okHandler())
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("Accept-Version", "2")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if version := rr.Header().Get("API-Version"); version != "2" {
+		t.Errorf("expected the API version to be 2, got %v instead", version)
+	}
+}
+
+func TestUnsupportedVersionsAreRejected(t *testing.T) {
+	for _, version := range []string{"6", "0", "latest"} {
+		// Arrange
+		handler := apiVersions(okHandler())
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		req.Header.Set("Accept-Version", version)
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("expected the status code for version %v to be %v, got %v instead", version, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestVersionPrefixesAreStrippedBeforeTheIPFilter(t *testing.T) {
+	// Arrange
+	rules, _ := parseCIDRs("10.0.0.0/8")
+	handler := apiVersions(ipFilter([]ipRule{{Prefix: "/admin/", Allow: rules}}, okHandler()))
+	req := httptest.NewRequest("GET", "/v1/admin/debug/vars", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusForbidden, status)
+	}
+}
+
+func TestSunsetVersionsAreAnnouncedThenRetired(t *testing.T) {
+	// Arrange
+	defer func(saved map[int]time.Time) { versionSunsets = saved }(versionSunsets)
+	sunsets, err := parseVersionSunsets("1=2000-01-01, v3=2999-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionSunsets = sunsets
+	handler := apiVersions(okHandler())
+	retired := httptest.NewRecorder()
+	sunsetting := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(retired, httptest.NewRequest("GET", "/v1/healthz", nil))
+	handler.ServeHTTP(sunsetting, httptest.NewRequest("GET", "/v3/healthz", nil))
+
+	// Assert
+	if retired.Code != http.StatusGone || strings.TrimSpace(retired.Body.String()) != `{"message":"API version 1 was retired on 2000-01-01, upgrade to version 5"}` {
+		t.Errorf("expected a retired version to be gone in its own format, got %v %v instead", retired.Code, retired.Body.String())
+	}
+	if sunsetting.Code != http.StatusOK {
+		t.Errorf("expected the version to be served until its sunset, got %v instead", sunsetting.Code)
+	}
+	if got := sunsetting.Header().Get("Sunset"); got != "Tue, 01 Jan 2999 00:00:00 GMT" {
+		t.Errorf("expected the Sunset header to announce the date, got %v instead", got)
+	}
+	if got := sunsetting.Header().Get("Deprecation"); got != "true" {
+		t.Errorf("expected the version to be deprecated, got %v instead", got)
+	}
+}
+
+func TestOnlyOlderVersionsCanBeSunset(t *testing.T) {
+	for _, s := range []string{"5=2027-01-01", "0=2027-01-01", "1=soon", "1"} {
+		// Act
+		_, err := parseVersionSunsets(s)
+
+		// Assert
+		if err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestOlderVersionsStreamResponsesThatArentJSON(t *testing.T) {
+	// Arrange
+	rr := httptest.NewRecorder()
+	streamed := ""
+	handler := apiVersions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte("BEGIN:VCALENDAR\r\n"))
+		w.(http.Flusher).Flush()
+		streamed = rr.Body.String()
+		w.Write([]byte("END:VCALENDAR\r\n"))
+	}))
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/meetups/calendar.ics", nil))
+
+	// Assert
+	if streamed != "BEGIN:VCALENDAR\r\n" {
+		t.Errorf("expected the calendar to be sent as it was written, got %q before the handler finished instead", streamed)
+	}
+	if rr.Header().Get("Deprecation") != "true" || rr.Body.String() != "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("expected the deprecated calendar untouched, got %v %q instead", rr.Header(), rr.Body.String())
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// warmUpSteps checks the database connection, runs the hot queries once so
+// their statements are prepared, and exercises the validators
+func warmUpSteps(db *gorm.DB) []warmUpStep {
+	return []warmUpStep{
+		{name: "database", run: func() error {
+			return db.DB().Ping()
+		}},
+		{name: "queries", run: func() error {
+			total := 0
+			if err := db.Model(&user{}).Count(&total).Error; err != nil {
+				return err
+			}
+			if err := db.Order("id asc").Limit(1).Find(&[]user{}).Error; err != nil {
+				return err
+			}
+			return db.Where("hash = ? AND revoked_at IS NULL", "").Find(&[]token{}).Error
+		}},
+		// the handlers' own rules, so what is warmed up can't drift from
+		// what requests are validated against
+		{name: "validators", run: func() error {
+			for _, rules := range []govalidator.MapData{userStoreRules, sessionStoreRules} {
+				req := userStoreRequest{Email: "warm@up.example", Password: "warmingUp1!", Username: "warming_up"}
+				govalidator.New(govalidator.Options{Data: &req, Rules: rules}).ValidateStruct()
+			}
+			return nil
+		}},
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// the watchdog metrics, published with expvar at /admin/debug/vars
+var (
+	heapAllocMetric    = expvar.NewInt("heap_alloc_bytes")
+	goroutinesMetric   = expvar.NewInt("goroutines")
+	loadSheddingMetric = expvar.NewInt("load_shedding")
+	shedRequestsMetric = expvar.NewInt("shed_requests")
+)
+
+// watchdog watches the heap and goroutine counts, once the heap grows past
+// shedRatio of heapLimit it sheds low priority requests until it recovers
+type watchdog struct {
+	heapLimit      uint64
+	goroutineLimit int
+	shedRatio      float64
+
+	shedding       int32
+	overGoroutines bool
+}
+
+func newWatchdog(heapLimit uint64, goroutineLimit int) *watchdog {
+	return &watchdog{
+		heapLimit:      heapLimit,
+		goroutineLimit: goroutineLimit,
+		shedRatio:      0.9,
+	}
+}
+
+// check samples the runtime, it is meant to be run periodically
+func (wd *watchdog) check() {
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+	wd.observe(m.HeapAlloc, runtime.NumGoroutine())
+}
+
+// observe records a sample and switches load shedding on or off
+func (wd *watchdog) observe(heap uint64, goroutines int) {
+	heapAllocMetric.Set(int64(heap))
+	goroutinesMetric.Set(int64(goroutines))
+
+	over := goroutines > wd.goroutineLimit
+	if over && !wd.overGoroutines {
+		log.Printf("watchdog: %v goroutines exceeds the limit of %v", goroutines, wd.goroutineLimit)
+	}
+	wd.overGoroutines = over
+
+	if wd.heapLimit == 0 {
+		return
+	}
+
+	shed := float64(heap) >= wd.shedRatio*float64(wd.heapLimit)
+	if shed && !wd.isShedding() {
+		log.Printf("watchdog: heap of %v bytes is near the limit of %v bytes, shedding low priority requests", heap, wd.heapLimit)
+		atomic.StoreInt32(&wd.shedding, 1)
+		loadSheddingMetric.Set(1)
+	}
+	if !shed && wd.isShedding() {
+		log.Printf("watchdog: heap of %v bytes has recovered, no longer shedding requests", heap)
+		atomic.StoreInt32(&wd.shedding, 0)
+		loadSheddingMetric.Set(0)
+	}
+}
+
+func (wd *watchdog) isShedding() bool {
+	return atomic.LoadInt32(&wd.shedding) == 1
+}
+
+// shed rejects exports and reads with a 503 while the watchdog is shedding so
+// writes, signups, and logins keep working
+func (wd *watchdog) shed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wd.isShedding() && classify(r) <= priorityRead {
+			shedRequestsMetric.Add(1)
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusServiceUnavailable, "service is under heavy load")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTheWatchdogShedsReadsNearTheHeapLimit(t *testing.T) {
+	// Arrange
+	wd := newWatchdog(100<<20, 10000)
+	handler := wd.shed(okHandler())
+	wd.observe(95<<20, 10)
+
+	// Act
+	read := httptest.NewRecorder()
+	handler.ServeHTTP(read, httptest.NewRequest("GET", "/users", nil))
+	write := httptest.NewRecorder()
+	handler.ServeHTTP(write, httptest.NewRequest("POST", "/users", nil))
+
+	// Assert
+	if status := read.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusServiceUnavailable, status)
+	}
+	if read.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header on shed requests")
+	}
+	if status := write.Code; status != http.StatusOK {
+		t.Errorf("expected the status code to be %v, got %v instead", http.StatusOK, status)
+	}
+}
+
+func TestTheWatchdogStopsSheddingOnceTheHeapRecovers(t *testing.T) {
+	// Arrange
+	wd := newWatchdog(100<<20, 10000)
+	wd.observe(95<<20, 10)
+
+	// Act
+	wd.observe(20<<20, 10)
+
+	// Assert
+	if wd.isShedding() {
+		t.Errorf("expected load shedding to stop once the heap recovered")
+	}
+	if loadSheddingMetric.Value() != 0 {
+		t.Errorf("expected the load shedding metric to be reset, got %v instead", loadSheddingMetric.Value())
+	}
+}
+
+func TestTheWatchdogIsDisabledWithoutAHeapLimit(t *testing.T) {
+	// Arrange
+	wd := newWatchdog(0, 10000)
+
+	// Act
+	wd.observe(1<<40, 10)
+
+	// Assert
+	if wd.isShedding() {
+		t.Errorf("expected no load shedding without a heap limit")
+	}
+}